@@ -98,13 +98,15 @@ type Assoc interface {
 	// Get returns the digest associated with key digest k and the
 	// provided kind. Get returns an errors.NotExist when no such
 	// mapping exists. Get expands the provided key when it is abbreviated,
-	// and returns the expanded key when appropriate.
+	// and returns the expanded key when appropriate. Get may refresh
+	// the access time of the mapping.
 	Get(ctx context.Context, kind Kind, k digest.Digest) (kexp, v digest.Digest, err error)
 
 	// BatchGet fetches a batch of keys. The result or error is set for each key.
 	// Global errors (such as context errors or unrecoverable system errors)
 	// are returned. Errors that can be attributable to a single fetch is returned
-	// as that key's error.
+	// as that key's error. Like Get, BatchGet may refresh the access
+	// times of the mappings it returns.
 	BatchGet(ctx context.Context, batch Batch) error
 
 	// CollectWithThreshold removes from this assoc any objects whose keys are not in the
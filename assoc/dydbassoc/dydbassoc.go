@@ -402,7 +402,14 @@ func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (dige
 	if err != nil {
 		return k, v, errors.E("lookup", k, err)
 	}
-	_, err = a.DB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+	a.touch(ctx, k)
+	return k, v, nil
+}
+
+// touch refreshes the access time of the key k, and increments its
+// access count. Errors are logged.
+func (a *Assoc) touch(ctx context.Context, k digest.Digest) {
+	_, err := a.DB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"ID": {
 				S: aws.String(k.String()),
@@ -423,13 +430,13 @@ func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (dige
 			log.Errorf("dynamodb: update %v: %v", k, err)
 		}
 	}
-	return k, v, nil
 }
 
 // BatchGet implements the assoc interface. BatchGet will return a result for each key in the batch.
 // BatchGet could internally split the keys into several batches. Any global errors, like context
 // cancellation, S3 API errors or a key parse error would be returned from BatchGet. Any value parse
 // errors would be returned as part of the result for that key.
+// Like Get, BatchGet refreshes the access times of the keys it finds.
 func (a *Assoc) BatchGet(ctx context.Context, batch assoc.Batch) error {
 	unique := make(map[digest.Digest]map[assoc.Kind]bool)
 	for k := range batch {
@@ -515,11 +522,24 @@ func (a *Assoc) BatchGet(ctx context.Context, batch assoc.Batch) error {
 	if err != nil {
 		return err
 	}
+	// Refresh the access times of the keys that were found, so that
+	// they are retained by collection (see reflow collect -policy).
+	// A key may be found for several kinds; it is touched once.
+	found := make(map[digest.Digest]bool)
+	keys = keys[:0]
 	for _, b := range batches {
 		for k, v := range b {
 			batch[k] = v
+			if v.Error == nil && !found[k.Digest] {
+				found[k.Digest] = true
+				keys = append(keys, k.Digest)
+			}
 		}
 	}
+	_ = traverse.Limit(updaterConcurrency).Each(len(keys), func(i int) error {
+		a.touch(ctx, keys[i])
+		return nil
+	})
 	return nil
 }
 
@@ -40,6 +40,9 @@ type mockdb struct {
 	MockStore []mockEntry
 	dbscanned bool
 	muScan    sync.Mutex
+
+	mu      sync.Mutex
+	updated []string
 }
 
 func (m *mockdb) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, options ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
@@ -84,6 +87,9 @@ func (m *mockdb) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchG
 }
 
 func (m *mockdb) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	m.updated = append(m.updated, *input.Key["ID"].S)
+	m.mu.Unlock()
 	return nil, nil
 }
 
@@ -143,7 +149,8 @@ func TestSimpleBatchGetItem(t *testing.T) {
 
 func TestMultiKindBatchGetItem(t *testing.T) {
 	ctx := context.Background()
-	ass := &Assoc{DB: &mockdb{}, TableName: mockTable}
+	db := &mockdb{}
+	ass := &Assoc{DB: db, TableName: mockTable}
 	k := reflow.Digester.Rand(nil)
 	keys := []assoc.Key{{assoc.Fileset, k}, {assoc.ExecInspect, k}}
 	batch := make(assoc.Batch)
@@ -161,6 +168,10 @@ func TestMultiKindBatchGetItem(t *testing.T) {
 	if got, want := batch[keys[1]].Digest, k; batch.Found(keys[0]) && got != want {
 		t.Errorf("want %v, got %v", got, want)
 	}
+	// The access time of the key is refreshed once.
+	if got, want := db.updated, []string{k.String()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
 }
 
 type mockdbunprocessed struct {
@@ -224,6 +235,10 @@ func (m *mockdbunprocessed) BatchGetItemWithContext(ctx aws.Context, input *dyna
 	return o, nil
 }
 
+func (m *mockdbunprocessed) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return nil, nil
+}
+
 func TestParallelBatchGetItem(t *testing.T) {
 	ctx := context.Background()
 	ass := &Assoc{DB: &mockdbunprocessed{maxRetries: 10}, TableName: mockTable}
@@ -330,6 +345,10 @@ func (m *mockdbInvalidDigest) BatchGetItemWithContext(ctx aws.Context, input *dy
 	return o, nil
 }
 
+func (m *mockdbInvalidDigest) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return nil, nil
+}
+
 func TestInvalidDigest(t *testing.T) {
 	batch := make(assoc.Batch)
 	for i := 0; i < 1000; i++ {
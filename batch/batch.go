@@ -4,13 +4,14 @@
 
 // Package batch implements support for running batches of reflow
 // (stateful) evaluations. The user specifies a reflow program and
-// enumerates each run by way of parameters specified in a CSV file.
-// The batch runner then takes care of the rest.
+// enumerates each run by way of parameters specified in a CSV file,
+// or by typed run specs in a JSON-lines or YAML file. The batch
+// runner then takes care of the rest.
 package batch
 
 import (
+	"bytes"
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -22,10 +23,12 @@ import (
 	"time"
 
 	"github.com/grailbio/base/digest"
+	"github.com/grailbio/base/retry"
 	"github.com/grailbio/base/state"
 	"github.com/grailbio/base/status"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	"github.com/grailbio/reflow/flow"
 	"github.com/grailbio/reflow/lang"
 	"github.com/grailbio/reflow/log"
@@ -86,22 +89,37 @@ type Run struct {
 	ID string
 	// Program is the path of the Reflow program to be evaluated.
 	Program string
-	// Args contains the run's parameters.
+	// Args contains the run's parameters, as read from a CSV file.
 	Args map[string]string
+	// Params contains the run's typed parameters, as read from a
+	// JSON-lines or YAML run spec. Params is nil for runs read from
+	// CSV files.
+	Params map[string]interface{}
 	// Argv contains the run's argument vector.
 	Argv []string
+	// Deps contains the IDs of the runs on which this run depends.
+	// The run is started only after its dependencies have completed
+	// successfully.
+	Deps []string
 
 	// RunID is the global run ID for this run.
 	RunID taskdb.RunID
 
 	// State stores the runner state of this run.
 	State runner.State `json:"-"`
+	// Attempts records the attempts to evaluate this run, and the
+	// decisions made by the batch's policy. Attempts are stored,
+	// together with State, in the run's state file.
+	Attempts []Attempt `json:"-"`
 
 	// Status receives status updates from batch execution.
 	Status *status.Task
 
 	batch *Batch
 	log   *log.Logger
+	// results contains the decoded results of the run's
+	// dependencies, keyed by run ID.
+	results map[string]interface{}
 }
 
 // Go runs the run according to its state. If the run was previously
@@ -147,6 +165,27 @@ func (r *Run) Go(ctx context.Context, initWG *sync.WaitGroup) error {
 	)
 	evalConfig := r.batch.EvalConfig
 	evalConfig.Log = r.log
+	evalConfig.RunID = r.RunID
+	if tdb := evalConfig.TaskDB; tdb != nil {
+		tctx, tcancel := context.WithCancel(ctx)
+		defer tcancel()
+		if err := tdb.CreateRun(tctx, r.RunID, r.batch.User); err != nil {
+			r.log.Debugf("error writing run to taskdb: %v", err)
+		} else {
+			go func() { _ = taskdb.KeepRunAlive(tctx, tdb, r.RunID) }()
+		}
+	}
+	var notify func(context.Context, runner.State, pool.Labels)
+	if r.batch.Notifier != nil {
+		var sink events.Sink
+		sink, notify = r.batch.Notifier(r)
+		if sink != nil {
+			if evalConfig.Events != nil {
+				sink = events.Multi(evalConfig.Events, sink)
+			}
+			evalConfig.Events = sink
+		}
+	}
 	run := &runner.Runner{
 		State:      r.State,
 		Cluster:    r.batch.Cluster,
@@ -155,9 +194,13 @@ func (r *Run) Go(ctx context.Context, initWG *sync.WaitGroup) error {
 		EvalConfig: evalConfig,
 		Type:       typ,
 		Labels:     pool.Labels{"program": r.Program},
+		Notify:     notify,
 	}
 	run.Program = r.Program
 	run.Params = r.Args
+	if r.Params != nil {
+		run.Params = paramLabels(r.Params)
+	}
 	run.Args = r.Argv
 	switch run.Phase {
 	default:
@@ -199,6 +242,9 @@ func (r *Run) flow() (*flow.Flow, *types.T, error) {
 	default:
 		return nil, nil, fmt.Errorf("unrecognized file extension %s", ext)
 	case ".reflow":
+		if r.Params != nil {
+			return nil, nil, fmt.Errorf("%s: typed parameters require a .rf module", r.Program)
+		}
 		f, err := os.Open(r.batch.path(r.Program))
 		if err != nil {
 			return nil, nil, err
@@ -239,17 +285,29 @@ func (r *Run) flow() (*flow.Flow, *types.T, error) {
 		if maintyp == nil {
 			return nil, nil, fmt.Errorf("module %v does not define symbol Main", r.Program)
 		}
-		flags, err := m.Flags(sess, sess.Values)
-		if err != nil {
-			return nil, nil, err
-		}
-		err = parseFlags(flags, r.Args, r.batch.Args)
-		if err != nil {
-			return nil, nil, err
-		}
 		env := sess.Values.Push()
-		if err := m.FlagEnv(flags, env, types.NewEnv()); err != nil {
-			return nil, nil, err
+		if r.Params != nil {
+			conv := newParamConverter(sess)
+			conv.Results = r.results
+			params, err := conv.Params(m.Params(), r.Params, r.batch.Args)
+			if err != nil {
+				return nil, nil, err
+			}
+			for k, v := range params {
+				env.Bind(k, v)
+			}
+		} else {
+			flags, err := m.Flags(sess, sess.Values)
+			if err != nil {
+				return nil, nil, err
+			}
+			err = parseFlags(flags, r.Args, r.batch.Args)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := m.FlagEnv(flags, env, types.NewEnv()); err != nil {
+				return nil, nil, err
+			}
 		}
 		v, err := m.Make(sess, env)
 		if err != nil {
@@ -272,6 +330,7 @@ func (r *Run) flow() (*flow.Flow, *types.T, error) {
 type config struct {
 	Program  string `json:"program"`
 	RunsFile string `json:"runs_file"`
+	Policy   Policy `json:"policy"`
 }
 
 // Batch represents a batch of reflow evaluations. It manages setting
@@ -308,13 +367,27 @@ type Batch struct {
 
 	flow.EvalConfig
 
+	// Notifier, if not nil, is called with each run attempt before
+	// it is evaluated. It returns a sink to which the attempt's
+	// evaluation events are published, and a function that is called
+	// with the attempt's state and labels when it completes. Either
+	// may be nil.
+	Notifier func(run *Run) (events.Sink, func(ctx context.Context, state runner.State, labels pool.Labels))
+
 	// Runs is the set of runs managed by this batch.
 	Runs map[string]*Run
+	// Policy is the batch's policy. It is read from the batch
+	// configuration by Init, and may be modified before the batch
+	// is run.
+	Policy Policy
 	// Admitter is a rate limiter to control the rate of new evaluations.
 	// This can be used to prevent "thundering herds" against systems
 	// like S3. Admitter should be set prior to running the batch.
 	Admitter *rate.Limiter
 
+	// all contains all of the batch's runs, including those that
+	// were removed from Runs, so that dependencies can be resolved.
+	all    map[string]*Run
 	file   *state.File
 	states map[string]*state.File
 	config config
@@ -350,10 +423,17 @@ func (b *Batch) Init(reset bool) error {
 		}
 	}
 	b.commit(nil)
+	b.Policy = b.config.Policy
 	b.Log.Printf("batch program %v runsfile %v", b.config.Program, b.config.RunsFile)
 	return b.read()
 }
 
+// Program returns the path of the batch's program, relative to the
+// batch directory.
+func (b *Batch) Program() string {
+	return b.config.Program
+}
+
 // Close releases resources held by the batch.
 func (b *Batch) Close() {
 	for _, file := range b.states {
@@ -367,22 +447,102 @@ func (b *Batch) Close() {
 // Run runs the batch until completion, too many errors, or context
 // completion. Run reports batch progress to the batch's logger every
 // 10 seconds.
+//
+// Runs are started once their dependencies have completed
+// successfully. Runs whose dependencies fail are marked as failed
+// without being started. Runs are scheduled, retried, and timed out
+// according to the batch's policy; if the policy is fail-fast, Run
+// returns an error once a run has failed. Runs that completed when
+// the batch was last run, successfully or not, are not attempted
+// again unless they are reset.
 func (b *Batch) Run(ctx context.Context) error {
-	done := make(chan *Run)
+	if err := b.Policy.Validate(); err != nil {
+		return err
+	}
+	var (
+		done     = make(chan *Run, len(b.Runs))
+		failed   = make(chan error, len(b.Runs))
+		finished = make(map[string]chan struct{})
+		// completed records the states of the runs that had
+		// completed successfully before the batch was started.
+		completed = make(map[string]runner.State)
+		// limit limits the number of concurrent evaluations.
+		limit chan struct{}
+	)
+	for id, run := range b.all {
+		if succeeded(run.State) {
+			completed[id] = run.State
+		}
+	}
+	for id := range b.Runs {
+		finished[id] = make(chan struct{})
+	}
+	if n := b.Policy.MaxConcurrent; n > 0 {
+		limit = make(chan struct{}, n)
+	}
 	var wg sync.WaitGroup
 	for _, run := range b.Runs {
+		if run.State.Phase != runner.Eval {
+			continue
+		}
+		// A run can be evaluating while its dependencies are not
+		// complete only if they were reset since the run started; the
+		// run must then be restarted once they are done.
+		for _, dep := range run.Deps {
+			if _, ok := completed[dep]; !ok {
+				run.State.Reset()
+				b.commit(run)
+				break
+			}
+		}
 		if run.State.Phase == runner.Eval {
 			wg.Add(1)
 		}
 	}
-	b.Status.Printf("remaining: %d", len(b.Runs))
+	b.Status.Printf("remaining: %d (policy: %s)", len(b.Runs), b.Policy)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, run := range b.Runs {
 		go func(run *Run) {
-			err := run.Go(ctx, &wg)
+			defer close(finished[run.ID])
+			if run.State.Phase == runner.Done {
+				if run.State.Err != nil {
+					b.Log.Printf("run %v: failed previously: %v", run.ID, run.State.Err)
+				}
+				run.Status.Done()
+				done <- run
+				return
+			}
+			err := b.waitDeps(runCtx, run, completed, finished)
+			if err != nil && run.State.Phase == runner.Eval {
+				// The run will not restore its alloc; don't hold up
+				// the runs waiting for it.
+				wg.Done()
+			}
+			switch {
+			case err == nil:
+				err = b.attempt(ctx, runCtx, run, &wg, limit)
+			case runCtx.Err() == nil:
+				run.State.Phase = runner.Done
+				run.State.Err = errors.Recover(err)
+				run.State.Completion = time.Now()
+				now := time.Now()
+				run.Attempts = append(run.Attempts, Attempt{
+					Start: now, End: now, Err: run.State.Err,
+					Decision: DecisionFail, Reason: "dependencies not satisfied",
+				})
+				b.commit(run)
+				run.Status.Printf("error %v", err)
+				run.Status.Done()
+			}
 			switch {
-			case ctx.Err() != nil:
+			case runCtx.Err() != nil:
 			case err != nil:
 				b.Log.Errorf("run %v: error: %v", run.ID, err)
+				if b.Policy.FailFast {
+					failed <- errors.E(errors.Fatal, errors.Errorf("run %s failed: %v", run.ID, err))
+					cancel()
+				}
 			default:
 				b.Log.Printf("run %v: done: %v", run.ID, run.State.Result)
 			}
@@ -399,36 +559,163 @@ func (b *Batch) Run(ctx context.Context) error {
 			n++
 		}
 	}
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return nil
+	}
+}
+
+// attempt evaluates the run, retrying it according to the batch's
+// policy. Each attempt, and the policy's decision, is recorded in
+// the run's state; retries made before the batch was last stopped
+// count against the policy's limit. Attempt returns the error of the
+// run's last attempt. Runs are canceled (and reset, so that they are restarted
+// when the batch is next run) when runCtx is canceled by a failed
+// run in a fail-fast batch; ctx is the batch's context.
+func (b *Batch) attempt(ctx, runCtx context.Context, run *Run, initWG *sync.WaitGroup, limit chan struct{}) error {
+	for retries := countRetries(run.Attempts); ; retries++ {
+		if run.State.Phase != runner.Eval {
+			// Runs that need a new alloc wait for the runs that reclaim
+			// theirs before they are admitted. (Run.Go also waits, but
+			// it must happen before the run is counted against the
+			// concurrency limit.)
+			initWG.Wait()
+		}
+		if limit != nil {
+			select {
+			case limit <- struct{}{}:
+			case <-runCtx.Done():
+				if run.State.Phase == runner.Eval {
+					initWG.Done()
+				}
+				return runCtx.Err()
+			}
+		}
+		attemptCtx, cancel := runCtx, context.CancelFunc(func() {})
+		if b.Policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(runCtx, b.Policy.Timeout)
+		}
+		attempt := Attempt{Start: time.Now()}
+		err := run.Go(attemptCtx, initWG)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded && runCtx.Err() == nil
+		cancel()
+		if limit != nil {
+			<-limit
+		}
+		attempt.End = time.Now()
+		switch {
+		case ctx.Err() != nil:
+			// The batch was canceled; the run is resumed when the batch
+			// is next run.
+			return err
+		case runCtx.Err() != nil:
+			run.State.Reset()
+			attempt.Decision = DecisionCancel
+			attempt.Reason = "another run failed"
+			run.Attempts = append(run.Attempts, attempt)
+			b.commit(run)
+			return err
+		case timedOut:
+			err = errors.E(errors.Timeout, errors.Errorf("run exceeded timeout of %s", b.Policy.Timeout))
+			run.State.Phase = runner.Done
+			run.State.Err = errors.Recover(err)
+			run.State.Completion = time.Now()
+		case err == nil:
+			attempt.Decision = DecisionDone
+			run.Attempts = append(run.Attempts, attempt)
+			b.commit(run)
+			return nil
+		}
+		attempt.Err = run.State.Err
+		if attempt.Err == nil {
+			attempt.Err = errors.Recover(err)
+		}
+		if retries >= b.Policy.Retries {
+			attempt.Decision = DecisionFail
+			attempt.Reason = fmt.Sprintf("retries exhausted (%d/%d)", retries, b.Policy.Retries)
+			run.Attempts = append(run.Attempts, attempt)
+			b.commit(run)
+			return err
+		}
+		policy := b.Policy.retryPolicy()
+		_, wait := policy.Retry(retries)
+		attempt.Decision = DecisionRetry
+		attempt.Reason = fmt.Sprintf("retry %d/%d after %s", retries+1, b.Policy.Retries, wait)
+		run.Attempts = append(run.Attempts, attempt)
+		b.commit(run)
+		b.Log.Printf("run %v: %s", run.ID, attempt)
+		run.Status = b.Status.Start(run.RunID.IDShort())
+		run.Status.Printf("waiting %s to retry", wait)
+		if err := retry.Wait(runCtx, policy, retries); err != nil {
+			return err
+		}
+		run.State.Reset()
+		b.commit(run)
+	}
+}
+
+// waitDeps waits for the dependencies of the provided run to
+// complete, and then loads their results. WaitDeps returns an error
+// if a dependency failed or is not part of the batch's runs.
+func (b *Batch) waitDeps(ctx context.Context, run *Run, completed map[string]runner.State, finished map[string]chan struct{}) error {
+	if len(run.Deps) == 0 {
+		return nil
+	}
+	run.Status.Print("waiting for dependencies")
+	run.results = make(map[string]interface{})
+	for _, id := range run.Deps {
+		state, ok := completed[id]
+		if !ok {
+			ch, ok := finished[id]
+			if !ok {
+				return errors.E(errors.Precondition, errors.Errorf("dependency %s has not completed", id))
+			}
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			state = b.all[id].State
+		}
+		if !succeeded(state) {
+			return errors.E(errors.Precondition, errors.Errorf("dependency %s failed", id))
+		}
+		if len(state.Value) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(state.Value))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return errors.E(errors.Precondition, errors.Errorf("dependency %s: decode result: %v", id, err))
+		}
+		run.results[id] = v
+	}
 	return nil
 }
 
+// succeeded tells whether the run with the provided state has
+// completed successfully.
+func succeeded(state runner.State) bool {
+	return state.Phase == runner.Done && state.Err == nil
+}
+
 func (b *Batch) read() error {
-	f, err := os.Open(b.path(b.config.RunsFile))
+	specs, typed, err := readSpecs(b.path(b.config.RunsFile))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	r := csv.NewReader(f)
-	r.FieldsPerRecord = -1
-	records, err := r.ReadAll()
-	if err != nil {
-		return err
-	}
-	if len(records) < 1 {
-		return errors.New("empty batch")
+	program := b.path(b.config.Program)
+	if typed {
+		if err := checkSpecs(program, specs); err != nil {
+			return err
+		}
 	}
-	header := records[0]
-	records = records[1:]
 	runs := map[string]*Run{}
-	for _, fields := range records {
-		if len(fields) != len(header) {
-			return errors.Errorf("batch file row [%v] has %v fields, need %v", fields, len(fields), len(header))
-		}
-		id := fields[0]
-		attrs := map[string]string{}
-		for j := 1; j < len(header); j++ {
-			attrs[header[j]] = fields[j]
-		}
+	for _, spec := range specs {
+		id := spec.ID
 		run := b.Runs[id]
 		if run == nil {
 			run = new(Run)
@@ -436,9 +723,19 @@ func (b *Batch) read() error {
 			run.RunID = taskdb.NewRunID()
 		}
 		run.ID = id
-		run.Args = attrs
-		run.Argv = fields[len(header):]
-		run.Program = b.path(b.config.Program)
+		if typed {
+			run.Args = nil
+			run.Params = spec.Params
+		} else {
+			run.Args = make(map[string]string, len(spec.Params))
+			for k, v := range spec.Params {
+				run.Args[k] = v.(string)
+			}
+			run.Params = nil
+		}
+		run.Argv = spec.Args
+		run.Deps = spec.Deps
+		run.Program = program
 		run.Status = b.Status.Start(run.RunID.IDShort())
 		run.Status.Print("waiting")
 		run.batch = b
@@ -446,19 +743,51 @@ func (b *Batch) read() error {
 		if err != nil {
 			return err
 		}
-		if err := b.states[id].Unmarshal(&run.State); err != nil && err != state.ErrNoState {
+		var rs runState
+		if err := b.states[id].Unmarshal(&rs); err != nil && err != state.ErrNoState {
 			return err
 		}
+		run.State, run.Attempts = rs.State, rs.Attempts
 		if !run.State.ID.IsValid() {
 			run.State.ID = run.RunID
 		}
 		runs[id] = run
 	}
 	b.Runs = runs
+	b.all = make(map[string]*Run, len(runs))
+	for id, run := range runs {
+		b.all[id] = run
+	}
 	b.commit(nil)
 	return nil
 }
 
+// checkSpecs checks the typed parameters of the provided run specs
+// against the parameters of the module at path, so that malformed
+// specs are rejected before any run begins.
+func checkSpecs(path string, specs []RunSpec) error {
+	switch ext := filepath.Ext(path); ext {
+	case ".rf", ".rfx":
+	default:
+		return fmt.Errorf("%s: typed parameters require a .rf module", path)
+	}
+	m, err := syntax.NewSession(nil).Open(path)
+	if err != nil {
+		return err
+	}
+	params := m.Params()
+	maintyp := m.Type(nil).Field("Main")
+	if maintyp.Kind == types.ErrorKind {
+		return fmt.Errorf("module %v does not define symbol Main", path)
+	}
+	for _, spec := range specs {
+		if err := checkParams(params, spec.Params, maintyp); err != nil {
+			return errors.Errorf("run %s: %v", spec.ID, err)
+		}
+	}
+	return nil
+}
+
 func (b *Batch) path(path string) string {
 	if filepath.IsAbs(path) {
 		return filepath.Clean(path)
@@ -474,7 +803,7 @@ func (b *Batch) commit(run *Run) {
 		}
 		b.file.UnlockLocal()
 	} else {
-		if err := b.states[run.ID].Marshal(&run.State); err != nil {
+		if err := b.states[run.ID].Marshal(&runState{run.State, run.Attempts}); err != nil {
 			b.Log.Errorf("marshal %s: %v", run.ID, err)
 		}
 	}
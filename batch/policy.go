@@ -0,0 +1,218 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/runner"
+)
+
+const (
+	defaultRetryBackoff    = time.Minute
+	defaultMaxRetryBackoff = 30 * time.Minute
+)
+
+// A Policy determines how a batch schedules its runs and how it
+// handles their failures. The zero Policy runs all runs
+// concurrently, without timeouts, and does not retry runs that
+// fail.
+type Policy struct {
+	// MaxConcurrent is the maximum number of runs that are evaluated
+	// concurrently. If zero, the number is unlimited.
+	MaxConcurrent int
+	// Retries is the number of times a failed run is retried.
+	Retries int
+	// RetryBackoff is the time to wait before the first retry of a
+	// run. The wait is doubled for each subsequent retry, up to
+	// MaxRetryBackoff.
+	RetryBackoff time.Duration
+	// MaxRetryBackoff is the maximum time to wait between retries.
+	MaxRetryBackoff time.Duration
+	// FailFast tells whether the batch should stop when a run fails
+	// (after it has exhausted its retries). Runs that are stopped
+	// this way are restarted when the batch is next run. If FailFast
+	// is false, the batch continues with its other runs.
+	FailFast bool
+	// Timeout is the maximum duration of each attempt to evaluate a
+	// run. Runs that time out are failed, and may be retried. If
+	// zero, runs do not time out.
+	Timeout time.Duration
+}
+
+// policyJSON is the JSON representation of a Policy, as it appears
+// in batch configuration files. Durations are strings parsed by
+// time.ParseDuration.
+type policyJSON struct {
+	MaxConcurrent   int    `json:"max_concurrent"`
+	Retries         int    `json:"retries"`
+	RetryBackoff    string `json:"retry_backoff"`
+	MaxRetryBackoff string `json:"max_retry_backoff"`
+	FailFast        bool   `json:"fail_fast"`
+	Timeout         string `json:"timeout"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Policy) UnmarshalJSON(b []byte) error {
+	var j policyJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*p = Policy{MaxConcurrent: j.MaxConcurrent, Retries: j.Retries, FailFast: j.FailFast}
+	for _, d := range []struct {
+		name string
+		s    string
+		d    *time.Duration
+	}{
+		{"retry_backoff", j.RetryBackoff, &p.RetryBackoff},
+		{"max_retry_backoff", j.MaxRetryBackoff, &p.MaxRetryBackoff},
+		{"timeout", j.Timeout, &p.Timeout},
+	} {
+		if d.s == "" {
+			continue
+		}
+		var err error
+		if *d.d, err = time.ParseDuration(d.s); err != nil {
+			return errors.E(errors.Invalid, errors.Errorf("policy: %s: %v", d.name, err))
+		}
+	}
+	return p.Validate()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Policy) MarshalJSON() ([]byte, error) {
+	j := policyJSON{MaxConcurrent: p.MaxConcurrent, Retries: p.Retries, FailFast: p.FailFast}
+	if p.RetryBackoff > 0 {
+		j.RetryBackoff = p.RetryBackoff.String()
+	}
+	if p.MaxRetryBackoff > 0 {
+		j.MaxRetryBackoff = p.MaxRetryBackoff.String()
+	}
+	if p.Timeout > 0 {
+		j.Timeout = p.Timeout.String()
+	}
+	return json.Marshal(j)
+}
+
+// Validate returns an error if the policy is invalid.
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxConcurrent < 0:
+		return errors.E(errors.Invalid, errors.Errorf("policy: negative max_concurrent %d", p.MaxConcurrent))
+	case p.Retries < 0:
+		return errors.E(errors.Invalid, errors.Errorf("policy: negative retries %d", p.Retries))
+	case p.RetryBackoff < 0 || p.MaxRetryBackoff < 0 || p.Timeout < 0:
+		return errors.E(errors.Invalid, errors.New("policy: negative duration"))
+	}
+	return nil
+}
+
+// String returns a summary of the policy.
+func (p Policy) String() string {
+	concurrent := "unlimited"
+	if p.MaxConcurrent > 0 {
+		concurrent = fmt.Sprint(p.MaxConcurrent)
+	}
+	timeout := "none"
+	if p.Timeout > 0 {
+		timeout = p.Timeout.String()
+	}
+	onFailure := "continue"
+	if p.FailFast {
+		onFailure = "fail-fast"
+	}
+	return fmt.Sprintf("concurrency %s, retries %d (backoff %s, max %s), timeout %s, on failure %s",
+		concurrent, p.Retries, p.retryBackoff(), p.maxRetryBackoff(), timeout, onFailure)
+}
+
+func (p Policy) retryBackoff() time.Duration {
+	if p.RetryBackoff > 0 {
+		return p.RetryBackoff
+	}
+	return defaultRetryBackoff
+}
+
+func (p Policy) maxRetryBackoff() time.Duration {
+	if p.MaxRetryBackoff > 0 {
+		return p.MaxRetryBackoff
+	}
+	return defaultMaxRetryBackoff
+}
+
+// retryPolicy returns the retry policy used to space retries.
+func (p Policy) retryPolicy() retry.Policy {
+	return retry.Backoff(p.retryBackoff(), p.maxRetryBackoff(), 2)
+}
+
+// Decision is a decision made by a batch's policy at the end of an
+// attempt to evaluate a run.
+type Decision string
+
+const (
+	// DecisionDone indicates that the run completed successfully.
+	DecisionDone Decision = "done"
+	// DecisionRetry indicates that the run failed and is retried.
+	DecisionRetry Decision = "retry"
+	// DecisionFail indicates that the run failed and is not retried.
+	DecisionFail Decision = "fail"
+	// DecisionCancel indicates that the run was stopped because
+	// another run failed in a fail-fast batch. The run is restarted
+	// when the batch is next run.
+	DecisionCancel Decision = "cancel"
+)
+
+// An Attempt records an attempt to evaluate a run, and the decision
+// made by the batch's policy when the attempt completed.
+type Attempt struct {
+	// Start and End are the times at which the attempt started and
+	// completed.
+	Start, End time.Time
+	// Err is the attempt's error, if any.
+	Err *errors.Error `json:",omitempty"`
+	// Decision is the decision made by the batch's policy.
+	Decision Decision
+	// Reason explains the decision.
+	Reason string `json:",omitempty"`
+}
+
+// String returns a summary of the attempt.
+func (a Attempt) String() string {
+	s := string(a.Decision)
+	if a.Reason != "" {
+		s += ": " + a.Reason
+	}
+	return s
+}
+
+// countRetries returns the number of times the run with the provided
+// attempts has been retried since it was last started afresh: the
+// number of retry decisions made since its last successful or failed
+// attempt. Canceled attempts are not counted, since they are not the
+// run's failures.
+func countRetries(attempts []Attempt) int {
+	var n int
+	for i := len(attempts) - 1; i >= 0; i-- {
+		switch attempts[i].Decision {
+		case DecisionRetry:
+			n++
+		case DecisionDone, DecisionFail:
+			return n
+		}
+	}
+	return n
+}
+
+// runState is the state of a run as it is stored in the run's state
+// file: the runner's state, together with the run's attempts.
+// Because runner.State is embedded, state files written before
+// attempts were recorded are read as states without attempts.
+type runState struct {
+	runner.State
+	Attempts []Attempt `json:",omitempty"`
+}
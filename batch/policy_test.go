@@ -0,0 +1,127 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package batch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/runner"
+)
+
+func TestPolicyJSON(t *testing.T) {
+	var c config
+	err := json.Unmarshal([]byte(`{
+		"program": "x.rf",
+		"runs_file": "runs.csv",
+		"policy": {"max_concurrent": 4, "retries": 2, "retry_backoff": "30s", "fail_fast": true, "timeout": "2h"}
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Policy{MaxConcurrent: 4, Retries: 2, RetryBackoff: 30 * time.Second, FailFast: true, Timeout: 2 * time.Hour}
+	if got := c.Policy; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := c.Policy.String(), "concurrency 4, retries 2 (backoff 30s, max 30m0s), timeout 2h0m0s, on failure fail-fast"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	p, err := json.Marshal(c.Policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rt Policy
+	if err := json.Unmarshal(p, &rt); err != nil {
+		t.Fatal(err)
+	}
+	if rt != want {
+		t.Errorf("got %+v, want %+v", rt, want)
+	}
+
+	for _, c := range []struct{ policy, err string }{
+		{`{"timeout": "forever"}`, "policy: timeout"},
+		{`{"retries": -1}`, "negative retries"},
+		{`{"max_concurrent": -2}`, "negative max_concurrent"},
+		{`{"retry_backoff": "-1s"}`, "negative duration"},
+	} {
+		var p Policy
+		err := json.Unmarshal([]byte(c.policy), &p)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want %q", c.policy, err, c.err)
+		}
+	}
+}
+
+func TestRunState(t *testing.T) {
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		s     = runState{
+			State: runner.State{Phase: runner.Done},
+			Attempts: []Attempt{
+				{Start: start, End: start.Add(time.Hour), Err: errors.Recover(errors.E(errors.Timeout, "run")), Decision: DecisionRetry, Reason: "retry 1/1 after 1m0s"},
+				{Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour), Decision: DecisionDone},
+			},
+		}
+	)
+	p, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runState
+	if err := json.Unmarshal(p, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Phase != runner.Done {
+		t.Errorf("got %v, want %v", got.Phase, runner.Done)
+	}
+	if got, want := len(got.Attempts), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := got.Attempts[0].String(), "retry: retry 1/1 after 1m0s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !errors.Is(errors.Timeout, got.Attempts[0].Err) {
+		t.Errorf("expected timeout error, got %v", got.Attempts[0].Err)
+	}
+
+	// State files written before attempts were recorded contain only
+	// the runner's state.
+	p, err = json.Marshal(runner.State{Phase: runner.Eval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = runState{}
+	if err := json.Unmarshal(p, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Phase != runner.Eval || got.Attempts != nil {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCountRetries(t *testing.T) {
+	for _, c := range []struct {
+		decisions []Decision
+		want      int
+	}{
+		{nil, 0},
+		{[]Decision{DecisionRetry}, 1},
+		{[]Decision{DecisionRetry, DecisionCancel, DecisionRetry}, 2},
+		{[]Decision{DecisionRetry, DecisionFail}, 0},
+		{[]Decision{DecisionRetry, DecisionFail, DecisionRetry}, 1},
+		{[]Decision{DecisionRetry, DecisionDone}, 0},
+	} {
+		attempts := make([]Attempt, len(c.decisions))
+		for i, d := range c.decisions {
+			attempts[i].Decision = d
+		}
+		if got := countRetries(attempts); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.decisions, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,784 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package batch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/syntax"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A RunSpec specifies a single run of a batch. Runs are specified
+// either by rows of a CSV file, in which case all parameters are
+// strings, or by JSON-lines or YAML run specs, in which case
+// parameters are typed values that are checked against the
+// parameters of the batch's module.
+//
+// Typed parameters may refer to the results of other runs in the
+// batch. A reference is an object of the form
+//
+//	{"$run": id}
+//
+// which stands for the result of the run with the given ID, or
+//
+//	{"$run": id, "$field": "a.b"}
+//
+// which stands for the field a.b of that result. A run that refers
+// to another run depends on it, and is started only after the other
+// run completes successfully.
+type RunSpec struct {
+	// ID is the run's identifier. IDs must be unique inside of a batch.
+	ID string `json:"id" yaml:"id"`
+	// Params contains the run's parameters, keyed by name.
+	Params map[string]interface{} `json:"params" yaml:"params"`
+	// Args contains the run's argument vector.
+	Args []string `json:"args" yaml:"args"`
+	// Deps contains the IDs of the runs on which this run depends,
+	// in addition to those referred to by its parameters. When the
+	// specs are read, Deps is set to the sorted IDs of all of the
+	// run's dependencies.
+	Deps []string `json:"deps" yaml:"deps"`
+}
+
+const (
+	// refRun and refField are the keys of references to the results
+	// of other runs.
+	refRun   = "$run"
+	refField = "$field"
+)
+
+// parseRef parses the value v as a reference to the result of a
+// run. Ok is false if v is not a reference.
+func parseRef(v interface{}) (id, field string, ok bool, err error) {
+	m, isMap := v.(map[string]interface{})
+	if !isMap {
+		return "", "", false, nil
+	}
+	r, isRef := m[refRun]
+	if !isRef {
+		return "", "", false, nil
+	}
+	if id, ok = r.(string); !ok || id == "" {
+		return "", "", false, errors.Errorf("invalid run reference %v", r)
+	}
+	for k, f := range m {
+		switch k {
+		case refRun:
+		case refField:
+			if field, ok = f.(string); !ok {
+				return "", "", false, errors.Errorf("invalid field %v in reference to run %s", f, id)
+			}
+		default:
+			return "", "", false, errors.Errorf("unexpected key %s in reference to run %s", k, id)
+		}
+	}
+	return id, field, true, nil
+}
+
+// refs adds the IDs of the runs referred to by v to ids.
+func refs(v interface{}, ids map[string]bool) error {
+	id, _, ok, err := parseRef(v)
+	if err != nil {
+		return err
+	}
+	if ok {
+		ids[id] = true
+		return nil
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, elem := range v {
+			if err := refs(elem, ids); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := refs(elem, ids); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDeps sets the dependencies of each spec to the union of
+// its declared dependencies and the runs referred to by its
+// parameters. ResolveDeps returns an error if a spec depends on a
+// run that does not exist, or if the dependencies form a cycle.
+func resolveDeps(specs []RunSpec) error {
+	index := make(map[string]int)
+	for i, spec := range specs {
+		index[spec.ID] = i
+	}
+	for i := range specs {
+		ids := make(map[string]bool)
+		for _, id := range specs[i].Deps {
+			ids[id] = true
+		}
+		for k, v := range specs[i].Params {
+			if err := refs(v, ids); err != nil {
+				return errors.Errorf("run %s: param %s: %v", specs[i].ID, k, err)
+			}
+		}
+		deps := make([]string, 0, len(ids))
+		for id := range ids {
+			if _, ok := index[id]; !ok {
+				return errors.Errorf("run %s depends on nonexistent run %s", specs[i].ID, id)
+			}
+			deps = append(deps, id)
+		}
+		sort.Strings(deps)
+		specs[i].Deps = deps
+	}
+	// Check for cycles by depth-first search.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(specs))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, specs[i].ID)
+		switch state[i] {
+		case visiting:
+			return errors.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, id := range specs[i].Deps {
+			if err := visit(index[id], path); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range specs {
+		if err := visit(i, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSpecs reads the run specs from the file at the provided path.
+// The format of the file is determined by its extension: ".jsonl"
+// and ".ndjson" files contain a JSON-encoded RunSpec per line;
+// ".yaml" and ".yml" files contain a YAML list of RunSpecs; all
+// other files are read as CSV files. Typed reports whether the
+// specs' parameters are typed: parameters read from CSV files are
+// always strings.
+func readSpecs(path string) (specs []RunSpec, typed bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	switch filepath.Ext(path) {
+	case ".jsonl", ".ndjson":
+		specs, err = readJSONSpecs(f)
+		typed = true
+	case ".yaml", ".yml":
+		specs, err = readYAMLSpecs(f)
+		typed = true
+	default:
+		specs, err = readCSVSpecs(f)
+	}
+	if err != nil {
+		return nil, false, errors.E("read", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, false, errors.New("empty batch")
+	}
+	ids := make(map[string]bool)
+	for i, spec := range specs {
+		if spec.ID == "" {
+			return nil, false, errors.Errorf("%s: run %d has no id", path, i+1)
+		}
+		if ids[spec.ID] {
+			return nil, false, errors.Errorf("%s: duplicate run id %s", path, spec.ID)
+		}
+		ids[spec.ID] = true
+	}
+	if err := resolveDeps(specs); err != nil {
+		return nil, false, errors.Errorf("%s: %v", path, err)
+	}
+	return specs, typed, nil
+}
+
+func readCSVSpecs(r io.Reader) ([]RunSpec, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 1 {
+		return nil, nil
+	}
+	header := records[0]
+	records = records[1:]
+	specs := make([]RunSpec, len(records))
+	for i, fields := range records {
+		if len(fields) != len(header) {
+			return nil, errors.Errorf("batch file row [%v] has %v fields, need %v", fields, len(fields), len(header))
+		}
+		params := make(map[string]interface{})
+		for j := 1; j < len(header); j++ {
+			params[header[j]] = fields[j]
+		}
+		specs[i] = RunSpec{ID: fields[0], Params: params, Args: fields[len(header):]}
+	}
+	return specs, nil
+}
+
+func readJSONSpecs(r io.Reader) ([]RunSpec, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	var specs []RunSpec
+	for {
+		var spec RunSpec
+		if err := dec.Decode(&spec); err == io.EOF {
+			return specs, nil
+		} else if err != nil {
+			return nil, errors.Errorf("run %d: %v", len(specs)+1, err)
+		}
+		specs = append(specs, spec)
+	}
+}
+
+func readYAMLSpecs(r io.Reader) ([]RunSpec, error) {
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var specs []RunSpec
+	if err := yaml.UnmarshalStrict(p, &specs); err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		for k, v := range specs[i].Params {
+			specs[i].Params[k] = yamlValue(v)
+		}
+	}
+	return specs, nil
+}
+
+// yamlValue converts the maps decoded by the YAML decoder, which are
+// keyed by arbitrary values, to maps keyed by strings, as decoded by
+// the JSON decoder.
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[fmt.Sprint(k)] = yamlValue(elem)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = yamlValue(v[i])
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// A paramConverter converts typed parameter values, as decoded from
+// run specs, to reflow values.
+type paramConverter struct {
+	// File and Dir are reflow's file and dir functions, used to
+	// construct file and directory values from URLs. If they are
+	// nil, file and directory values are checked but left as URLs.
+	File, Dir values.Func
+	// Results contains the results of the batch's runs, as decoded
+	// from their JSON renderings, keyed by run ID. Results is used
+	// to resolve references to the results of other runs. If Results
+	// is nil, references are checked against ResultType instead.
+	Results map[string]interface{}
+	// ResultType is the type of the results of the batch's runs.
+	ResultType *types.T
+
+	// extra tells whether structs may contain fields beyond those of
+	// their types, as do run results that are subtypes of the
+	// parameters to which they are passed.
+	extra bool
+}
+
+// newParamConverter returns a paramConverter that constructs file
+// and directory values using the functions defined in the session
+// sess.
+func newParamConverter(sess *syntax.Session) paramConverter {
+	return paramConverter{
+		File: sess.Values.Value("file").(values.Func),
+		Dir:  sess.Values.Value("dir").(values.Func),
+	}
+}
+
+// Params converts the typed parameters given to values, checking
+// them against the module parameters params. Parameters may be
+// overridden by the command line flags in args, which must name
+// parameters of string, int, float, bool, file, or dir type.
+// Params returns an error if given or args name a parameter that
+// is not defined, or if a required parameter is not provided.
+func (c paramConverter) Params(params []syntax.Param, given map[string]interface{}, args []string) (map[string]values.T, error) {
+	if err := checkDefined(params, given); err != nil {
+		return nil, err
+	}
+	overrides, err := paramFlags(params, args)
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string]values.T)
+	for _, p := range params {
+		var (
+			v   values.T
+			err error
+		)
+		if s, ok := overrides[p.Ident]; ok {
+			v, err = c.convertString(p.Ident, p.Type, s)
+		} else if g, ok := given[p.Ident]; ok {
+			v, err = c.convert(p.Ident, p.Type, g)
+		} else if p.Required {
+			return nil, errors.Errorf("missing parameter %s of type %s", p.Ident, p.Type)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals[p.Ident] = v
+	}
+	return vals, nil
+}
+
+// checkParams checks that the typed parameters given are defined by
+// the module parameters params and that they are of the correct
+// types. References to the results of other runs are checked
+// against the result type resultType, if it is not nil. Required
+// parameters may be missing, since they may be provided on the
+// command line when the batch is run.
+func checkParams(params []syntax.Param, given map[string]interface{}, resultType *types.T) error {
+	if err := checkDefined(params, given); err != nil {
+		return err
+	}
+	c := paramConverter{ResultType: resultType}
+	for _, p := range params {
+		if g, ok := given[p.Ident]; ok {
+			if _, err := c.convert(p.Ident, p.Type, g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkDefined(params []syntax.Param, given map[string]interface{}) error {
+	defined := make(map[string]bool)
+	for _, p := range params {
+		defined[p.Ident] = true
+	}
+	for _, k := range sortedKeys(given) {
+		if !defined[k] {
+			return errors.New("non-existent parameter " + k)
+		}
+	}
+	return nil
+}
+
+// paramFlags parses command line flags args for the parameters
+// params, returning the string values of the flags that were set.
+func paramFlags(params []syntax.Param, args []string) (map[string]string, error) {
+	var (
+		flags = flag.NewFlagSet("params", flag.ContinueOnError)
+		set   = make(map[string]*string)
+	)
+	flags.SetOutput(ioutil.Discard)
+	flags.Usage = func() {}
+	for _, p := range params {
+		switch p.Type.Kind {
+		case types.StringKind, types.IntKind, types.FloatKind, types.BoolKind, types.FileKind, types.DirKind:
+			set[p.Ident] = flags.String(p.Ident, "", "")
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		overrides[f.Name] = *set[f.Name]
+	})
+	return overrides, nil
+}
+
+// convertString converts the string s, as given on the command
+// line, to a value of type t.
+func (c paramConverter) convertString(path string, t *types.T, s string) (values.T, error) {
+	switch t.Kind {
+	case types.IntKind, types.FloatKind:
+		v, err := c.convert(path, t, json.Number(s))
+		if err != nil {
+			return nil, errors.Errorf("param %s: invalid %s %q", path, t, s)
+		}
+		return v, nil
+	case types.BoolKind:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.Errorf("param %s: invalid bool %q", path, s)
+		}
+		return b, nil
+	default:
+		return c.convert(path, t, s)
+	}
+}
+
+// convert converts the value v, as decoded from a run spec, to a
+// value of type t. Path names the value in error messages.
+func (c paramConverter) convert(path string, t *types.T, v interface{}) (values.T, error) {
+	mismatch := func() error {
+		return errors.Errorf("param %s: expected %s, got %s", path, t, describe(v))
+	}
+	if id, field, ok, err := parseRef(v); err != nil {
+		return nil, errors.Errorf("param %s: %v", path, err)
+	} else if ok {
+		return c.convertRef(path, t, id, field)
+	}
+	switch t.Kind {
+	case types.StringKind:
+		s, ok := v.(string)
+		if !ok {
+			return nil, mismatch()
+		}
+		return s, nil
+	case types.IntKind:
+		switch n := v.(type) {
+		case json.Number:
+			i, ok := new(big.Int).SetString(string(n), 10)
+			if !ok {
+				return nil, mismatch()
+			}
+			return i, nil
+		case int:
+			return big.NewInt(int64(n)), nil
+		case int64:
+			return big.NewInt(n), nil
+		case uint64:
+			return new(big.Int).SetUint64(n), nil
+		case float64:
+			if n != math.Trunc(n) || math.IsInf(n, 0) {
+				return nil, mismatch()
+			}
+			i, _ := big.NewFloat(n).Int(nil)
+			return i, nil
+		default:
+			return nil, mismatch()
+		}
+	case types.FloatKind:
+		switch n := v.(type) {
+		case json.Number:
+			f, ok := new(big.Float).SetString(string(n))
+			if !ok {
+				return nil, mismatch()
+			}
+			return f, nil
+		case int:
+			return new(big.Float).SetInt64(int64(n)), nil
+		case int64:
+			return new(big.Float).SetInt64(n), nil
+		case uint64:
+			return new(big.Float).SetUint64(n), nil
+		case float64:
+			return big.NewFloat(n), nil
+		default:
+			return nil, mismatch()
+		}
+	case types.BoolKind:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, mismatch()
+		}
+		return b, nil
+	case types.FileKind, types.DirKind:
+		url, ok := v.(string)
+		if !ok {
+			// Files and directories may also be given as rendered by
+			// values.MarshalJSON, as they are in run results.
+			return c.convertFiles(path, t, v)
+		}
+		fn := c.File
+		if t.Kind == types.DirKind {
+			fn = c.Dir
+		}
+		if fn == nil {
+			return url, nil
+		}
+		return fn.Apply(values.Location{Ident: path}, []values.T{url})
+	case types.ListKind:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+		vals := make(values.List, len(list))
+		for i := range list {
+			var err error
+			vals[i], err = c.convert(fmt.Sprintf("%s[%d]", path, i), t.Elem, list[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	case types.TupleKind:
+		list, ok := v.([]interface{})
+		if !ok || len(list) != len(t.Fields) {
+			return nil, mismatch()
+		}
+		vals := make(values.Tuple, len(list))
+		for i, f := range t.Fields {
+			var err error
+			vals[i], err = c.convert(fmt.Sprintf("%s[%d]", path, i), f.T, list[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	case types.MapKind:
+		if pairs, ok := v.([]interface{}); ok && t.Index.Kind != types.StringKind {
+			return c.convertPairs(path, t, pairs)
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+		switch t.Index.Kind {
+		case types.StringKind, types.IntKind, types.FloatKind, types.BoolKind:
+		default:
+			return nil, errors.Errorf("param %s: unsupported map key type %s", path, t.Index)
+		}
+		vals := new(values.Map)
+		for _, k := range sortedKeys(m) {
+			kpath := fmt.Sprintf("%s[%q]", path, k)
+			key, err := c.convertString(kpath, t.Index, k)
+			if err != nil {
+				return nil, err
+			}
+			val, err := c.convert(kpath, t.Elem, m[k])
+			if err != nil {
+				return nil, err
+			}
+			vals.Insert(values.Digest(key, t.Index), key, val)
+		}
+		return vals, nil
+	case types.StructKind:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+		fields := make(map[string]*types.T)
+		for _, f := range t.Fields {
+			fields[f.Name] = f.T
+		}
+		for _, k := range sortedKeys(m) {
+			if _, ok := fields[k]; !ok && !c.extra {
+				return nil, errors.Errorf("param %s: unexpected field %s in %s", path, k, t)
+			}
+		}
+		vals := make(values.Struct)
+		for _, f := range t.Fields {
+			fv, ok := m[f.Name]
+			if !ok {
+				return nil, errors.Errorf("param %s: missing field %s of %s", path, f.Name, t)
+			}
+			var err error
+			vals[f.Name], err = c.convert(path+"."+f.Name, f.T, fv)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	default:
+		return nil, errors.Errorf("param %s: unsupported type %s", path, t)
+	}
+}
+
+// convertRef resolves the reference to the field field of the
+// result of run id, converting it to a value of type t. If c has no
+// results, the reference is only checked against c.ResultType.
+func (c paramConverter) convertRef(path string, t *types.T, id, field string) (values.T, error) {
+	var names []string
+	if field != "" {
+		names = strings.Split(field, ".")
+	}
+	if c.Results == nil {
+		rt := c.ResultType
+		if rt == nil {
+			return nil, nil
+		}
+		for _, name := range names {
+			if rt = rt.Field(name); rt.Kind == types.ErrorKind {
+				return nil, errors.Errorf("param %s: result of run %s has no field %s", path, id, field)
+			}
+		}
+		if !rt.Sub(t) {
+			return nil, errors.Errorf("param %s: result of run %s has type %s, expected %s", path, id, rt, t)
+		}
+		return nil, nil
+	}
+	v, ok := c.Results[id]
+	if !ok {
+		return nil, errors.Errorf("param %s: result of run %s is not available", path, id)
+	}
+	for _, name := range names {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("param %s: result of run %s has no field %s", path, id, field)
+		}
+		if v, ok = m[name]; !ok {
+			return nil, errors.Errorf("param %s: result of run %s has no field %s", path, id, field)
+		}
+	}
+	c.extra = true
+	return c.convert(fmt.Sprintf("%s (run %s)", path, id), t, v)
+}
+
+// convertFiles converts a file or directory, as rendered by
+// values.MarshalJSON, to a value of type t.
+func (c paramConverter) convertFiles(path string, t *types.T, v interface{}) (values.T, error) {
+	if t.Kind == types.FileKind {
+		return decodeFile(path, v)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("param %s: expected %s, got %s", path, t, describe(v))
+	}
+	var dir values.Dir
+	for _, k := range sortedKeys(m) {
+		file, err := decodeFile(fmt.Sprintf("%s[%q]", path, k), m[k])
+		if err != nil {
+			return nil, err
+		}
+		dir.Set(k, file)
+	}
+	return dir, nil
+}
+
+// decodeFile decodes the JSON-rendered reflow.File v.
+func decodeFile(path string, v interface{}) (reflow.File, error) {
+	var file reflow.File
+	if _, ok := v.(map[string]interface{}); !ok {
+		return file, errors.Errorf("param %s: expected file, got %s", path, describe(v))
+	}
+	p, err := json.Marshal(v)
+	if err != nil {
+		return file, errors.Errorf("param %s: %v", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return file, errors.Errorf("param %s: invalid file: %v", path, err)
+	}
+	return file, nil
+}
+
+// convertPairs converts a map, rendered by values.MarshalJSON as a
+// list of key-value pairs, to a value of type t.
+func (c paramConverter) convertPairs(path string, t *types.T, pairs []interface{}) (values.T, error) {
+	switch t.Index.Kind {
+	case types.IntKind, types.FloatKind, types.BoolKind:
+	default:
+		return nil, errors.Errorf("param %s: unsupported map key type %s", path, t.Index)
+	}
+	m := new(values.Map)
+	for i, pair := range pairs {
+		kv, ok := pair.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, errors.Errorf("param %s[%d]: expected key-value pair, got %s", path, i, describe(pair))
+		}
+		ipath := fmt.Sprintf("%s[%d]", path, i)
+		key, err := c.convert(ipath, t.Index, kv[0])
+		if err != nil {
+			return nil, err
+		}
+		val, err := c.convert(ipath, t.Elem, kv[1])
+		if err != nil {
+			return nil, err
+		}
+		m.Insert(values.Digest(key, t.Index), key, val)
+	}
+	return m, nil
+}
+
+// describe describes the type of the decoded value v, for error
+// messages.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case json.Number, int, int64, uint64, float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("bool %v", v)
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// paramLabels renders typed parameters as strings, for use in run
+// labels. String parameters are rendered as-is; others are rendered
+// as JSON.
+func paramLabels(params map[string]interface{}) map[string]string {
+	labels := make(map[string]string, len(params))
+	for k, v := range params {
+		if s, ok := v.(string); ok {
+			labels[k] = s
+			continue
+		}
+		p, err := json.Marshal(v)
+		if err != nil {
+			labels[k] = fmt.Sprint(v)
+			continue
+		}
+		labels[k] = string(p)
+	}
+	return labels
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
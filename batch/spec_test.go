@@ -0,0 +1,261 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/syntax"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+)
+
+var (
+	pairType = types.Struct(
+		&types.Field{Name: "r1", T: types.File},
+		&types.Field{Name: "r2", T: types.File},
+	)
+	specParams = []syntax.Param{
+		{Ident: "sample", Type: types.String, Required: true},
+		{Ident: "pairs", Type: types.List(pairType), Required: true},
+		{Ident: "depth", Type: types.Int},
+		{Ident: "frac", Type: types.Float},
+		{Ident: "tags", Type: types.Map(types.String, types.Bool)},
+	}
+)
+
+func writeSpecs(t *testing.T, name, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadSpecs(t *testing.T) {
+	const (
+		jsonl = `{"id": "a", "params": {"sample": "a", "pairs": [{"r1": "s3://b/a_1.fq", "r2": "s3://b/a_2.fq"}], "depth": 30}}
+{"id": "b", "params": {"sample": "b", "pairs": [], "frac": 0.5, "tags": {"wgs": true}}, "args": ["x"]}
+`
+		yml = `- id: a
+  params:
+    sample: a
+    pairs:
+      - r1: s3://b/a_1.fq
+        r2: s3://b/a_2.fq
+    depth: 30
+- id: b
+  params:
+    sample: b
+    pairs: []
+    frac: 0.5
+    tags:
+      wgs: true
+  args: [x]
+`
+	)
+	for _, c := range []struct{ name, contents string }{{"runs.jsonl", jsonl}, {"runs.yaml", yml}} {
+		path := writeSpecs(t, c.name, c.contents)
+		defer os.RemoveAll(filepath.Dir(path))
+		specs, typed, err := readSpecs(path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !typed {
+			t.Errorf("%s: expected typed specs", c.name)
+		}
+		if got, want := len(specs), 2; got != want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, want)
+		}
+		if got, want := specs[1].Args, []string{"x"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, want)
+		}
+		var conv paramConverter
+		for _, spec := range specs {
+			if err := checkParams(specParams, spec.Params, nil); err != nil {
+				t.Errorf("%s: run %s: %v", c.name, spec.ID, err)
+			}
+		}
+		params, err := conv.Params(specParams, specs[0].Params, []string{"-depth", "40"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs := params["pairs"].(values.List)
+		if got, want := len(pairs), 1; got != want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, want)
+		}
+		if got, want := pairs[0].(values.Struct)["r2"], values.T("s3://b/a_2.fq"); got != want {
+			t.Errorf("%s: got %v, want %v", c.name, got, want)
+		}
+		if got, want := values.Sprint(params["depth"], types.Int), "40"; got != want {
+			t.Errorf("%s: got %v, want %v", c.name, got, want)
+		}
+		params, err = conv.Params(specParams, specs[1].Params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := values.Sprint(params["tags"], types.Map(types.String, types.Bool)), `["wgs": true]`; got != want {
+			t.Errorf("%s: got %v, want %v", c.name, got, want)
+		}
+	}
+}
+
+func TestReadSpecsCSV(t *testing.T) {
+	path := writeSpecs(t, "runs.csv", "id,bam,sample\n1,1.bam,a\n2,2.bam,b\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	specs, typed, err := readSpecs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed {
+		t.Error("expected untyped specs")
+	}
+	want := []RunSpec{
+		{ID: "1", Params: map[string]interface{}{"bam": "1.bam", "sample": "a"}, Args: []string{}, Deps: []string{}},
+		{ID: "2", Params: map[string]interface{}{"bam": "2.bam", "sample": "b"}, Args: []string{}, Deps: []string{}},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("got %v, want %v", specs, want)
+	}
+
+	path = writeSpecs(t, "dup.jsonl", `{"id": "a"}`+"\n"+`{"id": "a"}`+"\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	if _, _, err := readSpecs(path); err == nil || !strings.Contains(err.Error(), "duplicate run id a") {
+		t.Errorf("expected duplicate id error, got %v", err)
+	}
+}
+
+func TestParamErrors(t *testing.T) {
+	for _, c := range []struct {
+		params map[string]interface{}
+		args   []string
+		err    string
+	}{
+		{map[string]interface{}{"pairs": []interface{}{}}, nil, "missing parameter sample"},
+		{map[string]interface{}{"sample": "a", "pairs": []interface{}{}, "bogus": 1}, nil, "non-existent parameter bogus"},
+		{map[string]interface{}{"sample": 1, "pairs": []interface{}{}}, nil, "param sample: expected string, got number 1"},
+		{
+			map[string]interface{}{"sample": "a", "pairs": []interface{}{map[string]interface{}{"r1": "x"}}},
+			nil, "param pairs[0]: missing field r2",
+		},
+		{
+			map[string]interface{}{"sample": "a", "pairs": []interface{}{map[string]interface{}{"r1": "x", "r2": true}}},
+			nil, "param pairs[0].r2: expected file, got bool true",
+		},
+		{map[string]interface{}{"sample": "a", "pairs": []interface{}{}, "depth": 1.5}, nil, "param depth: expected int"},
+		{map[string]interface{}{"sample": "a", "pairs": []interface{}{}}, []string{"-depth", "x"}, `param depth: invalid int "x"`},
+		{map[string]interface{}{"sample": "a", "pairs": []interface{}{}}, []string{"-pairs", "x"}, "flag provided but not defined"},
+	} {
+		_, err := paramConverter{}.Params(specParams, c.params, c.args)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v %v: got error %v, want %q", c.params, c.args, err, c.err)
+		}
+	}
+	// Required parameters may be provided on the command line.
+	if err := checkParams(specParams, map[string]interface{}{"pairs": []interface{}{}}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResolveDeps(t *testing.T) {
+	ref := func(id string) map[string]interface{} {
+		return map[string]interface{}{"$run": id}
+	}
+	specs := []RunSpec{
+		{ID: "a"},
+		{ID: "b"},
+		{ID: "joint", Params: map[string]interface{}{"vcfs": []interface{}{ref("a"), ref("b")}}, Deps: []string{"c"}},
+		{ID: "c"},
+	}
+	if err := resolveDeps(specs); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := specs[2].Deps, []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	specs = []RunSpec{{ID: "a", Deps: []string{"x"}}}
+	if err := resolveDeps(specs); err == nil || !strings.Contains(err.Error(), "nonexistent run x") {
+		t.Errorf("expected nonexistent run error, got %v", err)
+	}
+	specs = []RunSpec{
+		{ID: "a", Deps: []string{"b"}},
+		{ID: "b", Params: map[string]interface{}{"x": ref("c")}},
+		{ID: "c", Deps: []string{"a"}},
+	}
+	if err := resolveDeps(specs); err == nil || !strings.Contains(err.Error(), "dependency cycle: a -> b -> c -> a") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+	specs = []RunSpec{{ID: "a", Params: map[string]interface{}{"x": map[string]interface{}{"$run": "a", "bogus": 1}}}}
+	if err := resolveDeps(specs); err == nil || !strings.Contains(err.Error(), "unexpected key bogus") {
+		t.Errorf("expected invalid reference error, got %v", err)
+	}
+}
+
+func TestParamRefs(t *testing.T) {
+	var (
+		vcfType    = types.Struct(&types.Field{Name: "vcf", T: types.File}, &types.Field{Name: "sample", T: types.String})
+		resultType = types.Struct(&types.Field{Name: "calls", T: vcfType}, &types.Field{Name: "qc", T: types.Float})
+		params     = []syntax.Param{{Ident: "calls", Type: types.List(vcfType), Required: true}}
+		given      = map[string]interface{}{
+			"calls": []interface{}{
+				map[string]interface{}{"$run": "a", "$field": "calls"},
+				map[string]interface{}{"$run": "b", "$field": "calls"},
+			},
+		}
+	)
+	if err := checkParams(params, given, resultType); err != nil {
+		t.Fatal(err)
+	}
+	bad := map[string]interface{}{"calls": []interface{}{map[string]interface{}{"$run": "a", "$field": "qc"}}}
+	if err := checkParams(params, bad, resultType); err == nil || !strings.Contains(err.Error(), "result of run a has type float") {
+		t.Errorf("expected type error, got %v", err)
+	}
+
+	file := reflow.File{ID: reflow.Digester.FromString("a.vcf"), Size: 123}
+	result := values.Struct{
+		"calls": values.Struct{"vcf": file, "sample": "a"},
+		"qc":    values.NewFloat(0.9),
+	}
+	p, err := values.MarshalJSON(result, resultType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	conv := paramConverter{Results: map[string]interface{}{"a": decoded, "b": decoded}}
+	vals, err := conv.Params(params, given, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := vals["calls"].(values.List)
+	if got, want := len(calls), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := calls[1].(values.Struct)["vcf"].(reflow.File), file; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	delete(conv.Results, "b")
+	if _, err := conv.Params(params, given, nil); err == nil || !strings.Contains(err.Error(), "result of run b is not available") {
+		t.Errorf("expected unavailable result error, got %v", err)
+	}
+}
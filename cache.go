@@ -25,7 +25,11 @@ type Cache interface {
 	// TODO(marius): allow the caller to maintain a lease on the desired
 	// objects so that garbage collection can (safely) be run
 	// concurrently with flows. This isn't a correctness concern (the
-	// flows may be restarted), but rather one of efficiency.
+	// flows may be restarted), but rather one of efficiency. For now,
+	// assoc lookups refresh the access times of the entries they
+	// retrieve, and collection with a retention policy (see reflow
+	// collect -policy) retains entries accessed since the start of any
+	// running evaluation.
 	Lookup(context.Context, digest.Digest) (Fileset, error)
 
 	// Transfer transmits the file objects associated with value v
@@ -18,16 +18,22 @@ import (
 	"github.com/grailbio/reflow/assoc"
 	_ "github.com/grailbio/reflow/assoc/dydbassoc"
 	_ "github.com/grailbio/reflow/ec2cluster"
+	"github.com/grailbio/reflow/events"
 	infra2 "github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/logsink"
+	"github.com/grailbio/reflow/notify"
 	"github.com/grailbio/reflow/pool"
 	_ "github.com/grailbio/reflow/repository/s3"
+	"github.com/grailbio/reflow/rest/auth"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/taskdb"
 	_ "github.com/grailbio/reflow/taskdb/dynamodbtask"
 	"github.com/grailbio/reflow/tool"
 	"github.com/grailbio/reflow/trace"
 	_ "github.com/grailbio/reflow/trace"
+	_ "github.com/grailbio/reflow/trace/chrometrace"
+	_ "github.com/grailbio/reflow/trace/otlptrace"
 	_ "github.com/grailbio/reflow/trace/xraytrace"
 )
 
@@ -95,6 +101,10 @@ func main() {
 		infra2.Tracer:     new(trace.Tracer),
 		infra2.TaskDB:     new(taskdb.TaskDB),
 		infra2.Docker:     new(infra2.DockerConfig),
+		infra2.Events:     new(events.Sink),
+		infra2.Notifier:   new(notify.Notifier),
+		infra2.LogSink:    new(logsink.Sink),
+		infra2.Auth:       new(auth.Authenticator),
 	}
 	cmd.SchemaKeys = infra.Keys{
 		infra2.AWSCreds:  "awscreds",
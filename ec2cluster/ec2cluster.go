@@ -18,6 +18,7 @@ package ec2cluster
 
 import (
 	"context"
+	cryptotls "crypto/tls"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -43,6 +44,7 @@ import (
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/pool/client"
+	"github.com/grailbio/reflow/rest/auth"
 	"golang.org/x/net/http2"
 )
 
@@ -75,6 +77,12 @@ type Cluster struct {
 	// running on the individual instances. In Cluster, this is done for
 	// liveness/health checking.
 	HTTPClient *http.Client `yaml:"-"`
+
+	// clientConfig is the TLS configuration of HTTPClient, before it
+	// is configured to present client credentials.
+	clientConfig *cryptotls.Config
+	// transport is the transport of HTTPClient.
+	transport *clientTransport
 	// Logger for cluster events.
 	Log *log.Logger `yaml:"-"`
 	// EC2 is the EC2 API instance through which EC2 calls are made.
@@ -204,7 +212,9 @@ func (c *Cluster) Init(tls tls.Certs, sess *session.Session, labels pool.Labels,
 	}
 	transport := &http.Transport{TLSClientConfig: clientConfig}
 	http2.ConfigureTransport(transport)
-	httpClient := &http.Client{Transport: transport}
+	c.clientConfig = clientConfig
+	c.transport = &clientTransport{rt: transport}
+	httpClient := &http.Client{Transport: c.transport}
 	svc := ec2.New(sess, &aws.Config{MaxRetries: aws.Int(13)})
 	if reflowVersion.Value() == "" {
 		return errors.New("no version specified in cluster configuration")
@@ -243,6 +253,42 @@ func (c *Cluster) Init(tls tls.Certs, sess *session.Session, labels pool.Labels,
 	return nil
 }
 
+// ConfigureClient configures the cluster's HTTPClient to present
+// the client credentials of authn, if any, to the cluster's
+// reflowlets, which may authenticate their clients. It may be
+// called while the cluster is in use.
+func (c *Cluster) ConfigureClient(authn auth.Authenticator) error {
+	transport := &http.Transport{TLSClientConfig: c.clientConfig.Clone()}
+	http2.ConfigureTransport(transport)
+	rt, err := auth.ClientTransport(authn, transport)
+	if err != nil {
+		return err
+	}
+	c.transport.set(rt)
+	return nil
+}
+
+// clientTransport is an http.RoundTripper whose underlying round
+// tripper may be replaced while it is in use.
+type clientTransport struct {
+	mu sync.Mutex
+	rt http.RoundTripper
+}
+
+func (t *clientTransport) set(rt http.RoundTripper) {
+	t.mu.Lock()
+	t.rt = rt
+	t.mu.Unlock()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *clientTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	rt := t.rt
+	t.mu.Unlock()
+	return rt.RoundTrip(r)
+}
+
 type waiter struct {
 	reflow.Requirements
 	ctx context.Context
@@ -0,0 +1,116 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package events defines a machine-readable stream of events that
+// describe the progress of Reflow runs. Evaluators publish events to
+// a Sink, which is configured through infra; this package provides
+// sinks that append events to a JSON-lines file (provider
+// "eventfile") and that serve them to subscribers as HTTP
+// Server-Sent Events (provider "eventserver"), e.g., to drive
+// external dashboards.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is the kind of an event.
+type Kind string
+
+const (
+	// FlowState events are published when a flow transitions to
+	// a new state.
+	FlowState Kind = "flow"
+	// TaskSubmitted events are published when a task is submitted
+	// to the scheduler.
+	TaskSubmitted Kind = "task"
+	// AllocAcquired events are published when the scheduler
+	// acquires a new alloc.
+	AllocAcquired Kind = "alloc"
+	// CacheHit events are published when a flow's result is
+	// retrieved from the cache.
+	CacheHit Kind = "cachehit"
+	// Error events are published when a flow fails.
+	Error Kind = "error"
+)
+
+// An Event describes a single occurrence in a run. Only the fields
+// that are relevant to the event's kind are set.
+type Event struct {
+	// Time is the time at which the event occurred.
+	Time time.Time `json:"time"`
+	// Kind is the event's kind.
+	Kind Kind `json:"kind"`
+	// Run is the ID of the run in which the event occurred.
+	Run string `json:"run,omitempty"`
+	// Flow is the digest of the flow to which the event pertains.
+	Flow string `json:"flow,omitempty"`
+	// Ident is the identifier of the flow's exec.
+	Ident string `json:"ident,omitempty"`
+	// Op is the flow's operation (e.g., exec, intern, extern).
+	Op string `json:"op,omitempty"`
+	// State is the flow's new state.
+	State string `json:"state,omitempty"`
+	// Task is the ID of the task to which the event pertains.
+	Task string `json:"task,omitempty"`
+	// Alloc is the ID of the alloc to which the event pertains.
+	Alloc string `json:"alloc,omitempty"`
+	// Error is the error message of an error event.
+	Error string `json:"error,omitempty"`
+}
+
+// A Sink receives published events. Publish must not block:
+// implementations that perform I/O should buffer events and drop
+// them if they cannot keep up.
+type Sink interface {
+	Publish(e Event)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Publish(e Event) {
+	for _, s := range m {
+		s.Publish(e)
+	}
+}
+
+// Multi returns a Sink that publishes each event to all of the
+// provided sinks.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+// Publish publishes the event e to the sink s, setting its time if
+// it is unset. Publish is a no-op if s is nil.
+func Publish(s Sink, e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.Publish(e)
+}
+
+// A Buffer is a Sink that retains the events published to it. It is
+// useful for testing.
+type Buffer struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Publish implements Sink.
+func (b *Buffer) Publish(e Event) {
+	b.mu.Lock()
+	b.events = append(b.events, e)
+	b.mu.Unlock()
+}
+
+// Events returns the events published to the buffer so far.
+func (b *Buffer) Events() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Event(nil), b.events...)
+}
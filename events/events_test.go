@@ -0,0 +1,116 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvents = []Event{
+	{Time: time.Unix(1, 0).UTC(), Kind: TaskSubmitted, Run: "run1", Task: "task1"},
+	{Time: time.Unix(2, 0).UTC(), Kind: FlowState, Run: "run1", Flow: "sha256:abc", State: "Running"},
+	{Time: time.Unix(3, 0).UTC(), Kind: Error, Run: "run1", Flow: "sha256:abc", Error: "failed"},
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &File{Path: filepath.Join(dir, "events.jsonl")}
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var b Buffer
+	sink := Multi(f, &b)
+	for _, e := range testEvents {
+		Publish(sink, e)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Events published after the sink is closed are dropped.
+	Publish(f, testEvents[0])
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if !reflect.DeepEqual(got, testEvents) {
+		t.Errorf("got %v, want %v", got, testEvents)
+	}
+	if got := b.Events(); !reflect.DeepEqual(got, testEvents) {
+		t.Errorf("got %v, want %v", got, testEvents)
+	}
+}
+
+// readEvents reads n server-sent events from r.
+func readEvents(t *testing.T, r *bufio.Reader, n int) (kinds []string, events []Event) {
+	t.Helper()
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			kinds = append(kinds, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			var e Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+func TestServer(t *testing.T) {
+	s := new(Server)
+	// Events published before the client subscribes are replayed.
+	s.Publish(testEvents[0])
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := bufio.NewReader(resp.Body)
+	kinds, events := readEvents(t, r, 1)
+	for _, e := range testEvents[1:] {
+		s.Publish(e)
+	}
+	moreKinds, moreEvents := readEvents(t, r, 2)
+	kinds, events = append(kinds, moreKinds...), append(events, moreEvents...)
+	if !reflect.DeepEqual(events, testEvents) {
+		t.Errorf("got %v, want %v", events, testEvents)
+	}
+	if got, want := kinds, []string{"task", "flow", "error"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package events
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sync"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+func init() {
+	infra.Register("eventfile", new(File))
+}
+
+// fileBuffer is the number of events buffered by File sinks.
+// Events are dropped when the buffer is full.
+const fileBuffer = 1024
+
+// File is a Sink that appends events to a file, as JSON objects,
+// one per line. Events are written by a separate goroutine as they
+// are published, so that the file may be followed (e.g., with tail
+// -f) while a run is in progress; events are dropped if the file
+// cannot keep up. Close flushes buffered events to the file.
+type File struct {
+	// Path is the path of the events file.
+	Path string
+
+	file   *os.File
+	buffer chan Event
+	done   chan struct{}
+	err    error
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+// Help implements infra.Provider.
+func (*File) Help() string {
+	return "configure an event sink that appends run events to a JSON-lines file"
+}
+
+// Flags implements infra.Provider.
+func (f *File) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&f.Path, "path", "reflow.events.jsonl", "path of the events file")
+}
+
+// Init implements infra.Provider. Init starts writing events.
+func (f *File) Init() error {
+	if f.Path == "" {
+		return errors.E(errors.Invalid, errors.New("eventfile: missing path"))
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.E("eventfile", f.Path, err)
+	}
+	f.file = file
+	f.buffer = make(chan Event, fileBuffer)
+	f.done = make(chan struct{})
+	go f.loop()
+	return nil
+}
+
+// Publish implements Sink. Events published after the sink is
+// closed are dropped.
+func (f *File) Publish(e Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	select {
+	case f.buffer <- e:
+	default:
+		f.dropped++
+	}
+}
+
+// Close writes any buffered events, and then syncs and closes the
+// file. Close returns the first error encountered while writing
+// events.
+func (f *File) Close() error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.buffer)
+	}
+	f.mu.Unlock()
+	<-f.done
+	return f.err
+}
+
+// loop writes events to the file until the sink is closed. Write
+// errors are logged once; subsequent events are discarded.
+func (f *File) loop() {
+	defer close(f.done)
+	var (
+		enc = json.NewEncoder(f.file)
+		err error
+	)
+	for e := range f.buffer {
+		if err != nil {
+			continue
+		}
+		if err = enc.Encode(e); err != nil {
+			log.Errorf("eventfile %s: %v", f.Path, err)
+		}
+	}
+	f.mu.Lock()
+	dropped := f.dropped
+	f.mu.Unlock()
+	if dropped > 0 {
+		log.Errorf("eventfile %s: dropped %d events", f.Path, dropped)
+	}
+	if serr := f.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		f.err = errors.E("eventfile", f.Path, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package events
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+func init() {
+	infra.Register("eventserver", new(Server))
+}
+
+const (
+	// historySize is the number of recent events that are replayed
+	// to new subscribers.
+	historySize = 1024
+	// subscriberBuffer is the number of events buffered for each
+	// subscriber. Subscribers that fall further behind are
+	// disconnected.
+	subscriberBuffer = 256
+)
+
+type subscriber struct {
+	c chan Event
+}
+
+// Server is a Sink that serves events to HTTP clients as a stream
+// of Server-Sent Events [1], at the path /events. Each event is sent
+// with its kind as the event type and its JSON encoding as data.
+// New subscribers first receive the most recent events, so that a
+// dashboard may be attached to a run that is already in progress.
+// Subscribers that cannot keep up with the stream are disconnected.
+//
+// [1] https://html.spec.whatwg.org/multipage/server-sent-events.html
+type Server struct {
+	// Addr is the address on which the server listens.
+	Addr string
+
+	mu      sync.Mutex
+	seq     int
+	history []Event
+	subs    map[*subscriber]bool
+}
+
+// Help implements infra.Provider.
+func (*Server) Help() string {
+	return "configure an event sink that serves run events as HTTP Server-Sent Events at /events"
+}
+
+// Flags implements infra.Provider.
+func (s *Server) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&s.Addr, "addr", "localhost:9091", "address on which to serve events")
+}
+
+// Init implements infra.Provider. Init starts serving events on the
+// configured address.
+func (s *Server) Init() error {
+	if s.Addr == "" {
+		return errors.E(errors.Invalid, errors.New("eventserver: missing addr"))
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return errors.E("eventserver", s.Addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/events", s)
+	go func() {
+		log.Errorf("eventserver %s: %v", s.Addr, http.Serve(l, mux))
+	}()
+	return nil
+}
+
+// Publish implements Sink.
+func (s *Server) Publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	if len(s.history) == historySize {
+		copy(s.history, s.history[1:])
+		s.history = s.history[:historySize-1]
+	}
+	s.history = append(s.history, e)
+	for sub := range s.subs {
+		select {
+		case sub.c <- e:
+		default:
+			close(sub.c)
+			delete(s.subs, sub)
+		}
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub := &subscriber{c: make(chan Event, subscriberBuffer)}
+	s.mu.Lock()
+	history := append([]Event(nil), s.history...)
+	seq := s.seq - len(history)
+	if s.subs == nil {
+		s.subs = make(map[*subscriber]bool)
+	}
+	s.subs[sub] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, sub)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	write := func(e Event) error {
+		seq++
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", seq, e.Kind, b)
+		return err
+	}
+	for _, e := range history {
+		if err := write(e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-sub.c:
+			if !ok {
+				return
+			}
+			if err := write(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -211,6 +211,52 @@ func (e ExecInspect) Runtime() time.Duration {
 	return diff
 }
 
+// An ExecPhase is a phase in the lifetime of an exec.
+type ExecPhase struct {
+	// Name is the name of the phase: "staging", "running", or
+	// "uploading".
+	Name       string
+	Start, End time.Time
+}
+
+// Phases returns the phases of the exec that can be determined from
+// its Docker timestamps: staging (pulling the exec's image and
+// staging its inputs) lasts from the exec's creation until its
+// container is created; running lasts while its container runs; and
+// uploading (installing the exec's outputs) lasts from the
+// container's completion until end, if end is nonzero. Phases whose
+// timestamps are unknown are omitted.
+func (e ExecInspect) Phases(end time.Time) []ExecPhase {
+	const dockerFmt = "2006-01-02T15:04:05.999999999Z"
+	if e.Docker.ContainerJSONBase == nil {
+		return nil
+	}
+	parse := func(s string) time.Time {
+		t, err := time.Parse(dockerFmt, s)
+		if err != nil || t.Year() <= 1 {
+			return time.Time{}
+		}
+		return t
+	}
+	var (
+		phases            []ExecPhase
+		created           = parse(e.Docker.Created)
+		started, finished time.Time
+	)
+	if state := e.Docker.State; state != nil {
+		started, finished = parse(state.StartedAt), parse(state.FinishedAt)
+	}
+	add := func(name string, start, end time.Time) {
+		if !start.IsZero() && end.After(start) {
+			phases = append(phases, ExecPhase{name, start, end})
+		}
+	}
+	add("staging", e.Created, created)
+	add("running", started, finished)
+	add("uploading", finished, end)
+	return phases
+}
+
 // Resources describes a set of labeled resources. Each resource is
 // described by a string label and assigned a value. The zero value
 // of Resources represents the resources with zeros for all labels.
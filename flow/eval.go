@@ -30,9 +30,12 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/assoc"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	infra2 "github.com/grailbio/reflow/infra"
+	"github.com/grailbio/reflow/internal/assertgen"
 	"github.com/grailbio/reflow/liveset/bloomlive"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/metrics"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/sched"
 	"github.com/grailbio/reflow/taskdb"
@@ -66,6 +69,10 @@ const (
 
 const defaultCacheLookupTimeout = 20 * time.Minute
 
+// imageAssertionTimeout bounds the time spent generating the
+// assertions of an image, which usually requires a registry query.
+const imageAssertionTimeout = time.Minute
+
 // stateStatusOrder defines the order in which differenet flow
 // statuses are rendered.
 var stateStatusOrder = []State{
@@ -125,6 +132,13 @@ type EvalConfig struct {
 	// Assert is the policy to use for asserting cached Assertions.
 	Assert reflow.Assert
 
+	// AssertImages determines whether exec results assert the digests
+	// of their images, so that cached results are invalidated when
+	// the image tags from which they were computed are updated.
+	// If set, AssertionGenerator must generate assertions in the
+	// assertgen.ImageNamespace namespace.
+	AssertImages bool
+
 	// TaskDB is the db to which run/tasks information and keepalives are maintained.
 	TaskDB taskdb.TaskDB
 
@@ -170,6 +184,12 @@ type EvalConfig struct {
 
 	// Labels is the labels for this run.
 	Labels pool.Labels
+
+	// Events is an (optional) sink to which the evaluator publishes
+	// run events: the state transitions of external flows (execs,
+	// interns, and externs), task submissions, cache hits, and
+	// errors.
+	Events events.Sink
 }
 
 // String returns a human-readable form of the evaluation configuration.
@@ -241,6 +261,12 @@ type Eval struct {
 	// used to ensure that no two flows can be computed with conflicting assertions.
 	assertions *reflow.Assertions
 
+	// imageAssertions memoizes the assertions generated for each
+	// image, so that the image's registry is queried once per
+	// evaluation.
+	imageAssertions   map[string]*reflow.Assertions
+	imageAssertionsMu sync.Mutex
+
 	// A channel indicating how much extra resources are needed
 	// in order to avoid queueing.
 	needch chan reflow.Requirements
@@ -614,7 +640,7 @@ func (e *Eval) Do(ctx context.Context) error {
 						e.Mutate(f, Unreserve(f.Reserved), Reserve(newReserved), Execing)
 						task = e.newTask(f)
 						e.Log.Printf("flow %s: OOM: re-submitting task %s with %v of memory (%v/%v)", task.FlowID.Short(), task.ID.IDShort(), data.Size(task.Config.Resources["mem"]), retries+1, maxOOMRetries)
-						e.Scheduler.Submit(task)
+						e.submit(task)
 						if err := e.taskWait(f, task, ctx); err != nil {
 							return err
 						}
@@ -638,7 +664,7 @@ func (e *Eval) Do(ctx context.Context) error {
 		// helps the scheduler better allocate underlying resources since
 		// we always submit the largest available working set.
 		if e.Scheduler != nil && len(tasks) > 0 && e.pending.NState(Lookup)+e.pending.NState(Running) == 0 {
-			e.Scheduler.Submit(tasks...)
+			e.submit(tasks...)
 			tasks = tasks[:0]
 		}
 		if root.State == Done {
@@ -682,6 +708,30 @@ func (e *Eval) Do(ctx context.Context) error {
 	return nil
 }
 
+// CacheStats returns the number of execs, interns, and externs that
+// were completed by the evaluation, and the number of those that
+// were retrieved from cache.
+func (e *Eval) CacheStats() (n, ncache int) {
+	for v := e.root.Visitor(); v.Walk(); v.Visit() {
+		if v.Parent != nil {
+			v.Push(v.Parent)
+		}
+		if v.State < Done {
+			continue
+		}
+		switch v.Op {
+		case Exec, Intern, Extern:
+		default:
+			continue
+		}
+		n++
+		if v.Cached {
+			ncache++
+		}
+	}
+	return
+}
+
 // LogSummary prints an execution summary to an io.Writer.
 func (e *Eval) LogSummary(log *log.Logger) {
 	var n int
@@ -1237,16 +1287,7 @@ func (e *Eval) eval(ctx context.Context, f *Flow) (err error) {
 
 	switch f.Op {
 	case Intern, Extern, Exec:
-		var name string
-		switch f.Op {
-		case Extern:
-			name = fmt.Sprintf("extern %s %s", f.URL, data.Size(f.Deps[0].Value.(reflow.Fileset).Size()))
-		case Intern:
-			name = fmt.Sprintf("intern %s", f.URL)
-		case Exec:
-			name = fmt.Sprintf("exec %s", f.AbbrevCmd())
-		}
-		ctx, done := trace.Start(ctx, trace.Exec, f.Digest(), name)
+		ctx, done := trace.Start(ctx, trace.Exec, f.Digest(), execTraceName(f))
 		trace.Note(ctx, "ident", f.Ident)
 		defer done()
 		if err := e.exec(ctx, f); err != nil {
@@ -1500,6 +1541,11 @@ func (e *Eval) lookupFailed(f *Flow) {
 	}
 }
 
+var (
+	cacheLookups = metrics.NewCounter("reflow_cache_lookups_total", "Number of cache lookups of flows.")
+	cacheHits    = metrics.NewCounter("reflow_cache_hits_total", "Number of cache lookups of flows that were hits.")
+)
+
 // BatchLookup performs a cache lookup of a set of flow nodes.
 func (e *Eval) batchLookup(ctx context.Context, flows ...*Flow) {
 	batch := make(assoc.Batch)
@@ -1518,6 +1564,7 @@ func (e *Eval) batchLookup(ctx context.Context, flows ...*Flow) {
 		for _, key := range keys {
 			batch.Add(assoc.Key{assoc.Fileset, key})
 		}
+		cacheLookups.With().Inc()
 		if e.Log.At(log.DebugLevel) {
 			e.Log.Debugf("cache.Lookup flow: %s (%s) keys: %s\n", f.Digest().Short(), f.Ident, strings.Join(
 				func() []string {
@@ -1651,6 +1698,8 @@ func (e *Eval) batchLookup(ctx context.Context, flows ...*Flow) {
 			// found in the cache's repository, the node will be marked for
 			// recomputation.
 			e.Mutate(f, fs, Cached, Done)
+			cacheHits.With().Inc()
+			e.publish(events.Event{Kind: events.CacheHit, Flow: f.Digest().String(), Ident: f.Ident, Op: f.Op.String()})
 			if e.BottomUp {
 				e.LogFlow(ctx, f)
 			}
@@ -1781,6 +1830,45 @@ func (e *Eval) assignExecId(ctx context.Context, f *Flow) error {
 	return nil
 }
 
+// assertImage sets the image assertions of the given Exec flow, if
+// images are asserted. Images that are referenced by digest cannot
+// change, and are not asserted. Assertions are generated at most
+// once for each image (errors are not memoized), and generation is
+// bounded by imageAssertionTimeout.
+func (e *Eval) assertImage(ctx context.Context, f *Flow) error {
+	if !e.AssertImages || f.Op != Exec || f.ImageAssertions != nil {
+		return nil
+	}
+	image := f.OriginalImage
+	if image == "" {
+		image = f.Image
+	}
+	image, _, _ = ImageQualifiers(image)
+	if strings.Contains(image, "@") {
+		return nil
+	}
+	e.imageAssertionsMu.Lock()
+	a, ok := e.imageAssertions[image]
+	e.imageAssertionsMu.Unlock()
+	if !ok {
+		ctx, cancel := context.WithTimeout(ctx, imageAssertionTimeout)
+		defer cancel()
+		var err error
+		a, err = e.AssertionGenerator.Generate(ctx, reflow.AssertionKey{Subject: image, Namespace: assertgen.ImageNamespace})
+		if err != nil {
+			return err
+		}
+		e.imageAssertionsMu.Lock()
+		if e.imageAssertions == nil {
+			e.imageAssertions = make(map[string]*reflow.Assertions)
+		}
+		e.imageAssertions[image] = a
+		e.imageAssertionsMu.Unlock()
+	}
+	f.ImageAssertions = a
+	return nil
+}
+
 // assertionsConsistent checks whether the given set of assertions
 // are consistent with the given flow's dependencies.
 //
@@ -1870,6 +1958,9 @@ func (e *Eval) exec(ctx context.Context, f *Flow) error {
 			}
 		case stateInspect:
 			f.Inspect, err = x.Inspect(ctx)
+			if err == nil {
+				tracePhases(ctx, f)
+			}
 		case stateResult:
 			r, err = x.Result(ctx)
 			if err == nil {
@@ -2020,9 +2111,15 @@ func (e *Eval) Mutate(f *Flow, muts ...interface{}) {
 		}
 	}
 	e.muGC.RUnlock()
+	if prevState != thisState {
+		e.publishState(f)
+	}
 	// Assign an ExecId for Execing (external) flows. In the scheduler case, f.ExecId is a random digest and is only set
 	// if the digest is its zero value.
 	if f.Op.External() && f.State == Execing {
+		if err := e.assertImage(context.Background(), f); err != nil {
+			f.Err = errors.Recover(errors.E("assert image", f.Digest(), errors.Temporary, err))
+		}
 		switch {
 		case e.Scheduler == nil:
 			if err := e.assignExecId(context.Background(), f); err != nil {
@@ -2363,6 +2460,16 @@ func (e *Eval) taskWait(f *Flow, task *sched.Task, ctx context.Context) error {
 		return err
 	}
 	f.Inspect = task.Inspect
+	if trace.On(ctx) && !f.Inspect.Created.IsZero() {
+		// Tasks are executed by the scheduler, so we trace their
+		// execs after the fact.
+		event := trace.Event{Time: f.Inspect.Created, Kind: trace.StartEvent, SpanKind: trace.Exec, Id: f.Digest(), Name: execTraceName(f)}
+		ectx, _ := trace.Emit(ctx, event)
+		trace.Note(ectx, "ident", f.Ident)
+		tracePhases(ectx, f)
+		event.Time, event.Kind = time.Now(), trace.EndEvent
+		trace.Emit(ectx, event)
+	}
 	if task.Err != nil {
 		e.Mutate(f, task.Err, Done)
 	} else {
@@ -2374,13 +2481,72 @@ func (e *Eval) taskWait(f *Flow, task *sched.Task, ctx context.Context) error {
 	return nil
 }
 
+// execTraceName returns the name of the trace span of the exec
+// of flow f.
+func execTraceName(f *Flow) string {
+	switch f.Op {
+	case Extern:
+		return fmt.Sprintf("extern %s %s", f.URL, data.Size(f.Deps[0].Value.(reflow.Fileset).Size()))
+	case Intern:
+		return fmt.Sprintf("intern %s", f.URL)
+	default:
+		return fmt.Sprintf("exec %s", f.AbbrevCmd())
+	}
+}
+
+// tracePhases traces the phases of the completed exec of flow f as
+// children of the span of the provided context.
+func tracePhases(ctx context.Context, f *Flow) {
+	for _, phase := range f.Inspect.Phases(time.Now()) {
+		trace.Span(ctx, trace.Phase, f.Digest(), phase.Name, phase.Start, phase.End)
+	}
+}
+
+// submit submits the provided tasks to the scheduler.
+func (e *Eval) submit(tasks ...*sched.Task) {
+	for _, task := range tasks {
+		e.publish(events.Event{Kind: events.TaskSubmitted, Flow: task.FlowID.String(), Ident: task.Config.Ident, Task: task.ID.ID()})
+	}
+	e.Scheduler.Submit(tasks...)
+}
+
+// publish publishes the event ev, which pertains to this
+// evaluation's run, to the evaluator's event sink, if any.
+func (e *Eval) publish(ev events.Event) {
+	if e.Events == nil {
+		return
+	}
+	ev.Run = e.RunID.ID()
+	events.Publish(e.Events, ev)
+}
+
+// publishState publishes the current state of the external flow f,
+// and its error, if it failed.
+func (e *Eval) publishState(f *Flow) {
+	if e.Events == nil {
+		return
+	}
+	switch f.Op {
+	case Exec, Intern, Extern:
+	default:
+		return
+	}
+	ev := events.Event{Kind: events.FlowState, Flow: f.Digest().String(), Ident: f.Ident, Op: f.Op.String(), State: f.State.String()}
+	e.publish(ev)
+	if f.State == Done && f.Err != nil {
+		ev.Kind, ev.State, ev.Error = events.Error, "", f.Err.Error()
+		e.publish(ev)
+	}
+}
+
 func (e *Eval) newTask(f *Flow) *sched.Task {
 	t := sched.NewTask()
 	t.ID = taskdb.TaskID(f.ExecId)
 	t.RunID = e.RunID
 	t.FlowID = f.Digest()
 	t.Config = f.ExecConfig()
-	t.Log = e.Log.Tee(nil, fmt.Sprintf("scheduler task %s (flow %s): ", t.ID.IDShort(), t.FlowID.Short()))
+	t.Log = e.Log.Tee(nil, fmt.Sprintf("scheduler task %s (flow %s): ", t.ID.IDShort(), t.FlowID.Short())).
+		With(log.TaskKey, t.ID.ID(), log.FlowKey, t.FlowID.String(), log.IdentKey, f.Ident)
 	return t
 }
 
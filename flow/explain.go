@@ -0,0 +1,199 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+)
+
+// ExecCacheKeys returns the physical cache keys of the exec or
+// extern described by the exec configuration cfg, ordered as in
+// Flow.CacheKeys. Since an ExecConfig does not retain its flow's
+// dependency structure, ExecCacheKeys assumes that each input
+// argument corresponds to exactly one dependency, in argument
+// order, as is the case for flows produced by package syntax.
+// Keys of flows that carry an ExtraDigest, or whose image included
+// qualifiers (e.g., "$aws"), cannot be reconstructed.
+//
+// ExecCacheKeys returns nil for configurations of other types.
+func ExecCacheKeys(cfg reflow.ExecConfig) []digest.Digest {
+	f := &Flow{Image: cfg.Image, OriginalImage: cfg.OriginalImage, Cmd: cfg.Cmd}
+	switch cfg.Type {
+	case "exec":
+		f.Op = Exec
+		f.Argmap = make([]ExecArg, len(cfg.Args))
+		for i, arg := range cfg.Args {
+			if arg.Out {
+				f.Argmap[i] = ExecArg{Out: true, Index: arg.Index}
+				continue
+			}
+			if arg.Fileset == nil {
+				return nil
+			}
+			f.Argmap[i] = ExecArg{Index: len(f.Deps)}
+			f.Deps = append(f.Deps, &Flow{Op: Val, State: Done, Value: *arg.Fileset})
+		}
+	case "extern":
+		if len(cfg.Args) != 1 || cfg.Args[0].Fileset == nil {
+			return nil
+		}
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil
+		}
+		f.Op = Extern
+		f.URL = u
+		f.Deps = []*Flow{{Op: Val, State: Done, Value: *cfg.Args[0].Fileset}}
+	default:
+		return nil
+	}
+	return f.physicalDigests()
+}
+
+// CacheLookup is the outcome of a cache lookup of a single key.
+type CacheLookup struct {
+	// Key is the cache key that was looked up.
+	Key digest.Digest
+	// Fileset is the digest of the fileset to which the key maps.
+	// It is zero if the key is not present in the assoc.
+	Fileset digest.Digest
+	// Missing holds the files of the fileset that are not present
+	// in the repository.
+	Missing []reflow.File
+	// Err is the error, if any, encountered during the lookup.
+	// Keys that are not present in the assoc have an error of
+	// kind errors.NotExist.
+	Err error
+}
+
+// Hit tells whether the lookup would have produced a cache hit.
+// Assertions are not checked.
+func (l CacheLookup) Hit() bool {
+	return l.Err == nil && len(l.Missing) == 0
+}
+
+func (l CacheLookup) String() string {
+	switch {
+	case errors.Is(errors.NotExist, l.Err) && l.Fileset.IsZero():
+		return "miss: no assoc entry"
+	case l.Err != nil:
+		return fmt.Sprintf("error: %v", l.Err)
+	case len(l.Missing) > 0:
+		return fmt.Sprintf("miss: fileset %s is missing %d file(s) from the repository", l.Fileset.Short(), len(l.Missing))
+	}
+	return fmt.Sprintf("hit: fileset %s", l.Fileset.Short())
+}
+
+// LookupCacheKeys looks up each of the provided keys as the evaluator
+// would: the key is resolved to a fileset through the assoc, and
+// the fileset's files are checked for presence in the repository.
+func LookupCacheKeys(ctx context.Context, ass assoc.Assoc, repo reflow.Repository, keys ...digest.Digest) []CacheLookup {
+	lookups := make([]CacheLookup, len(keys))
+	for i, key := range keys {
+		l := &lookups[i]
+		l.Key = key
+		_, l.Fileset, l.Err = ass.Get(ctx, assoc.Fileset, key)
+		if l.Err != nil {
+			continue
+		}
+		var fs reflow.Fileset
+		if err := unmarshal(ctx, repo, l.Fileset, &fs); err != nil {
+			l.Err = errors.E("unmarshal", l.Fileset, err)
+			continue
+		}
+		l.Missing, l.Err = missing(ctx, repo, fs.Files()...)
+	}
+	return lookups
+}
+
+// DiffExecConfig returns a human-readable description of the
+// differences between the exec configurations prev and cur that
+// bear on caching: the image, the command template, the input
+// arguments' files, their assertions, and (if resources is true)
+// the exec's resource requirements. An empty slice is returned if
+// no such differences exist.
+func DiffExecConfig(prev, cur reflow.ExecConfig, resources bool) []string {
+	var diffs []string
+	if prev.Type != cur.Type {
+		return append(diffs, fmt.Sprintf("type: %s -> %s", prev.Type, cur.Type))
+	}
+	if prev.Image != cur.Image {
+		diffs = append(diffs, fmt.Sprintf("image: %s -> %s", prev.Image, cur.Image))
+	}
+	if prev.OriginalImage != cur.OriginalImage {
+		diffs = append(diffs, fmt.Sprintf("original image: %s -> %s", prev.OriginalImage, cur.OriginalImage))
+	}
+	if prev.URL != cur.URL {
+		diffs = append(diffs, fmt.Sprintf("url: %s -> %s", prev.URL, cur.URL))
+	}
+	if prev.Cmd != cur.Cmd {
+		diffs = append(diffs, "command:\n"+indent(diffLines(prev.Cmd, cur.Cmd)))
+	}
+	if len(prev.Args) != len(cur.Args) {
+		diffs = append(diffs, fmt.Sprintf("arguments: %d -> %d", len(prev.Args), len(cur.Args)))
+	} else {
+		for i := range cur.Args {
+			p, c := prev.Args[i], cur.Args[i]
+			switch {
+			case p.Out != c.Out:
+				diffs = append(diffs, fmt.Sprintf("arg[%d]: output %v -> %v", i, p.Out, c.Out))
+			case p.Out && p.Index != c.Index:
+				diffs = append(diffs, fmt.Sprintf("arg[%d]: output index %d -> %d", i, p.Index, c.Index))
+			case !p.Out && p.Fileset != nil && c.Fileset != nil:
+				if d, ok := p.Fileset.Diff(*c.Fileset); ok {
+					diffs = append(diffs, fmt.Sprintf("arg[%d]: files:\n%s", i, indent(d)))
+				}
+				if d := reflow.PrettyDiff(p.Fileset.Assertions(), c.Fileset.Assertions()); d != "" {
+					diffs = append(diffs, fmt.Sprintf("arg[%d]: assertions:\n%s", i, indent(d)))
+				}
+			}
+		}
+	}
+	if resources && !prev.Resources.Equal(cur.Resources) {
+		diffs = append(diffs, fmt.Sprintf("resources: %s -> %s", prev.Resources, cur.Resources))
+	}
+	return diffs
+}
+
+// diffLines returns a simple line-oriented diff of a and b: lines
+// only in a are prefixed with "-", lines only in b with "+".
+func diffLines(a, b string) string {
+	alines, blines := strings.Split(a, "\n"), strings.Split(b, "\n")
+	inA, inB := make(map[string]bool), make(map[string]bool)
+	for _, l := range alines {
+		inA[l] = true
+	}
+	for _, l := range blines {
+		inB[l] = true
+	}
+	var out []string
+	for _, l := range alines {
+		if !inB[l] {
+			out = append(out, "- "+l)
+		}
+	}
+	for _, l := range blines {
+		if !inA[l] {
+			out = append(out, "+ "+l)
+		}
+	}
+	if len(out) == 0 {
+		// Only whitespace or line ordering differs.
+		out = append(out, fmt.Sprintf("- %q", a), fmt.Sprintf("+ %q", b))
+	}
+	return strings.Join(out, "\n")
+}
+
+func indent(s string) string {
+	return "\t" + strings.Replace(s, "\n", "\n\t", -1)
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package flow_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/repository"
+	op "github.com/grailbio/reflow/test/flow"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func TestExecCacheKeys(t *testing.T) {
+	a := reflow.Fileset{Map: map[string]reflow.File{".": {ID: reflow.Digester.FromString("a"), Size: 1}}}
+	b := reflow.Fileset{List: []reflow.Fileset{{Map: map[string]reflow.File{"x": {ID: reflow.Digester.FromString("b"), Size: 2}}}}}
+
+	exec := op.Exec("image@sha256:1234", "cmd %s %s %s", testutil.Resources, op.Val(a), op.Val(b))
+	exec.OriginalImage = "image:latest"
+	exec.Argmap = []flow.ExecArg{{Index: 0}, {Out: true, Index: 0}, {Index: 1}}
+	extern := op.Extern("s3://bucket/prefix", op.Val(b))
+
+	for _, f := range []*flow.Flow{exec, extern} {
+		want := flow.PhysicalDigests(f)
+		if len(want) == 0 {
+			t.Fatalf("%v: no physical digests", f)
+		}
+		if got := flow.ExecCacheKeys(f.ExecConfig()); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", f, got, want)
+		}
+	}
+	if keys := flow.ExecCacheKeys(op.Intern("s3://bucket/file").ExecConfig()); keys != nil {
+		t.Errorf("expected no keys for intern, got %v", keys)
+	}
+}
+
+func TestLookupCacheKeys(t *testing.T) {
+	ctx := context.Background()
+	var (
+		repo = testutil.NewInmemoryRepository()
+		ass  = testutil.NewInmemoryAssoc()
+	)
+	present, err := repo.Put(ctx, strings.NewReader("present"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	absent := reflow.Digester.FromString("absent")
+	var (
+		hit      = reflow.Digester.FromString("hit")
+		miss     = reflow.Digester.FromString("miss")
+		partial  = reflow.Digester.FromString("partial")
+		complete = reflow.Fileset{Map: map[string]reflow.File{"a": {ID: present, Size: 7}}}
+		broken   = reflow.Fileset{Map: map[string]reflow.File{"a": {ID: present, Size: 7}, "b": {ID: absent, Size: 6}}}
+	)
+	for key, fs := range map[digest.Digest]reflow.Fileset{hit: complete, partial: broken} {
+		id, err := repository.Marshal(ctx, repo, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ass.Store(ctx, assoc.Fileset, key, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookups := flow.LookupCacheKeys(ctx, ass, repo, hit, miss, partial)
+	if got, want := len(lookups), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if l := lookups[0]; !l.Hit() {
+		t.Errorf("%v: expected hit, got %v", l.Key, l)
+	}
+	if l := lookups[1]; l.Hit() || !errors.Is(errors.NotExist, l.Err) {
+		t.Errorf("%v: expected assoc miss, got %v", l.Key, l)
+	}
+	if l := lookups[2]; l.Hit() || len(l.Missing) != 1 || l.Missing[0].ID != absent {
+		t.Errorf("%v: expected missing file %v, got %v", l.Key, absent, l)
+	}
+}
+
+func TestDiffExecConfig(t *testing.T) {
+	fs := func(s string) *reflow.Fileset {
+		return &reflow.Fileset{Map: map[string]reflow.File{".": {ID: reflow.Digester.FromString(s), Size: 1}}}
+	}
+	prev := reflow.ExecConfig{
+		Type:      "exec",
+		Image:     "image@sha256:1",
+		Cmd:       "echo %s\ncat %s",
+		Args:      []reflow.Arg{{Fileset: fs("a")}, {Out: true}},
+		Resources: reflow.Resources{"mem": 10, "cpu": 1},
+	}
+	if diffs := flow.DiffExecConfig(prev, prev, true); len(diffs) != 0 {
+		t.Errorf("expected no diffs, got %v", diffs)
+	}
+	cur := prev
+	cur.Image = "image@sha256:2"
+	cur.Cmd = "echo %s\nhead %s"
+	cur.Args = []reflow.Arg{{Fileset: fs("b")}, {Out: true}}
+	cur.Resources = reflow.Resources{"mem": 20, "cpu": 1}
+
+	diffs := flow.DiffExecConfig(prev, cur, false)
+	if got, want := len(diffs), 3; got != want {
+		t.Fatalf("got %v, want %v: %v", got, want, diffs)
+	}
+	for i, prefix := range []string{"image: ", "command:\n\t- cat %s\n\t+ head %s", "arg[0]: files:"} {
+		if !strings.HasPrefix(diffs[i], prefix) {
+			t.Errorf("diff %d: got %q, want prefix %q", i, diffs[i], prefix)
+		}
+	}
+	if got, want := len(flow.DiffExecConfig(prev, cur, true)), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
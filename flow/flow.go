@@ -366,6 +366,11 @@ type Flow struct {
 	// NonDeterministic, in the case of Execs, denotes if the exec is non-deterministic.
 	NonDeterministic bool
 
+	// ImageAssertions, in the case of Execs, asserts the digest of
+	// the exec's image. It is set by the evaluator when images are
+	// asserted.
+	ImageAssertions *reflow.Assertions
+
 	digestOnce sync.Once
 	digest     digest.Digest
 }
@@ -812,6 +817,9 @@ func (f *Flow) depAssertions() []*reflow.Assertions {
 			}
 			depAs = append(depAs, f.Deps[earg.Index].Value.(reflow.Fileset).Assertions()...)
 		}
+		if f.ImageAssertions != nil {
+			depAs = append(depAs, f.ImageAssertions)
+		}
 	}
 	return depAs
 }
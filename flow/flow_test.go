@@ -153,6 +153,10 @@ func TestDepAssertions(t *testing.T) {
 	ex2 := op.Extern("externurl", e2)
 	mExecs := op.Merge(e1, e2)
 	exM := op.Extern("externurl", mExecs)
+	e3 := op.Exec("image", "cmd3", reflow.Resources{"mem": 10, "cpu": 1, "disk": 110}, i1)
+	imageA := reflow.AssertionsFromEntry(reflow.AssertionKey{Subject: "image", Namespace: "docker"}, map[string]string{"digest": "sha256:1234"})
+	e3.ImageAssertions = imageA
+	e3A, _ := reflow.MergeAssertions(i1A, imageA)
 
 	tests := []struct {
 		f    *flow.Flow
@@ -162,6 +166,7 @@ func TestDepAssertions(t *testing.T) {
 		{i1, nil, false}, {i2, nil, false}, {mInterns, nil, false},
 		{e1, i1A, false}, {e2, i2A, false}, {mExecs, nil, false},
 		{ex1, e1A, false}, {ex2, e2A, false}, {exM, nil, false},
+		{e3, e3A, false},
 	}
 	for _, tt := range tests {
 		got, gotE := reflow.MergeAssertions(flow.DepAssertions(tt.f)...)
@@ -51,6 +51,10 @@ const (
 	Tracer     = "tracer"
 	TaskDB     = "taskdb"
 	Docker     = "docker"
+	Events     = "events"
+	Notifier   = "notifier"
+	LogSink    = "logsink"
+	Auth       = "auth"
 )
 
 // User is the infrastructure provider for username.
@@ -284,7 +288,8 @@ func (c *CacheProviderReadWrite) Init() error {
 // Logger is the infra provider for logger.
 type Logger struct {
 	*log.Logger
-	level string
+	level  string
+	format string
 }
 
 // Help implements infra.Provider
@@ -315,12 +320,20 @@ func (l *Logger) Init() error {
 		logflags = golog.LstdFlags
 		logprefix = ""
 	}
-	l.Logger = log.New(golog.New(os.Stderr, logprefix, logflags), level)
+	switch l.format {
+	case "text":
+		l.Logger = log.New(golog.New(os.Stderr, logprefix, logflags), level)
+	case "json":
+		l.Logger = log.New(log.NewJSONOutputter(os.Stderr), level)
+	default:
+		return fmt.Errorf("unrecognized log format %v", l.format)
+	}
 	return nil
 }
 
 func (l *Logger) Flags(flags *flag.FlagSet) {
 	flags.StringVar(&l.level, "level", "info", "level of logging: off, error, info, debug.")
+	flags.StringVar(&l.format, "format", "text", "format of log messages: text (human readable) or json (one object per line, including the messages' fields).")
 }
 
 // KV is provider that takes a semicolon separated key=value list.
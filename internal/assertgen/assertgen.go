@@ -0,0 +1,113 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package assertgen implements reflow.AssertionGenerators for
+// subjects that are not stored in blob stores: Docker images, whose
+// tags may be updated to refer to different image digests, and
+// resources served over HTTP(S), which may be changed in place.
+//
+// Cached results carrying assertions in these namespaces are
+// invalidated (under an exact assertion policy) when the image
+// tags or web resources from which they were computed change.
+package assertgen
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+)
+
+const (
+	// ImageNamespace is the namespace of assertions on Docker
+	// images. The subject of an image assertion is an image
+	// reference; its "digest" property is the digest of the
+	// image to which the reference resolves.
+	ImageNamespace = "docker"
+
+	// HTTPNamespace and HTTPSNamespace are the namespaces of
+	// assertions on resources served over HTTP and HTTPS,
+	// respectively. The subject of an HTTP(S) assertion is the
+	// resource's URL; its properties are the resource's "etag",
+	// "last-modified" time, and "size", as reported by its server.
+	HTTPNamespace  = "http"
+	HTTPSNamespace = "https"
+)
+
+// ImageGenerator generates assertions on the digests of Docker
+// images.
+type ImageGenerator struct {
+	// Resolve resolves an image reference to a digest reference
+	// of the form name@sha256:<hex>, typically by querying the
+	// image's registry.
+	Resolve func(ctx context.Context, image string) (string, error)
+}
+
+// Generate implements reflow.AssertionGenerator.
+func (g ImageGenerator) Generate(ctx context.Context, key reflow.AssertionKey) (*reflow.Assertions, error) {
+	if key.Namespace != ImageNamespace {
+		return nil, fmt.Errorf("unsupported namespace: %v", key.Namespace)
+	}
+	ref, err := g.Resolve(ctx, key.Subject)
+	if err != nil {
+		return nil, errors.E("generate", key.Subject, err)
+	}
+	i := strings.LastIndex(ref, "@")
+	if i < 0 {
+		return nil, errors.E("generate", key.Subject, errors.Invalid, errors.Errorf("image resolved to %s, which is not a digest reference", ref))
+	}
+	return reflow.AssertionsFromEntry(key, map[string]string{"digest": ref[i+1:]}), nil
+}
+
+// HTTPGenerator generates assertions on resources served over
+// HTTP(S). Properties are retrieved by HEAD requests.
+type HTTPGenerator struct {
+	// Client is the HTTP client used to make requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Generate implements reflow.AssertionGenerator.
+func (g HTTPGenerator) Generate(ctx context.Context, key reflow.AssertionKey) (*reflow.Assertions, error) {
+	if key.Namespace != HTTPNamespace && key.Namespace != HTTPSNamespace {
+		return nil, fmt.Errorf("unsupported namespace: %v", key.Namespace)
+	}
+	req, err := http.NewRequest("HEAD", key.Subject, nil)
+	if err != nil {
+		return nil, errors.E("generate", key.Subject, errors.Invalid, err)
+	}
+	if req.URL.Scheme != key.Namespace {
+		return nil, errors.E("generate", key.Subject, errors.Invalid, errors.Errorf("scheme does not match namespace %s", key.Namespace))
+	}
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.E("generate", key.Subject, errors.Unavailable, err)
+	}
+	resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return nil, errors.E("generate", key.Subject, errors.NotExist)
+	case code < 200 || code > 299:
+		return nil, errors.E("generate", key.Subject, errors.Errorf("HEAD: %s", resp.Status))
+	}
+	m := make(map[string]string, 3)
+	if etag := resp.Header.Get("Etag"); etag != "" {
+		m["etag"] = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		m["last-modified"] = lastModified
+	}
+	if resp.ContentLength >= 0 {
+		m["size"] = strconv.FormatInt(resp.ContentLength, 10)
+	}
+	return reflow.AssertionsFromEntry(key, m), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package assertgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+)
+
+// resource serves a single resource, at /data.txt, whose entity tag
+// may be changed.
+type resource struct {
+	mu   sync.Mutex
+	etag string
+}
+
+func (r *resource) setETag(etag string) {
+	r.mu.Lock()
+	r.etag = etag
+	r.mu.Unlock()
+}
+
+func (r *resource) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/data.txt" {
+		http.NotFound(w, req)
+		return
+	}
+	r.mu.Lock()
+	w.Header().Set("Etag", r.etag)
+	r.mu.Unlock()
+	w.Header().Set("Last-Modified", "Wed, 18 Mar 2020 12:00:00 GMT")
+	w.Header().Set("Content-Length", "5")
+	if req.Method != "HEAD" {
+		w.Write([]byte("hello"))
+	}
+}
+
+func TestHTTPGenerator(t *testing.T) {
+	for _, c := range []struct {
+		namespace string
+		newServer func(http.Handler) *httptest.Server
+	}{
+		{HTTPNamespace, httptest.NewServer},
+		{HTTPSNamespace, httptest.NewTLSServer},
+	} {
+		t.Run(c.namespace, func(t *testing.T) {
+			ctx := context.Background()
+			res := &resource{etag: `"v1"`}
+			srv := c.newServer(res)
+			defer srv.Close()
+
+			g := HTTPGenerator{Client: srv.Client()}
+			key := reflow.AssertionKey{Subject: srv.URL + "/data.txt", Namespace: c.namespace}
+			before, err := g.Generate(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := reflow.AssertionsFromEntry(key, map[string]string{
+				"etag":          `"v1"`,
+				"last-modified": "Wed, 18 Mar 2020 12:00:00 GMT",
+				"size":          "5",
+			})
+			if !before.Equal(want) {
+				t.Errorf("got %v, want %v", before, want)
+			}
+			res.setETag(`"v2"`)
+			after, err := g.Generate(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflow.AssertExact(ctx, []*reflow.Assertions{after}, []*reflow.Assertions{before}) {
+				t.Error("assertions on a modified resource must not match")
+			}
+			if _, err := g.Generate(ctx, reflow.AssertionKey{Subject: srv.URL + "/missing", Namespace: c.namespace}); !errors.Is(errors.NotExist, err) {
+				t.Errorf("expected NotExist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPGeneratorNamespace(t *testing.T) {
+	ctx := context.Background()
+	var g HTTPGenerator
+	if _, err := g.Generate(ctx, reflow.AssertionKey{Subject: "s3://bucket/key", Namespace: "blob"}); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := g.Generate(ctx, reflow.AssertionKey{Subject: "http://example.com/data.txt", Namespace: HTTPSNamespace}); !errors.Is(errors.Invalid, err) {
+		t.Errorf("expected Invalid, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package cachearchive implements a self-describing archive format
+// for moving Reflow cache entries (assoc mappings together with the
+// repository objects they reference) between installations.
+//
+// An archive is a tar stream. Its first entry, named "MANIFEST", is
+// a JSON-encoded Manifest that lists every mapping and object carried
+// by the archive. The manifest is followed by one entry per object,
+// named "objects/<digest>", containing the object's contents.
+package cachearchive
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
+)
+
+const (
+	// Version is the archive format version written by Exporter.
+	Version = 1
+
+	manifestName = "MANIFEST"
+	objectsDir   = "objects"
+)
+
+// Kinds is the set of assoc kinds that are exported.
+var Kinds = []assoc.Kind{assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle}
+
+// Mapping is a single assoc entry carried by an archive.
+type Mapping struct {
+	// Kind is the kind of the mapping.
+	Kind assoc.Kind
+	// Key is the mapping's key.
+	Key digest.Digest
+	// Value is the mapping's value; it names an object in the archive.
+	Value digest.Digest
+}
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	// Version is the archive format version.
+	Version int
+	// Created is the time at which the archive was created.
+	Created time.Time
+	// Mappings is the set of assoc mappings carried by the archive.
+	Mappings []Mapping
+	// Objects is the set of repository objects carried by the archive,
+	// in the order in which they appear.
+	Objects []reflow.File
+}
+
+// Exporter gathers cache entries from an assoc and repository and
+// writes them to an archive.
+type Exporter struct {
+	// Assoc is the assoc from which mappings are read.
+	Assoc assoc.Assoc
+	// Repository is the repository from which objects are read.
+	Repository reflow.Repository
+	// Log is used to report progress.
+	Log *log.Logger
+
+	mappings []Mapping
+	objects  map[digest.Digest]bool
+}
+
+// Add looks up key k for every exported kind and adds the mappings
+// found, along with the objects they reference, to the exporter.
+// Add returns the number of mappings found; keys for which no
+// mapping exists are not an error.
+func (e *Exporter) Add(ctx context.Context, k digest.Digest) (int, error) {
+	if e.objects == nil {
+		e.objects = make(map[digest.Digest]bool)
+	}
+	var n int
+	for _, kind := range Kinds {
+		kexp, v, err := e.Assoc.Get(ctx, kind, k)
+		if errors.Is(errors.NotExist, err) {
+			continue
+		}
+		if err != nil {
+			return n, errors.E("export", k, err)
+		}
+		if v.IsZero() {
+			continue
+		}
+		if kind == assoc.Fileset {
+			var fs reflow.Fileset
+			if err := repository.Unmarshal(ctx, e.Repository, v, &fs); err != nil {
+				if errors.Is(errors.NotExist, err) {
+					e.Log.Printf("export %v: fileset %v missing from repository; skipping", kexp, v)
+					continue
+				}
+				return n, errors.E("export", kexp, v, err)
+			}
+			for _, file := range fs.Files() {
+				if file.IsRef() {
+					continue
+				}
+				e.objects[file.ID] = true
+			}
+		}
+		e.objects[v] = true
+		e.mappings = append(e.mappings, Mapping{Kind: kind, Key: kexp, Value: v})
+		n++
+	}
+	return n, nil
+}
+
+// Len returns the number of mappings and objects currently gathered
+// by the exporter.
+func (e *Exporter) Len() (mappings, objects int) {
+	return len(e.mappings), len(e.objects)
+}
+
+// Write writes the archive of the gathered mappings and objects to w.
+func (e *Exporter) Write(ctx context.Context, w io.Writer) error {
+	ids := make([]digest.Digest, 0, len(e.objects))
+	for id := range e.objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	manifest := Manifest{
+		Version:  Version,
+		Created:  time.Now(),
+		Mappings: e.mappings,
+		Objects:  make([]reflow.File, len(ids)),
+	}
+	for i, id := range ids {
+		file, err := e.Repository.Stat(ctx, id)
+		if err != nil {
+			return errors.E("export", id, err)
+		}
+		file.ID = id
+		manifest.Objects[i] = file
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(b)),
+		ModTime: manifest.Created,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	for i, file := range manifest.Objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(objectsDir, file.ID.String()),
+			Mode:    0644,
+			Size:    file.Size,
+			ModTime: manifest.Created,
+		}); err != nil {
+			return err
+		}
+		rc, err := e.Repository.Get(ctx, file.ID)
+		if err != nil {
+			return errors.E("export", file.ID, err)
+		}
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return errors.E("export", file.ID, err)
+		}
+		if (i+1)%1000 == 0 {
+			e.Log.Debugf("exported %d of %d objects", i+1, len(manifest.Objects))
+		}
+	}
+	return tw.Close()
+}
+
+// Stats reports the outcome of an import.
+type Stats struct {
+	// ObjectsWritten and ObjectsSkipped count objects that were,
+	// respectively, written to the repository and already present.
+	ObjectsWritten, ObjectsSkipped int
+	// BytesWritten is the number of object bytes written to the repository.
+	BytesWritten int64
+	// MappingsWritten and MappingsSkipped count assoc mappings that
+	// were, respectively, stored and already present.
+	MappingsWritten, MappingsSkipped int
+	// MappingsConflicting counts assoc mappings whose keys were
+	// already mapped to different values. They are skipped unless
+	// the importer overwrites existing mappings.
+	MappingsConflicting int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("objects: %d written (%d bytes), %d skipped; mappings: %d written, %d skipped, %d conflicting",
+		s.ObjectsWritten, s.BytesWritten, s.ObjectsSkipped, s.MappingsWritten, s.MappingsSkipped, s.MappingsConflicting)
+}
+
+// Importer reads archives and installs their contents into an
+// assoc and repository.
+type Importer struct {
+	// Assoc is the assoc into which mappings are stored.
+	Assoc assoc.Assoc
+	// Repository is the repository into which objects are stored.
+	Repository reflow.Repository
+	// Log is used to report progress.
+	Log *log.Logger
+	// DryRun, when set, validates the archive without modifying
+	// the assoc or repository.
+	DryRun bool
+	// Overwrite, when set, replaces existing mappings whose values
+	// differ from the archive's. By default they are left in place.
+	Overwrite bool
+}
+
+// Import reads an archive from r. Every object is verified against
+// its digest; objects already present in the repository are skipped.
+// Mappings are stored only after all objects have been installed, so
+// that an interrupted import never leaves mappings that refer to
+// missing objects. Mappings already present in the assoc are skipped,
+// as are keys already mapped to other values, unless im.Overwrite is
+// set.
+func (im *Importer) Import(ctx context.Context, r io.Reader) (Manifest, Stats, error) {
+	var (
+		manifest Manifest
+		stats    Stats
+		tr       = tar.NewReader(r)
+	)
+	hdr, err := tr.Next()
+	if err != nil {
+		return manifest, stats, errors.E("import", errors.Invalid, errors.Errorf("reading manifest: %v", err))
+	}
+	if hdr.Name != manifestName {
+		return manifest, stats, errors.E("import", errors.Invalid, errors.Errorf("expected %s, got %s", manifestName, hdr.Name))
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return manifest, stats, errors.E("import", errors.Invalid, err)
+	}
+	if manifest.Version != Version {
+		return manifest, stats, errors.E("import", errors.NotSupported, errors.Errorf("archive version %d", manifest.Version))
+	}
+	sizes := make(map[digest.Digest]int64, len(manifest.Objects))
+	for _, file := range manifest.Objects {
+		sizes[file.ID] = file.Size
+	}
+	present := make(map[digest.Digest]bool, len(manifest.Objects))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, stats, errors.E("import", err)
+		}
+		dir, name := path.Split(hdr.Name)
+		if path.Clean(dir) != objectsDir {
+			return manifest, stats, errors.E("import", errors.Invalid, errors.Errorf("unexpected entry %s", hdr.Name))
+		}
+		id, err := reflow.Digester.Parse(name)
+		if err != nil {
+			return manifest, stats, errors.E("import", hdr.Name, errors.Invalid, err)
+		}
+		size, ok := sizes[id]
+		if !ok {
+			return manifest, stats, errors.E("import", id, errors.Invalid, errors.New("object not in manifest"))
+		}
+		if hdr.Size != size {
+			return manifest, stats, errors.E("import", id, errors.Integrity, errors.Errorf("size %d, manifest %d", hdr.Size, size))
+		}
+		if _, err := im.Repository.Stat(ctx, id); err == nil {
+			stats.ObjectsSkipped++
+			present[id] = true
+			continue
+		} else if !errors.Is(errors.NotExist, err) {
+			return manifest, stats, errors.E("import", id, err)
+		}
+		if im.DryRun {
+			w := reflow.Digester.NewWriter()
+			if _, err := io.Copy(w, tr); err != nil {
+				return manifest, stats, errors.E("import", id, err)
+			}
+			if got := w.Digest(); got != id {
+				return manifest, stats, errors.E("import", id, errors.Integrity, errors.Errorf("content digest %v", got))
+			}
+		} else {
+			got, err := im.Repository.Put(ctx, tr)
+			if err != nil {
+				return manifest, stats, errors.E("import", id, err)
+			}
+			if got != id {
+				return manifest, stats, errors.E("import", id, errors.Integrity, errors.Errorf("content digest %v", got))
+			}
+		}
+		stats.ObjectsWritten++
+		stats.BytesWritten += size
+		present[id] = true
+	}
+	for _, file := range manifest.Objects {
+		if !present[file.ID] {
+			return manifest, stats, errors.E("import", file.ID, errors.Integrity, errors.New("object missing from archive"))
+		}
+	}
+	for _, m := range manifest.Mappings {
+		if !present[m.Value] {
+			return manifest, stats, errors.E("import", m.Key, m.Value, errors.Integrity, errors.New("mapping value missing from archive"))
+		}
+		_, v, err := im.Assoc.Get(ctx, m.Kind, m.Key)
+		switch {
+		case err == nil && v == m.Value:
+			stats.MappingsSkipped++
+			continue
+		case err == nil:
+			stats.MappingsConflicting++
+			if !im.Overwrite {
+				im.Log.Printf("import %v %v: existing mapping %v differs from archive's %v; skipping", m.Kind, m.Key, v, m.Value)
+				continue
+			}
+		case errors.Is(errors.NotExist, err):
+		default:
+			return manifest, stats, errors.E("import", m.Key, err)
+		}
+		if !im.DryRun {
+			if err := im.Assoc.Store(ctx, m.Kind, m.Key, m.Value); err != nil {
+				return manifest, stats, errors.E("import", m.Key, err)
+			}
+		}
+		stats.MappingsWritten++
+	}
+	return manifest, stats, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package cachearchive
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func put(t *testing.T, repo reflow.Repository, s string) reflow.File {
+	t.Helper()
+	id, err := repo.Put(context.Background(), strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reflow.File{ID: id, Size: int64(len(s))}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	var (
+		srcRepo  = testutil.NewInmemoryRepository()
+		srcAssoc = testutil.NewInmemoryAssoc()
+		key      = reflow.Digester.FromString("flow")
+		other    = reflow.Digester.FromString("unknown")
+	)
+	fs := reflow.Fileset{Map: map[string]reflow.File{
+		"a": put(t, srcRepo, "contents of a"),
+		"b": put(t, srcRepo, "contents of b"),
+	}}
+	fsid, err := repository.Marshal(ctx, srcRepo, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspect := put(t, srcRepo, `{"State": "complete"}`)
+	if err := srcAssoc.Store(ctx, assoc.Fileset, key, fsid); err != nil {
+		t.Fatal(err)
+	}
+	if err := srcAssoc.Store(ctx, assoc.ExecInspect, key, inspect.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Exporter{Assoc: srcAssoc, Repository: srcRepo}
+	if n, err := exp.Add(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n, err := exp.Add(ctx, other); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("unexpected mappings for %v", other)
+	}
+	if m, o := exp.Len(); m != 2 || o != 4 {
+		t.Errorf("got %v mappings, %v objects, want 2, 4", m, o)
+	}
+	var b bytes.Buffer
+	if err := exp.Write(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dstRepo  = testutil.NewInmemoryRepository()
+		dstAssoc = testutil.NewInmemoryAssoc()
+	)
+	// Pre-populate one object so that it is skipped.
+	put(t, dstRepo, "contents of a")
+	im := Importer{Assoc: dstAssoc, Repository: dstRepo}
+	_, stats, err := im.Import(ctx, bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats, (Stats{ObjectsWritten: 3, ObjectsSkipped: 1, BytesWritten: stats.BytesWritten, MappingsWritten: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, kind := range []assoc.Kind{assoc.Fileset, assoc.ExecInspect} {
+		_, v, err := dstAssoc.Get(ctx, kind, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, w, _ := srcAssoc.Get(ctx, kind, key)
+		if v != w {
+			t.Errorf("%v: got %v, want %v", kind, v, w)
+		}
+	}
+	for _, file := range fs.Files() {
+		if _, err := dstRepo.Stat(ctx, file.ID); err != nil {
+			t.Errorf("file %v: %v", file.ID, err)
+		}
+	}
+
+	// A second import is a no-op.
+	_, stats, err = im.Import(ctx, bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats, (Stats{ObjectsSkipped: 4, MappingsSkipped: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Conflicting mappings are left in place unless overwritten.
+	stale := put(t, dstRepo, "stale inspect")
+	if err := dstAssoc.Store(ctx, assoc.ExecInspect, key, stale.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, stats, err = im.Import(ctx, bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats, (Stats{ObjectsSkipped: 4, MappingsSkipped: 1, MappingsConflicting: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, v, err := dstAssoc.Get(ctx, assoc.ExecInspect, key); err != nil {
+		t.Fatal(err)
+	} else if v != stale.ID {
+		t.Errorf("got %v, want %v", v, stale.ID)
+	}
+	im.Overwrite = true
+	_, stats, err = im.Import(ctx, bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats, (Stats{ObjectsSkipped: 4, MappingsSkipped: 1, MappingsWritten: 1, MappingsConflicting: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, v, err := dstAssoc.Get(ctx, assoc.ExecInspect, key); err != nil {
+		t.Fatal(err)
+	} else if v != inspect.ID {
+		t.Errorf("got %v, want %v", v, inspect.ID)
+	}
+}
+
+func TestImportCorrupt(t *testing.T) {
+	ctx := context.Background()
+	var (
+		srcRepo  = testutil.NewInmemoryRepository()
+		srcAssoc = testutil.NewInmemoryAssoc()
+		key      = reflow.Digester.FromString("flow")
+	)
+	file := put(t, srcRepo, "precious data")
+	if err := srcAssoc.Store(ctx, assoc.Logs, key, file.ID); err != nil {
+		t.Fatal(err)
+	}
+	exp := Exporter{Assoc: srcAssoc, Repository: srcRepo}
+	if _, err := exp.Add(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := exp.Write(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := bytes.Replace(b.Bytes(), []byte("precious data"), []byte("precious dada"), 1)
+
+	var (
+		dstRepo  = testutil.NewInmemoryRepository()
+		dstAssoc = testutil.NewInmemoryAssoc()
+	)
+	im := Importer{Assoc: dstAssoc, Repository: dstRepo}
+	if _, _, err := im.Import(ctx, bytes.NewReader(corrupt)); !errors.Is(errors.Integrity, err) {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+	if _, _, err := dstAssoc.Get(ctx, assoc.Logs, key); !errors.Is(errors.NotExist, err) {
+		t.Errorf("expected no mapping, got %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package scrub implements integrity checking of a Reflow cache.
+//
+// A scrub proceeds in two phases. First, repository objects are
+// enumerated (in digest order) and a sample of them is read back
+// and re-digested; objects whose contents do not match their names
+// are corrupt. Second, every fileset mapping in the assoc is checked
+// for completeness: the fileset itself, and every object it refers
+// to, must be present (and not corrupt) in the repository. Mappings
+// that fail this check would produce cache misses or, worse,
+// corrupt inputs, and may be removed so that they are recomputed.
+//
+// The progress of both phases is checkpointed periodically, so that
+// an interrupted scrub may be resumed.
+package scrub
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
+	"github.com/willf/bloom"
+	"golang.org/x/time/rate"
+)
+
+// Stats reports the outcome of a scrub.
+type Stats struct {
+	// ObjectsScanned is the number of repository objects enumerated.
+	ObjectsScanned int64
+	// ObjectsVerified is the number of objects that were read back
+	// and re-digested, and BytesVerified the number of bytes read.
+	ObjectsVerified, BytesVerified int64
+	// ObjectsCorrupt is the number of objects whose contents do not
+	// match their digest; ObjectsDeleted is the number of these that
+	// were removed from the repository.
+	ObjectsCorrupt, ObjectsDeleted int64
+	// MappingsChecked is the number of fileset mappings checked.
+	MappingsChecked int64
+	// MappingsIncomplete is the number of fileset mappings that refer
+	// to missing or corrupt objects; MappingsDeleted is the number of
+	// these that were removed from the assoc.
+	MappingsIncomplete, MappingsDeleted int64
+	// Errors is the number of objects or mappings that could not be
+	// checked because of an error.
+	Errors int64
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("objects: %d scanned, %d verified (%d bytes), %d corrupt, %d deleted; "+
+		"mappings: %d checked, %d incomplete, %d deleted; errors: %d",
+		s.ObjectsScanned, s.ObjectsVerified, s.BytesVerified, s.ObjectsCorrupt, s.ObjectsDeleted,
+		s.MappingsChecked, s.MappingsIncomplete, s.MappingsDeleted, s.Errors)
+}
+
+const (
+	// falsePositiveRate is the false positive rate of the bloom
+	// filters used by the mapping phase.
+	falsePositiveRate = 1e-6
+	// defaultMappings is the number of mappings assumed when the
+	// assoc cannot be counted, and minMappings the least number
+	// for which bloom filters are sized.
+	defaultMappings = 1 << 20
+	minMappings     = 1 << 10
+)
+
+// Progress records the state of a scrub so that an interrupted
+// scrub may be resumed.
+type Progress struct {
+	// Cursor is the last object examined by the object phase.
+	// Objects that sort at or before Cursor are skipped when the
+	// scrub is resumed.
+	Cursor digest.Digest
+	// ObjectsDone is set once the object phase has completed.
+	ObjectsDone bool
+	// Corrupt holds the corrupt objects found so far.
+	Corrupt []digest.Digest
+	// Checked is the set of keys of the fileset mappings checked so
+	// far by the mapping phase. Mappings in Checked are skipped when
+	// the scrub is resumed. Since Checked is a bloom filter, a
+	// resumed scrub may (very rarely) skip an unchecked mapping.
+	Checked *bloom.BloomFilter
+	// Incomplete holds the keys of the incomplete fileset mappings
+	// found so far.
+	Incomplete []digest.Digest
+	// Stats holds the statistics accumulated so far.
+	Stats Stats
+}
+
+// Scrubber checks the integrity of a repository and the fileset
+// mappings in an assoc that refer to it.
+type Scrubber struct {
+	// Repository is the repository to scrub. The object phase is
+	// performed only if the repository implements repository.Scanner.
+	Repository reflow.Repository
+	// Assoc is the assoc whose fileset mappings are checked. If nil,
+	// only the object phase is performed.
+	Assoc assoc.Assoc
+	// Log is used to report progress and problems.
+	Log *log.Logger
+	// Sample is the fraction of objects that are verified in the
+	// object phase. Sampling is deterministic in the object digest,
+	// so that repeated (or resumed) scrubs verify the same objects.
+	// Values outside (0, 1) verify every object.
+	Sample float64
+	// Limiter, if not nil, limits the rate of repository operations.
+	Limiter *rate.Limiter
+	// Repair, when set, removes corrupt objects from the repository
+	// (if it implements repository.Deleter) and incomplete fileset
+	// mappings from the assoc.
+	Repair bool
+	// Checkpoint, if not nil, is called periodically with the current
+	// progress, and at the end of each phase. A scrub is aborted if
+	// Checkpoint returns an error.
+	Checkpoint func(Progress) error
+	// CheckpointInterval is the number of objects or mappings
+	// examined between calls to Checkpoint. If zero, a default of
+	// 1000 is used.
+	CheckpointInterval int
+}
+
+// Scrub performs a scrub, starting (or resuming) from the progress p,
+// which is updated as the scrub proceeds.
+func (s *Scrubber) Scrub(ctx context.Context, p *Progress) error {
+	if !p.ObjectsDone {
+		if err := s.scrubObjects(ctx, p); err != nil {
+			return err
+		}
+		p.ObjectsDone = true
+		if err := s.checkpoint(*p); err != nil {
+			return err
+		}
+	}
+	if s.Assoc == nil {
+		return nil
+	}
+	if err := s.scrubMappings(ctx, p); err != nil {
+		return err
+	}
+	return s.checkpoint(*p)
+}
+
+func (s *Scrubber) scrubObjects(ctx context.Context, p *Progress) error {
+	scanner, ok := s.Repository.(repository.Scanner)
+	if !ok {
+		s.Log.Printf("repository %s does not support scanning; skipping object verification", s.Repository.URL())
+		return nil
+	}
+	interval := s.CheckpointInterval
+	if interval <= 0 {
+		interval = 1000
+	}
+	var (
+		cursor = p.Cursor.String()
+		n      int
+		start  = time.Now()
+	)
+	if p.Cursor.IsZero() {
+		cursor = ""
+	} else {
+		s.Log.Printf("resuming object scan after %s", p.Cursor)
+	}
+	err := scanner.Scan(ctx, func(id digest.Digest) error {
+		if id.String() <= cursor {
+			return nil
+		}
+		p.Stats.ObjectsScanned++
+		if sampled(id, s.Sample) {
+			if err := s.verify(ctx, id, p); err != nil {
+				return err
+			}
+		}
+		p.Cursor = id
+		if n++; n%interval == 0 {
+			s.Log.Debugf("scrub: %s (%s elapsed)", p.Stats, time.Since(start))
+			return s.checkpoint(*p)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.E("scrub", err)
+	}
+	return nil
+}
+
+// verify re-digests the object named by id, recording and
+// (optionally) removing it if it is corrupt.
+func (s *Scrubber) verify(ctx context.Context, id digest.Digest, p *Progress) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	rc, err := s.Repository.Get(ctx, id)
+	if errors.Is(errors.NotExist, err) {
+		// The object was removed since it was enumerated.
+		return nil
+	}
+	if err != nil {
+		s.Log.Errorf("get %s: %v", id, err)
+		p.Stats.Errors++
+		return nil
+	}
+	w := reflow.Digester.NewWriter()
+	n, err := io.Copy(w, rc)
+	rc.Close()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.Log.Errorf("read %s: %v", id, err)
+		p.Stats.Errors++
+		return nil
+	}
+	p.Stats.ObjectsVerified++
+	p.Stats.BytesVerified += n
+	got := w.Digest()
+	if got == id {
+		return nil
+	}
+	s.Log.Printf("object %s is corrupt: content digest %s (%d bytes)", id, got, n)
+	p.Stats.ObjectsCorrupt++
+	p.Corrupt = append(p.Corrupt, id)
+	if !s.Repair {
+		return nil
+	}
+	deleter, ok := s.Repository.(repository.Deleter)
+	if !ok {
+		s.Log.Errorf("repository %s does not support deletion; corrupt object %s not removed", s.Repository.URL(), id)
+		return nil
+	}
+	if err := deleter.Delete(ctx, id); err != nil {
+		s.Log.Errorf("delete %s: %v", id, err)
+		p.Stats.Errors++
+		return nil
+	}
+	p.Stats.ObjectsDeleted++
+	return nil
+}
+
+func (s *Scrubber) scrubMappings(ctx context.Context, p *Progress) error {
+	interval := s.CheckpointInterval
+	if interval <= 0 {
+		interval = 1000
+	}
+	// Use an estimate of the number of mappings in the assoc to size
+	// our bloom filters.
+	count, err := s.Assoc.Count(ctx)
+	if err != nil {
+		s.Log.Errorf("count mappings: %v; using default estimate", err)
+		count = defaultMappings
+	}
+	if count < minMappings {
+		count = minMappings
+	}
+	if p.Checked == nil {
+		p.Checked = bloom.NewWithEstimates(uint(count), falsePositiveRate)
+		p.Incomplete = nil
+		p.Stats.MappingsChecked = 0
+		p.Stats.MappingsIncomplete = 0
+		p.Stats.MappingsDeleted = 0
+	} else {
+		s.Log.Printf("resuming mapping checks after %d mappings", p.Stats.MappingsChecked)
+	}
+	var (
+		mu      sync.Mutex
+		corrupt = make(map[digest.Digest]bool, len(p.Corrupt))
+		// present records the objects known to be present. It is a
+		// bloom filter so that its size is bounded; with its low false
+		// positive rate, only very rarely is a missing object taken to
+		// be present.
+		present = bloom.NewWithEstimates(uint(count)*10, falsePositiveRate)
+		n       int
+		start   = time.Now()
+		// checkpointErr is the error returned by a checkpoint taken
+		// during the scan, which aborts the scan.
+		checkpointErr error
+	)
+	for _, id := range p.Corrupt {
+		corrupt[id] = true
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// exists tells whether the object named by id is present and not
+	// known to be corrupt. Results are memoized across mappings.
+	exists := func(id digest.Digest) (bool, error) {
+		mu.Lock()
+		if corrupt[id] {
+			mu.Unlock()
+			return false, nil
+		}
+		ok := present.Test(id.Bytes())
+		mu.Unlock()
+		if ok {
+			return true, nil
+		}
+		if err := s.wait(ctx); err != nil {
+			return false, err
+		}
+		_, err := s.Repository.Stat(ctx, id)
+		if errors.Is(errors.NotExist, err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		mu.Lock()
+		present.Add(id.Bytes())
+		mu.Unlock()
+		return true, nil
+	}
+	// check returns a description of the problem with the fileset
+	// named by fsid, or an empty string if it is complete.
+	check := func(fsid digest.Digest) (string, error) {
+		if ok, err := exists(fsid); err != nil || !ok {
+			return "fileset object missing or corrupt", err
+		}
+		if err := s.wait(ctx); err != nil {
+			return "", err
+		}
+		var fs reflow.Fileset
+		if err := repository.Unmarshal(ctx, s.Repository, fsid, &fs); err != nil {
+			if errors.Is(errors.NotExist, err) {
+				return "fileset object missing", nil
+			}
+			return "", err
+		}
+		var nmissing int
+		for _, file := range fs.Files() {
+			if file.IsRef() {
+				continue
+			}
+			ok, err := exists(file.ID)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				nmissing++
+			}
+		}
+		if nmissing > 0 {
+			return fmt.Sprintf("%d of %d objects missing or corrupt", nmissing, fs.N()), nil
+		}
+		return "", nil
+	}
+	err = s.Assoc.Scan(ctx, assoc.Fileset, assoc.MappingHandlerFunc(func(k digest.Digest, v []digest.Digest, kind assoc.Kind, _ time.Time, _ []string) {
+		if kind != assoc.Fileset || len(v) == 0 || ctx.Err() != nil {
+			return
+		}
+		mu.Lock()
+		checked := p.Checked.Test(k.Bytes())
+		mu.Unlock()
+		if checked {
+			return
+		}
+		problem, err := check(v[0])
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && ctx.Err() != nil {
+			// The mapping is rechecked if the scrub is resumed.
+			return
+		}
+		p.Stats.MappingsChecked++
+		switch {
+		case err != nil:
+			s.Log.Errorf("fileset %s (key %s): %v", v[0], k, err)
+			p.Stats.Errors++
+		case problem != "":
+			s.Log.Printf("fileset %s (key %s) is incomplete: %s", v[0], k, problem)
+			p.Stats.MappingsIncomplete++
+			p.Incomplete = append(p.Incomplete, k)
+		}
+		p.Checked.Add(k.Bytes())
+		if n++; n%interval == 0 {
+			s.Log.Debugf("scrub: checked %d mappings (%s elapsed)", p.Stats.MappingsChecked, time.Since(start))
+			if err := s.checkpoint(*p); err != nil {
+				checkpointErr = err
+				cancel()
+			}
+		}
+	}))
+	mu.Lock()
+	if checkpointErr != nil {
+		err = checkpointErr
+	}
+	mu.Unlock()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return errors.E("scrub", err)
+	}
+	if s.Repair {
+		sort.Slice(p.Incomplete, func(i, j int) bool { return p.Incomplete[i].Less(p.Incomplete[j]) })
+		for _, k := range p.Incomplete {
+			if err := s.wait(ctx); err != nil {
+				return err
+			}
+			if err := assoc.Delete(ctx, s.Assoc, assoc.Fileset, k); err != nil {
+				s.Log.Errorf("delete mapping %s: %v", k, err)
+				p.Stats.Errors++
+				continue
+			}
+			p.Stats.MappingsDeleted++
+		}
+	}
+	return nil
+}
+
+func (s *Scrubber) wait(ctx context.Context) error {
+	if s.Limiter == nil {
+		return nil
+	}
+	return s.Limiter.Wait(ctx)
+}
+
+func (s *Scrubber) checkpoint(p Progress) error {
+	if s.Checkpoint == nil {
+		return nil
+	}
+	return s.Checkpoint(p)
+}
+
+// sampled tells whether the object named by id is included in a
+// sample of the given fraction of objects. Since digests are
+// uniformly distributed, the leading bytes of a digest serve as
+// a deterministic sampling key.
+func sampled(id digest.Digest, fraction float64) bool {
+	if fraction <= 0 || fraction >= 1 {
+		return true
+	}
+	b := id.Bytes()
+	if len(b) < 8 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(b)) < fraction*math.MaxUint64
+}
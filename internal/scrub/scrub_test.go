@@ -0,0 +1,236 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+// corruptRepository returns garbage for the objects in corrupt.
+type corruptRepository struct {
+	*testutil.InmemoryRepository
+	corrupt map[digest.Digest]bool
+}
+
+func (r *corruptRepository) Get(ctx context.Context, id digest.Digest) (io.ReadCloser, error) {
+	if r.corrupt[id] {
+		return ioutil.NopCloser(bytes.NewReader([]byte("garbage"))), nil
+	}
+	return r.InmemoryRepository.Get(ctx, id)
+}
+
+type fixture struct {
+	repo              *corruptRepository
+	ass               assoc.Assoc
+	good              digest.Digest
+	corrupt, nobjects int
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	f := &fixture{
+		repo: &corruptRepository{testutil.NewInmemoryRepository(), make(map[digest.Digest]bool)},
+		ass:  testutil.NewInmemoryAssoc(),
+	}
+	put := func(s string) reflow.File {
+		id, err := f.repo.Put(ctx, strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reflow.File{ID: id, Size: int64(len(s))}
+	}
+	var (
+		a       = put("a")
+		b       = put("b")
+		c       = put("c")
+		missing = reflow.File{ID: reflow.Digester.FromString("missing"), Size: 7}
+	)
+	f.corrupt = 1
+	f.repo.corrupt[b.ID] = true
+	filesets := map[string]reflow.Fileset{
+		"good":    {Map: map[string]reflow.File{"a": a, "c": c}},
+		"corrupt": {Map: map[string]reflow.File{"a": a, "b": b}},
+		"missing": {Map: map[string]reflow.File{"c": c, "d": missing}},
+	}
+	for name, fs := range filesets {
+		id, err := repository.Marshal(ctx, f.repo, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := reflow.Digester.FromString(name)
+		if err := f.ass.Store(ctx, assoc.Fileset, key, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.good = reflow.Digester.FromString("good")
+	f.nobjects = 3 + len(filesets)
+	return f
+}
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	s := Scrubber{Repository: f.repo, Assoc: f.ass}
+	var p Progress
+	if err := s.Scrub(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		ObjectsScanned:     int64(f.nobjects),
+		ObjectsVerified:    int64(f.nobjects),
+		BytesVerified:      p.Stats.BytesVerified,
+		ObjectsCorrupt:     1,
+		MappingsChecked:    3,
+		MappingsIncomplete: 2,
+	}
+	if got := p.Stats; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Nothing is removed without Repair.
+	for _, name := range []string{"good", "corrupt", "missing"} {
+		if _, _, err := f.ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString(name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	s.Repair = true
+	p = Progress{}
+	if err := s.Scrub(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Stats.ObjectsDeleted, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := p.Stats.MappingsDeleted, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := f.repo.Stat(ctx, p.Corrupt[0]); !errors.Is(errors.NotExist, err) {
+		t.Errorf("expected corrupt object to be removed, got %v", err)
+	}
+	for _, name := range []string{"corrupt", "missing"} {
+		if _, _, err := f.ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString(name)); !errors.Is(errors.NotExist, err) {
+			t.Errorf("%s: expected mapping to be removed, got %v", name, err)
+		}
+	}
+	if _, _, err := f.ass.Get(ctx, assoc.Fileset, f.good); err != nil {
+		t.Errorf("good: %v", err)
+	}
+}
+
+func TestScrubResume(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	var saved []Progress
+	s := Scrubber{
+		Repository:         f.repo,
+		Assoc:              f.ass,
+		CheckpointInterval: 2,
+		Checkpoint: func(p Progress) error {
+			saved = append(saved, p)
+			if len(saved) == 1 {
+				return errors.New("interrupted")
+			}
+			return nil
+		},
+	}
+	var p Progress
+	if err := s.Scrub(ctx, &p); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected interruption, got %v", err)
+	}
+	p = saved[0]
+	if got, want := p.Stats.ObjectsScanned, int64(2); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := s.Scrub(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Stats.ObjectsScanned, int64(f.nobjects); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := p.Stats.ObjectsCorrupt, int64(f.corrupt); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !saved[len(saved)-1].ObjectsDone {
+		t.Error("expected final checkpoint to complete the object phase")
+	}
+}
+
+func TestScrubResumeMappings(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	var saved []Progress
+	s := Scrubber{
+		Repository:         f.repo,
+		Assoc:              f.ass,
+		CheckpointInterval: 1,
+		Checkpoint: func(p Progress) error {
+			// Progress is saved as JSON, as by reflow scrub.
+			b, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			var q Progress
+			if err := json.Unmarshal(b, &q); err != nil {
+				return err
+			}
+			saved = append(saved, q)
+			if p.ObjectsDone && p.Stats.MappingsChecked == 1 {
+				return errors.New("interrupted")
+			}
+			return nil
+		},
+	}
+	var p Progress
+	if err := s.Scrub(ctx, &p); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected interruption, got %v", err)
+	}
+	p = saved[len(saved)-1]
+	if got, want := p.Stats.MappingsChecked, int64(1); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := s.Scrub(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Stats.MappingsChecked, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := p.Stats.MappingsIncomplete, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(p.Incomplete), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSampled(t *testing.T) {
+	const n = 10000
+	var count int
+	for i := 0; i < n; i++ {
+		id := reflow.Digester.FromBytes([]byte{byte(i), byte(i >> 8)})
+		if sampled(id, 0.1) {
+			count++
+		}
+		if !sampled(id, 1) {
+			t.Fatalf("%v not sampled with fraction 1", id)
+		}
+	}
+	if count < n/20 || count > n/5 {
+		t.Errorf("sampled %d of %d objects, expected about %d", count, n, n/10)
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package usage implements storage accounting for a Reflow cache.
+//
+// An accounting scans the fileset mappings of an assoc, attributing
+// the sizes of the repository objects referenced by each mapping to
+// groups along a set of dimensions: the mapping's labels, the program
+// that produced it, and the ident and image of the exec that computed
+// it. Mappings are processed as they are scanned: only per-group
+// counters are retained, and objects are deduplicated using bloom
+// filters, so that the accounting of a large cache does not require
+// memory proportional to its size. The execs that computed mappings
+// are determined from the tasks recorded in a task database.
+package usage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/taskdb"
+	"github.com/willf/bloom"
+)
+
+// A Dimension is an attribute of cache entries by which usage is
+// grouped.
+type Dimension string
+
+const (
+	// Label groups entries by each of their labels.
+	Label Dimension = "label"
+	// Program groups entries by their "program" label.
+	Program Dimension = "program"
+	// Ident groups entries by the ident of the exec that computed
+	// them.
+	Ident Dimension = "ident"
+	// Image groups entries by the image of the exec that computed
+	// them.
+	Image Dimension = "image"
+)
+
+// Dimensions are all of the supported dimensions.
+var Dimensions = []Dimension{Label, Program, Ident, Image}
+
+// ParseDimensions parses a comma-separated list of dimensions.
+func ParseDimensions(s string) ([]Dimension, error) {
+	var dims []Dimension
+	for _, name := range strings.Split(s, ",") {
+		d := Dimension(strings.TrimSpace(name))
+		var ok bool
+		for _, known := range Dimensions {
+			if d == known {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, errors.E("parsedimensions", name, errors.Invalid, errors.New("unknown dimension"))
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// Unknown is the group of entries for which the value of a dimension
+// cannot be determined.
+const Unknown = "(unknown)"
+
+// A Granularity determines the width of the time buckets in which
+// growth is reported.
+type Granularity string
+
+const (
+	Day   Granularity = "day"
+	Week  Granularity = "week"
+	Month Granularity = "month"
+)
+
+// Bucket returns the name of the time bucket containing t, which is
+// the date on which the bucket begins.
+func (g Granularity) Bucket(t time.Time) string {
+	if t.IsZero() {
+		return Unknown
+	}
+	t = t.UTC()
+	switch g {
+	case Week:
+		t = t.AddDate(0, 0, -int(t.Weekday()))
+	case Month:
+		t = t.AddDate(0, 0, 1-t.Day())
+	}
+	return t.Format("2006-01-02")
+}
+
+// Usage is the storage attributed to a group of cache entries.
+type Usage struct {
+	// Dimension and Group identify the group.
+	Dimension Dimension `json:"dimension"`
+	Group     string    `json:"group"`
+	// Entries is the number of cache entries in the group.
+	Entries int64 `json:"entries"`
+	// Bytes is the total size of the objects referenced by the
+	// group's entries. An object is counted once for each entry
+	// that refers to it.
+	Bytes int64 `json:"bytes"`
+	// UniqueBytes is the total size of the distinct objects
+	// referenced by the group's entries.
+	UniqueBytes int64 `json:"uniqueBytes"`
+	// Growth is the number of unique bytes referenced by entries
+	// in the group, by the time bucket in which the entries were
+	// created.
+	Growth map[string]int64 `json:"growth,omitempty"`
+}
+
+func (u *Usage) add(bucket string, size int64, unique bool) {
+	u.Bytes += size
+	if !unique {
+		return
+	}
+	u.UniqueBytes += size
+	if u.Growth == nil {
+		u.Growth = make(map[string]int64)
+	}
+	u.Growth[bucket] += size
+}
+
+// Report is the outcome of an accounting.
+type Report struct {
+	// Total is the usage of all entries.
+	Total Usage `json:"total"`
+	// Groups is the usage of each group, ordered by dimension and
+	// then by decreasing unique bytes.
+	Groups []Usage `json:"groups"`
+	// Errors is the number of entries that could not be accounted.
+	Errors int64 `json:"errors"`
+}
+
+// Top returns the (at most) n groups of the provided dimension that
+// use the most unique bytes. If n is not positive, all of the
+// dimension's groups are returned.
+func (r *Report) Top(d Dimension, n int) []Usage {
+	var groups []Usage
+	for _, u := range r.Groups {
+		if u.Dimension == d {
+			groups = append(groups, u)
+		}
+	}
+	if n > 0 && len(groups) > n {
+		groups = groups[:n]
+	}
+	return groups
+}
+
+// minEstimate is the minimum number of items for which bloom filters
+// are sized.
+const minEstimate = 1 << 16
+
+// Accountant accounts for the storage used by a cache.
+type Accountant struct {
+	// Assoc is the assoc whose fileset mappings are accounted.
+	Assoc assoc.Assoc
+	// Repository stores the filesets and objects.
+	Repository reflow.Repository
+	// Dimensions are the dimensions by which usage is grouped.
+	Dimensions []Dimension
+	// Granularity is the width of growth buckets.
+	Granularity Granularity
+	// TaskDB, if not nil, records the tasks that computed the
+	// mappings, by whose execs entries are grouped along the Ident
+	// and Image dimensions. Entries computed by tasks that are not
+	// found are grouped as Unknown.
+	TaskDB taskdb.TaskDB
+	// Since is the time since which the tasks in TaskDB are queried.
+	// It must be set if TaskDB is.
+	Since time.Time
+	// Log, if not nil, is used to report progress and errors.
+	Log *log.Logger
+
+	// execs indexes the most recent task of each flow by the flow's
+	// digest, which is also the key of the flow's fileset mapping.
+	execs map[digest.Digest]taskdb.Task
+
+	mu     sync.Mutex
+	seen   *bloom.BloomFilter
+	total  Usage
+	groups map[Dimension]map[string]*Usage
+	n      int64
+	errors int64
+}
+
+// Account scans the assoc and returns a usage report.
+func (a *Accountant) Account(ctx context.Context) (*Report, error) {
+	count, err := a.Assoc.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// We estimate ten objects per fileset, each of which is counted
+	// in each group to which its entry belongs, as well as in the
+	// total. False positives cause objects to be undercounted.
+	n := uint(count) * 10 * uint(2+len(a.Dimensions))
+	if n < minEstimate {
+		n = minEstimate
+	}
+	a.seen = bloom.NewWithEstimates(n, 1e-6)
+	a.total = Usage{Group: "total"}
+	a.groups = make(map[Dimension]map[string]*Usage)
+	for _, d := range a.Dimensions {
+		a.groups[d] = make(map[string]*Usage)
+	}
+	if err := a.loadTasks(ctx); err != nil {
+		return nil, err
+	}
+	err = a.Assoc.Scan(ctx, assoc.Fileset, assoc.MappingHandlerFunc(func(k digest.Digest, v []digest.Digest, kind assoc.Kind, lastAccessTime time.Time, labels []string) {
+		if kind != assoc.Fileset || len(v) == 0 {
+			return
+		}
+		if err := a.account(ctx, k, v[0], labels); err != nil {
+			a.mu.Lock()
+			a.errors++
+			a.mu.Unlock()
+			if a.Log != nil && ctx.Err() == nil {
+				a.Log.Errorf("account %v: %v", k, err)
+			}
+		}
+	}))
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Total: a.total, Errors: a.errors}
+	for _, d := range a.Dimensions {
+		var groups []Usage
+		for _, u := range a.groups[d] {
+			groups = append(groups, *u)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i].UniqueBytes == groups[j].UniqueBytes {
+				return groups[i].Group < groups[j].Group
+			}
+			return groups[i].UniqueBytes > groups[j].UniqueBytes
+		})
+		r.Groups = append(r.Groups, groups...)
+	}
+	return r, nil
+}
+
+// account attributes the fileset v of the entry k.
+func (a *Accountant) account(ctx context.Context, k, v digest.Digest, labels []string) error {
+	var fs reflow.Fileset
+	if err := repository.Unmarshal(ctx, a.Repository, v, &fs); err != nil {
+		return err
+	}
+	// The fileset object is written along with the entry, and thus
+	// dates it.
+	var created time.Time
+	if file, err := a.Repository.Stat(ctx, v); err == nil {
+		created = file.LastModified
+	}
+	groups := make(map[Dimension][]string)
+	for _, d := range a.Dimensions {
+		switch d {
+		case Label:
+			groups[d] = labels
+		case Program:
+			for _, label := range labels {
+				if strings.HasPrefix(label, "program=") {
+					groups[d] = append(groups[d], strings.TrimPrefix(label, "program="))
+				}
+			}
+		}
+	}
+	if task, ok := a.execs[k]; ok {
+		if task.Ident != "" {
+			groups[Ident] = []string{task.Ident}
+		}
+		if a.has(Image) && !task.Inspect.IsZero() {
+			var inspect reflow.ExecInspect
+			if err := repository.Unmarshal(ctx, a.Repository, task.Inspect, &inspect); err == nil {
+				if inspect.Config.Image != "" {
+					groups[Image] = []string{inspect.Config.Image}
+				}
+			} else if !errors.Is(errors.NotExist, err) {
+				return err
+			}
+		}
+	}
+	bucket := a.Granularity.Bucket(created)
+	files := fs.Files()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total.Entries++
+	for _, file := range files {
+		a.total.add(bucket, file.Size, !a.seen.TestAndAdd(file.ID.Bytes()))
+	}
+	for _, d := range a.Dimensions {
+		names := groups[d]
+		if len(names) == 0 {
+			names = []string{Unknown}
+		}
+		for _, name := range names {
+			u := a.groups[d][name]
+			if u == nil {
+				u = &Usage{Dimension: d, Group: name}
+				a.groups[d][name] = u
+			}
+			u.Entries++
+			key := []byte(string(d) + "\x00" + name + "\x00")
+			for _, file := range files {
+				u.add(bucket, file.Size, !a.seen.TestAndAdd(append(key, file.ID.Bytes()...)))
+			}
+		}
+	}
+	a.n++
+	if a.n%10000 == 0 && a.Log != nil {
+		a.Log.Debugf("accounted %d entries", a.n)
+	}
+	return nil
+}
+
+// has tells whether usage is grouped along dimension d.
+func (a *Accountant) has(d Dimension) bool {
+	for _, e := range a.Dimensions {
+		if e == d {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTasks indexes the most recent task of each flow, if usage is
+// grouped by exec.
+func (a *Accountant) loadTasks(ctx context.Context) error {
+	a.execs = make(map[digest.Digest]taskdb.Task)
+	if a.TaskDB == nil || !(a.has(Ident) || a.has(Image)) {
+		return nil
+	}
+	tasks, err := a.TaskDB.Tasks(ctx, taskdb.TaskQuery{Since: a.Since})
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if task.FlowID.IsZero() {
+			continue
+		}
+		if prev, ok := a.execs[task.FlowID]; ok && prev.Start.After(task.Start) {
+			continue
+		}
+		a.execs[task.FlowID] = task
+	}
+	if a.Log != nil {
+		a.Log.Debugf("indexed %d tasks of %d flows", len(tasks), len(a.execs))
+	}
+	return nil
+}
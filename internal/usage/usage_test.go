@@ -0,0 +1,159 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+// labeledAssoc is an assoc that reports labels for its mappings.
+type labeledAssoc struct {
+	assoc.Assoc
+	labels map[digest.Digest][]string
+}
+
+func (a *labeledAssoc) Scan(ctx context.Context, kind assoc.Kind, handler assoc.MappingHandler) error {
+	return a.Assoc.Scan(ctx, kind, assoc.MappingHandlerFunc(func(k digest.Digest, v []digest.Digest, kind assoc.Kind, lastAccessTime time.Time, labels []string) {
+		handler.HandleMapping(k, v, kind, lastAccessTime, a.labels[k])
+	}))
+}
+
+// taskDB is a task database that returns a fixed set of tasks.
+type taskDB struct {
+	taskdb.TaskDB
+	tasks []taskdb.Task
+}
+
+func (db *taskDB) Tasks(ctx context.Context, query taskdb.TaskQuery) ([]taskdb.Task, error) {
+	return db.tasks, nil
+}
+
+func put(t *testing.T, repo reflow.Repository, v interface{}) digest.Digest {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.Put(context.Background(), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestAccount(t *testing.T) {
+	ctx := context.Background()
+	var (
+		repo = testutil.NewInmemoryRepository()
+		ass  = &labeledAssoc{testutil.NewInmemoryAssoc(), make(map[digest.Digest][]string)}
+		tdb  = &taskDB{TaskDB: testutil.NewNopTaskDB()}
+		now  = time.Now()
+		a    = reflow.File{ID: reflow.Digester.FromString("a"), Size: 100}
+		b    = reflow.File{ID: reflow.Digester.FromString("b"), Size: 10}
+		c    = reflow.File{ID: reflow.Digester.FromString("c"), Size: 1}
+	)
+	for i, entry := range []struct {
+		files  []reflow.File
+		labels []string
+		image  string
+	}{
+		{[]reflow.File{a, b}, []string{"program=align", "user=x"}, "bwa"},
+		{[]reflow.File{a, c}, []string{"program=align", "user=y"}, "bwa"},
+		{[]reflow.File{c}, []string{"program=call", "user=y"}, ""},
+	} {
+		k := reflow.Digester.FromString(fmt.Sprint(i))
+		fs := reflow.Fileset{Map: make(map[string]reflow.File)}
+		for _, file := range entry.files {
+			fs.Map[file.ID.Hex()] = file
+		}
+		if err := ass.Store(ctx, assoc.Fileset, k, put(t, repo, fs)); err != nil {
+			t.Fatal(err)
+		}
+		if entry.image != "" {
+			// Only the most recent task of a flow is attributed.
+			for _, task := range []struct {
+				image string
+				start time.Time
+			}{
+				{entry.image, now},
+				{"old", now.Add(-time.Hour)},
+			} {
+				inspect := reflow.ExecInspect{Config: reflow.ExecConfig{Ident: "ident", Image: task.image}}
+				tdb.tasks = append(tdb.tasks, taskdb.Task{FlowID: k, Ident: "ident", Start: task.start, Inspect: put(t, repo, inspect)})
+			}
+		}
+		ass.labels[k] = entry.labels
+	}
+	acct := &Accountant{Assoc: ass, Repository: repo, Dimensions: Dimensions, Granularity: Month, TaskDB: tdb, Since: now.Add(-24 * time.Hour)}
+	r, err := acct.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Total, (Usage{Group: "total", Entries: 3, Bytes: 212, UniqueBytes: 111, Growth: map[string]int64{Unknown: 111}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for _, want := range []Usage{
+		{Dimension: Program, Group: "align", Entries: 2, Bytes: 211, UniqueBytes: 111},
+		{Dimension: Program, Group: "call", Entries: 1, Bytes: 1, UniqueBytes: 1},
+		{Dimension: Label, Group: "user=y", Entries: 2, Bytes: 102, UniqueBytes: 101},
+		{Dimension: Image, Group: "bwa", Entries: 2, Bytes: 211, UniqueBytes: 111},
+		{Dimension: Image, Group: Unknown, Entries: 1, Bytes: 1, UniqueBytes: 1},
+		{Dimension: Ident, Group: "ident", Entries: 2, Bytes: 211, UniqueBytes: 111},
+	} {
+		var found bool
+		for _, got := range r.Top(want.Dimension, 0) {
+			if got.Group != want.Group {
+				continue
+			}
+			found = true
+			got.Growth = nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		}
+		if !found {
+			t.Errorf("group %s %s not found", want.Dimension, want.Group)
+		}
+	}
+	for _, u := range r.Top(Image, 0) {
+		if u.Group == "old" {
+			t.Errorf("entry attributed to a superseded task: %+v", u)
+		}
+	}
+	if top := r.Top(Program, 1); len(top) != 1 || top[0].Group != "align" {
+		t.Errorf("unexpected top programs %v", top)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	tm := time.Date(2020, 3, 18, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		g    Granularity
+		want string
+	}{
+		{Day, "2020-03-18"},
+		{Week, "2020-03-15"},
+		{Month, "2020-03-01"},
+	} {
+		if got := c.g.Bucket(tm); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.g, got, c.want)
+		}
+	}
+	if got, want := Day.Bucket(time.Time{}), Unknown; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -5,8 +5,6 @@
 package local
 
 import (
-	"errors"
-	"io"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,30 +12,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/logsink"
 )
 
-// TODO(pgopal) - Put this code into a separate package and inject the
-// implementation via Config.
-
-var errDropped = errors.New("dropped log message: buffer full")
-
-type streamType string
-
-const (
-	stdout streamType = "stdout"
-	stderr            = "stderr"
-)
-
-// remoteStream is an interface to log to cloud but must be closed once all
-// the streams are done. Closing before all the streams are done writing
-// can cause panic.
-type remoteStream interface {
-	io.Closer
-	// NewStream creates a new stream for logging. Creating two remote
-	// loggers that write to the same output stream is undefined.
-	NewStream(string, streamType) (log.Outputter, error)
-}
-
 type logEntry struct {
 	stream string
 	msg    string
@@ -55,7 +32,7 @@ type cloudWatchLogs struct {
 // NewCloudWatchLogs creates a new remote logger client for Amazon
 // CloudWatchLogs. The remoteLogger client can be used to create a new stream
 // and log to them.
-func newCloudWatchLogs(client cloudwatchlogsiface.CloudWatchLogsAPI, group string) (remoteStream, error) {
+func newCloudWatchLogs(client cloudwatchlogsiface.CloudWatchLogsAPI, group string) (logsink.Sink, error) {
 	cwl := &cloudWatchLogs{client: client, group: group}
 	_, err := cwl.client.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String(group)})
 	if err != nil {
@@ -70,7 +47,7 @@ func newCloudWatchLogs(client cloudwatchlogsiface.CloudWatchLogsAPI, group strin
 }
 
 // NewStream creates new stream with the given stream prefix and type.
-func (c *cloudWatchLogs) NewStream(prefix string, sType streamType) (log.Outputter, error) {
+func (c *cloudWatchLogs) NewStream(prefix string, sType logsink.Type) (log.Outputter, error) {
 	stream := &cloudWatchLogsStream{
 		client: c,
 		name:   prefix + "/" + string(sType),
@@ -128,6 +105,6 @@ func (s *cloudWatchLogsStream) Output(calldepth int, msg string) error {
 	case s.client.buffer <- logEntry{s.name, msg}:
 		return nil
 	default:
-		return errDropped
+		return logsink.ErrDropped
 	}
 }
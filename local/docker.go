@@ -30,6 +30,7 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/metrics"
 	"github.com/grailbio/reflow/repository/filerepo"
 )
 
@@ -80,7 +81,7 @@ func newDockerExec(id digest.Digest, x *Executor, cfg reflow.ExecConfig, stdout,
 	e := &dockerExec{
 		Executor: x,
 		// Fill in from executor:
-		Log:    x.Log.Tee(nil, fmt.Sprintf("%s: ", id)),
+		Log:    x.Log.Tee(nil, fmt.Sprintf("%s: ", id)).With(log.ExecKey, x.URI()+"/"+id.Hex()),
 		repo:   x.FileRepository,
 		id:     id,
 		client: x.Client,
@@ -427,12 +428,15 @@ func (e *dockerExec) wait(ctx context.Context) (state execState, err error) {
 		if err := e.install(ctx); err != nil {
 			return execInit, err
 		}
+		execsCompleted.With(e.Config.Image, "ok").Inc()
 	// Note: /dev/kmsg only exists on linux. If the container is running on a non-linux machine isOOMSystem will
 	// always return false.
 	case e.Docker.State.OOMKilled || e.isOOMSystem():
 		e.Manifest.Result.Err = errors.Recover(errors.E("exec", e.id, errors.OOM, errors.New("killed by the OOM killer")))
+		execsCompleted.With(e.Config.Image, "oom").Inc()
 	default:
 		e.Manifest.Result.Err = errors.Recover(errors.E("exec", e.id, errors.Errorf("exited with code %d", code)))
+		execsCompleted.With(e.Config.Image, "error").Inc()
 	}
 
 	// Clean up args. TODO(marius): replace these with symlinks to sha256s also?
@@ -572,7 +576,20 @@ func (e *dockerExec) Go(ctx context.Context) {
 			}()
 		}
 	*/
+	// The exec is accounted for in the gauge of its current state
+	// while the state machine is in progress.
+	var gauge *metrics.Gauge
+	defer func() {
+		if gauge != nil {
+			gauge.Dec()
+		}
+	}()
 	for state, err := e.getState(); err == nil && state != execComplete; e.setState(state, err) {
+		if gauge != nil {
+			gauge.Dec()
+		}
+		gauge = execsGauge.With(stateLabel(state), e.Config.Image)
+		gauge.Inc()
 		switch state {
 		case execUnstarted:
 			state = execInit
@@ -28,6 +28,7 @@ import (
 	"github.com/grailbio/reflow/internal/ecrauth"
 	"github.com/grailbio/reflow/internal/walker"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/logsink"
 	"github.com/grailbio/reflow/repository/filerepo"
 	"golang.org/x/sync/errgroup"
 )
@@ -101,7 +102,7 @@ type Executor struct {
 
 	// remoteStream is the client used to write logs to a remote cloud
 	// stream.
-	remoteStream remoteStream
+	remoteStream logsink.Sink
 
 	resources reflow.Resources
 
@@ -262,13 +263,13 @@ func (e *Executor) getRemoteStreams(id digest.Digest, wantStdout, wantStderr boo
 	var err error
 	instanceID := strings.Join([]string{e.RunID, e.URI(), id.Hex()}, "/")
 	if wantStdout {
-		so, err = e.remoteStream.NewStream(instanceID, stdout)
+		so, err = e.remoteStream.NewStream(instanceID, logsink.Stdout)
 		if err != nil {
 			e.Log.Errorf("creating remote logger stream: %v", err)
 		}
 	}
 	if wantStderr {
-		se, err = e.remoteStream.NewStream(instanceID, stderr)
+		se, err = e.remoteStream.NewStream(instanceID, logsink.Stderr)
 		if err != nil {
 			e.Log.Errorf("creating remote logger stream: %v", err)
 		}
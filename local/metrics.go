@@ -0,0 +1,46 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"strings"
+
+	"github.com/grailbio/reflow/metrics"
+)
+
+var (
+	execsGauge = metrics.NewGauge("reflow_local_execs",
+		"Number of Docker execs in progress, by state and image.", "state", "image")
+	execsCompleted = metrics.NewCounter("reflow_local_execs_completed_total",
+		"Number of completed Docker execs, by image and result (ok, error, or oom).", "image", "result")
+)
+
+func init() {
+	// The blob transfer statistics are maintained as expvars; they
+	// are exposed as metrics as well.
+	metrics.NewFunc("reflow_local_blob_files",
+		"Number of blob files being transferred, by operation.",
+		metrics.GaugeType, []string{"op"}, func() []metrics.Sample {
+			return []metrics.Sample{
+				{Labels: []string{"fetching"}, Value: float64(fetchingFiles.Value())},
+				{Labels: []string{"downloading"}, Value: float64(downloadingFiles.Value())},
+				{Labels: []string{"digesting"}, Value: float64(digestingFiles.Value())},
+				{Labels: []string{"uploading"}, Value: float64(uploadingFiles.Value())},
+			}
+		})
+	metrics.NewFunc("reflow_local_blob_rate_bytes",
+		"Aggregate rate, in bytes per second, of blob interns and externs in progress.",
+		metrics.GaugeType, []string{"op"}, func() []metrics.Sample {
+			return []metrics.Sample{
+				{Labels: []string{"intern"}, Value: float64(internRate.Value())},
+				{Labels: []string{"extern"}, Value: float64(externRate.Value())},
+			}
+		})
+}
+
+// stateLabel returns the metric label for the exec state s.
+func stateLabel(s execState) string {
+	return strings.ToLower(strings.TrimPrefix(s.String(), "exec"))
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/internal/fs"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/logsink"
 	"github.com/grailbio/reflow/pool"
 )
 
@@ -82,6 +83,10 @@ type Pool struct {
 	Blob blob.Mux
 	// Log
 	Log *log.Logger
+	// LogSink, if non-nil, receives the logs of all execs in the
+	// pool. It is shared by all allocs, and is not closed by them.
+	// If nil, exec logs are streamed to CloudWatch Logs.
+	LogSink logsink.Sink
 
 	HardMemLimit bool
 
@@ -425,7 +430,9 @@ type alloc struct {
 	lastKeepalive time.Time
 	freed         bool
 	meta          pool.AllocMeta
-	remoteStream
+	// remoteStream is the alloc's own log sink, if any; it is closed
+	// when the alloc is freed.
+	remoteStream logsink.Sink
 }
 
 // NewAlloc creates a new alloc. The returned alloc is not started.
@@ -441,22 +448,28 @@ func (p *Pool) newAlloc(id string, keepalive time.Duration) *alloc {
 		AWSImage:      p.AWSImage,
 		AWSCreds:      p.AWSCreds,
 		Blob:          p.Blob,
-		Log:           p.Log.Tee(nil, id+": "),
+		Log:           p.Log.Tee(nil, id+": ").With(log.AllocKey, id),
 		HardMemLimit:  p.HardMemLimit,
 	}
 
-	// TODO(pgopal) - Get this info from Config.
-	cwlclient := cloudwatchlogs.New(
-		session.New(
-			&aws.Config{
-				Credentials: e.AWSCreds,
-				Region:      aws.String(defaultRegion),
-			}))
-	remoteStream, err := newCloudWatchLogs(cwlclient, "reflow")
-	if err != nil {
-		log.Errorf("create remote logger: %v", err)
+	var remoteStream logsink.Sink
+	if p.LogSink != nil {
+		e.remoteStream = p.LogSink
+	} else {
+		// TODO(pgopal) - Get this info from Config.
+		cwlclient := cloudwatchlogs.New(
+			session.New(
+				&aws.Config{
+					Credentials: e.AWSCreds,
+					Region:      aws.String(defaultRegion),
+				}))
+		var err error
+		remoteStream, err = newCloudWatchLogs(cwlclient, "reflow")
+		if err != nil {
+			log.Errorf("create remote logger: %v", err)
+		}
+		e.remoteStream = remoteStream
 	}
-	e.remoteStream = remoteStream
 
 	// Note that we refresh the keepalive time on exec restore. This is
 	// probably a useful safeguard, but could be annoying when keepalive
@@ -0,0 +1,79 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSONOutputter is a FieldOutputter that writes each message as a
+// single line of JSON, so that messages may be indexed by their
+// fields. Each message is an object with the members "time",
+// "level", and "msg", followed by the message's fields, e.g.:
+//
+//	{"time":"2020-03-18T12:00:00.000Z","level":"info","msg":"done","run":"f1e2..."}
+//
+// Field values that are errors or fmt.Stringers are written as
+// strings; other values are marshaled as JSON, or written as strings
+// formatted by fmt.Sprint if they cannot be marshaled.
+type JSONOutputter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewJSONOutputter returns a JSONOutputter that writes messages to w.
+func NewJSONOutputter(w io.Writer) *JSONOutputter {
+	return &JSONOutputter{w: w}
+}
+
+// Output implements Outputter. The message is written at InfoLevel,
+// without fields.
+func (o *JSONOutputter) Output(calldepth int, s string) error {
+	return o.OutputFields(calldepth+1, InfoLevel, nil, s)
+}
+
+// OutputFields implements FieldOutputter.
+func (o *JSONOutputter) OutputFields(calldepth int, level Level, fields []Field, s string) error {
+	now := time.Now().UTC()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.Reset()
+	o.buf.WriteByte('{')
+	o.member("time", now.Format(time.RFC3339Nano))
+	o.buf.WriteByte(',')
+	o.member("level", level.String())
+	o.buf.WriteByte(',')
+	o.member("msg", s)
+	for _, f := range fields {
+		o.buf.WriteByte(',')
+		o.member(f.Key, f.Value)
+	}
+	o.buf.WriteString("}\n")
+	_, err := o.w.Write(o.buf.Bytes())
+	return err
+}
+
+func (o *JSONOutputter) member(key string, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	o.buf.Write(k)
+	o.buf.WriteByte(':')
+	o.buf.Write(v)
+}
@@ -29,12 +29,58 @@ const (
 	DebugLevel
 )
 
+var levelNames = [...]string{
+	OffLevel:   "off",
+	ErrorLevel: "error",
+	InfoLevel:  "info",
+	DebugLevel: "debug",
+}
+
+// String returns the name of the level.
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", l)
+	}
+	return levelNames[l]
+}
+
+// Keys of fields that are commonly used to annotate log messages.
+const (
+	// RunKey is the key of the field that identifies a run.
+	RunKey = "run"
+	// TaskKey is the key of the field that identifies a task.
+	TaskKey = "task"
+	// FlowKey is the key of the field that holds a flow digest.
+	FlowKey = "flow"
+	// IdentKey is the key of the field that holds an exec's
+	// identifier.
+	IdentKey = "ident"
+	// AllocKey is the key of the field that identifies an alloc.
+	AllocKey = "alloc"
+	// ExecKey is the key of the field that holds an exec URI.
+	ExecKey = "exec"
+)
+
+// A Field is a key-value pair that annotates a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
 // An Outputter receives published log messages. Go's
 // *log.Logger implements Outputter.
 type Outputter interface {
 	Output(calldepth int, s string) error
 }
 
+// A FieldOutputter is an Outputter that also receives the levels and
+// fields of published messages. Loggers publish messages to
+// FieldOutputters through OutputFields instead of Output.
+type FieldOutputter interface {
+	Outputter
+	OutputFields(calldepth int, level Level, fields []Field, s string) error
+}
+
 type multiOutputter []Outputter
 
 func (m multiOutputter) Output(calldepth int, s string) error {
@@ -48,6 +94,22 @@ func (m multiOutputter) Output(calldepth int, s string) error {
 	return err
 }
 
+func (m multiOutputter) OutputFields(calldepth int, level Level, fields []Field, s string) error {
+	var err error
+	for _, out := range m {
+		var err1 error
+		if fout, ok := out.(FieldOutputter); ok {
+			err1 = fout.OutputFields(calldepth, level, fields, s)
+		} else {
+			err1 = out.Output(calldepth, s)
+		}
+		if err1 != nil {
+			err = err1
+		}
+	}
+	return err
+}
+
 // MultiOutputter returns an Outputter that outputs each
 // message to all the provided outputters.
 func MultiOutputter(outputters ...Outputter) Outputter {
@@ -57,6 +119,11 @@ func MultiOutputter(outputters ...Outputter) Outputter {
 // A Logger receives log messages at multiple levels, and publishes
 // those messages to its outputter if the level (or logger) is
 // active. Nil Loggers ignore all log messages.
+//
+// Loggers may carry a set of fields (see With) with which their
+// messages are annotated. Fields are published only to outputters
+// that implement FieldOutputter; other outputters receive the
+// messages' text alone.
 type Logger struct {
 	// Outputter receives all log messages at or below the Logger's
 	// current level.
@@ -66,6 +133,7 @@ type Logger struct {
 
 	parent *Logger
 	prefix string
+	fields []Field
 }
 
 // New creates a new Logger that publishes messsages at or below the
@@ -125,30 +193,38 @@ func (l *Logger) print(calldepth int, level Level, prefix string, v ...interface
 	if l == nil {
 		return
 	}
-	if l.Outputter != nil && level <= l.Level {
-		l.Output(calldepth+1, prefix+fmt.Sprint(v...))
-	}
-	if l.parent != nil {
-		l.parent.print(calldepth+1, level, l.prefix+prefix, v...)
-	}
+	l.output(calldepth+1, level, prefix, l.fields, func() string { return fmt.Sprint(v...) })
 }
 
 func (l *Logger) printf(calldepth int, level Level, prefix, format string, args ...interface{}) {
 	if l == nil {
 		return
 	}
+	l.output(calldepth+1, level, prefix, l.fields, func() string { return fmt.Sprintf(format, args...) })
+}
+
+// output publishes the message returned by msg to l's outputter and
+// to its ancestors. The message is formatted only if it is
+// published. Fields are those of the logger at which the message
+// originated, which include those of all of its ancestors.
+func (l *Logger) output(calldepth int, level Level, prefix string, fields []Field, msg func() string) {
 	if l.Outputter != nil && level <= l.Level {
-		l.Output(calldepth+1, prefix+fmt.Sprintf(format, args...))
+		if fout, ok := l.Outputter.(FieldOutputter); ok {
+			fout.OutputFields(calldepth+1, level, fields, prefix+msg())
+		} else {
+			l.Output(calldepth+1, prefix+msg())
+		}
 	}
 	if l.parent != nil {
-		l.parent.printf(calldepth+1, level, l.prefix+prefix, format, args...)
+		l.parent.output(calldepth+1, level, l.prefix+prefix, fields, msg)
 	}
 }
 
 // Tee constructs a new logger that tees its output to the provided
 // outputter and parent logger. Messages sent to the parent are
 // prefixed with the provided prefix string. Out may be nil, in which
-// cases messages are published to the parent only.
+// cases messages are published to the parent only. The new logger
+// inherits l's fields.
 func (l *Logger) Tee(out Outputter, prefix string) *Logger {
 	if l == nil {
 		return nil
@@ -158,7 +234,41 @@ func (l *Logger) Tee(out Outputter, prefix string) *Logger {
 		Level:     l.Level,
 		parent:    l,
 		prefix:    prefix,
+		fields:    l.fields,
+	}
+}
+
+// With returns a child logger that annotates its messages with the
+// provided fields, in addition to those inherited from l. Fields are
+// given as alternating keys and values; keys are strings, e.g.:
+//
+//	log.With(log.RunKey, runID, log.FlowKey, f.Digest())
+//
+// Messages are published to l without additional prefixes.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	child := l.Tee(nil, "")
+	fields := make([]Field, len(l.fields), len(l.fields)+(len(keyvals)+1)/2)
+	copy(fields, l.fields)
+	for i := 0; i < len(keyvals); i += 2 {
+		f := Field{Key: fmt.Sprint(keyvals[i])}
+		if i+1 < len(keyvals) {
+			f.Value = keyvals[i+1]
+		}
+		fields = append(fields, f)
+	}
+	child.fields = fields
+	return child
+}
+
+// Fields returns the fields with which l annotates its messages.
+func (l *Logger) Fields() []Field {
+	if l == nil {
+		return nil
 	}
+	return l.fields
 }
 
 // Std is the standard global logger.
@@ -5,7 +5,11 @@
 package log_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/reflow/log"
@@ -86,3 +90,76 @@ func TestMultiOutputter(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+type fieldBuffer struct {
+	outputBuffer
+	fields [][]log.Field
+}
+
+func (o *fieldBuffer) OutputFields(calldepth int, level log.Level, fields []log.Field, s string) error {
+	o.fields = append(o.fields, fields)
+	return o.Output(calldepth+1, s)
+}
+
+func TestFields(t *testing.T) {
+	var root fieldBuffer
+	var plain outputBuffer
+	l := log.New(log.MultiOutputter(&root, &plain), log.InfoLevel)
+	run := l.With(log.RunKey, "run1")
+	task := run.Tee(nil, "task: ").With(log.TaskKey, "task1", log.IdentKey, "align")
+	task.Printf("done")
+	run.Print("finished")
+	l.Print("bye")
+
+	if got, want := root.messages, []string{"task: done", "finished", "bye"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := plain.messages, root.messages; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := [][]log.Field{
+		{{log.RunKey, "run1"}, {log.TaskKey, "task1"}, {log.IdentKey, "align"}},
+		{{log.RunKey, "run1"}},
+		nil,
+	}
+	if got := root.fields; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Fields of the parent are not modified by its children.
+	if got, want := run.Fields(), want[1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJSONOutputter(t *testing.T) {
+	var b bytes.Buffer
+	l := log.New(log.NewJSONOutputter(&b), log.DebugLevel)
+	l = l.Tee(nil, "executor: ").With(log.AllocKey, "alloc1", "size", 123, "err", errors.New("failed"))
+	l.Debugf("exec %s", "complete")
+	l.Error("line1\nline2")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("got %v lines, want %v: %s", got, want, b.String())
+	}
+	for i, want := range []map[string]interface{}{
+		{"level": "debug", "msg": "executor: exec complete", "alloc": "alloc1", "size": 123.0, "err": "failed"},
+		{"level": "error", "msg": "executor: line1\nline2", "alloc": "alloc1", "size": 123.0, "err": "failed"},
+	} {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got["time"]; !ok {
+			t.Errorf("line %d: missing time", i)
+		}
+		delete(got, "time")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	// Members are written in order, fields last.
+	if !strings.HasPrefix(lines[0], `{"time":`) || !strings.HasSuffix(lines[0], `"err":"failed"}`) {
+		t.Errorf("unexpected order: %s", lines[0])
+	}
+}
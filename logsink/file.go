@@ -0,0 +1,147 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package logsink
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+func init() {
+	infra.Register("logfile", new(File))
+}
+
+// fileIdleTimeout is the duration after which idle log files are
+// closed. They are reopened if they are written to again.
+const fileIdleTimeout = time.Minute
+
+// File is a Sink that appends each stream to a file, at the path
+// Dir/prefix/type. Since streams are never closed, files that have
+// not been written to recently are closed, and reopened on demand.
+type File struct {
+	// Dir is the directory in which log files are written.
+	Dir string
+
+	mu     sync.Mutex
+	files  map[string]*openFile
+	closed bool
+	done   chan struct{}
+}
+
+type openFile struct {
+	*os.File
+	last time.Time
+}
+
+// Help implements infra.Provider.
+func (*File) Help() string {
+	return "configure a sink that appends exec logs to local files"
+}
+
+// Flags implements infra.Provider.
+func (f *File) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&f.Dir, "dir", "/mnt/data/reflow/logs", "directory in which exec logs are written")
+}
+
+// Init implements infra.Provider.
+func (f *File) Init() error {
+	if f.Dir == "" {
+		return errors.E(errors.Invalid, errors.New("logfile: missing dir"))
+	}
+	f.files = make(map[string]*openFile)
+	f.done = make(chan struct{})
+	go f.sweep()
+	return nil
+}
+
+// NewStream implements Sink.
+func (f *File) NewStream(prefix string, typ Type) (log.Outputter, error) {
+	path := filepath.Join(f.Dir, filepath.FromSlash(prefix), string(typ))
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, errors.E("logfile", path, err)
+	}
+	return &fileStream{sink: f, path: path}, nil
+}
+
+// Close implements Sink.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	var err error
+	for path, file := range f.files {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(f.files, path)
+	}
+	return err
+}
+
+func (f *File) write(path, msg string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return errors.E("logfile", path, errors.New("sink closed"))
+	}
+	file := f.files[path]
+	if file == nil {
+		osfile, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		file = &openFile{File: osfile}
+		f.files[path] = file
+	}
+	file.last = time.Now()
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	_, err := file.WriteString(msg)
+	return err
+}
+
+// sweep periodically closes idle files.
+func (f *File) sweep() {
+	ticker := time.NewTicker(fileIdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case now := <-ticker.C:
+			f.mu.Lock()
+			for path, file := range f.files {
+				if now.Sub(file.last) > fileIdleTimeout {
+					file.Close()
+					delete(f.files, path)
+				}
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+type fileStream struct {
+	sink *File
+	path string
+}
+
+// Output appends the message s to the stream's file.
+func (s *fileStream) Output(calldepth int, msg string) error {
+	return s.sink.write(s.path, msg)
+}
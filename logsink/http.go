@@ -0,0 +1,164 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+func init() {
+	infra.Register("loghttp", new(HTTP))
+}
+
+const (
+	// httpBuffer is the number of log entries buffered by HTTP
+	// sinks. Entries are dropped when the buffer is full.
+	httpBuffer = 1024
+	// httpBatch is the maximum number of entries posted in a single
+	// request.
+	httpBatch = 256
+)
+
+// An Entry is a single log message, as posted by HTTP sinks.
+type Entry struct {
+	// Stream is the name of the stream: prefix/type.
+	Stream string `json:"stream"`
+	// Time is the time at which the message was logged.
+	Time time.Time `json:"time"`
+	// Message is the log message.
+	Message string `json:"message"`
+}
+
+// HTTP is a Sink that POSTs batches of log entries, as JSON arrays
+// of Entry, to a URL. Entries are buffered and posted at most every
+// interval; entries are dropped if the endpoint cannot keep up.
+type HTTP struct {
+	// URL is the URL to which log entries are posted.
+	URL string
+	// Interval is the maximum interval between posts.
+	Interval time.Duration
+	// Client is the HTTP client used to post entries. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	buffer    chan Entry
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Help implements infra.Provider.
+func (*HTTP) Help() string {
+	return "configure a sink that posts exec logs to an HTTP endpoint"
+}
+
+// Flags implements infra.Provider.
+func (h *HTTP) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&h.URL, "url", "", "URL to which exec log entries are posted")
+	flags.DurationVar(&h.Interval, "interval", time.Second, "maximum interval between posts")
+}
+
+// Init implements infra.Provider. Init starts posting entries.
+func (h *HTTP) Init() error {
+	if h.URL == "" {
+		return errors.E(errors.Invalid, errors.New("loghttp: missing url"))
+	}
+	if h.Interval <= 0 {
+		h.Interval = time.Second
+	}
+	h.buffer = make(chan Entry, httpBuffer)
+	h.done = make(chan struct{})
+	go h.loop()
+	return nil
+}
+
+// NewStream implements Sink.
+func (h *HTTP) NewStream(prefix string, typ Type) (log.Outputter, error) {
+	return &httpStream{sink: h, name: prefix + "/" + string(typ)}, nil
+}
+
+// Close implements Sink. Close posts any buffered entries before
+// returning.
+func (h *HTTP) Close() error {
+	h.closeOnce.Do(func() { close(h.buffer) })
+	<-h.done
+	return nil
+}
+
+func (h *HTTP) loop() {
+	defer close(h.done)
+	var (
+		ticker = time.NewTicker(h.Interval)
+		batch  []Entry
+	)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-h.buffer:
+			if !ok {
+				h.post(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) < httpBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		h.post(batch)
+		batch = batch[:0]
+	}
+}
+
+func (h *HTTP) post(batch []Entry) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		log.Errorf("loghttp %s: %v", h.URL, err)
+		return
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("loghttp %s: %v", h.URL, err)
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Errorf("loghttp %s: %s; dropped %d entries", h.URL, resp.Status, len(batch))
+	}
+}
+
+type httpStream struct {
+	sink *HTTP
+	name string
+}
+
+// Output buffers the message s to be posted. If the buffer is full,
+// the message is dropped.
+func (s *httpStream) Output(calldepth int, msg string) error {
+	select {
+	case s.sink.buffer <- Entry{Stream: s.name, Time: time.Now(), Message: msg}:
+		return nil
+	default:
+		return ErrDropped
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package logsink defines remote destinations for exec logs. Exec
+// logs are retained by allocs only for as long as the allocs live;
+// a Sink, configured through infra, receives a copy of each exec's
+// stdout and stderr as it is produced. This package provides sinks
+// that append logs to local files (provider "logfile") and that post
+// them to an HTTP endpoint (provider "loghttp"). When no sink is
+// configured, reflowlets stream exec logs to Amazon CloudWatch Logs.
+package logsink
+
+import (
+	"errors"
+	"io"
+
+	"github.com/grailbio/reflow/log"
+)
+
+// ErrDropped is returned by streams when a log message is dropped
+// because the sink cannot keep up.
+var ErrDropped = errors.New("dropped log message: buffer full")
+
+// Type is the type of a log stream.
+type Type string
+
+const (
+	// Stdout is the type of streams that carry an exec's standard output.
+	Stdout Type = "stdout"
+	// Stderr is the type of streams that carry an exec's standard error.
+	Stderr Type = "stderr"
+)
+
+// A Sink is a remote destination for exec logs. A sink must be
+// closed once all of its streams are done; closing it before its
+// streams are done writing can cause a panic.
+type Sink interface {
+	io.Closer
+	// NewStream creates a new stream with the provided name prefix
+	// and type. Creating two streams that write to the same output
+	// stream is undefined.
+	NewStream(prefix string, typ Type) (log.Outputter, error)
+}
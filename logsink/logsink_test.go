@@ -0,0 +1,119 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package logsink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &File{Dir: dir}
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := f.NewStream("run/alloc/exec", Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := f.NewStream("run/alloc/exec", Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b\n", "c"} {
+		if err := stdout.Output(1, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stderr.Output(1, "error"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for typ, want := range map[Type]string{Stdout: "a\nb\nc\n", Stderr: "error\n"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "run", "alloc", "exec", string(typ)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != want {
+			t.Errorf("%s: got %q, want %q", typ, got, want)
+		}
+	}
+	if err := stdout.Output(1, "d"); err == nil {
+		t.Error("expected error writing to closed sink")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries []Entry
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Entry
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		entries = append(entries, batch...)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	h := &HTTP{URL: srv.URL, Interval: time.Hour}
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := h.NewStream("run/alloc/exec", Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := h.NewStream("run/alloc/exec", Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []struct {
+		stream interface{ Output(int, string) error }
+		msg    string
+	}{{stdout, "a"}, {stderr, "b"}, {stdout, "c"}} {
+		if err := out.stream.Output(1, out.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Buffered entries are posted on close.
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var got [][2]string
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			t.Errorf("entry %v has no time", e)
+		}
+		got = append(got, [2]string{e.Stream, e.Message})
+	}
+	want := [][2]string{
+		{"run/alloc/exec/stdout", "a"},
+		{"run/alloc/exec/stderr", "b"},
+		{"run/alloc/exec/stdout", "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package metrics implements a small registry of counters and
+// gauges that is exposed in the Prometheus text exposition format
+// [1]. As with package expvar, metrics are typically declared as
+// package globals and registered with the default registry, which
+// is served by Handler.
+//
+// Metrics may carry a set of labels; each distinct combination of
+// label values is a separate time series. Label values should be
+// drawn from small sets (e.g., states, images, repository hosts).
+//
+// [1] https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is the type of a metric.
+type Type string
+
+const (
+	// CounterType is the type of metrics whose values only increase.
+	CounterType Type = "counter"
+	// GaugeType is the type of metrics whose values may go up and
+	// down.
+	GaugeType Type = "gauge"
+)
+
+// A Sample is a single value of a metric computed by a collector
+// function. Labels are the values of the metric's labels, in order.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// value is a float64 that is updated atomically.
+type value struct {
+	labels []string
+	bits   uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		new := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, new) {
+			return
+		}
+	}
+}
+
+func (v *value) set(x float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(x))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// A family is a named metric together with all of its time series.
+type family struct {
+	name, help string
+	typ        Type
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*value
+
+	// collect, if non-nil, computes the family's samples at
+	// collection time.
+	collect func() []Sample
+}
+
+func (f *family) with(labels []string) *value {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s: got %d label values, want %d", f.name, len(labels), len(f.labels)))
+	}
+	k := strings.Join(labels, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v := f.values[k]
+	if v == nil {
+		v = &value{labels: append([]string(nil), labels...)}
+		f.values[k] = v
+	}
+	return v
+}
+
+func (f *family) samples() []Sample {
+	if f.collect != nil {
+		return f.collect()
+	}
+	f.mu.Lock()
+	samples := make([]Sample, 0, len(f.values))
+	for _, v := range f.values {
+		samples = append(samples, Sample{v.labels, v.get()})
+	}
+	f.mu.Unlock()
+	return samples
+}
+
+// A Counter is a single time series of a counter metric.
+type Counter struct{ v *value }
+
+// Add adds delta, which must be non-negative, to the counter.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.v.add(delta)
+}
+
+// Inc increments the counter.
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Value returns the counter's current value.
+func (c *Counter) Value() float64 { return c.v.get() }
+
+// A CounterVec is a counter metric, partitioned by its labels.
+type CounterVec struct{ f *family }
+
+// With returns the counter with the provided label values, which
+// must be given in the order in which the metric's labels were
+// declared.
+func (c *CounterVec) With(labels ...string) *Counter {
+	return &Counter{c.f.with(labels)}
+}
+
+// A Gauge is a single time series of a gauge metric.
+type Gauge struct{ v *value }
+
+// Set sets the gauge's value.
+func (g *Gauge) Set(x float64) { g.v.set(x) }
+
+// Add adds delta to the gauge.
+func (g *Gauge) Add(delta float64) { g.v.add(delta) }
+
+// Inc increments the gauge.
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec decrements the gauge.
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() float64 { return g.v.get() }
+
+// A GaugeVec is a gauge metric, partitioned by its labels.
+type GaugeVec struct{ f *family }
+
+// With returns the gauge with the provided label values, which
+// must be given in the order in which the metric's labels were
+// declared.
+func (g *GaugeVec) With(labels ...string) *Gauge {
+	return &Gauge{g.f.with(labels)}
+}
+
+// A Registry is a set of named metrics. Registries are safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns a new, empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic("metrics: reuse of metric name " + f.name)
+	}
+	r.families[f.name] = f
+}
+
+// NewCounter registers and returns a new counter metric with the
+// provided name, help text, and labels. NewCounter panics if a
+// metric with the same name has already been registered.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	f := &family{name: name, help: help, typ: CounterType, labels: labels, values: make(map[string]*value)}
+	r.register(f)
+	return &CounterVec{f}
+}
+
+// NewGauge registers and returns a new gauge metric with the
+// provided name, help text, and labels. NewGauge panics if a metric
+// with the same name has already been registered.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	f := &family{name: name, help: help, typ: GaugeType, labels: labels, values: make(map[string]*value)}
+	r.register(f)
+	return &GaugeVec{f}
+}
+
+// NewFunc registers a metric of the provided type whose samples are
+// computed by the function collect each time the registry is
+// written. This is useful for exposing state that is already
+// maintained elsewhere, such as scheduler statistics.
+func (r *Registry) NewFunc(name, help string, typ Type, labels []string, collect func() []Sample) {
+	r.register(&family{name: name, help: help, typ: typ, labels: labels, collect: collect})
+}
+
+// WriteText writes all of the registry's metrics to w in the
+// Prometheus text exposition format. Metrics are written in order
+// of their names; time series in order of their label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		samples := f.samples()
+		sort.Slice(samples, func(i, j int) bool {
+			return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+		})
+		fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range samples {
+			b.WriteString(f.name)
+			if len(f.labels) > 0 {
+				b.WriteByte('{')
+				for i, label := range f.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					var v string
+					if i < len(s.Labels) {
+						v = s.Labels[i]
+					}
+					fmt.Fprintf(b, "%s=\"%s\"", label, escapeLabel(v))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(s.Value))
+			b.WriteByte('\n')
+		}
+	}
+	return b.Flush()
+}
+
+// ServeHTTP serves the registry's metrics in the Prometheus text
+// exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// defaultRegistry is the default registry, to which the package-level
+// functions register their metrics.
+var defaultRegistry = NewRegistry()
+
+// NewCounter registers a new counter metric with the default
+// registry.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return defaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewGauge registers a new gauge metric with the default registry.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return defaultRegistry.NewGauge(name, help, labels...)
+}
+
+// NewFunc registers a new collector function with the default
+// registry.
+func NewFunc(name, help string, typ Type, labels []string, collect func() []Sample) {
+	defaultRegistry.NewFunc(name, help, typ, labels, collect)
+}
+
+// Handler returns an HTTP handler that serves the default
+// registry's metrics. It is conventionally installed at /metrics;
+// it is not installed by default.
+func Handler() http.Handler {
+	return defaultRegistry
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	execs := r.NewCounter("execs_total", "Completed execs.", "image", "result")
+	running := r.NewGauge("execs_running", "Running execs.")
+	r.NewFunc("queue_depth", "Queued tasks.", GaugeType, []string{"state"}, func() []Sample {
+		return []Sample{{[]string{"lost"}, 1}, {[]string{"init"}, 3}}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			execs.With("ubuntu", "ok").Inc()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	execs.With(`quoted"image`, "oom").Add(2)
+	running.With().Set(3)
+	running.With().Dec()
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP execs_running Running execs.
+# TYPE execs_running gauge
+execs_running 2
+# HELP execs_total Completed execs.
+# TYPE execs_total counter
+execs_total{image="quoted\"image",result="oom"} 2
+execs_total{image="ubuntu",result="ok"} 10
+# HELP queue_depth Queued tasks.
+# TYPE queue_depth gauge
+queue_depth{state="init"} 3
+queue_depth{state="lost"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").With().Inc()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("requests_total 1\n")) {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("g", "A gauge.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	r.NewCounter("g", "A counter.")
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package notify implements notifications that are sent when Reflow
+// runs complete. A Notifier is configured through infra; this
+// package provides notifiers that POST a JSON summary of the run to
+// a webhook (provider "webhook") and that send it by email (provider
+// "smtp"). Notifications are retried on transient failures.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/grailbio/reflow/errors"
+)
+
+// Status is the completion status of a run.
+type Status string
+
+const (
+	// Succeeded indicates that the run completed successfully.
+	Succeeded Status = "succeeded"
+	// Failed indicates that the run failed.
+	Failed Status = "failed"
+)
+
+// Summary summarizes a completed run.
+type Summary struct {
+	// RunID is the ID of the run.
+	RunID string `json:"runid"`
+	// User is the user who started the run.
+	User string `json:"user,omitempty"`
+	// Program is the program that was run, together with its
+	// parameters and arguments.
+	Program string `json:"program,omitempty"`
+	// Labels are the run's labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status is the run's completion status.
+	Status Status `json:"status"`
+	// Start and End are the times at which the run started and
+	// completed.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Duration is the run's duration, in seconds.
+	Duration float64 `json:"duration"`
+	// Cost is the estimated cost of the run's execs, in dollars, if
+	// it is known.
+	Cost float64 `json:"cost,omitempty"`
+	// Value is the run's result, rendered as a string, if the run
+	// succeeded.
+	Value string `json:"value,omitempty"`
+	// Error is the run's error, if the run failed.
+	Error string `json:"error,omitempty"`
+	// FailedIdents are the identifiers of the run's execs that failed.
+	FailedIdents []string `json:"failedidents,omitempty"`
+}
+
+// Subject returns a one-line description of the summary, suitable
+// for use as an email subject.
+func (s Summary) Subject() string {
+	return fmt.Sprintf("reflow run %s %s", s.RunID, s.Status)
+}
+
+// String returns a human-readable rendering of the summary.
+func (s Summary) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "run:      %s\n", s.RunID)
+	if s.User != "" {
+		fmt.Fprintf(&b, "user:     %s\n", s.User)
+	}
+	if s.Program != "" {
+		fmt.Fprintf(&b, "program:  %s\n", s.Program)
+	}
+	fmt.Fprintf(&b, "status:   %s\n", s.Status)
+	fmt.Fprintf(&b, "duration: %s\n", time.Duration(s.Duration*float64(time.Second)).Round(time.Second))
+	if s.Cost > 0 {
+		fmt.Fprintf(&b, "cost:     $%.2f (estimated)\n", s.Cost)
+	}
+	if len(s.Labels) > 0 {
+		keys := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("labels:\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "\t%s=%s\n", k, s.Labels[k])
+		}
+	}
+	if s.Error != "" {
+		fmt.Fprintf(&b, "error:    %s\n", s.Error)
+	}
+	if len(s.FailedIdents) > 0 {
+		b.WriteString("failed execs:\n")
+		for _, ident := range s.FailedIdents {
+			fmt.Fprintf(&b, "\t%s\n", ident)
+		}
+	}
+	if s.Value != "" {
+		fmt.Fprintf(&b, "value:\n%s\n", s.Value)
+	}
+	return b.String()
+}
+
+// A Notifier sends notifications of completed runs.
+type Notifier interface {
+	// Notify sends a notification with the provided run summary.
+	Notify(ctx context.Context, s Summary) error
+}
+
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(ctx context.Context, s Summary) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errors.E("notify", errors.Errorf("%d notifiers failed; first error: %v", len(errs), errs[0]))
+	}
+}
+
+// Multi returns a Notifier that sends each notification through all
+// of the provided notifiers.
+func Multi(notifiers ...Notifier) Notifier {
+	return multiNotifier(notifiers)
+}
+
+// backoff is the backoff policy used between notification attempts.
+var backoff = retry.Backoff(time.Second, time.Minute, 2)
+
+// withRetries calls fn until it succeeds, it returns a non-retriable
+// error, or it has been tried the provided number of times.
+func withRetries(ctx context.Context, tries int, fn func() error) error {
+	if tries < 1 {
+		tries = 1
+	}
+	policy := retry.MaxTries(backoff, tries)
+	for retries := 0; ; retries++ {
+		err := fn()
+		if err == nil || errors.Is(errors.Invalid, err) {
+			return err
+		}
+		if werr := retry.Wait(ctx, policy, retries); werr != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/grailbio/reflow/errors"
+)
+
+func init() {
+	backoff = retry.Backoff(time.Millisecond, 10*time.Millisecond, 2)
+}
+
+var testSummary = Summary{
+	RunID:        "f4ab0a7e1c2d",
+	User:         "alice@example.com",
+	Labels:       map[string]string{"project": "align"},
+	Status:       Failed,
+	Start:        time.Unix(1000, 0).UTC(),
+	End:          time.Unix(4600, 0).UTC(),
+	Duration:     3600,
+	Cost:         1.25,
+	Error:        "exec failed",
+	FailedIdents: []string{"align.sam"},
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		got      []Summary
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if r.URL.Path == "/bad" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if attempts < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if got, want := r.Header.Get("Content-Type"), "application/json"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var s Summary
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Error(err)
+		}
+		got = append(got, s)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	w := &Webhook{URL: srv.URL, Retries: 5}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(ctx, testSummary); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Summary{testSummary}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := attempts, 3; got != want {
+		t.Errorf("got %v attempts, want %v", got, want)
+	}
+
+	// Client errors are not retried.
+	attempts = 0
+	w = &Webhook{URL: srv.URL + "/bad", Retries: 5}
+	if err := w.Notify(ctx, testSummary); !errors.Is(errors.Invalid, err) {
+		t.Errorf("expected invalid error, got %v", err)
+	}
+	if got, want := attempts, 1; got != want {
+		t.Errorf("got %v attempts, want %v", got, want)
+	}
+
+	// Retries are bounded.
+	attempts = -10
+	w = &Webhook{URL: srv.URL, Retries: 2}
+	if err := w.Notify(ctx, testSummary); !errors.Is(errors.Unavailable, err) {
+		t.Errorf("expected unavailable error, got %v", err)
+	}
+	if got, want := attempts, -8; got != want {
+		t.Errorf("got %v attempts, want %v", got, want)
+	}
+}
+
+// smtpServer is a minimal SMTP server that records the messages it
+// receives.
+type smtpServer struct {
+	net.Listener
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var (
+		r   = bufio.NewReader(conn)
+		msg smtpMessage
+	)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	reply("220 localhost test server")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg.From = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data []string
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data = append(data, line)
+			}
+			msg.Data = strings.Join(data, "")
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = smtpMessage{}
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &smtpServer{Listener: l}
+	defer srv.Close()
+	go srv.serve()
+
+	s := &SMTP{
+		Addr:    l.Addr().String(),
+		From:    "reflow@example.com",
+		To:      "alice@example.com, bob@example.com",
+		Retries: 1,
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Notify(context.Background(), testSummary); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got, want := len(srv.messages), 1; got != want {
+		t.Fatalf("got %v messages, want %v", got, want)
+	}
+	msg := srv.messages[0]
+	if got, want := msg.From, "reflow@example.com"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := msg.To, []string{"alice@example.com", "bob@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, want := range []string{
+		"Subject: reflow run f4ab0a7e1c2d failed\r\n",
+		"cost:     $1.25 (estimated)\r\n",
+		"\talign.sam\r\n",
+		`"failedidents": [`,
+	} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg.Data)
+		}
+	}
+
+	if err := (&SMTP{Addr: "localhost", From: "a", To: "b"}).Init(); !errors.Is(errors.Invalid, err) {
+		t.Errorf("expected invalid error, got %v", err)
+	}
+}
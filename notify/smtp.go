@@ -0,0 +1,111 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+)
+
+func init() {
+	infra.Register("smtp", new(SMTP))
+}
+
+// SMTP is a Notifier that emails run summaries. Each message
+// contains a human-readable summary of the run, followed by its JSON
+// encoding. If a username is configured, the notifier authenticates
+// using PLAIN authentication, with the password taken from the
+// environment variable REFLOW_SMTP_PASSWORD.
+type SMTP struct {
+	// Addr is the address (host:port) of the SMTP server.
+	Addr string
+	// From is the sender's address.
+	From string
+	// To is the comma-separated list of recipient addresses.
+	To string
+	// Username is the username used to authenticate, if any.
+	Username string
+	// Retries is the number of times a notification is attempted.
+	Retries int
+}
+
+// Help implements infra.Provider.
+func (*SMTP) Help() string {
+	return "configure a notifier that emails summaries of completed runs"
+}
+
+// Flags implements infra.Provider.
+func (s *SMTP) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&s.Addr, "addr", "localhost:25", "address (host:port) of the SMTP server")
+	flags.StringVar(&s.From, "from", "", "sender address")
+	flags.StringVar(&s.To, "to", "", "comma-separated list of recipient addresses")
+	flags.StringVar(&s.Username, "username", "", "username with which to authenticate")
+	flags.IntVar(&s.Retries, "retries", 5, "number of times a notification is attempted")
+}
+
+// Init implements infra.Provider.
+func (s *SMTP) Init() error {
+	if s.From == "" || s.To == "" {
+		return errors.E(errors.Invalid, errors.New("smtp: from and to addresses are required"))
+	}
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		return errors.E(errors.Invalid, "smtp", s.Addr, err)
+	}
+	return nil
+}
+
+// Notify implements Notifier.
+func (s *SMTP) Notify(ctx context.Context, sum Summary) error {
+	var to []string
+	for _, addr := range strings.Split(s.To, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	msg, err := s.message(sum, to, time.Now())
+	if err != nil {
+		return errors.E("smtp", s.Addr, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, os.Getenv("REFLOW_SMTP_PASSWORD"), host)
+	}
+	return withRetries(ctx, s.Retries, func() error {
+		if err := smtp.SendMail(s.Addr, auth, s.From, to, msg); err != nil {
+			return errors.E(errors.Unavailable, "smtp", s.Addr, err)
+		}
+		return nil
+	})
+}
+
+// message renders the email message for the summary sum.
+func (s *SMTP) message(sum Summary, to []string, now time.Time) ([]byte, error) {
+	js, err := json.MarshalIndent(sum, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", sum.Subject())
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body := sum.String() + "\n" + string(js) + "\n"
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return b.Bytes(), nil
+}
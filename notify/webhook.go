@@ -0,0 +1,101 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+)
+
+func init() {
+	infra.Register("webhook", new(Webhook))
+}
+
+// Webhook is a Notifier that POSTs run summaries, encoded as JSON,
+// to a URL. Requests that fail with a server error (5xx), or that
+// are throttled (429), are retried.
+type Webhook struct {
+	// URL is the URL to which summaries are posted.
+	URL string
+	// Retries is the number of times a notification is attempted.
+	Retries int
+	// Timeout is the timeout for each request.
+	Timeout time.Duration
+	// Client is the HTTP client used to make requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Help implements infra.Provider.
+func (*Webhook) Help() string {
+	return "configure a notifier that posts JSON summaries of completed runs to a webhook"
+}
+
+// Flags implements infra.Provider.
+func (w *Webhook) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&w.URL, "url", "", "URL to which run summaries are posted")
+	flags.IntVar(&w.Retries, "retries", 5, "number of times a notification is attempted")
+	flags.DurationVar(&w.Timeout, "timeout", 30*time.Second, "timeout for each request")
+}
+
+// Init implements infra.Provider.
+func (w *Webhook) Init() error {
+	if w.URL == "" {
+		return errors.E(errors.Invalid, errors.New("webhook: missing url"))
+	}
+	return nil
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(ctx context.Context, s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return errors.E("webhook", w.URL, err)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return withRetries(ctx, w.Retries, func() error {
+		return w.post(ctx, client, body)
+	})
+}
+
+func (w *Webhook) post(ctx context.Context, client *http.Client, body []byte) error {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.E(errors.Invalid, "webhook", w.URL, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.E(errors.Unavailable, "webhook", w.URL, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return errors.E(errors.Unavailable, "webhook", w.URL, errors.New(resp.Status))
+	default:
+		return errors.E(errors.Invalid, "webhook", w.URL, errors.New(resp.Status))
+	}
+}
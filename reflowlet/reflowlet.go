@@ -15,6 +15,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
@@ -37,10 +38,16 @@ import (
 	infra2 "github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/local"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/logsink"
+	"github.com/grailbio/reflow/metrics"
 	"github.com/grailbio/reflow/pool/server"
+	"github.com/grailbio/reflow/repository"
 	"github.com/grailbio/reflow/repository/blobrepo"
 	repositoryhttp "github.com/grailbio/reflow/repository/http"
+	"github.com/grailbio/reflow/repository/tieredrepo"
 	"github.com/grailbio/reflow/rest"
+	"github.com/grailbio/reflow/rest/auth"
+	"github.com/grailbio/reflow/trace"
 	"golang.org/x/net/http2"
 )
 
@@ -67,6 +74,19 @@ type Server struct {
 	EC2Cluster bool
 	// HTTPDebug determines whether HTTP debug logging is turned on.
 	HTTPDebug bool
+	// RepoCacheDir is the directory of the local cache of objects
+	// retrieved from remote repositories, shared by all of the
+	// reflowlet's allocs. No cache is used if it is empty.
+	RepoCacheDir string
+	// RepoCacheSize is the maximum size of the repository cache, in GiB.
+	RepoCacheSize int
+	// MetricsAddr is the address of an additional HTTP server that
+	// serves only the reflowlet's metrics, so that they may be scraped
+	// without client certificates. This server is neither encrypted nor
+	// authenticated, and so should be reachable only from trusted
+	// networks. Metrics are always served, authenticated, at /metrics
+	// on the main server.
+	MetricsAddr string
 
 	// server is the underlying HTTP server
 	server *http.Server
@@ -91,6 +111,9 @@ func (s *Server) AddFlags(flags *flag.FlagSet) {
 	flags.StringVar(&s.Dir, "dir", "/mnt/data/reflow", "runtime data directory")
 	flags.BoolVar(&s.EC2Cluster, "ec2cluster", false, "this reflowlet is part of an ec2cluster")
 	flags.BoolVar(&s.HTTPDebug, "httpdebug", false, "turn on HTTP debug logging")
+	flags.StringVar(&s.RepoCacheDir, "repocachedir", "", "directory in which objects retrieved from remote repositories are cached")
+	flags.IntVar(&s.RepoCacheSize, "repocachesize", 100, "maximum size of the repository cache (GiB)")
+	flags.StringVar(&s.MetricsAddr, "metricsaddr", "", "address of an unauthenticated HTTP server that serves only Prometheus metrics")
 }
 
 // spotNoticeWatcher watches for a spot termination notice and logs if found.
@@ -213,6 +236,18 @@ func (s *Server) ListenAndServe() error {
 	} else if dockerconfig.Value() == "hard" {
 		hardMemLimit = true
 	}
+	var logSink logsink.Sink
+	if err := s.Config.Instance(&logSink); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type logsink.Sink") {
+			return err
+		}
+	}
+	var authn auth.Authenticator
+	if err := s.Config.Instance(&authn); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type auth.Authenticator") {
+			return err
+		}
+	}
 	if err := s.setTags(sess); err != nil {
 		return fmt.Errorf("set tags: %v", err)
 	}
@@ -221,6 +256,17 @@ func (s *Server) ListenAndServe() error {
 	// TODO(marius): handle this more elegantly, perhaps by
 	// avoiding global registration altogether.
 	blobrepo.Register("s3", s3blob.New(sess))
+	if s.RepoCacheDir != "" {
+		// Objects retrieved from s3 repositories are cached locally,
+		// so that they are downloaded once per instance, rather than
+		// once per alloc.
+		cache, err := tieredrepo.NewCache(filepath.Join(s.Prefix, s.RepoCacheDir),
+			int64(s.RepoCacheSize)<<30, log.Std.Tee(nil, "repocache: "))
+		if err != nil {
+			return fmt.Errorf("repository cache: %v", err)
+		}
+		repository.RegisterScheme("s3", tieredrepo.Dialer(cache, blobrepo.Dial))
+	}
 	transport := &http.Transport{TLSClientConfig: clientConfig}
 	http2.ConfigureTransport(transport)
 	repositoryhttp.HTTPClient = &http.Client{Transport: transport}
@@ -236,11 +282,27 @@ func (s *Server) ListenAndServe() error {
 			"s3": s3blob.New(sess),
 		},
 		Log:          log.Std.Tee(nil, "executor: "),
+		LogSink:      logSink,
 		HardMemLimit: hardMemLimit,
 	}
 	if err := p.Start(); err != nil {
 		return err
 	}
+	metrics.NewFunc("reflow_reflowlet_allocs", "Number of allocs on this reflowlet.",
+		metrics.GaugeType, nil, func() []metrics.Sample {
+			allocs, err := p.Allocs(context.Background())
+			if err != nil {
+				return nil
+			}
+			return []metrics.Sample{{Value: float64(len(allocs))}}
+		})
+	if s.MetricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			log.Printf("metrics server: %v", http.ListenAndServe(s.MetricsAddr, mux))
+		}()
+	}
 	if s.EC2Cluster {
 		ctx, cancel := context.WithCancel(context.Background())
 		if err := s.setupWatcher(ctx, sess, filepath.Join(s.Prefix, s.Dir), rc.VolumeWatcher); err != nil {
@@ -272,13 +334,29 @@ func (s *Server) ListenAndServe() error {
 		log.Std.Level = log.DebugLevel
 	}
 
-	http.Handle("/", rest.Handler(server.NewNode(p), httpLog))
+	// Calls are traced as part of their callers' traces if a tracer
+	// is configured.
+	var tracer trace.Tracer
+	traced := s.Config.Instance(&tracer) == nil
+	// Calls are authenticated and authorized if an authenticator
+	// is configured.
+	handle := func(pattern string, h http.Handler) {
+		if traced {
+			h = rest.TraceHandler(h, tracer)
+		}
+		if authn != nil {
+			h = auth.Handler(h, authn, log.Std.Tee(nil, "auth: "))
+		}
+		http.Handle(pattern, h)
+	}
+	handle("/", rest.Handler(server.NewNode(p), httpLog))
 	// Create a servlet node for this reflowlet's config.
 	cfgNode, err := newConfigNode(s.Config)
 	if err != nil {
 		return fmt.Errorf("read config: %v", err)
 	}
-	http.Handle("/v1/config", rest.DoFuncHandler(cfgNode, httpLog))
+	handle("/v1/config", rest.DoFuncHandler(cfgNode, httpLog))
+	handle("/metrics", metrics.Handler())
 	var repo reflow.Repository
 	err = s.Config.Instance(&repo)
 	if err != nil {
@@ -289,6 +367,11 @@ func (s *Server) ListenAndServe() error {
 		return s.server.ListenAndServe()
 	}
 	serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	if c, ok := authn.(auth.ServerConfigurer); ok {
+		if err := c.ConfigureServer(serverConfig); err != nil {
+			return fmt.Errorf("auth: %v", err)
+		}
+	}
 	s.server.TLSConfig = serverConfig
 	http2.ConfigureServer(s.server, &http2.Server{
 		MaxConcurrentStreams: maxConcurrentStreams,
@@ -11,8 +11,10 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/grailbio/base/digest"
@@ -22,11 +24,17 @@ import (
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/liveset"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
 )
 
 const (
 	objectsPath = "objects"
 	uploadsPath = "uploads"
+
+	// compressedExt is the extension of the keys of compressed objects.
+	compressedExt = ".zst"
+	// encryptedExt is the extension of the keys of encrypted objects.
+	encryptedExt = ".enc"
 )
 
 // Repository implements an blob-backed Repository. Objects are stored
@@ -39,9 +47,51 @@ const (
 //	type://bucket/<prefix>/uploads/<hex>
 //
 // Prefix may be empty.
+//
+// Objects may also be stored compressed (with zstd), in which case
+// their keys carry the size of the uncompressed object:
+//
+//	type://bucket/<prefix>/objects/sha256:<hex>.<size>.zst
+//
+// Objects are always named by the digest of their uncompressed
+// contents, and the repository's methods present them uncompressed.
+// Objects stored in either form are accessible regardless of whether
+// the repository is configured to compress new objects.
+//
+// Objects encrypted by a client (see package cryptorepo) are stored
+// similarly, keyed by the digest and size of their plaintext:
+//
+//	type://bucket/<prefix>/objects/sha256:<hex>.<size>.enc
+//
+// The repository cannot decrypt such objects; it refuses to retrieve
+// or locate them so that their ciphertext is never mistaken for
+// their contents.
+//
+// Large objects may also be stored in content-defined chunks, which
+// are shared among objects; see chunk.go.
+//
+// Objects that are not stored in chunks are transferred between blob
+// repositories (see ReadFrom and WriteTo) by copying them directly,
+// in their stored representations.
 type Repository struct {
 	Bucket blob.Bucket
 	Prefix string
+	// Compress determines whether objects installed in the repository
+	// are stored compressed.
+	Compress bool
+	// Chunk determines whether objects installed in the repository
+	// are split into content-defined chunks, which are deduplicated
+	// across objects. Objects that comprise a single chunk are
+	// stored whole. Chunked objects are not compressed.
+	Chunk bool
+
+	// chunker overrides the default chunker; used in tests.
+	chunker *chunker
+
+	// missMu guards misses, which records the times at which Stat
+	// found objects not to be stored in any non-raw representation.
+	missMu sync.Mutex
+	misses map[digest.Digest]time.Time
 }
 
 // String returns the repository URL.
@@ -49,64 +99,166 @@ func (r *Repository) String() string {
 	return r.Bucket.Location() + r.Prefix
 }
 
-// Stat queries the repository for object metadata.
+// Stat queries the repository for object metadata. The returned
+// size is the size of the uncompressed object. Stat remembers
+// briefly that objects are not stored in non-raw representations;
+// see storedMissTTL.
 func (r *Repository) Stat(ctx context.Context, id digest.Digest) (reflow.File, error) {
-	id, err := r.resolve(ctx, id)
-	if err != nil {
-		return reflow.File{}, err
-	}
-	file, err := r.Bucket.File(ctx, path.Join(r.Prefix, objectsPath, id.String()))
-	if err == nil {
-		file.ID = id
-	}
+	_, file, _, err := r.object(ctx, id, true)
 	return file, err
 }
 
-// Location returns the location of this object.
+// Location returns the location of this object. Objects that are
+// stored compressed, encrypted, or in chunks cannot be located: their contents
+// must be retrieved through the repository (or its client).
 func (r *Repository) Location(ctx context.Context, id digest.Digest) (string, error) {
-	id, err := r.resolve(ctx, id)
+	_, file, ext, err := r.object(ctx, id, false)
 	if err != nil {
 		return "", err
 	}
-	var src string
-	file, err := r.Bucket.File(ctx, path.Join(r.Prefix, objectsPath, id.String()))
-	if err == nil {
-		src = file.Source
+	if ext != "" {
+		return "", errStored("location", r, id, ext)
 	}
-	return src, err
+	return file.Source, nil
 }
 
-// Get retrieves an object from the repository.
+// Get retrieves an object from the repository. Compressed objects
+// are decompressed as they are read.
 func (r *Repository) Get(ctx context.Context, id digest.Digest) (io.ReadCloser, error) {
 	id, err := r.resolve(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	rc, _, err := r.Bucket.Get(ctx, path.Join(r.Prefix, objectsPath, id.String()), "")
-	return rc, err
+	if !r.Compress {
+		// Most objects in a repository that does not compress
+		// are stored raw, so we attempt to retrieve the raw object
+		// directly, saving a metadata lookup.
+		rc, _, err := r.Bucket.Get(ctx, r.key(id), "")
+		if !errors.Is(errors.NotExist, err) {
+			return rc, err
+		}
+	}
+	key, _, ext, err := r.object(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case encryptedExt:
+		return nil, errStored("get", r, id, ext)
+	case chunkedExt:
+		return r.getChunked(ctx, key)
+	}
+	rc, _, err := r.Bucket.Get(ctx, key, "")
+	if err != nil || ext == "" {
+		return rc, err
+	}
+	return repository.Decompress(rc)
 }
 
 // GetFile retrieves an object from the repository directly to the a io.WriterAt.
 // This uses the S3 download manager to download chunks concurrently.
+// Compressed objects are instead streamed, and decompressed, sequentially.
 func (r *Repository) GetFile(ctx context.Context, id digest.Digest, w io.WriterAt) (int64, error) {
 	id, err := r.resolve(ctx, id)
 	if err != nil {
 		return 0, err
 	}
-	return r.Bucket.Download(ctx, path.Join(r.Prefix, objectsPath, id.String()), "", 0, w)
+	if !r.Compress {
+		n, err := r.Bucket.Download(ctx, r.key(id), "", 0, w)
+		if !errors.Is(errors.NotExist, err) {
+			return n, err
+		}
+	}
+	key, file, ext, err := r.object(ctx, id, false)
+	if err != nil {
+		return 0, err
+	}
+	switch ext {
+	case "":
+		return r.Bucket.Download(ctx, key, "", file.Size, w)
+	case encryptedExt:
+		return 0, errStored("getfile", r, id, ext)
+	case chunkedExt:
+		return r.getFileChunked(ctx, key, w)
+	}
+	rc, _, err := r.Bucket.Get(ctx, key, "")
+	if err != nil {
+		return 0, err
+	}
+	dc, err := repository.Decompress(rc)
+	if err != nil {
+		return 0, err
+	}
+	defer dc.Close()
+	return io.Copy(&offsetWriter{w: w}, dc)
+}
+
+// GetCompressed retrieves the compressed contents of an object. It
+// implements repository.CompressedGetter.
+func (r *Repository) GetCompressed(ctx context.Context, id digest.Digest) (io.ReadCloser, reflow.File, error) {
+	key, file, ext, err := r.object(ctx, id, false)
+	if err != nil {
+		return nil, file, err
+	}
+	if ext != compressedExt {
+		return nil, file, errors.E("getcompressed", r.String(), id, errors.NotSupported)
+	}
+	rc, _, err := r.Bucket.Get(ctx, key, "")
+	return rc, file, err
+}
+
+// GetEncrypted retrieves the ciphertext of an object stored
+// encrypted. It returns an error of kind errors.NotSupported if
+// the object is not stored encrypted.
+func (r *Repository) GetEncrypted(ctx context.Context, id digest.Digest) (io.ReadCloser, reflow.File, error) {
+	id, err := r.resolve(ctx, id)
+	if err != nil {
+		return nil, reflow.File{}, err
+	}
+	key, file, err := r.lookupStored(ctx, id, encryptedExt)
+	if errors.Is(errors.NotExist, err) {
+		if _, err := r.Stat(ctx, id); err != nil {
+			return nil, reflow.File{}, err
+		}
+		return nil, reflow.File{}, errors.E("getencrypted", r.String(), id, errors.NotSupported)
+	}
+	if err != nil {
+		return nil, reflow.File{}, err
+	}
+	file.ID = id
+	rc, _, err := r.Bucket.Get(ctx, key, "")
+	return rc, file, err
 }
 
 // Put installs an object into the repository; its digest ID is returned.
 func (r *Repository) Put(ctx context.Context, body io.Reader) (digest.Digest, error) {
-	dw := reflow.Digester.NewWriter()
-	uploadKey := path.Join(r.Prefix, uploadsPath, newID())
-	err := r.Bucket.Put(ctx, uploadKey, 0, io.TeeReader(body, dw), "")
+	var (
+		dw        = reflow.Digester.NewWriter()
+		cw        = &countWriter{Writer: dw}
+		uploadKey = path.Join(r.Prefix, uploadsPath, newID())
+		err       error
+	)
+	if r.Chunk {
+		id, _, err := r.putChunked(ctx, body)
+		return id, err
+	}
+	body = io.TeeReader(body, cw)
+	if r.Compress {
+		err = r.putCompressed(ctx, uploadKey, body, "")
+	} else {
+		err = r.Bucket.Put(ctx, uploadKey, 0, body, "")
+	}
 	if err != nil {
 		return digest.Digest{}, err
 	}
 	defer r.Bucket.Delete(ctx, uploadKey)
 	id := dw.Digest()
-	return id, r.Bucket.Copy(ctx, uploadKey, path.Join(r.Prefix, objectsPath, id.String()), id.Hex())
+	key := r.key(id)
+	if r.Compress {
+		key = r.storedKey(id, cw.n, compressedExt)
+	}
+	r.stored(id)
+	return id, r.Bucket.Copy(ctx, uploadKey, key, id.Hex())
 }
 
 // PutFile installs a file into the repository. PutFile uses the S3 upload manager
@@ -116,22 +268,122 @@ func (r *Repository) PutFile(ctx context.Context, file reflow.File, body io.Read
 	if _, err := r.Stat(ctx, file.ID); err == nil {
 		return nil
 	}
-	key := path.Join(r.Prefix, objectsPath, file.ID.String())
-	return r.Bucket.Put(ctx, key, file.Size, body, file.ID.Hex())
+	r.stored(file.ID)
+	if r.chunks(file.Size) {
+		id, size, err := r.putChunked(ctx, body)
+		if err == nil && (id != file.ID || size != file.Size) {
+			err = errors.E("putfile", r.String(), file.ID, errors.Integrity,
+				errors.Errorf("object has digest %v and size %d", id, size))
+		}
+		return err
+	}
+	if r.Compress {
+		return r.putCompressed(ctx, r.storedKey(file.ID, file.Size, compressedExt), body, file.ID.Hex())
+	}
+	return r.Bucket.Put(ctx, r.key(file.ID), file.Size, body, file.ID.Hex())
 }
 
-// WriteTo is unsupported by the blob repository.
-//
-// TODO(marius): we can support other s3r here by performing CopyObjects.
-func (r *Repository) WriteTo(_ context.Context, id digest.Digest, u *url.URL) error {
-	return errors.E("writeto", r.URL().String(), id, u.String(), errors.NotSupported)
+// PutCompressed installs a file from its compressed contents. It
+// implements repository.CompressedPutter. If the repository is not
+// configured to compress objects, the object is decompressed before
+// it is stored.
+func (r *Repository) PutCompressed(ctx context.Context, file reflow.File, body io.Reader) error {
+	if _, err := r.Stat(ctx, file.ID); err == nil {
+		return nil
+	}
+	r.stored(file.ID)
+	if r.Compress {
+		return r.Bucket.Put(ctx, r.storedKey(file.ID, file.Size, compressedExt), 0, body, file.ID.Hex())
+	}
+	dc, err := repository.Decompress(ioutil.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	return r.Bucket.Put(ctx, r.key(file.ID), file.Size, dc, file.ID.Hex())
 }
 
-// ReadFrom is unsupported by the blob repository.
-//
-// TODO(marius): we can support other s3r here by performing CopyObjects.
-func (r *Repository) ReadFrom(_ context.Context, id digest.Digest, u *url.URL) error {
-	return errors.E("readfrom", r.URL().String(), id, u.String(), errors.NotSupported)
+// PutEncrypted installs the ciphertext body of the file described
+// by file. The caller guarantees that body decrypts to contents
+// with file's digest and size. PutEncrypted is a no-op if the object
+// is already stored encrypted.
+func (r *Repository) PutEncrypted(ctx context.Context, file reflow.File, body io.Reader) error {
+	if _, _, err := r.lookupStored(ctx, file.ID, encryptedExt); err == nil {
+		return nil
+	}
+	r.stored(file.ID)
+	return r.Bucket.Put(ctx, r.storedKey(file.ID, file.Size, encryptedExt), 0, body, "")
+}
+
+// putCompressed compresses body as it is uploaded to the provided key.
+func (r *Repository) putCompressed(ctx context.Context, key string, body io.Reader, contentHash string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := repository.Compress(pw)
+		if err == nil {
+			_, err = io.Copy(zw, body)
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	err := r.Bucket.Put(ctx, key, 0, pr, contentHash)
+	// Unblock the compressor if the upload failed.
+	pr.CloseWithError(err)
+	return err
+}
+
+// WriteTo copies the object named by id directly to the blob
+// repository at u, in the representation in which it is stored:
+// compressed objects are copied without being decompressed. WriteTo
+// returns an error of kind errors.NotSupported if u does not name a
+// blob repository, if the object is stored in chunks, or if the
+// buckets do not support direct copies.
+func (r *Repository) WriteTo(ctx context.Context, id digest.Digest, u *url.URL) error {
+	dst, err := dialRepository(u)
+	if err != nil {
+		return errors.E("writeto", r.URL().String(), id, u.String(), err)
+	}
+	if err := dst.copyFrom(ctx, r, id); err != nil {
+		return errors.E("writeto", r.URL().String(), id, u.String(), err)
+	}
+	return nil
+}
+
+// ReadFrom copies the object named by id directly from the blob
+// repository at u, in the representation in which it is stored; see
+// WriteTo.
+func (r *Repository) ReadFrom(ctx context.Context, id digest.Digest, u *url.URL) error {
+	src, err := dialRepository(u)
+	if err != nil {
+		return errors.E("readfrom", r.URL().String(), id, u.String(), err)
+	}
+	if err := r.copyFrom(ctx, src, id); err != nil {
+		return errors.E("readfrom", r.URL().String(), id, u.String(), err)
+	}
+	return nil
+}
+
+// copyFrom copies the object named by id from src, unless it is
+// already present in the repository.
+func (r *Repository) copyFrom(ctx context.Context, src *Repository, id digest.Digest) error {
+	if _, err := r.Stat(ctx, id); err == nil {
+		return nil
+	}
+	key, file, ext, err := src.object(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if ext == chunkedExt {
+		return errStored("copy", src, id, ext)
+	}
+	dstKey := r.key(file.ID)
+	if ext != "" {
+		dstKey = r.storedKey(file.ID, file.Size, ext)
+	}
+	r.stored(file.ID)
+	return r.Bucket.CopyFrom(ctx, src.Bucket, key, dstKey)
 }
 
 // Collect is not supported on S3.
@@ -153,17 +405,21 @@ func (r *Repository) resolve(ctx context.Context, id digest.Digest) (digest.Dige
 	if !scan.Scan(ctx) {
 		return id, errors.E("blobrepo.resolve", id, errors.NotExist)
 	}
-	key := scan.Key()
-
-	if scan.Scan(ctx) {
-		return id, errors.E("blobrepo.resolve", id,
-			errors.Errorf("abbreviated id %s not unique", abbrev))
+	resolved, _, _, err := parseKey(scan.Key())
+	if err != nil {
+		return id, errors.E("blobrepo.resolve", id, err)
+	}
+	// An object may be stored in more than one representation.
+	for scan.Scan(ctx) {
+		if other, _, _, err := parseKey(scan.Key()); err != nil || other != resolved {
+			return id, errors.E("blobrepo.resolve", id,
+				errors.Errorf("abbreviated id %s not unique", abbrev))
+		}
 	}
 	if err := scan.Err(); err != nil {
 		return id, errors.E("blobrepo.resolve", id, err)
 	}
-	_, name := path.Split(key)
-	return reflow.Digester.Parse(name)
+	return resolved, nil
 }
 
 const (
@@ -186,9 +442,54 @@ func (r *Repository) delete(ctx context.Context, keys []string) error {
 	return err
 }
 
+// Scan invokes handler for each object in the repository, in digest
+// order. Keys under the objects prefix that are not valid digests
+// are skipped.
+func (r *Repository) Scan(ctx context.Context, handler func(digest.Digest) error) error {
+	var (
+		scan = r.Bucket.Scan(path.Join(r.Prefix, objectsPath))
+		last digest.Digest
+	)
+	for scan.Scan(ctx) {
+		key := scan.Key()
+		id, _, _, err := parseKey(key)
+		if err != nil {
+			log.Errorf("invalid s3 entry %v (%s)", key, scan.File())
+			continue
+		}
+		// The representations of the same object
+		// are adjacent in the scan.
+		if id == last {
+			continue
+		}
+		last = id
+		if err := handler(id); err != nil {
+			return err
+		}
+	}
+	return scan.Err()
+}
+
+// Delete removes the object named by id, in all of its
+// representations, from the repository. The chunks of chunked
+// objects, which may be shared, are removed by collection.
+func (r *Repository) Delete(ctx context.Context, id digest.Digest) error {
+	keys := []string{r.key(id)}
+	for _, ext := range storedExts {
+		if key, _, err := r.lookupStored(ctx, id, ext); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	if err := r.delete(ctx, keys); err != nil {
+		return errors.E("delete", r.String(), id, err)
+	}
+	return nil
+}
+
 // CollectWithThreshold removes from this repository any objects which are not in the
 // liveset and which have not been accessed more recently than the liveset's
-// threshold time
+// threshold time. Chunks that are not referenced by any remaining
+// chunked object are then removed, subject to the same threshold.
 func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Liveset, dead liveset.Liveset, threshold time.Time, dryRun bool) error {
 	var (
 		objectsCheckedCount int64
@@ -199,6 +500,7 @@ func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Live
 		totalBytesCollected int64
 		start               = time.Now()
 		todo                = make([]string, 0, deleteMaxObjects)
+		manifests           []string
 	)
 
 	scan := r.Bucket.Scan(path.Join(r.Prefix, objectsPath))
@@ -212,7 +514,7 @@ func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Live
 			file = scan.File()
 			key  = scan.Key()
 		)
-		digest, err := reflow.Digester.Parse(path.Base(key))
+		digest, _, ext, err := parseKey(key)
 		if err != nil {
 			invalidObjectsCount++
 			log.Errorf("invalid s3 entry %v (%s)", key, file)
@@ -220,8 +522,14 @@ func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Live
 		}
 		if live.Contains(digest) {
 			liveObjectsCount++
+			if ext == chunkedExt {
+				manifests = append(manifests, key)
+			}
 		} else if !dead.Contains(digest) && file.LastModified.After(threshold) {
 			afterThresholdCount++
+			if ext == chunkedExt {
+				manifests = append(manifests, key)
+			}
 		} else {
 			if !dryRun {
 				// Stick this on our delete queue
@@ -259,20 +567,49 @@ func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Live
 	log.Printf("%d of %d objects (%.2f%%) and %d bytes %s collected",
 		collectedCount, objectsCheckedCount, float64(collectedCount)/float64(objectsCheckedCount)*100, totalBytesCollected, action)
 
-	return scan.Err()
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	return r.collectChunks(ctx, manifests, threshold, dryRun)
 }
 
 // URL returns the URL for this repository. It is of the form:
 //
 //	<type>://bucket/prefix
+//
+// If the repository compresses or chunks objects, the queries
+// "compress=zstd" or "chunk=cdc" are appended, so that repositories
+// dialed from the URL also do.
 func (r *Repository) URL() *url.URL {
 	u, err := url.Parse(r.Bucket.Location() + "/" + r.Prefix)
 	if err != nil {
 		panic(err)
 	}
+	q := make(url.Values)
+	if r.Compress {
+		q.Set("compress", "zstd")
+	}
+	if r.Chunk {
+		q.Set("chunk", "cdc")
+	}
+	u.RawQuery = q.Encode()
 	return u
 }
 
+// errStored returns an error of kind errors.NotSupported for the
+// operation op, which cannot be performed on the object named by id
+// because of the representation in which it is stored.
+func errStored(op string, r *Repository, id digest.Digest, ext string) error {
+	what := "compressed"
+	switch ext {
+	case encryptedExt:
+		what = "encrypted"
+	case chunkedExt:
+		what = "in chunks"
+	}
+	return errors.E(op, r.String(), id, errors.NotSupported, errors.Errorf("object is stored %s", what))
+}
+
 // newID returns a new, randomly generated hexadecimal
 // identifier of length 16.
 func newID() string {
@@ -7,12 +7,23 @@ package blobrepo
 import (
 	"bytes"
 	"context"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/grailbio/base/digest"
 	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/blob"
 	"github.com/grailbio/reflow/blob/testblob"
+	"github.com/grailbio/reflow/errors"
 )
 
 const bucket = "test"
@@ -116,3 +127,477 @@ func TestAbbrevGet(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestScanDelete(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	var ids []digest.Digest
+	for _, content := range []string{"foo", "bar", "baz"} {
+		id, err := r.Put(ctx, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	var scanned []digest.Digest
+	if err := r.Scan(ctx, func(id digest.Digest) error {
+		scanned = append(scanned, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := scanned, ids; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := r.Delete(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(ctx, ids[0]); !errors.Is(errors.NotExist, err) {
+		t.Errorf("expected NotExist, got %v", err)
+	}
+	if _, err := r.Stat(ctx, ids[1]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	r.Compress = true
+	content := bytes.Repeat([]byte("compressible content "), 1000)
+	id, err := r.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := id, reflow.Digester.FromBytes(content); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	file, err := r.Stat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file.Size, int64(len(content)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	key, stored, err := r.lookupStored(ctx, id, compressedExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := r.Bucket.File(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if raw.Size >= stored.Size {
+		t.Errorf("compressed object (%d bytes) is not smaller than its contents (%d bytes)", raw.Size, stored.Size)
+	}
+	if _, err := r.Location(ctx, id); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+
+	// Compressed objects are readable whether or not the repository
+	// compresses new objects.
+	for _, compress := range []bool{true, false} {
+		r.Compress = compress
+		rc, err := r.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Errorf("compress=%v: Get returned wrong contents", compress)
+		}
+		f, err := ioutil.TempFile("", "blobrepotest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := r.GetFile(ctx, id, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := n, int64(len(content)); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		b, err = ioutil.ReadFile(f.Name())
+		f.Close()
+		os.Remove(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Errorf("compress=%v: GetFile returned wrong contents", compress)
+		}
+	}
+
+	// Transfer the compressed object to a repository that does not
+	// compress, and back again.
+	raw := newTestRepository(t)
+	rc, file, err := r.GetCompressed(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = raw.PutCompressed(ctx, file, rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := raw.GetCompressed(ctx, id); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+	if file, err := raw.Stat(ctx, id); err != nil {
+		t.Fatal(err)
+	} else if got, want := file.Size, int64(len(content)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Store the object raw as well: it is scanned once, and deleted
+	// in both representations.
+	if err := r.Bucket.Put(ctx, r.key(id), 0, bytes.NewReader(content), ""); err != nil {
+		t.Fatal(err)
+	}
+	var scanned []digest.Digest
+	if err := r.Scan(ctx, func(id digest.Digest) error {
+		scanned = append(scanned, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := scanned, []digest.Digest{id}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := r.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(ctx, id); !errors.Is(errors.NotExist, err) {
+		t.Errorf("expected NotExist, got %v", err)
+	}
+}
+
+type mapLiveset map[digest.Digest]bool
+
+func (m mapLiveset) Contains(id digest.Digest) bool { return m[id] }
+
+func countChunks(t *testing.T, r *Repository) int {
+	t.Helper()
+	var n int
+	scan := r.Bucket.Scan(chunksPath)
+	for scan.Scan(context.Background()) {
+		n++
+	}
+	if err := scan.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestChunked(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	r.Chunk = true
+	r.chunker = &chunker{min: 64, max: 1024, bits: 8}
+	content := make([]byte, 16<<10)
+	rand.New(rand.NewSource(0)).Read(content)
+	id, err := r.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := id, reflow.Digester.FromBytes(content); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, err := r.lookupStored(ctx, id, chunkedExt); err != nil {
+		t.Fatal(err)
+	}
+	file, err := r.Stat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file.Size, int64(len(content)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := r.Location(ctx, id); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+	rc, err := r.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("Get returned wrong contents")
+	}
+	f, err := ioutil.TempFile("", "blobrepotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	n, err := r.GetFile(ctx, id, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, int64(len(content)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	b, err = ioutil.ReadFile(f.Name())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("GetFile returned wrong contents")
+	}
+
+	// An object that shares most of its content with the first
+	// shares most of its chunks.
+	chunks := countChunks(t, r)
+	appended := append(append([]byte{}, content...), "appended"...)
+	appendedID := reflow.Digester.FromBytes(appended)
+	if err := r.PutFile(ctx, reflow.File{ID: appendedID, Size: int64(len(appended))}, bytes.NewReader(appended)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := countChunks(t, r), chunks+2; got > want {
+		t.Errorf("got %v new chunks, want at most 2", got-chunks)
+	}
+	// Mislabeled files are rejected.
+	mislabeled := reflow.File{ID: reflow.Digester.FromString("mislabeled"), Size: int64(len(appended))}
+	if err := r.PutFile(ctx, mislabeled, bytes.NewReader(appended)); !errors.Is(errors.Integrity, err) {
+		t.Errorf("expected Integrity, got %v", err)
+	}
+
+	// Small objects are stored whole.
+	small, err := r.Put(ctx, bytes.NewReader([]byte("small")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Bucket.File(ctx, r.key(small)); err != nil {
+		t.Error(err)
+	}
+
+	// Collection retains the chunks of live objects only.
+	if err := r.CollectWithThreshold(ctx, mapLiveset{appendedID: true}, mapLiveset{}, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(ctx, id); !errors.Is(errors.NotExist, err) {
+		t.Errorf("expected NotExist, got %v", err)
+	}
+	rc, err = r.Get(ctx, appendedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, appended) {
+		t.Error("Get returned wrong contents after collection")
+	}
+	m, err := r.readManifest(ctx, r.storedKey(appendedID, int64(len(appended)), chunkedExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := countChunks(t, r), len(m.Chunks); got != want {
+		t.Errorf("got %v chunks, want %v", got, want)
+	}
+}
+
+// modBucket is a bucket that records the times at which its objects
+// were last modified, and counts the chunks that are put.
+type modBucket struct {
+	blob.Bucket
+	mu        sync.Mutex
+	modified  map[string]time.Time
+	chunkPuts int
+}
+
+func (b *modBucket) File(ctx context.Context, key string) (reflow.File, error) {
+	file, err := b.Bucket.File(ctx, key)
+	b.mu.Lock()
+	file.LastModified = b.modified[key]
+	b.mu.Unlock()
+	return file, err
+}
+
+func (b *modBucket) Put(ctx context.Context, key string, size int64, body io.Reader, contentHash string) error {
+	if err := b.Bucket.Put(ctx, key, size, body, contentHash); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.modified[key] = time.Now()
+	if strings.HasPrefix(key, chunksPath+"/") {
+		b.chunkPuts++
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// age sets the modification times of all chunks to t.
+func (b *modBucket) age(t time.Time) {
+	b.mu.Lock()
+	for key := range b.modified {
+		if strings.HasPrefix(key, chunksPath+"/") {
+			b.modified[key] = t
+		}
+	}
+	b.mu.Unlock()
+}
+
+func TestChunkRefresh(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	bucket := &modBucket{Bucket: r.Bucket, modified: make(map[string]time.Time)}
+	r.Bucket = bucket
+	r.Chunk = true
+	r.chunker = &chunker{min: 64, max: 1024, bits: 8}
+	content := make([]byte, 16<<10)
+	rand.New(rand.NewSource(0)).Read(content)
+	if _, err := r.Put(ctx, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	chunks := bucket.chunkPuts
+
+	// Recently written chunks are shared without being written again.
+	appended := append(append([]byte{}, content...), "appended"...)
+	if _, err := r.Put(ctx, bytes.NewReader(appended)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bucket.chunkPuts-chunks, 2; got > want {
+		t.Errorf("got %v chunk puts, want at most %v", got, want)
+	}
+
+	// Older chunks are written again when they are shared, so that
+	// they are not collected before the new object's manifest is
+	// written.
+	bucket.age(time.Now().Add(-2 * chunkRefreshAge))
+	start := time.Now()
+	prepended := append([]byte("prepended"), content...)
+	id := reflow.Digester.FromBytes(prepended)
+	if err := r.PutFile(ctx, reflow.File{ID: id, Size: int64(len(prepended))}, bytes.NewReader(prepended)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.readManifest(ctx, r.storedKey(id, int64(len(prepended)), chunkedExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range m.Chunks {
+		file, err := bucket.File(ctx, r.chunkKey(c.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.LastModified.Before(start) {
+			t.Errorf("chunk %v was not refreshed", c.ID)
+		}
+	}
+}
+
+// scanBucket is a bucket that counts the scans of its keys.
+type scanBucket struct {
+	blob.Bucket
+	mu    sync.Mutex
+	scans int
+}
+
+func (b *scanBucket) Scan(prefix string) blob.Scanner {
+	b.mu.Lock()
+	b.scans++
+	b.mu.Unlock()
+	return b.Bucket.Scan(prefix)
+}
+
+func TestStatMiss(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	bucket := &scanBucket{Bucket: r.Bucket}
+	r.Bucket = bucket
+	id := reflow.Digester.FromString("missing")
+	for i := 0; i < 3; i++ {
+		if _, err := r.Stat(ctx, id); !errors.Is(errors.NotExist, err) {
+			t.Fatalf("expected NotExist, got %v", err)
+		}
+	}
+	if got, want := bucket.scans, 1; got != want {
+		t.Errorf("got %v scans, want %v", got, want)
+	}
+	// Objects installed through the repository are found
+	// regardless of earlier misses.
+	r.Compress = true
+	id, err := r.Put(ctx, bytes.NewReader([]byte("missing")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFromWriteTo(t *testing.T) {
+	ctx := context.Background()
+	store := testblob.New("blobrepotest")
+	Register("blobrepotest", store)
+	repo := func(name string, compress bool) *Repository {
+		bucket, err := store.Bucket(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Repository{Bucket: bucket, Compress: compress}
+	}
+	var (
+		src     = repo("src", true)
+		dst     = repo("dst", false)
+		content = bytes.Repeat([]byte("compressible content "), 1000)
+	)
+	id, err := src.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, transfer := range []func() error{
+		func() error { return dst.ReadFrom(ctx, id, src.URL()) },
+		func() error { return src.WriteTo(ctx, id, dst.URL()) },
+	} {
+		if err := transfer(); err != nil {
+			t.Fatal(err)
+		}
+		// The object is copied compressed.
+		if _, _, err := dst.lookupStored(ctx, id, compressedExt); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := dst.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Error("Get returned wrong contents")
+		}
+		if err := dst.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Chunked objects and other repositories are not transferred
+	// directly.
+	src.Chunk, src.chunker = true, &chunker{min: 64, max: 1024, bits: 8}
+	id, err = src.Put(ctx, bytes.NewReader(append(append([]byte{}, content...), "chunked"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.ReadFrom(ctx, id, src.URL()); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+	u, err := url.Parse("https://example.com/repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.ReadFrom(ctx, id, u); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+}
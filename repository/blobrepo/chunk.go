@@ -0,0 +1,405 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package blobrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Large objects may be stored in chunks, so that objects that share
+// content (e.g., successive versions of a file to which records are
+// appended) share storage. Objects are split into chunks at
+// boundaries determined by their content, so that the boundaries
+// are not disturbed by insertions or deletions elsewhere in the
+// object. Chunks are stored, named by their digests, under
+// "chunks":
+//
+//	type://bucket/<prefix>/chunks/sha256:<hex>
+//
+// A chunked object is represented by a manifest that lists its
+// chunks, stored with the extension chunkedExt.
+
+const (
+	chunksPath = "chunks"
+
+	// chunkedExt is the extension of the keys of chunked object
+	// manifests.
+	chunkedExt = ".chunked"
+
+	// chunkConcurrency is the number of chunks that are uploaded or
+	// downloaded concurrently.
+	chunkConcurrency = 8
+
+	// chunkRefreshAge is the age beyond which chunks shared with a
+	// newly stored object are written again. Collection removes
+	// chunks that are not referenced by the manifests it has read,
+	// and that were last modified before its threshold; refreshing
+	// shared chunks keeps them from being collected before the
+	// manifest of the object that now references them is written.
+	chunkRefreshAge = time.Hour
+)
+
+// gear is the table of random values used by the chunker's rolling
+// hash. It is fixed, since chunk boundaries must be stable for
+// chunks to be shared.
+var gear [256]uint64
+
+func init() {
+	// Populate the table with splitmix64.
+	x := uint64(0x5265666c6f774344)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// A chunker splits streams into content-defined chunks using a
+// gear-based rolling hash. Chunks are at least min and at most max
+// bytes long; boundaries occur on average every 2^bits bytes past
+// min.
+type chunker struct {
+	min, max int
+	bits     uint
+}
+
+// defaultChunker produces chunks of 5 MiB on average, and of at
+// most 16 MiB.
+var defaultChunker = &chunker{min: 1 << 20, max: 16 << 20, bits: 22}
+
+// Split reads r to completion, invoking handle for each chunk. The
+// chunk passed to handle is valid only until handle returns.
+func (c *chunker) Split(r io.Reader, handle func(chunk []byte) error) error {
+	var (
+		buf = make([]byte, 0, c.max)
+		eof bool
+	)
+	for {
+		for !eof && len(buf) < c.max {
+			n, err := r.Read(buf[len(buf):c.max])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+		n := c.cut(buf)
+		if err := handle(buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[:copy(buf, buf[n:])]
+	}
+}
+
+// cut returns the length of the first chunk in b, which must
+// contain max bytes unless it is the remainder of the stream.
+func (c *chunker) cut(b []byte) int {
+	if len(b) <= c.min {
+		return len(b)
+	}
+	n := len(b)
+	if n > c.max {
+		n = c.max
+	}
+	var (
+		h     uint64
+		shift = 64 - c.bits
+	)
+	for i := c.min; i < n; i++ {
+		h = h<<1 + gear[b[i]]
+		if h>>shift == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// chunk describes a chunk of an object.
+type chunk struct {
+	ID   digest.Digest
+	Size int64
+}
+
+// manifest describes the chunks of an object.
+type manifest struct {
+	Chunks []chunk
+}
+
+// splitter returns the chunker used to split the repository's
+// objects.
+func (r *Repository) splitter() *chunker {
+	if r.chunker != nil {
+		return r.chunker
+	}
+	return defaultChunker
+}
+
+// chunks tells whether an object of the provided size may be
+// stored in chunks. Objects no larger than the chunker's minimum
+// chunk size always comprise a single chunk, and are stored whole.
+func (r *Repository) chunks(size int64) bool {
+	return r.Chunk && size > int64(r.splitter().min)
+}
+
+// chunkKey returns the key of the chunk named by id.
+func (r *Repository) chunkKey(id digest.Digest) string {
+	return path.Join(r.Prefix, chunksPath, id.String())
+}
+
+// putChunked splits the object read from body into chunks, storing
+// each chunk that is not already present in the repository, or that
+// was last modified more than chunkRefreshAge ago. If the object
+// comprises a single chunk, it is instead stored whole.
+// Otherwise, a manifest of the object is stored. putChunked returns
+// the object's digest and size.
+func (r *Repository) putChunked(ctx context.Context, body io.Reader) (digest.Digest, int64, error) {
+	var (
+		dw    = reflow.Digester.NewWriter()
+		m     manifest
+		size  int64
+		first []byte
+		sema  = make(chan struct{}, chunkConcurrency)
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	put := func(p []byte) {
+		id := reflow.Digester.FromBytes(p)
+		m.Chunks = append(m.Chunks, chunk{id, int64(len(p))})
+		sema <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sema }()
+			key := r.chunkKey(id)
+			file, err := r.Bucket.File(gctx, key)
+			switch {
+			case err == nil && time.Since(file.LastModified) < chunkRefreshAge:
+				// The chunk is shared with another object.
+				return nil
+			case err != nil && !errors.Is(errors.NotExist, err):
+				return err
+			}
+			return r.Bucket.Put(gctx, key, int64(len(p)), bytes.NewReader(p), id.Hex())
+		})
+	}
+	err := r.splitter().Split(io.TeeReader(body, dw), func(p []byte) error {
+		size += int64(len(p))
+		// We hold on to the first chunk until we know whether the
+		// object comprises more than one.
+		if len(m.Chunks) == 0 && first == nil {
+			first = append([]byte{}, p...)
+			return gctx.Err()
+		}
+		if first != nil {
+			put(first)
+			first = nil
+		}
+		put(append([]byte{}, p...))
+		return gctx.Err()
+	})
+	if werr := g.Wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return digest.Digest{}, 0, err
+	}
+	id := dw.Digest()
+	r.stored(id)
+	if first != nil || size == 0 {
+		return id, size, r.Bucket.Put(ctx, r.key(id), size, bytes.NewReader(first), id.Hex())
+	}
+	if _, err := r.Stat(ctx, id); err == nil {
+		return id, size, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return digest.Digest{}, 0, err
+	}
+	return id, size, r.Bucket.Put(ctx, r.storedKey(id, size, chunkedExt), int64(len(b)), bytes.NewReader(b), "")
+}
+
+// readManifest reads the chunked object manifest stored at key.
+func (r *Repository) readManifest(ctx context.Context, key string) (manifest, error) {
+	var m manifest
+	rc, _, err := r.Bucket.Get(ctx, key, "")
+	if err != nil {
+		return m, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, errors.E("readmanifest", key, errors.Integrity, err)
+	}
+	return m, nil
+}
+
+// getChunked returns a reader of the chunked object whose manifest
+// is stored at key. Chunks are retrieved as they are read.
+func (r *Repository) getChunked(ctx context.Context, key string) (io.ReadCloser, error) {
+	m, err := r.readManifest(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, r: r, chunks: m.Chunks}, nil
+}
+
+// getFileChunked retrieves the chunked object whose manifest is
+// stored at key into w. Chunks are retrieved concurrently.
+func (r *Repository) getFileChunked(ctx context.Context, key string, w io.WriterAt) (int64, error) {
+	m, err := r.readManifest(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		off  int64
+		sema = make(chan struct{}, chunkConcurrency)
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	for _, c := range m.Chunks {
+		c, sw := c, &sectionWriter{w: w, off: off}
+		off += c.Size
+		sema <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sema }()
+			_, err := r.Bucket.Download(gctx, r.chunkKey(c.ID), "", c.Size, sw)
+			return err
+		})
+	}
+	return off, g.Wait()
+}
+
+// collectChunks removes the chunks that are not referenced by any of
+// the chunked object manifests stored at the provided keys, and that
+// were last modified before threshold. Chunks written more recently
+// may belong to objects that are being installed.
+func (r *Repository) collectChunks(ctx context.Context, manifests []string, threshold time.Time, dryRun bool) error {
+	live := make(map[digest.Digest]bool)
+	for _, key := range manifests {
+		m, err := r.readManifest(ctx, key)
+		if err != nil {
+			// We cannot safely collect chunks without knowing
+			// all of the live ones.
+			return errors.E("collect", r.String(), key, err)
+		}
+		for _, c := range m.Chunks {
+			live[c.ID] = true
+		}
+	}
+	var (
+		checked, collected int64
+		collectedBytes     int64
+		todo               = make([]string, 0, deleteMaxObjects)
+	)
+	scan := r.Bucket.Scan(path.Join(r.Prefix, chunksPath))
+	for scan.Scan(ctx) {
+		checked++
+		var (
+			file = scan.File()
+			key  = scan.Key()
+		)
+		id, err := reflow.Digester.Parse(path.Base(key))
+		if err != nil {
+			log.Errorf("invalid s3 entry %v (%s)", key, file)
+			continue
+		}
+		if live[id] || file.LastModified.After(threshold) {
+			continue
+		}
+		collected++
+		collectedBytes += file.Size
+		if dryRun {
+			continue
+		}
+		todo = append(todo, key)
+		if len(todo) == cap(todo) {
+			if err := r.delete(ctx, todo); err != nil {
+				log.Errorf("error deleting chunks: %v", err)
+			}
+			todo = todo[:0]
+		}
+	}
+	if len(todo) > 0 {
+		if err := r.delete(ctx, todo); err != nil {
+			log.Errorf("error deleting chunks: %v", err)
+		}
+	}
+	if checked > 0 {
+		action := "would have been"
+		if !dryRun {
+			action = "were"
+		}
+		log.Printf("%d of %d chunks and %d bytes %s collected", collected, checked, collectedBytes, action)
+	}
+	return scan.Err()
+}
+
+// chunkReader reads the concatenation of a sequence of chunks.
+type chunkReader struct {
+	ctx    context.Context
+	r      *Repository
+	chunks []chunk
+	cur    io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			rc, _, err := c.r.Bucket.Get(c.ctx, c.r.chunkKey(c.chunks[0].ID), "")
+			if err != nil {
+				return 0, err
+			}
+			c.cur, c.chunks = rc, c.chunks[1:]
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	return c.cur.Close()
+}
+
+// sectionWriter is an io.WriterAt that writes to w at offsets
+// relative to off.
+type sectionWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (s *sectionWriter) WriteAt(p []byte, off int64) (int, error) {
+	return s.w.WriteAt(p, s.off+off)
+}
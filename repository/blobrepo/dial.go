@@ -7,10 +7,12 @@ package blobrepo
 import (
 	"context"
 	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/blob"
+	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/repository"
 )
 
@@ -32,14 +34,44 @@ func Register(scheme string, store blob.Store) {
 //
 //	type://bucket/prefix
 //
+// The queries "compress=zstd" and "chunk=cdc" may be appended in
+// order to dial a repository that stores new objects compressed or
+// in chunks.
+//
 // TODO(marius): we should support shipping authentication
 // information in the URL also.
 func Dial(u *url.URL) (reflow.Repository, error) {
+	var (
+		compress = u.Query().Get("compress") == "zstd"
+		chunk    = u.Query().Get("chunk") == "cdc"
+	)
+	bu := *u
+	bu.RawQuery = ""
 	mu.RLock()
-	bucket, prefix, err := mux.Bucket(context.Background(), u.String())
+	bucket, prefix, err := mux.Bucket(context.Background(), bu.String())
 	mu.RUnlock()
 	if err != nil {
 		return nil, err
 	}
-	return &Repository{bucket, prefix}, nil
+	// Repository.URL separates the bucket's location from the
+	// prefix with a slash.
+	prefix = strings.TrimPrefix(prefix, "/")
+	return &Repository{Bucket: bucket, Prefix: prefix, Compress: compress, Chunk: chunk}, nil
+}
+
+// dialRepository dials the blob repository at u. It returns an error
+// of kind errors.NotSupported if no blob store is registered for u's
+// scheme.
+func dialRepository(u *url.URL) (*Repository, error) {
+	mu.RLock()
+	_, ok := mux[u.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, errors.E(errors.NotSupported, errors.Errorf("no blob store for scheme %s", u.Scheme))
+	}
+	repo, err := Dial(u)
+	if err != nil {
+		return nil, err
+	}
+	return repo.(*Repository), nil
 }
@@ -0,0 +1,198 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package blobrepo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+)
+
+const (
+	// storedMissTTL is the time for which Stat remembers that an
+	// object is not stored in any non-raw representation, so that
+	// repeated existence checks do not each list the bucket. Objects
+	// stored in non-raw representations by other clients may thus go
+	// unnoticed by Stat for up to storedMissTTL.
+	storedMissTTL = time.Minute
+	// maxStoredMisses bounds the number of remembered misses.
+	maxStoredMisses = 1 << 16
+)
+
+// key returns the key of the raw object named by id.
+func (r *Repository) key(id digest.Digest) string {
+	return path.Join(r.Prefix, objectsPath, id.String())
+}
+
+// storedKey returns the key of the object named by id, whose
+// (logical) size is size, stored in the representation given by the
+// extension ext.
+func (r *Repository) storedKey(id digest.Digest, size int64, ext string) string {
+	return fmt.Sprintf("%s.%d%s", r.key(id), size, ext)
+}
+
+// storedExts are the extensions of the keys of objects that are
+// not stored raw.
+var storedExts = []string{compressedExt, encryptedExt, chunkedExt}
+
+// parseKey parses an object key, returning the object's digest, and,
+// if the object is not stored raw, the extension of its
+// representation and its logical size.
+func parseKey(key string) (id digest.Digest, size int64, ext string, err error) {
+	name := path.Base(key)
+	for _, e := range storedExts {
+		if !strings.HasSuffix(name, e) {
+			continue
+		}
+		name = strings.TrimSuffix(name, e)
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return id, 0, "", errors.E("parsekey", key, errors.Invalid)
+		}
+		size, err = strconv.ParseInt(name[i+1:], 10, 64)
+		if err != nil {
+			return id, 0, "", errors.E("parsekey", key, errors.Invalid, err)
+		}
+		name, ext = name[:i], e
+		break
+	}
+	id, err = reflow.Digester.Parse(name)
+	return
+}
+
+// lookupStored returns the key and metadata of the object named by
+// id that is stored in the representation given by ext, or in any
+// non-raw representation if ext is empty. The returned file's size
+// is the logical size of the object.
+func (r *Repository) lookupStored(ctx context.Context, id digest.Digest, ext string) (string, reflow.File, error) {
+	scan := r.Bucket.Scan(r.key(id) + ".")
+	for scan.Scan(ctx) {
+		key := scan.Key()
+		kid, size, kext, err := parseKey(key)
+		if err != nil || kext == "" || kid != id || (ext != "" && kext != ext) {
+			continue
+		}
+		file := scan.File()
+		file.Size = size
+		return key, file, nil
+	}
+	if err := scan.Err(); err != nil {
+		return "", reflow.File{}, err
+	}
+	return "", reflow.File{}, errors.E("stat", r.String(), id, errors.NotExist)
+}
+
+// object looks up the object named by the (possibly abbreviated) id,
+// returning its key, its metadata, and the extension of its
+// representation, which is empty for raw objects. Objects are looked
+// up first in the representation in which the repository stores new
+// objects. If cached is true, recent misses of the object's non-raw
+// representations are reused; see storedMissTTL.
+func (r *Repository) object(ctx context.Context, id digest.Digest, cached bool) (key string, file reflow.File, ext string, err error) {
+	id, err = r.resolve(ctx, id)
+	if err != nil {
+		return
+	}
+	lookupRaw := func() (string, reflow.File, string, error) {
+		key := r.key(id)
+		file, err := r.Bucket.File(ctx, key)
+		return key, file, "", err
+	}
+	lookupStored := func() (string, reflow.File, string, error) {
+		if cached && r.missedStored(id) {
+			return "", reflow.File{}, "", errors.E("stat", r.String(), id, errors.NotExist)
+		}
+		key, file, err := r.lookupStored(ctx, id, "")
+		if cached && errors.Is(errors.NotExist, err) {
+			r.missStored(id)
+		}
+		if err != nil {
+			return "", file, "", err
+		}
+		_, _, ext, err := parseKey(key)
+		return key, file, ext, err
+	}
+	lookups := [2]func() (string, reflow.File, string, error){lookupRaw, lookupStored}
+	if r.Compress {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	key, file, ext, err = lookups[0]()
+	if errors.Is(errors.NotExist, err) {
+		var err2 error
+		key, file, ext, err2 = lookups[1]()
+		if !errors.Is(errors.NotExist, err2) {
+			err = err2
+		}
+	}
+	if err == nil {
+		file.ID = id
+	}
+	return
+}
+
+// missedStored tells whether the non-raw representations of the
+// object named by id were found missing within storedMissTTL.
+func (r *Repository) missedStored(id digest.Digest) bool {
+	r.missMu.Lock()
+	defer r.missMu.Unlock()
+	t, ok := r.misses[id]
+	if ok && time.Since(t) >= storedMissTTL {
+		delete(r.misses, id)
+		ok = false
+	}
+	return ok
+}
+
+// missStored records that the non-raw representations of the
+// object named by id are missing.
+func (r *Repository) missStored(id digest.Digest) {
+	r.missMu.Lock()
+	defer r.missMu.Unlock()
+	if r.misses == nil || len(r.misses) >= maxStoredMisses {
+		r.misses = make(map[digest.Digest]time.Time)
+	}
+	r.misses[id] = time.Now()
+}
+
+// stored forgets any recorded miss of the object named by id, which
+// has been installed in the repository.
+func (r *Repository) stored(id digest.Digest) {
+	r.missMu.Lock()
+	delete(r.misses, id)
+	r.missMu.Unlock()
+}
+
+// offsetWriter adapts an io.WriterAt to an io.Writer that writes
+// sequentially from offset 0.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
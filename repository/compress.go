@@ -0,0 +1,78 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"io"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressedGetter is implemented by repositories that may store
+// objects in compressed form. GetCompressed returns a reader of the
+// zstd-compressed contents of the object named by id, together with
+// the object's metadata, which describes the uncompressed object.
+// GetCompressed returns an error of kind errors.NotSupported if the
+// object is not stored in compressed form.
+type CompressedGetter interface {
+	GetCompressed(ctx context.Context, id digest.Digest) (io.ReadCloser, reflow.File, error)
+}
+
+// CompressedPutter is implemented by repositories that can install
+// objects directly from their compressed form. PutCompressed installs
+// the object described by file from the zstd-compressed stream body.
+// The caller guarantees that file's ID and size describe the
+// uncompressed contents of body.
+type CompressedPutter interface {
+	PutCompressed(ctx context.Context, file reflow.File, body io.Reader) error
+}
+
+// Compress returns a writer that compresses the data written to it
+// and writes the compressed stream to w. The returned writer must be
+// closed in order to flush the compressed stream; closing it does not
+// close w.
+func Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// Decompress returns a reader of the decompressed contents of the
+// compressed stream r. Closing the returned reader also closes r.
+func Decompress(r io.ReadCloser) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &decompressor{dec, r}, nil
+}
+
+type decompressor struct {
+	*zstd.Decoder
+	r io.ReadCloser
+}
+
+func (d *decompressor) Close() error {
+	d.Decoder.Close()
+	return d.r.Close()
+}
+
+// transferCompressed transfers the object named by id from src to
+// dst in its compressed form. It returns an error of kind
+// errors.NotSupported if src does not store the object compressed.
+func transferCompressed(ctx context.Context, dst CompressedPutter, src CompressedGetter, id digest.Digest) error {
+	rc, file, err := src.GetCompressed(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if file.ID != id {
+		return errors.E("transfer", id, errors.Integrity, errors.Errorf("compressed object has digest %v", file.ID))
+	}
+	return dst.PutCompressed(ctx, file, rc)
+}
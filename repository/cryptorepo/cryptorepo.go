@@ -0,0 +1,183 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package cryptorepo implements a repository that encrypts objects
+// before they are stored, and decrypts them as they are retrieved.
+// Objects are encrypted with envelope encryption: each object is
+// encrypted (with AES-256-GCM) using its own data key, which is
+// sealed by a pluggable KeyProvider and stored with the object.
+//
+// Objects remain named by the digests of their plaintexts, so that
+// an encrypting repository may be used wherever a reflow.Repository
+// is expected, including as a cache.
+//
+// Assoc values are not encrypted. Assocs map digests of flows to
+// digests of the filesets they computed; both are opaque content
+// digests that do not disclose the contents of the objects they
+// name. The filesets themselves, like all other cached objects, are
+// stored in (and encrypted by) the repository. An encrypting
+// repository may be configured through infra with the s3 backed
+// provider "crypto" (see package repository/s3).
+package cryptorepo
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/liveset"
+	"github.com/grailbio/reflow/repository"
+)
+
+// Store is implemented by repositories that can store encrypted
+// objects on behalf of a client. Store is implemented by
+// blobrepo.Repository.
+type Store interface {
+	reflow.Repository
+
+	// GetEncrypted retrieves the ciphertext of the object named by
+	// id, together with the object's (plaintext) metadata. It
+	// returns an error of kind errors.NotSupported if the object
+	// is not stored encrypted.
+	GetEncrypted(ctx context.Context, id digest.Digest) (io.ReadCloser, reflow.File, error)
+
+	// PutEncrypted stores the ciphertext body of the object
+	// described by file.
+	PutEncrypted(ctx context.Context, file reflow.File, body io.Reader) error
+}
+
+// Repository is a reflow.Repository that encrypts objects before
+// they are stored in an underlying Store.
+//
+// Since only the client holds the keys needed to decrypt objects,
+// Repository does not support direct transfers: it has no URL, and
+// objects cannot be located. Objects are thus always transferred
+// through the repository, which decrypts them; ciphertext is never
+// copied to other repositories or to extern destinations.
+//
+// Objects that were stored unencrypted in the underlying Store
+// remain readable.
+type Repository struct {
+	// Store stores the repository's encrypted objects.
+	Store Store
+	// Keys generates and unseals the data keys of objects.
+	Keys KeyProvider
+	// TempDir is the directory in which ciphertexts are staged
+	// before they are stored. The system default is used if it
+	// is empty.
+	TempDir string
+}
+
+// Stat returns metadata for the object named by id. The returned
+// size is the size of the object's plaintext.
+func (r *Repository) Stat(ctx context.Context, id digest.Digest) (reflow.File, error) {
+	return r.Store.Stat(ctx, id)
+}
+
+// Get retrieves and decrypts the object named by id.
+func (r *Repository) Get(ctx context.Context, id digest.Digest) (io.ReadCloser, error) {
+	rc, _, err := r.Store.GetEncrypted(ctx, id)
+	if errors.Is(errors.NotSupported, err) {
+		return r.Store.Get(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dc, err := newDecrypter(ctx, rc, r.Keys)
+	if err != nil {
+		return nil, errors.E("get", id, err)
+	}
+	return dc, nil
+}
+
+// Put encrypts the object read from body and stores it. Its
+// (plaintext) digest is returned. The ciphertext is staged in a
+// temporary file, since the object's digest, which names it, is
+// known only once it has been read.
+func (r *Repository) Put(ctx context.Context, body io.Reader) (digest.Digest, error) {
+	key, sealed, err := r.Keys.GenerateKey(ctx)
+	if err != nil {
+		return digest.Digest{}, errors.E("put", err)
+	}
+	f, err := ioutil.TempFile(r.TempDir, "cryptorepo-")
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w, err := newEncrypter(f, key, sealed)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	dw := reflow.Digester.NewWriter()
+	n, err := io.Copy(io.MultiWriter(w, dw), body)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if err := w.Close(); err != nil {
+		return digest.Digest{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return digest.Digest{}, err
+	}
+	id := dw.Digest()
+	return id, r.Store.PutEncrypted(ctx, reflow.File{ID: id, Size: n}, f)
+}
+
+// Location is not supported by encrypting repositories: the
+// underlying objects are ciphertext.
+func (r *Repository) Location(ctx context.Context, id digest.Digest) (string, error) {
+	return "", errors.E("location", id, errors.NotSupported, errors.New("objects are stored encrypted"))
+}
+
+// WriteTo is not supported by encrypting repositories.
+func (r *Repository) WriteTo(ctx context.Context, id digest.Digest, u *url.URL) error {
+	return errors.E("writeto", id, u.String(), errors.NotSupported)
+}
+
+// ReadFrom is not supported by encrypting repositories.
+func (r *Repository) ReadFrom(ctx context.Context, id digest.Digest, u *url.URL) error {
+	return errors.E("readfrom", id, u.String(), errors.NotSupported)
+}
+
+// URL returns nil: encrypting repositories cannot be accessed
+// directly.
+func (r *Repository) URL() *url.URL {
+	return nil
+}
+
+// Collect collects the underlying store.
+func (r *Repository) Collect(ctx context.Context, live liveset.Liveset) error {
+	return r.Store.Collect(ctx, live)
+}
+
+// CollectWithThreshold collects the underlying store.
+func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Liveset, dead liveset.Liveset, threshold time.Time, dryRun bool) error {
+	return r.Store.CollectWithThreshold(ctx, live, dead, threshold, dryRun)
+}
+
+// Scan scans the underlying store, if it supports scanning.
+func (r *Repository) Scan(ctx context.Context, handler func(digest.Digest) error) error {
+	s, ok := r.Store.(repository.Scanner)
+	if !ok {
+		return errors.E("scan", errors.NotSupported)
+	}
+	return s.Scan(ctx, handler)
+}
+
+// Delete removes an object from the underlying store, if it
+// supports deletion.
+func (r *Repository) Delete(ctx context.Context, id digest.Digest) error {
+	d, ok := r.Store.(repository.Deleter)
+	if !ok {
+		return errors.E("delete", id, errors.NotSupported)
+	}
+	return d.Delete(ctx, id)
+}
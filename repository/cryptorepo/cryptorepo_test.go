@@ -0,0 +1,190 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package cryptorepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/blob/testblob"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/repository/blobrepo"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func newKeyfile(t *testing.T) *Keyfile {
+	t.Helper()
+	master := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, master); err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyfile(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func newTestRepository(t *testing.T) (*Repository, *blobrepo.Repository) {
+	t.Helper()
+	bucket, err := testblob.New("test").Bucket(context.Background(), "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &blobrepo.Repository{Bucket: bucket}
+	return &Repository{Store: store, Keys: newKeyfile(t)}, store
+}
+
+func get(t *testing.T, repo reflow.Repository, file reflow.File) []byte {
+	t.Helper()
+	rc, err := repo.Get(context.Background(), file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	r, store := newTestRepository(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, 3*chunkSize + 17} {
+		content := bytes.Repeat([]byte{'x'}, size)
+		if size > 0 {
+			content[size-1] = byte(size)
+		}
+		id, err := r.Put(ctx, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := id, reflow.Digester.FromBytes(content); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		file, err := r.Stat(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := file.Size, int64(size); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got := get(t, r, file); !bytes.Equal(got, content) {
+			t.Errorf("size %d: decrypted contents do not match", size)
+		}
+		// The ciphertext is neither retrievable nor locatable
+		// through the underlying repository.
+		if _, err := store.Get(ctx, id); !errors.Is(errors.NotSupported, err) {
+			t.Errorf("expected NotSupported, got %v", err)
+		}
+		if _, err := store.Location(ctx, id); !errors.Is(errors.NotSupported, err) {
+			t.Errorf("expected NotSupported, got %v", err)
+		}
+		rc, _, err := store.GetEncrypted(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size >= 16 && bytes.Contains(ciphertext, content) {
+			t.Errorf("size %d: ciphertext contains plaintext", size)
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestRepository(t)
+	content := []byte("protected health information")
+	id, err := r.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transfers go through the encrypting repository, so that
+	// the destination receives plaintext.
+	dst := testutil.NewInmemoryRepository()
+	if err := repository.Transfer(ctx, dst, r, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, dst, reflow.File{ID: id}); !bytes.Equal(got, content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+	if _, err := r.Location(ctx, id); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+}
+
+func TestUnencrypted(t *testing.T) {
+	ctx := context.Background()
+	r, store := newTestRepository(t)
+	content := []byte("stored before encryption was enabled")
+	id, err := store.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, r, reflow.File{ID: id}); !bytes.Equal(got, content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	r, store := newTestRepository(t)
+	content := bytes.Repeat([]byte("secret"), chunkSize)
+	id, err := r.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, file, err := store.GetEncrypted(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tamper := range []struct {
+		name string
+		f    func([]byte) []byte
+	}{
+		{"flip", func(b []byte) []byte { b[len(b)/2] ^= 1; return b }},
+		{"truncate", func(b []byte) []byte { return b[:len(b)-chunkSize/2] }},
+		{"truncatechunk", func(b []byte) []byte { return b[:len(b)-chunkSize-16] }},
+	} {
+		b := tamper.f(append([]byte{}, ciphertext...))
+		if err := store.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PutEncrypted(ctx, file, bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := r.Get(ctx, id)
+		if err == nil {
+			_, err = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+		if !errors.Is(errors.Integrity, err) {
+			t.Errorf("%s: expected Integrity error, got %v", tamper.name, err)
+		}
+	}
+
+	// Objects cannot be decrypted with another master key.
+	r.Keys = newKeyfile(t)
+	if _, err := r.Get(ctx, id); !errors.Is(errors.Invalid, err) {
+		t.Errorf("expected Invalid, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package cryptorepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+// KeySize is the size, in bytes, of data keys and of Keyfile master
+// keys. Keys are used with AES-256.
+const KeySize = 32
+
+// A KeyProvider generates and unseals data keys. Each object is
+// encrypted with its own data key; the key is sealed by the
+// provider (e.g., with a master key that never leaves a key
+// management service) and stored alongside the object's
+// ciphertext.
+type KeyProvider interface {
+	// GenerateKey returns a new data key of KeySize bytes,
+	// together with its sealed form.
+	GenerateKey(ctx context.Context) (key, sealed []byte, err error)
+
+	// OpenKey returns the data key sealed in sealed.
+	OpenKey(ctx context.Context, sealed []byte) ([]byte, error)
+}
+
+// keyIDSize is the number of bytes of a Keyfile master key's
+// identifier that are stored with each sealed data key.
+const keyIDSize = 8
+
+// Keyfile is a KeyProvider that seals data keys with a master key
+// stored in a local file. It is useful for testing, and for
+// deployments that distribute the key file by other means.
+type Keyfile struct {
+	aead cipher.AEAD
+	id   []byte
+}
+
+// NewKeyfile returns a Keyfile that seals data keys with the
+// provided master key, which must be KeySize bytes long.
+func NewKeyfile(master []byte) (*Keyfile, error) {
+	if len(master) != KeySize {
+		return nil, errors.E("keyfile", errors.Invalid,
+			errors.Errorf("master key is %d bytes; must be %d", len(master), KeySize))
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	// The key's identifier is stored with sealed keys so that keys
+	// sealed with another master key are detected.
+	sum := sha256.Sum256(master)
+	return &Keyfile{aead: aead, id: sum[:keyIDSize]}, nil
+}
+
+// ReadKeyfile reads a hex-encoded master key from the file at the
+// provided path and returns a Keyfile that uses it.
+func ReadKeyfile(path string) (*Keyfile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.E("keyfile", path, err)
+	}
+	master, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, errors.E("keyfile", path, errors.Invalid, err)
+	}
+	k, err := NewKeyfile(master)
+	if err != nil {
+		return nil, errors.E("keyfile", path, err)
+	}
+	return k, nil
+}
+
+// GenerateKey implements KeyProvider.
+func (k *Keyfile) GenerateKey(ctx context.Context) (key, sealed []byte, err error) {
+	key = make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	sealed = append(append([]byte{}, k.id...), nonce...)
+	sealed = k.aead.Seal(sealed, nonce, key, k.id)
+	return key, sealed, nil
+}
+
+// OpenKey implements KeyProvider.
+func (k *Keyfile) OpenKey(ctx context.Context, sealed []byte) ([]byte, error) {
+	n := keyIDSize + k.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.E("openkey", errors.Integrity, errors.New("sealed key is truncated"))
+	}
+	if !bytes.Equal(sealed[:keyIDSize], k.id) {
+		return nil, errors.E("openkey", errors.Invalid, errors.New("key was sealed with a different master key"))
+	}
+	key, err := k.aead.Open(nil, sealed[keyIDSize:n], sealed[n:], k.id)
+	if err != nil {
+		return nil, errors.E("openkey", errors.Integrity, err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package cryptorepo
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+// Encrypted objects are stored as a header followed by a sequence of
+// chunks. The header comprises a magic number and the object's
+// sealed data key, prefixed by its length:
+//
+//	magic [4]byte
+//	len   uint32 (big endian)
+//	key   [len]byte
+//
+// Each chunk is a chunkSize block of plaintext (the last of which
+// may be shorter, or empty) sealed with AES-GCM. Chunk nonces
+// comprise the chunk's index and a flag marking the last chunk, so
+// that chunks cannot be reordered and objects cannot be truncated
+// without detection. Since every object is encrypted with its own
+// data key, nonces are never reused.
+var magic = [4]byte{'R', 'F', 'E', '1'}
+
+const chunkSize = 64 << 10
+
+// nonce returns the nonce of the i'th chunk.
+func nonce(i uint64, last bool) []byte {
+	var n [12]byte
+	binary.BigEndian.PutUint64(n[:8], i)
+	if last {
+		n[11] = 1
+	}
+	return n[:]
+}
+
+// encrypter is an io.WriteCloser that encrypts the data written to
+// it. The encrypted stream is completed only when it is closed.
+type encrypter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	i    uint64
+	err  error
+}
+
+func newEncrypter(w io.Writer, key, sealed []byte) (*encrypter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, len(magic)+4, len(magic)+4+len(sealed))
+	copy(hdr, magic[:])
+	binary.BigEndian.PutUint32(hdr[len(magic):], uint32(len(sealed)))
+	if _, err := w.Write(append(hdr, sealed...)); err != nil {
+		return nil, err
+	}
+	return &encrypter{w: w, aead: aead, buf: make([]byte, 0, chunkSize+aead.Overhead())}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	var n int
+	for len(p) > 0 {
+		// We hold on to a full chunk until more data arrives, since
+		// we don't otherwise know whether it is the last one.
+		if len(e.buf) == chunkSize {
+			if e.err = e.flush(false); e.err != nil {
+				return n, e.err
+			}
+		}
+		m := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals the last chunk. It does not close the underlying
+// writer.
+func (e *encrypter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.flush(true); err != nil {
+		e.err = err
+		return err
+	}
+	e.err = errors.New("write to closed encrypter")
+	return nil
+}
+
+func (e *encrypter) flush(last bool) error {
+	b := e.aead.Seal(e.buf[:0], nonce(e.i, last), e.buf, nil)
+	e.i++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(b)
+	return err
+}
+
+// decrypter is an io.ReadCloser that decrypts an encrypted object.
+type decrypter struct {
+	r     *bufio.Reader
+	c     io.Closer
+	aead  cipher.AEAD
+	buf   []byte
+	plain []byte
+	i     uint64
+	done  bool
+}
+
+// newDecrypter returns a reader of the plaintext of the encrypted
+// object read from rc, whose data key is unsealed by keys. Closing
+// the decrypter closes rc.
+func newDecrypter(ctx context.Context, rc io.ReadCloser, keys KeyProvider) (*decrypter, error) {
+	r := bufio.NewReader(rc)
+	var hdr [len(magic) + 4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		rc.Close()
+		return nil, errors.E("decrypt", errors.Integrity, err)
+	}
+	if [4]byte{hdr[0], hdr[1], hdr[2], hdr[3]} != magic {
+		rc.Close()
+		return nil, errors.E("decrypt", errors.Integrity, errors.New("invalid object header"))
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(hdr[len(magic):]))
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		rc.Close()
+		return nil, errors.E("decrypt", errors.Integrity, err)
+	}
+	key, err := keys.OpenKey(ctx, sealed)
+	if err != nil {
+		rc.Close()
+		return nil, errors.E("decrypt", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &decrypter{r: r, c: rc, aead: aead, buf: make([]byte, chunkSize+aead.Overhead())}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk.
+func (d *decrypter) next() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	switch err {
+	case nil:
+		// A full chunk is the last one if nothing follows it.
+		switch _, err := d.r.Peek(1); err {
+		case nil:
+		case io.EOF:
+			d.done = true
+		default:
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		d.done = true
+	case io.EOF:
+		return errors.E("decrypt", errors.Integrity, errors.New("object is truncated"))
+	default:
+		return err
+	}
+	plain, err := d.aead.Open(d.buf[:0], nonce(d.i, d.done), d.buf[:n], nil)
+	if err != nil {
+		return errors.E("decrypt", errors.Integrity, err)
+	}
+	d.i++
+	d.plain = plain
+	return nil
+}
+
+func (d *decrypter) Close() error {
+	return d.c.Close()
+}
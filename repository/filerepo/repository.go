@@ -119,6 +119,15 @@ func (r *Repository) Remove(id digest.Digest) error {
 	return os.Remove(path)
 }
 
+// Delete removes an object from the repository. It implements
+// repository.Deleter.
+func (r *Repository) Delete(ctx context.Context, id digest.Digest) error {
+	if err := r.Remove(id); err != nil {
+		return errors.E("delete", r.Root, id, err)
+	}
+	return nil
+}
+
 // ReadFrom installs an object directly from a foreign repository. If
 // the foreign repository supports supports GetFile, it is used to
 // download directly.
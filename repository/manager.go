@@ -17,6 +17,7 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/metrics"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -41,6 +42,12 @@ func (l *Limits) Set(k string, v int) {
 	l.limits[k] = v
 }
 
+// SetRepository sets the limit for repository r. Unlike Set, it may
+// be used for repositories that do not have URLs.
+func (l *Limits) SetRepository(r reflow.Repository, v int) {
+	l.Set(key(r), v)
+}
+
 // Limit retrieves the limit for key k.
 func (l *Limits) Limit(k string) int {
 	if n, ok := l.limits[k]; ok {
@@ -49,6 +56,16 @@ func (l *Limits) Limit(k string) int {
 	return l.def
 }
 
+var (
+	transferBytes = metrics.NewCounter("reflow_transfer_bytes_total",
+		"Number of bytes transferred, by source and destination repository.", "src", "dst")
+	transferFiles = metrics.NewCounter("reflow_transfer_files_total",
+		"Number of files transferred, by source and destination repository.", "src", "dst")
+	transferPending = metrics.NewGauge("reflow_transfer_pending_bytes",
+		"Number of bytes in transfers that are waiting or transferring, by source and destination repository.",
+		"src", "dst", "status")
+)
+
 type transferStatus int
 
 const (
@@ -334,6 +351,19 @@ func (m *Manager) updateStats(src, dst reflow.Repository, status transferStatus,
 	m.managerStat.Update(status, stat)
 	m.Status.Print(m.managerStat)
 	m.mu.Unlock()
+
+	srcLabel, dstLabel := label(src), label(dst)
+	switch status {
+	case waiting:
+		transferPending.With(srcLabel, dstLabel, "waiting").Add(float64(stat.Size))
+	case transferring:
+		transferPending.With(srcLabel, dstLabel, "waiting").Add(-float64(stat.Size))
+		transferPending.With(srcLabel, dstLabel, "transferring").Add(float64(stat.Size))
+	case done:
+		transferPending.With(srcLabel, dstLabel, "transferring").Add(-float64(stat.Size))
+		transferBytes.With(srcLabel, dstLabel).Add(float64(stat.Size))
+		transferFiles.With(srcLabel, dstLabel).Add(float64(stat.N))
+	}
 }
 
 func (m *Manager) limiter(r reflow.Repository, lim *map[string]*limiter.Limiter, limits *Limits) *limiter.Limiter {
@@ -413,6 +443,15 @@ func description(r reflow.Repository) string {
 	return s
 }
 
+// label returns the metric label of repository r: its URL's scheme
+// and host, so that the number of distinct labels remains small.
+func label(r reflow.Repository) string {
+	if url := r.URL(); url != nil {
+		return url.Scheme + "://" + url.Host
+	}
+	return "anon"
+}
+
 func key(r reflow.Repository) string {
 	if url := r.URL(); url != nil {
 		return url.String()
@@ -55,9 +55,25 @@ func Dial(rawurl string) (reflow.Repository, error) {
 	return dial(u)
 }
 
+// Scanner is implemented by repositories that can enumerate the
+// objects they store. Scan invokes handler for each object in the
+// repository, in digest order. Scan stops and returns the first
+// error returned by handler.
+type Scanner interface {
+	Scan(ctx context.Context, handler func(digest.Digest) error) error
+}
+
+// Deleter is implemented by repositories from which individual
+// objects can be removed.
+type Deleter interface {
+	Delete(ctx context.Context, id digest.Digest) error
+}
+
 // Transfer attempts to transfer an object from one repository to
 // another. It attempts to achieve this via direct transfer, but
-// falling back to copying when necessary.
+// falling back to copying when necessary. Objects stored compressed
+// are copied in their compressed form if both repositories support
+// it.
 //
 // BUG(marius): Transfer (or the underyling repositories) should ensure
 // that progress is made.
@@ -82,6 +98,18 @@ func Transfer(ctx context.Context, dst, src reflow.Repository, id digest.Digest)
 			return err
 		}
 	}
+	if cg, ok := src.(CompressedGetter); ok {
+		if cp, ok := dst.(CompressedPutter); ok {
+			err := transferCompressed(ctx, cp, cg, id)
+			switch {
+			case err == nil:
+				return nil
+			case errors.Is(errors.NotSupported, err):
+			default:
+				return err
+			}
+		}
+	}
 	log.Printf("local transfer %v %v %v", dst.URL(), src.URL(), id)
 	return transferLocal(ctx, dst, src, id)
 }
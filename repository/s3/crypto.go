@@ -0,0 +1,74 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package s3
+
+import (
+	"context"
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/blob/s3blob"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository/blobrepo"
+	"github.com/grailbio/reflow/repository/cryptorepo"
+)
+
+func init() {
+	infra.Register("crypto", new(CryptoRepository))
+}
+
+// CryptoRepository is a s3 backed repository whose objects are
+// encrypted by the client before they are stored. Data keys are
+// sealed with a master key read from a key file; see package
+// cryptorepo.
+type CryptoRepository struct {
+	// Repository is the underlying encrypting repository.
+	*cryptorepo.Repository
+	// Bucket is the s3 bucket.
+	Bucket string
+	// Key is the path of the file containing the hex-encoded
+	// master key.
+	Key string
+}
+
+// Help implements infra.Provider
+func (CryptoRepository) Help() string {
+	return "configure a repository using a S3 bucket, encrypting objects with keys sealed by a local master key"
+}
+
+// Flags implements infra.Provider
+func (r *CryptoRepository) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&r.Bucket, "bucket", "", "bucket name")
+	flags.StringVar(&r.Key, "key", "", "path of the file containing the hex-encoded master key")
+}
+
+// Init implements infra.Provider
+func (r *CryptoRepository) Init(sess *session.Session) error {
+	if r.Key == "" {
+		return errors.E(errors.Invalid, errors.New("crypto: missing key"))
+	}
+	keys, err := cryptorepo.ReadKeyfile(r.Key)
+	if err != nil {
+		return err
+	}
+	blob := s3blob.New(sess)
+	blobrepo.Register("s3", blob)
+	bucket, err := blob.Bucket(context.Background(), r.Bucket)
+	if err != nil {
+		return err
+	}
+	r.Repository = &cryptorepo.Repository{
+		Store: &blobrepo.Repository{Bucket: bucket},
+		Keys:  keys,
+	}
+	return nil
+}
+
+// Setup implements infra.Provider
+func (r *CryptoRepository) Setup(sess *session.Session, log *log.Logger) error {
+	return (&Repository{Bucket: r.Bucket}).Setup(sess, log)
+}
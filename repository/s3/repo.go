@@ -24,6 +24,11 @@ type Repository struct {
 	*blobrepo.Repository
 	// Bucket is the s3 bucket.
 	Bucket string
+	// Compress determines whether new objects are stored compressed.
+	Compress bool
+	// Chunk determines whether new large objects are stored in
+	// deduplicated, content-defined chunks.
+	Chunk bool
 }
 
 // Help implements infra.Provider
@@ -34,6 +39,8 @@ func (Repository) Help() string {
 // Flags implements infra.Provider
 func (r *Repository) Flags(flags *flag.FlagSet) {
 	flags.StringVar(&r.Bucket, "bucket", "", "bucket name")
+	flags.BoolVar(&r.Compress, "compress", false, "store new objects compressed with zstd")
+	flags.BoolVar(&r.Chunk, "chunk", false, "store new large objects in deduplicated, content-defined chunks")
 }
 
 // Init implements infra.Provider
@@ -45,7 +52,7 @@ func (r *Repository) Init(sess *session.Session) error {
 	if err != nil {
 		return err
 	}
-	r.Repository = &blobrepo.Repository{Bucket: bucket}
+	r.Repository = &blobrepo.Repository{Bucket: bucket, Compress: r.Compress, Chunk: r.Chunk}
 	return nil
 }
 
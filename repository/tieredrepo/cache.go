@@ -0,0 +1,312 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tieredrepo
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/metrics"
+	"github.com/grailbio/reflow/repository/filerepo"
+	"golang.org/x/sync/singleflight"
+)
+
+// stats exports the aggregate statistics of all caches.
+var stats = expvar.NewMap("tieredrepo")
+
+// lookups counts the cache lookups of all caches, by result (hit or
+// miss).
+var lookups = metrics.NewCounter("reflow_tieredrepo_lookups_total",
+	"Number of lookups in the local object caches, by result (hit or miss).", "result")
+
+// Stats holds the statistics of a cache.
+type Stats struct {
+	// Hits is the number of objects retrieved from the cache.
+	Hits int64
+	// Misses is the number of objects that were not present in the
+	// cache, and were retrieved from a remote repository.
+	Misses int64
+	// HitBytes is the number of bytes retrieved from the cache.
+	HitBytes int64
+	// FillBytes is the number of bytes retrieved from remote
+	// repositories into the cache.
+	FillBytes int64
+	// Evictions is the number of objects evicted from the cache.
+	Evictions int64
+	// Objects is the number of objects in the cache.
+	Objects int64
+	// Bytes is the total size of the objects in the cache.
+	Bytes int64
+}
+
+// String returns a human-readable summary of the statistics.
+func (s Stats) String() string {
+	var rate float64
+	if n := s.Hits + s.Misses; n > 0 {
+		rate = float64(s.Hits) / float64(n)
+	}
+	return fmt.Sprintf("hits:%d misses:%d (hit rate %.1f%%) hit:%s filled:%s evictions:%d objects:%d (%s)",
+		s.Hits, s.Misses, 100*rate, data.Size(s.HitBytes), data.Size(s.FillBytes),
+		s.Evictions, s.Objects, data.Size(s.Bytes))
+}
+
+type entry struct {
+	id   digest.Digest
+	size int64
+}
+
+// A Cache is a bounded, local, file-based repository tier. Objects
+// are evicted in least-recently-used order when the cache's size
+// exceeds its limit. A Cache is content addressed, and may thus be
+// shared by any number of Repositories.
+type Cache struct {
+	repo     *filerepo.Repository
+	maxBytes int64
+	log      *log.Logger
+
+	fill singleflight.Group
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[digest.Digest]*list.Element
+	size    int64
+
+	hits, misses, hitBytes, fillBytes, evictions int64
+}
+
+// NewCache returns a new cache rooted at the provided directory,
+// whose size is limited to maxBytes (or unlimited if maxBytes is 0).
+// Objects already present in the directory are retained, the least
+// recently modified of them evicted first.
+func NewCache(root string, maxBytes int64, log *log.Logger) (*Cache, error) {
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		repo:     &filerepo.Repository{Root: root, Log: log},
+		maxBytes: maxBytes,
+		log:      log,
+		lru:      list.New(),
+		entries:  make(map[digest.Digest]*list.Element),
+	}
+	type object struct {
+		entry
+		mtime time.Time
+	}
+	var objects []object
+	err := c.repo.Scan(context.Background(), func(id digest.Digest) error {
+		_, path := c.repo.Path(id)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object{entry{id, info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E("newcache", root, err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].mtime.Before(objects[j].mtime) })
+	c.mu.Lock()
+	for _, obj := range objects {
+		c.addLocked(obj.id, obj.size)
+	}
+	c.mu.Unlock()
+	if log != nil {
+		log.Debugf("cache %s: %d objects (%s)", root, len(objects), data.Size(c.size))
+	}
+	return c, nil
+}
+
+// Stats returns the cache's current statistics.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	objects, size := int64(c.lru.Len()), c.size
+	c.mu.Unlock()
+	return Stats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		HitBytes:  atomic.LoadInt64(&c.hitBytes),
+		FillBytes: atomic.LoadInt64(&c.fillBytes),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		Objects:   objects,
+		Bytes:     size,
+	}
+}
+
+// stat returns the metadata of the object named by id, if it is
+// present in the cache.
+func (c *Cache) stat(id digest.Digest) (reflow.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return reflow.File{}, false
+	}
+	return reflow.File{ID: id, Size: e.Value.(*entry).size}, true
+}
+
+// open opens the cached object named by id, marking it as recently
+// used. It records a hit if the object is present.
+func (c *Cache) open(id digest.Digest) (*os.File, error) {
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, errors.E("open", c.repo.Root, id, errors.NotExist)
+	}
+	_, path := c.repo.Path(id)
+	f, err := os.Open(path)
+	if err != nil {
+		// The object may have been evicted concurrently.
+		return nil, errors.E("open", c.repo.Root, id, err)
+	}
+	c.hit(e.Value.(*entry).size)
+	return f, nil
+}
+
+func (c *Cache) hit(size int64) {
+	atomic.AddInt64(&c.hits, 1)
+	atomic.AddInt64(&c.hitBytes, size)
+	stats.Add("hits", 1)
+	stats.Add("hitbytes", size)
+	lookups.With("hit").Inc()
+}
+
+func (c *Cache) miss() {
+	atomic.AddInt64(&c.misses, 1)
+	stats.Add("misses", 1)
+	lookups.With("miss").Inc()
+}
+
+// fits tells whether an object of the given size may be cached.
+func (c *Cache) fits(size int64) bool {
+	return c.maxBytes <= 0 || size <= c.maxBytes
+}
+
+// Fill retrieves the object named by id from the remote repository
+// into the cache. Fill returns false if the object is too large to
+// be cached. Concurrent fills of the same object are coalesced.
+func (c *Cache) Fill(ctx context.Context, id digest.Digest, remote reflow.Repository) (bool, error) {
+	if _, ok := c.stat(id); ok {
+		return true, nil
+	}
+	v, err, _ := c.fill.Do(id.String(), func() (interface{}, error) {
+		file, err := remote.Stat(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if !c.fits(file.Size) {
+			return false, nil
+		}
+		temp, err := c.repo.TempFile("fill-")
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(temp.Name())
+		defer temp.Close()
+		var (
+			n  int64
+			dw = reflow.Digester.NewWriter()
+		)
+		if gf, ok := remote.(getFiler); ok {
+			n, err = gf.GetFile(ctx, id, temp)
+			if err != nil {
+				return false, err
+			}
+			// GetFile may write the object out of order, so we digest
+			// it once it has been retrieved in full.
+			if _, err := temp.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+			if _, err := io.Copy(dw, temp); err != nil {
+				return false, err
+			}
+		} else {
+			rc, err := remote.Get(ctx, id)
+			if err != nil {
+				return false, err
+			}
+			defer rc.Close()
+			n, err = io.Copy(io.MultiWriter(temp, dw), rc)
+			if err != nil {
+				return false, err
+			}
+		}
+		if got := dw.Digest(); got != id {
+			return false, errors.E("fill", id, errors.Integrity, errors.Errorf("retrieved object has digest %v", got))
+		}
+		if err := c.repo.InstallDigest(id, temp.Name()); err != nil {
+			return false, err
+		}
+		atomic.AddInt64(&c.fillBytes, n)
+		stats.Add("fillbytes", n)
+		c.add(id, n)
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// add records the presence of an object in the cache, evicting
+// objects as needed to stay within the cache's size limit.
+func (c *Cache) add(id digest.Digest, size int64) {
+	c.mu.Lock()
+	c.addLocked(id, size)
+	c.mu.Unlock()
+}
+
+func (c *Cache) addLocked(id digest.Digest, size int64) {
+	if e, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(&entry{id, size})
+	c.size += size
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		c.removeLocked(e.Value.(*entry).id)
+		atomic.AddInt64(&c.evictions, 1)
+		stats.Add("evictions", 1)
+	}
+}
+
+func (c *Cache) removeLocked(id digest.Digest) {
+	e, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	c.lru.Remove(e)
+	delete(c.entries, id)
+	c.size -= e.Value.(*entry).size
+	if err := c.repo.Remove(id); err != nil && !os.IsNotExist(err) && c.log != nil {
+		c.log.Errorf("evict %v: %v", id, err)
+	}
+}
+
+// remove removes the object named by id from the cache.
+func (c *Cache) remove(id digest.Digest) {
+	c.mu.Lock()
+	c.removeLocked(id)
+	c.mu.Unlock()
+}
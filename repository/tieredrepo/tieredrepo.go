@@ -0,0 +1,250 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package tieredrepo implements a repository that places a bounded,
+// local cache in front of a (typically remote) repository. Objects
+// are served from the cache when present, and are retrieved into the
+// cache when they are not. Objects are written through to the remote
+// repository.
+package tieredrepo
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/liveset"
+	"github.com/grailbio/reflow/repository"
+)
+
+type getFiler interface {
+	GetFile(ctx context.Context, id digest.Digest, w io.WriterAt) (int64, error)
+}
+
+type putFiler interface {
+	PutFile(context.Context, reflow.File, io.Reader) error
+}
+
+// Repository is a reflow.Repository that serves objects from a local
+// cache tier, backed by a remote tier. Objects retrieved through the
+// repository (with Get or GetFile) are counted as cache hits or
+// misses in the cache's statistics.
+//
+// The repository assumes the identity of the remote tier: its URL
+// is the remote's URL, and direct transfers are performed by the
+// remote.
+type Repository struct {
+	// Cache is the local tier.
+	Cache *Cache
+	// Remote is the remote tier.
+	Remote reflow.Repository
+}
+
+// Dialer returns a repository dialer that dials remote repositories
+// using dial, placing each behind the provided cache. It may be used
+// with repository.RegisterScheme so that repositories dialed by URL
+// share a local cache.
+func Dialer(cache *Cache, dial func(*url.URL) (reflow.Repository, error)) func(*url.URL) (reflow.Repository, error) {
+	return func(u *url.URL) (reflow.Repository, error) {
+		remote, err := dial(u)
+		if err != nil {
+			return nil, err
+		}
+		return &Repository{Cache: cache, Remote: remote}, nil
+	}
+}
+
+// Stat returns metadata for the object named by id, from the local
+// tier if it is present there.
+func (r *Repository) Stat(ctx context.Context, id digest.Digest) (reflow.File, error) {
+	if file, ok := r.Cache.stat(id); ok {
+		return file, nil
+	}
+	return r.Remote.Stat(ctx, id)
+}
+
+// Get retrieves the object named by id. Objects not present in the
+// local tier are first retrieved into it, unless they are too large
+// to be cached.
+func (r *Repository) Get(ctx context.Context, id digest.Digest) (io.ReadCloser, error) {
+	f, err := r.open(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return r.Remote.Get(ctx, id)
+	}
+	return f, nil
+}
+
+// GetFile retrieves the object named by id into w.
+func (r *Repository) GetFile(ctx context.Context, id digest.Digest, w io.WriterAt) (int64, error) {
+	f, err := r.open(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if f == nil {
+		if gf, ok := r.Remote.(getFiler); ok {
+			return gf.GetFile(ctx, id, w)
+		}
+		rc, err := r.Remote.Get(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		return io.Copy(&offsetWriter{w: w}, rc)
+	}
+	defer f.Close()
+	return io.Copy(&offsetWriter{w: w}, f)
+}
+
+// open opens the cached object named by id, filling the cache from
+// the remote tier if needed. It returns a nil file if the object
+// cannot be cached.
+func (r *Repository) open(ctx context.Context, id digest.Digest) (*os.File, error) {
+	if f, err := r.Cache.open(id); err == nil {
+		return f, nil
+	}
+	r.Cache.miss()
+	// The object may be evicted between its being filled and
+	// opened; we retry once in this case.
+	for i := 0; ; i++ {
+		ok, err := r.Cache.Fill(ctx, id, r.Remote)
+		if err != nil || !ok {
+			return nil, err
+		}
+		_, path := r.Cache.repo.Path(id)
+		f, err := os.Open(path)
+		if err == nil || i > 0 || !os.IsNotExist(err) {
+			return f, err
+		}
+		r.Cache.remove(id)
+	}
+}
+
+// Put installs an object into both tiers. The object is written to
+// the remote tier before Put returns.
+func (r *Repository) Put(ctx context.Context, body io.Reader) (digest.Digest, error) {
+	id, err := r.Cache.repo.Put(ctx, body)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	file, err := r.Cache.repo.Stat(ctx, id)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if err := r.putRemote(ctx, file); err != nil {
+		return digest.Digest{}, err
+	}
+	if r.Cache.fits(file.Size) {
+		r.Cache.add(id, file.Size)
+	} else if _, ok := r.Cache.stat(id); !ok {
+		r.Cache.repo.Remove(id)
+	}
+	return id, nil
+}
+
+// putRemote writes the locally stored object described by file to
+// the remote tier.
+func (r *Repository) putRemote(ctx context.Context, file reflow.File) error {
+	rc, err := r.Cache.repo.Get(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if pf, ok := r.Remote.(putFiler); ok {
+		return pf.PutFile(ctx, file, rc)
+	}
+	id, err := r.Remote.Put(ctx, rc)
+	if err != nil {
+		return err
+	}
+	if id != file.ID {
+		return errors.E("put", file.ID, errors.Integrity, errors.Errorf("%v != %v", file.ID, id))
+	}
+	return nil
+}
+
+// Location returns the location of the object named by id in the
+// remote tier, if the remote supports locating objects.
+func (r *Repository) Location(ctx context.Context, id digest.Digest) (string, error) {
+	type locator interface {
+		Location(ctx context.Context, id digest.Digest) (string, error)
+	}
+	l, ok := r.Remote.(locator)
+	if !ok {
+		return "", errors.E("location", id, errors.NotSupported)
+	}
+	return l.Location(ctx, id)
+}
+
+// WriteTo writes the object named by id to the repository named by
+// u. Objects present in the local tier are written from there.
+func (r *Repository) WriteTo(ctx context.Context, id digest.Digest, u *url.URL) error {
+	if _, ok := r.Cache.stat(id); ok {
+		if err := r.Cache.repo.WriteTo(ctx, id, u); err == nil {
+			return nil
+		}
+	}
+	return r.Remote.WriteTo(ctx, id, u)
+}
+
+// ReadFrom reads the object named by id from the repository named
+// by u into the remote tier.
+func (r *Repository) ReadFrom(ctx context.Context, id digest.Digest, u *url.URL) error {
+	return r.Remote.ReadFrom(ctx, id, u)
+}
+
+// URL returns the remote tier's URL.
+func (r *Repository) URL() *url.URL {
+	return r.Remote.URL()
+}
+
+// Collect collects the remote tier. The local tier is managed by
+// its cache eviction policy.
+func (r *Repository) Collect(ctx context.Context, live liveset.Liveset) error {
+	return r.Remote.Collect(ctx, live)
+}
+
+// CollectWithThreshold collects the remote tier.
+func (r *Repository) CollectWithThreshold(ctx context.Context, live liveset.Liveset, dead liveset.Liveset, threshold time.Time, dryRun bool) error {
+	return r.Remote.CollectWithThreshold(ctx, live, dead, threshold, dryRun)
+}
+
+// Scan scans the remote tier, if it supports scanning.
+func (r *Repository) Scan(ctx context.Context, handler func(digest.Digest) error) error {
+	s, ok := r.Remote.(repository.Scanner)
+	if !ok {
+		return errors.E("scan", errors.NotSupported)
+	}
+	return s.Scan(ctx, handler)
+}
+
+// Delete removes the object named by id from both tiers.
+func (r *Repository) Delete(ctx context.Context, id digest.Digest) error {
+	d, ok := r.Remote.(repository.Deleter)
+	if !ok {
+		return errors.E("delete", id, errors.NotSupported)
+	}
+	r.Cache.remove(id)
+	return d.Delete(ctx, id)
+}
+
+// offsetWriter adapts an io.WriterAt to an io.Writer that writes
+// sequentially from offset 0.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tieredrepo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func newCache(t *testing.T, maxBytes int64) (*Cache, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tieredrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCache(dir, maxBytes, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func get(t *testing.T, r reflow.Repository, id digest.Digest) string {
+	t.Helper()
+	rc, err := r.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func put(t *testing.T, r reflow.Repository, s string) digest.Digest {
+	t.Helper()
+	id, err := r.Put(context.Background(), strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	cache, cleanup := newCache(t, 0)
+	defer cleanup()
+	remote := testutil.NewInmemoryRepository()
+	r := &Repository{Cache: cache, Remote: remote}
+
+	// Puts are written through.
+	id := put(t, r, "written through")
+	if got, want := get(t, remote, id), "written through"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := get(t, r, id), "written through"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := cache.Stats(), (Stats{Hits: 1, HitBytes: 15, Objects: 1, Bytes: 15}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Objects missing from the local tier are filled from the
+	// remote tier.
+	id = put(t, remote, "remote only")
+	if got, want := get(t, r, id), "remote only"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := get(t, r, id), "remote only"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	stats := cache.Stats()
+	if got, want := stats.Hits, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Misses, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.FillBytes, int64(11); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Once cached, the object is served locally.
+	if err := remote.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(ctx, id); err != nil {
+		t.Error(err)
+	}
+	var b bytes.Buffer
+	f, err := ioutil.TempFile("", "tieredrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := r.GetFile(ctx, id, f); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+	b.ReadFrom(f)
+	f.Close()
+	if got, want := b.String(), "remote only"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	cache, cleanup := newCache(t, 10)
+	defer cleanup()
+	remote := testutil.NewInmemoryRepository()
+	r := &Repository{Cache: cache, Remote: remote}
+	var (
+		a = put(t, remote, "aaaa")
+		b = put(t, remote, "bbbb")
+		c = put(t, remote, "cccc")
+	)
+	get(t, r, a)
+	get(t, r, b)
+	// Touch a, so that b is the least recently used.
+	get(t, r, a)
+	get(t, r, c)
+	if _, ok := cache.stat(b); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, id := range []digest.Digest{a, c} {
+		if _, ok := cache.stat(id); !ok {
+			t.Errorf("expected %v to be cached", id)
+		}
+	}
+	stats := cache.Stats()
+	if got, want := stats.Evictions, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Bytes, int64(8); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Objects larger than the cache are served from the remote.
+	large := put(t, r, "larger than the cache")
+	if got, want := get(t, r, large), "larger than the cache"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := cache.stat(large); ok {
+		t.Error("expected large object not to be cached")
+	}
+	if ok, _ := cache.repo.Contains(large); ok {
+		t.Error("expected large object to be removed from the local tier")
+	}
+
+	// A new cache over the same directory retains its objects.
+	cache2, err := NewCache(cache.repo.Root, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cache2.Stats().Objects, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// corruptRepo is a repository whose GetFile retrieves corrupted
+// objects.
+type corruptRepo struct{ reflow.Repository }
+
+func (r corruptRepo) GetFile(ctx context.Context, id digest.Digest, w io.WriterAt) (int64, error) {
+	file, err := r.Stat(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.WriteAt(bytes.Repeat([]byte{'x'}, int(file.Size)), 0)
+	return int64(n), err
+}
+
+func TestFillIntegrity(t *testing.T) {
+	ctx := context.Background()
+	cache, cleanup := newCache(t, 0)
+	defer cleanup()
+	remote := testutil.NewInmemoryRepository()
+	id := put(t, remote, "uncorrupted")
+	if _, err := cache.Fill(ctx, id, corruptRepo{remote}); !errors.Is(errors.Integrity, err) {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+	if _, ok := cache.stat(id); ok {
+		t.Error("expected corrupted object not to be cached")
+	}
+}
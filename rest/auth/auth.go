@@ -0,0 +1,172 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package auth provides pluggable authentication and authorization
+// for Reflow's REST servers. An Authenticator, configured through
+// infra, determines the user that made each request, and whether
+// that user may make it. This package provides authenticators that
+// identify users by client certificates issued by a local
+// certificate authority (provider "mtls"), and by bearer tokens
+// listed in a static file (provider "tokens").
+//
+// Both authenticators authorize all authenticated users to make
+// nondestructive requests, and restrict destructive requests (see
+// Destructive) to a configured set of admins. Both also provide the
+// credentials that clients present: a client certificate or a bearer
+// token (see ClientTransport).
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+// An Authenticator authenticates and authorizes requests to a REST
+// server.
+type Authenticator interface {
+	// Authenticate returns the user that made the request r, or an
+	// error if the request could not be authenticated.
+	Authenticate(r *http.Request) (user string, err error)
+	// Authorize returns an error if the user is not permitted to
+	// make the request r.
+	Authorize(user string, r *http.Request) error
+}
+
+// A ServerConfigurer is an Authenticator that requires specific TLS
+// settings of the servers that use it.
+type ServerConfigurer interface {
+	// ConfigureServer modifies the server TLS configuration config.
+	ConfigureServer(config *tls.Config) error
+}
+
+// A ClientConfigurer is an Authenticator that provides the
+// credentials that clients present to the servers that use it.
+type ClientConfigurer interface {
+	// ConfigureClient configures the client transport to present
+	// the client's credentials, and returns the round tripper
+	// through which the client should make its requests.
+	ConfigureClient(transport *http.Transport) (http.RoundTripper, error)
+}
+
+// ClientTransport returns the round tripper through which clients
+// make requests to servers that authenticate with authn: transport,
+// configured to present the client credentials of authn, if any.
+// Authn may be nil, in which case transport is returned.
+func ClientTransport(authn Authenticator, transport *http.Transport) (http.RoundTripper, error) {
+	c, ok := authn.(ClientConfigurer)
+	if !ok {
+		return transport, nil
+	}
+	return c.ConfigureClient(transport)
+}
+
+// destructiveOps are the final path elements of the POST endpoints
+// that destroy or release server resources.
+var destructiveOps = map[string]bool{
+	"kill":    true,
+	"unload":  true,
+	"collect": true,
+}
+
+// Destructive tells whether the request r destroys or releases
+// server resources: DELETE requests (e.g., to free allocs or
+// remove execs), and POST requests to kill or unload endpoints, or
+// to collect repositories.
+func Destructive(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return destructiveOps[path.Base(path.Clean(r.URL.Path))]
+	}
+	return false
+}
+
+// Admins is the set of users that are authorized to make
+// destructive requests. Admins implements flag.Value: it is set from
+// a colon-separated list of users. (Commas separate the flags of
+// infra providers.)
+type Admins map[string]bool
+
+// String implements flag.Value.
+func (a Admins) String() string {
+	users := make([]string, 0, len(a))
+	for user := range a {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return strings.Join(users, ":")
+}
+
+// Set implements flag.Value.
+func (a *Admins) Set(s string) error {
+	*a = make(Admins)
+	for _, user := range strings.Split(s, ":") {
+		if user = strings.TrimSpace(user); user != "" {
+			(*a)[user] = true
+		}
+	}
+	return nil
+}
+
+// Authorize permits all users to make nondestructive requests, and
+// only admins to make destructive requests.
+func (a Admins) Authorize(user string, r *http.Request) error {
+	if !Destructive(r) || a[user] {
+		return nil
+	}
+	return errors.E(errors.NotAllowed, r.Method, r.URL.Path,
+		errors.Errorf("user %s is not authorized to make destructive requests", user))
+}
+
+type userKey struct{}
+
+// User returns the authenticated user of the request whose context
+// is ctx, as determined by Handler.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// Handler returns a handler that serves authenticated and
+// authorized requests with h. Requests that cannot be authenticated
+// are failed with http.StatusUnauthorized; requests that are not
+// authorized are failed with http.StatusForbidden. The contexts of
+// the served requests carry the authenticated user (see User).
+func Handler(h http.Handler, authn Authenticator, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authn.Authenticate(r)
+		if err != nil {
+			logger.Printf("%s %s: authentication failed: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="reflow"`)
+			reply(w, http.StatusUnauthorized, errors.E(errors.NotAllowed, r.Method, r.URL.Path, err))
+			return
+		}
+		if err := authn.Authorize(user, r); err != nil {
+			logger.Printf("%s %s: user %s: %v", r.Method, r.URL.Path, user, err)
+			reply(w, http.StatusForbidden, err)
+			return
+		}
+		if logger.At(log.DebugLevel) {
+			logger.Debugf("%s %s: user %s", r.Method, r.URL.Path, user)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// reply replies to a failed request, encoding the error as JSON as
+// REST servers do, so that REST clients recover it.
+func reply(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errors.Recover(err))
+}
@@ -0,0 +1,342 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/rest"
+)
+
+// userNode serves every path, replying to each call with the
+// authenticated user.
+type userNode struct{}
+
+func (n userNode) Walk(context.Context, *rest.Call, string) rest.Node {
+	return n
+}
+
+func (userNode) Do(ctx context.Context, call *rest.Call) {
+	call.Reply(http.StatusOK, User(ctx))
+}
+
+func tempFile(t *testing.T, dir, name string, p []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// do performs a request to url with the provided client, and
+// returns the response's status code and the user or error in its
+// body.
+func do(t *testing.T, client *http.Client, method, url string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := new(errors.Error)
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, e.Error()
+	}
+	var user string
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, user
+}
+
+func TestDestructive(t *testing.T) {
+	for _, c := range []struct {
+		method, path string
+		destructive  bool
+	}{
+		{"GET", "/v1/allocs/a", false},
+		{"DELETE", "/v1/allocs/a", true},
+		{"DELETE", "/v1/allocs/a/execs/e", true},
+		{"POST", "/v1/allocs/a/unload", true},
+		{"POST", "/v1/allocs/a/unload/", true},
+		{"POST", "/v1/allocs/a/execs/e/kill", true},
+		{"POST", "/v1/allocs/a/repository/collect", true},
+		{"POST", "/v1/allocs/a/load", false},
+		{"PUT", "/v1/allocs/a/execs/e", false},
+		{"GET", "/v1/allocs/a/unload", false},
+	} {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if got, want := Destructive(r), c.destructive; got != want {
+			t.Errorf("%s %s: got %v, want %v", c.method, c.path, got, want)
+		}
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	var admins Admins
+	if err := admins.Set("bob:alice::"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := admins, (Admins{"alice": true, "bob": true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := admins.String(), "alice:bob"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokens := &Tokens{
+		File:   tempFile(t, dir, "tokens", []byte("# reflow users\nsecret1 alice\n\nsecret2\tbob\n")),
+		Admins: Admins{"alice": true},
+	}
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(Handler(rest.Handler(userNode{}, nil), tokens, nil))
+	defer srv.Close()
+
+	client := func(token string) *http.Client {
+		tokens := &Tokens{Token: tempFile(t, dir, "token", []byte(token+"\n"))}
+		if err := tokens.Init(); err != nil {
+			t.Fatal(err)
+		}
+		transport, err := ClientTransport(tokens, new(http.Transport))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Client{Transport: transport}
+	}
+	for _, c := range []struct {
+		token, method, path string
+		code                int
+		reply               string
+	}{
+		{"", "GET", "/v1/allocs", http.StatusUnauthorized, "missing bearer token"},
+		{"bogus", "GET", "/v1/allocs", http.StatusUnauthorized, "invalid bearer token"},
+		{"secret2", "GET", "/v1/allocs", http.StatusOK, "bob"},
+		{"secret2", "DELETE", "/v1/allocs/a", http.StatusForbidden, "user bob is not authorized"},
+		{"secret2", "POST", "/v1/allocs/a/unload", http.StatusForbidden, "user bob is not authorized"},
+		{"secret1", "DELETE", "/v1/allocs/a", http.StatusOK, "alice"},
+		{"secret1", "POST", "/v1/allocs/a/unload", http.StatusOK, "alice"},
+	} {
+		cl := http.DefaultClient
+		if c.token != "" {
+			cl = client(c.token)
+		}
+		code, reply := do(t, cl, c.method, srv.URL+c.path)
+		if code != c.code || !strings.Contains(reply, c.reply) {
+			t.Errorf("%s %s %s: got %d %q, want %d %q", c.token, c.method, c.path, code, reply, c.code, c.reply)
+		}
+	}
+
+	// Bearer tokens are not accepted in other schemes.
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "secret1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := resp.Header.Get("WWW-Authenticate"), `Bearer realm="reflow"`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Servers require a token file.
+	if err := (&Tokens{token: "secret1"}).ConfigureServer(new(tls.Config)); err == nil {
+		t.Error("expected error")
+	}
+	bad := &Tokens{File: tempFile(t, dir, "bad", []byte("secret1 alice\nsecret1 bob\n"))}
+	if err := bad.Init(); err == nil || !strings.Contains(err.Error(), "line 2: duplicate token") {
+		t.Errorf("expected duplicate token error, got %v", err)
+	}
+	bad = &Tokens{File: tempFile(t, dir, "bad", []byte("secret1 alice admin\n"))}
+	if err := bad.Init(); err == nil || !strings.Contains(err.Error(), "line 1: expected token and user") {
+		t.Errorf("expected syntax error, got %v", err)
+	}
+}
+
+// testCA is a certificate authority used to issue test certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue issues a client certificate for user.
+func (ca *testCA) issue(t *testing.T, user string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: user},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		ca    = newTestCA(t, "local")
+		other = newTestCA(t, "other")
+	)
+	m := &MTLS{CA: tempFile(t, dir, "ca.pem", ca.pem), Admins: Admins{"alice": true}}
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(Handler(rest.Handler(userNode{}, nil), m, nil))
+	srv.TLS = new(tls.Config)
+	if err := m.ConfigureServer(srv.TLS); err != nil {
+		t.Fatal(err)
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := func(cert *tls.Certificate) *http.Client {
+		client := srv.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		return &http.Client{Transport: transport}
+	}
+	var (
+		alice   = ca.issue(t, "alice")
+		bob     = ca.issue(t, "bob")
+		mallory = other.issue(t, "alice")
+	)
+	for _, c := range []struct {
+		name         string
+		cert         *tls.Certificate
+		method, path string
+		code         int
+		reply        string
+	}{
+		{"alice", &alice, "GET", "/v1/allocs", http.StatusOK, "alice"},
+		{"alice", &alice, "DELETE", "/v1/allocs/a", http.StatusOK, "alice"},
+		{"bob", &bob, "GET", "/v1/allocs", http.StatusOK, "bob"},
+		{"bob", &bob, "DELETE", "/v1/allocs/a", http.StatusForbidden, "user bob is not authorized"},
+		{"mallory", &mallory, "GET", "/v1/allocs", 0, ""},
+		{"anonymous", nil, "GET", "/v1/allocs", 0, ""},
+	} {
+		code, reply := do(t, client(c.cert), c.method, srv.URL+c.path)
+		if code != c.code || !strings.Contains(reply, c.reply) {
+			t.Errorf("%s %s %s: got %d %q, want %d %q", c.name, c.method, c.path, code, reply, c.code, c.reply)
+		}
+	}
+
+	// Clients present the certificates with which they are
+	// configured.
+	key, err := x509.MarshalECPrivateKey(alice.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &MTLS{
+		CA:         m.CA,
+		ClientCert: tempFile(t, dir, "alice.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: alice.Certificate[0]})),
+		ClientKey:  tempFile(t, dir, "alice.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})),
+	}
+	if err := cm.Init(); err != nil {
+		t.Fatal(err)
+	}
+	transport, err := ClientTransport(cm, srv.Client().Transport.(*http.Transport).Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, reply := do(t, &http.Client{Transport: transport}, "DELETE", srv.URL+"/v1/allocs/a"); code != http.StatusOK || reply != "alice" {
+		t.Errorf("got %d %q, want %d %q", code, reply, http.StatusOK, "alice")
+	}
+
+	// Client certificates are verified by the authenticator even if
+	// servers accept certificates from other authorities.
+	r := httptest.NewRequest("GET", "/v1/allocs", nil)
+	leaf, err := x509.ParseCertificate(mallory.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	if _, err := m.Authenticate(r); err == nil {
+		t.Error("expected verification error")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+)
+
+func init() {
+	infra.Register("mtls", new(MTLS))
+}
+
+// MTLS is an Authenticator that identifies users by the client
+// certificates they present, which must be issued by a local
+// certificate authority. A user is named by the common name of its
+// certificate's subject.
+type MTLS struct {
+	// CA is the path of the PEM-encoded certificate of the authority
+	// that issues client certificates.
+	CA string
+	// Cert and Key are the paths of the PEM-encoded certificate and
+	// key that servers present to their clients. If empty, servers
+	// present their default certificates.
+	Cert, Key string
+	// ClientCert and ClientKey are the paths of the PEM-encoded
+	// certificate and key that clients present to servers.
+	ClientCert, ClientKey string
+	// Admins are the users that are authorized to make destructive
+	// requests.
+	Admins Admins
+
+	ca         []byte
+	roots      *x509.CertPool
+	cert       *tls.Certificate
+	clientCert *tls.Certificate
+}
+
+// Help implements infra.Provider.
+func (*MTLS) Help() string {
+	return "authenticate REST clients by certificates issued by a local certificate authority"
+}
+
+// Flags implements infra.Provider.
+func (m *MTLS) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&m.CA, "ca", "", "path of the PEM-encoded certificate authority that issues client certificates")
+	flags.StringVar(&m.Cert, "cert", "", "path of the PEM-encoded server certificate")
+	flags.StringVar(&m.Key, "key", "", "path of the PEM-encoded server key")
+	flags.StringVar(&m.ClientCert, "clientcert", "", "path of the PEM-encoded client certificate")
+	flags.StringVar(&m.ClientKey, "clientkey", "", "path of the PEM-encoded client key")
+	flags.Var(&m.Admins, "admins", "colon-separated list of users authorized to make destructive requests")
+}
+
+// Init implements infra.Provider.
+func (m *MTLS) Init() error {
+	if m.CA == "" {
+		return errors.E(errors.Invalid, errors.New("mtls: missing ca"))
+	}
+	var err error
+	if m.ca, err = ioutil.ReadFile(m.CA); err != nil {
+		return errors.E("mtls", m.CA, err)
+	}
+	m.roots = x509.NewCertPool()
+	if !m.roots.AppendCertsFromPEM(m.ca) {
+		return errors.E(errors.Invalid, "mtls", m.CA, errors.New("no certificates found"))
+	}
+	if (m.Cert == "") != (m.Key == "") {
+		return errors.E(errors.Invalid, errors.New("mtls: cert and key must be provided together"))
+	}
+	if m.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(m.Cert, m.Key)
+		if err != nil {
+			return errors.E("mtls", m.Cert, err)
+		}
+		m.cert = &cert
+	}
+	if (m.ClientCert == "") != (m.ClientKey == "") {
+		return errors.E(errors.Invalid, errors.New("mtls: clientcert and clientkey must be provided together"))
+	}
+	if m.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(m.ClientCert, m.ClientKey)
+		if err != nil {
+			return errors.E("mtls", m.ClientCert, err)
+		}
+		m.clientCert = &cert
+	}
+	return nil
+}
+
+// ConfigureServer implements ServerConfigurer. It requires clients
+// to present certificates issued by the authority m.CA.
+func (m *MTLS) ConfigureServer(config *tls.Config) error {
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = m.roots
+	if m.cert != nil {
+		config.Certificates = []tls.Certificate{*m.cert}
+	}
+	return nil
+}
+
+// ConfigureClient implements ClientConfigurer. Clients present the
+// certificate m.ClientCert, if any. Clients that verify servers
+// against a configured pool of authorities also trust servers whose
+// certificates are issued by the authority m.CA.
+func (m *MTLS) ConfigureClient(transport *http.Transport) (http.RoundTripper, error) {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = new(tls.Config)
+	}
+	config := transport.TLSClientConfig
+	if m.clientCert != nil {
+		config.Certificates = append(config.Certificates, *m.clientCert)
+	}
+	if config.RootCAs != nil {
+		config.RootCAs.AppendCertsFromPEM(m.ca)
+	}
+	return transport, nil
+}
+
+// Authenticate implements Authenticator. The client certificate is
+// verified against the authority m.CA, regardless of how the
+// server's TLS is configured.
+func (m *MTLS) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", errors.New("no client certificate")
+	}
+	var (
+		cert          = r.TLS.PeerCertificates[0]
+		intermediates = x509.NewCertPool()
+	)
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         m.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return "", errors.E("verify client certificate", err)
+	}
+	if cert.Subject.CommonName == "" {
+		return "", errors.New("client certificate has no common name")
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// Authorize implements Authenticator.
+func (m *MTLS) Authorize(user string, r *http.Request) error {
+	return m.Admins.Authorize(user, r)
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+)
+
+func init() {
+	infra.Register("tokens", new(Tokens))
+}
+
+// Tokens is an Authenticator that identifies users by the bearer
+// tokens they present in the Authorization headers of their
+// requests. Tokens are read from a file in which each line contains
+// a token and the user it identifies, separated by whitespace.
+// Empty lines and lines beginning with "#" are ignored.
+//
+// Clients present the token read from the file Token. Servers
+// require File; clients require Token.
+type Tokens struct {
+	// File is the path of the token file.
+	File string
+	// Token is the path of a file containing the token presented by
+	// clients.
+	Token string
+	// Admins are the users that are authorized to make destructive
+	// requests.
+	Admins Admins
+
+	// token is the token presented by clients.
+	token string
+
+	// users maps token digests to users, so that lookups do not
+	// depend on the tokens' contents.
+	users map[[sha256.Size]byte]string
+}
+
+// Help implements infra.Provider.
+func (*Tokens) Help() string {
+	return "authenticate REST clients by bearer tokens listed in a file"
+}
+
+// Flags implements infra.Provider.
+func (t *Tokens) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&t.File, "file", "", "path of the file of tokens and the users they identify")
+	flags.StringVar(&t.Token, "token", "", "path of a file containing the token presented by clients")
+	flags.Var(&t.Admins, "admins", "colon-separated list of users authorized to make destructive requests")
+}
+
+// Init implements infra.Provider.
+func (t *Tokens) Init() error {
+	if t.File == "" && t.Token == "" {
+		return errors.E(errors.Invalid, errors.New("tokens: missing file or token"))
+	}
+	if t.Token != "" {
+		p, err := ioutil.ReadFile(t.Token)
+		if err != nil {
+			return errors.E("tokens", t.Token, err)
+		}
+		if t.token = strings.TrimSpace(string(p)); t.token == "" {
+			return errors.E(errors.Invalid, "tokens", t.Token, errors.New("empty token"))
+		}
+	}
+	if t.File == "" {
+		return nil
+	}
+	f, err := os.Open(t.File)
+	if err != nil {
+		return errors.E("tokens", t.File, err)
+	}
+	defer f.Close()
+	t.users = make(map[[sha256.Size]byte]string)
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return errors.E(errors.Invalid, "tokens", t.File,
+				errors.Errorf("line %d: expected token and user, got %d fields", n, len(fields)))
+		}
+		key := sha256.Sum256([]byte(fields[0]))
+		if _, ok := t.users[key]; ok {
+			return errors.E(errors.Invalid, "tokens", t.File, errors.Errorf("line %d: duplicate token", n))
+		}
+		t.users[key] = fields[1]
+	}
+	if err := scan.Err(); err != nil {
+		return errors.E("tokens", t.File, err)
+	}
+	return nil
+}
+
+// ConfigureServer implements ServerConfigurer. Clients that
+// authenticate with tokens need not present certificates.
+func (t *Tokens) ConfigureServer(config *tls.Config) error {
+	if t.users == nil {
+		return errors.E(errors.Invalid, errors.New("tokens: servers require a token file"))
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// ConfigureClient implements ClientConfigurer. Clients present
+// the token read from t.Token, if any.
+func (t *Tokens) ConfigureClient(transport *http.Transport) (http.RoundTripper, error) {
+	if t.token == "" {
+		return transport, nil
+	}
+	return &BearerTransport{Token: t.token, Base: transport}, nil
+}
+
+// Authenticate implements Authenticator.
+func (t *Tokens) Authenticate(r *http.Request) (string, error) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errors.New("missing bearer token")
+	}
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", errors.New("authorization is not a bearer token")
+	}
+	user, ok := t.users[sha256.Sum256([]byte(strings.TrimSpace(header[len(prefix):])))]
+	if !ok {
+		return "", errors.New("invalid bearer token")
+	}
+	return user, nil
+}
+
+// Authorize implements Authenticator.
+func (t *Tokens) Authorize(user string, r *http.Request) error {
+	return t.Admins.Authorize(user, r)
+}
+
+// BearerTransport is an http.RoundTripper that presents a bearer
+// token with each request, for use by the clients of servers that
+// authenticate with Tokens.
+type BearerTransport struct {
+	// Token is the bearer token.
+	Token string
+	// Base is the underlying transport. If nil, http.DefaultTransport
+	// is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *BearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTrippers must not modify their requests.
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(r)
+}
@@ -34,6 +34,7 @@ import (
 
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/trace"
 )
 
 // Call represents an incoming call to be serviced. Calls encapsulate
@@ -259,7 +260,7 @@ func (h *nodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		h.log.Debugf("request %s", string(b))
 	}
-	ctx := r.Context()
+	ctx := trace.ReadHTTPContext(r.Context(), r.Header)
 	call := &Call{writer: w, req: r, log: h.log}
 	defer call.flush()
 	path := path.Clean(r.URL.Path)
@@ -281,6 +282,17 @@ func (h *nodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	n.Do(ctx, call)
 }
 
+// TraceHandler returns a handler that serves requests with h, in
+// contexts that emit trace events to the provided tracer. Handlers
+// returned by Handler and DoFuncHandler restore the trace contexts
+// of their callers, so that the spans of the served calls are part
+// of the callers' traces.
+func TraceHandler(h http.Handler, tracer trace.Tracer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(trace.WithTracer(r.Context(), tracer)))
+	})
+}
+
 type doFuncHandler struct {
 	node DoFunc
 	log  *log.Logger
@@ -302,7 +314,7 @@ func (h *doFuncHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		h.log.Debugf("request %s", string(b))
 	}
-	ctx := r.Context()
+	ctx := trace.ReadHTTPContext(r.Context(), r.Header)
 	call := &Call{writer: w, req: r, log: h.log}
 	defer call.flush()
 	h.node.Do(ctx, call)
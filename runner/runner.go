@@ -6,6 +6,7 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -67,8 +68,11 @@ type State struct {
 	AllocInspect pool.AllocInspect
 	// Value contains the result of the evaluation,
 	// rendered as a string.
-	// TODO(marius): serialize the value into JSON.
 	Result string
+	// Value contains the result of the evaluation, rendered as JSON
+	// by values.MarshalJSON. Value is empty if the result cannot be
+	// rendered as JSON.
+	Value json.RawMessage `json:",omitempty"`
 	// Err contains runtime errors.
 	Err *errors.Error
 	// NumTries is the number of evaluation attempts
@@ -81,6 +85,11 @@ type State struct {
 	// Completion is the time of the run's completion.
 	Completion time.Time
 
+	// Execs is the number of execs, interns, and externs completed
+	// by the run's evaluation, and CacheHits the number of those that
+	// were retrieved from cache.
+	Execs, CacheHits int
+
 	// TotalResources stores the total amount of resources used
 	// by this run. Note that the resources are in resource-minutes.
 	TotalResources reflow.Resources
@@ -93,11 +102,13 @@ func (s *State) Reset() {
 	s.AllocID = ""
 	s.AllocInspect = pool.AllocInspect{}
 	s.Result = ""
+	s.Value = nil
 	s.Err = nil
 	s.NumTries = 0
 	s.LastTry = time.Time{}
 	s.Created = time.Time{}
 	s.Completion = time.Time{}
+	s.Execs, s.CacheHits = 0, 0
 }
 
 // String returns a string representation of the state.
@@ -164,6 +175,10 @@ type Runner struct {
 
 	// Cmdline is a debug string with program name, params and args.
 	Cmdline string
+
+	// Notify, if not nil, is called with the run's state and labels
+	// when the run completes, successfully or not.
+	Notify func(ctx context.Context, state State, labels pool.Labels)
 }
 
 // Do steps the runner state machine. Do returns true whenever
@@ -179,6 +194,7 @@ func (r *Runner) Do(ctx context.Context) bool {
 	if r.Scheduler != nil && r.Phase == Init {
 		r.Phase = Eval
 	}
+	phase := r.Phase
 	switch r.Phase {
 	case Init:
 		if err := r.Allocate(ctx); err != nil {
@@ -208,9 +224,9 @@ func (r *Runner) Do(ctx context.Context) bool {
 				break
 			}
 		}
-		var err error
-		r.Result, err = r.Eval(ctx)
+		v, err := r.eval(ctx)
 		if err == nil {
+			r.Result, r.Value = r.render(v)
 			r.Phase = Done
 			r.Completion = time.Now()
 			break
@@ -247,6 +263,9 @@ func (r *Runner) Do(ctx context.Context) bool {
 		r.Phase = Init
 		r.Err = nil
 	}
+	if phase != Done && r.Phase == Done && r.Notify != nil {
+		r.Notify(ctx, r.State, r.labels())
+	}
 	return r.Phase != Done
 }
 
@@ -267,6 +286,15 @@ func (r *Runner) Allocate(ctx context.Context) error {
 // case of failure, r.Alloc is kept-alive for an additional r.Retain
 // duration.
 func (r *Runner) Eval(ctx context.Context) (string, error) {
+	v, err := r.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+	result, _ := r.render(v)
+	return result, nil
+}
+
+func (r *Runner) eval(ctx context.Context) (values.T, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	var wg sync.WaitGroup
 	if r.Alloc != nil {
@@ -308,6 +336,7 @@ func (r *Runner) Eval(ctx context.Context) (string, error) {
 
 	err := eval.Do(ctx)
 	done()
+	r.Execs, r.CacheHits = eval.CacheStats()
 	if err == nil {
 		// TODO(marius): use logger for this.
 		eval.LogSummary(r.Log)
@@ -327,15 +356,31 @@ func (r *Runner) Eval(ctx context.Context) (string, error) {
 		cancel()
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if err := eval.Err(); err != nil {
-		return "", errors.E(errors.Eval, err)
+		return nil, errors.E(errors.Eval, err)
 	}
+	return eval.Value(), nil
+}
+
+// render renders the result v of an evaluation as a string and as
+// JSON. If v cannot be rendered as JSON, the returned JSON is nil.
+func (r *Runner) render(v values.T) (string, json.RawMessage) {
 	if r.Type == nil {
-		return eval.Value().(reflow.Fileset).String(), nil
+		fs := v.(reflow.Fileset)
+		p, err := json.Marshal(fs)
+		if err != nil {
+			p = nil
+		}
+		return fs.String(), p
+	}
+	p, err := values.MarshalJSON(v, r.Type)
+	if err != nil {
+		r.Log.Debugf("result cannot be rendered as JSON: %v", err)
+		p = nil
 	}
-	return values.Sprint(eval.Value(), r.Type), nil
+	return values.Sprint(v, r.Type), p
 }
 
 func (r Runner) labels() pool.Labels {
@@ -35,6 +35,7 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/blob"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/taskdb"
@@ -74,6 +75,9 @@ type Scheduler struct {
 	Log *log.Logger
 	// TaskDB is  the task reporting db.
 	TaskDB taskdb.TaskDB
+	// Events is an (optional) sink to which the scheduler publishes
+	// alloc acquisitions.
+	Events events.Sink
 
 	// MaxPendingAllocs is the maximum number outstanding
 	// alloc requests.
@@ -117,7 +121,7 @@ func (s *Scheduler) Submit(tasks ...*Task) {
 	s.submitc <- tasks
 }
 
-// ExportStats exports scheduler stats as expvars.
+// ExportStats exports scheduler stats as expvars and metrics.
 func (s *Scheduler) ExportStats() {
 	s.Stats.publish()
 }
@@ -254,6 +258,7 @@ func (s *Scheduler) Do(ctx context.Context) error {
 				alloc.Init()
 				heap.Push(&live, alloc)
 				s.Stats.AddAlloc(alloc)
+				events.Publish(s.Events, events.Event{Kind: events.AllocAcquired, Alloc: alloc.id})
 			}
 		case alloc := <-deadc:
 			// The allocs tasks will be returned with state TaskLost.
@@ -390,6 +395,29 @@ func (e execState) String() string {
 	}
 }
 
+// load loads the fileset fs from the scheduler's repository into
+// alloc. Allocs retrieve objects directly from repositories that
+// have URLs. Repositories without URLs (e.g., because they are
+// anonymous, or because their objects are encrypted by the client)
+// cannot be accessed by allocs: their objects are instead
+// transferred to the alloc's repository, from which they are
+// loaded.
+func (s *Scheduler) load(ctx context.Context, alloc *alloc, fs reflow.Fileset) (reflow.Fileset, error) {
+	u := s.Repository.URL()
+	if u == nil {
+		var files []reflow.File
+		for _, file := range fs.Files() {
+			if !file.IsRef() {
+				files = append(files, file)
+			}
+		}
+		if err := s.Transferer.Transfer(ctx, alloc.Repository(), s.Repository, files...); err != nil {
+			return reflow.Fileset{}, err
+		}
+	}
+	return alloc.Load(ctx, u, fs)
+}
+
 func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 	var (
 		err            error
@@ -425,7 +453,7 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 				arg := task.Config.Args[i]
 				g.Go(func() error {
 					task.Log.Debugf("loading %s", (*arg.Fileset).Short())
-					fs, lerr := alloc.Load(gctx, s.Repository.URL(), *arg.Fileset)
+					fs, lerr := s.load(gctx, alloc, *arg.Fileset)
 					if lerr != nil {
 						return lerr
 					}
@@ -575,7 +603,9 @@ func requirements(tasks []*Task) reflow.Requirements {
 
 // doDirectTransfer attempts to do a direct transfer for externs.
 // Direct transfers are supported only if the scheduler's Repository
-// and the destination repository are both blob stores.
+// and the destination repository are both blob stores, and the
+// scheduler's repository can locate each of the files to be
+// transferred.
 func (s *Scheduler) doDirectTransfer(ctx context.Context, task *Task) error {
 	taskLogger := task.Log.Tee(nil, "direct transfer: ")
 	if task.Config.Type != "extern" {
@@ -601,26 +631,51 @@ func (s *Scheduler) doDirectTransfer(ctx context.Context, task *Task) error {
 			return errors.E(errors.NotSupported, errors.New("unresolved files not supported"))
 		}
 	}
+	// Locate all of the files before transferring any of them: objects
+	// that cannot be located (e.g., because they are stored compressed)
+	// require a non-direct transfer.
+	var (
+		locations = make(map[string]string, len(fs.Map))
+		mu        sync.Mutex
+		g, gctx   = errgroup.WithContext(ctx)
+	)
+	for k, v := range fs.Map {
+		filename, file := k, v
+		g.Go(func() error {
+			srcUrl, err := fileLocator.Location(gctx, file.ID)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			locations[filename] = srcUrl
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if errors.Is(errors.NotSupported, err) {
+			return err
+		}
+		task.Result.Err = errors.Recover(err)
+		return nil
+	}
 	task.mu.Lock()
 	task.Result.Fileset.Map = map[string]reflow.File{}
 	task.mu.Unlock()
 
 	extUrl := strings.TrimSuffix(task.Config.URL, "/")
 
-	g, ctx := errgroup.WithContext(ctx)
+	g, ctx = errgroup.WithContext(ctx)
 	for k, v := range fs.Map {
 		filename, file := k, v
 		g.Go(func() error {
-			srcUrl, err := fileLocator.Location(ctx, file.ID)
-			if err != nil {
-				return err
-			}
+			srcUrl := locations[filename]
 			dstUrl := extUrl + "/" + filename
 			if filename == "." {
 				dstUrl = extUrl
 			}
 			start := time.Now()
-			if err = s.Mux.Transfer(ctx, dstUrl, srcUrl); err != nil {
+			if err := s.Mux.Transfer(ctx, dstUrl, srcUrl); err != nil {
 				return errors.E(fmt.Sprintf("scheduler direct transfer: %s -> %s", srcUrl, dstUrl), err)
 			}
 			dur := time.Since(start).Round(time.Second)
@@ -631,7 +686,7 @@ func (s *Scheduler) doDirectTransfer(ctx context.Context, task *Task) error {
 			task.mu.Lock()
 			task.Result.Fileset.Map[filename] = file
 			task.mu.Unlock()
-			return err
+			return nil
 		})
 	}
 	task.Result.Err = errors.Recover(g.Wait())
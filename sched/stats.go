@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/metrics"
 )
 
 // ExpVarScheduler is the name of the expvar scheduler stats.
@@ -141,9 +142,55 @@ type Stats struct {
 	Tasks map[string]*TaskStats
 }
 
-// Publish publishes the stats as a go expvar.
+// Publish publishes the stats as a go expvar and as metrics.
 func (s *Stats) publish() {
 	expvar.Publish(ExpVarScheduler, expvar.Func(func() interface{} { return s.GetStats() }))
+
+	metrics.NewFunc("reflow_scheduler_allocs_total", "Number of allocs acquired by the scheduler.",
+		metrics.CounterType, nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(s.GetStats().TotalAllocs)}}
+		})
+	metrics.NewFunc("reflow_scheduler_allocs", "Number of the scheduler's allocs, by state (live or dead).",
+		metrics.GaugeType, []string{"state"}, func() []metrics.Sample {
+			var live, dead int
+			for _, a := range s.GetStats().Allocs {
+				if a.Dead {
+					dead++
+				} else {
+					live++
+				}
+			}
+			return []metrics.Sample{
+				{Labels: []string{"live"}, Value: float64(live)},
+				{Labels: []string{"dead"}, Value: float64(dead)},
+			}
+		})
+	metrics.NewFunc("reflow_scheduler_tasks_total", "Number of tasks submitted to the scheduler.",
+		metrics.CounterType, nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(s.GetStats().TotalTasks)}}
+		})
+	metrics.NewFunc("reflow_scheduler_tasks", "Number of the scheduler's tasks, by state.",
+		metrics.GaugeType, []string{"state"}, func() []metrics.Sample {
+			n := make(map[TaskState]int)
+			for _, t := range s.GetStats().Tasks {
+				n[TaskState(t.State)]++
+			}
+			samples := make([]metrics.Sample, 0, TaskDone+1)
+			for state := TaskInit; state <= TaskDone; state++ {
+				samples = append(samples, metrics.Sample{Labels: []string{state.String()}, Value: float64(n[state])})
+			}
+			return samples
+		})
+	metrics.NewFunc("reflow_scheduler_queue_depth", "Number of tasks waiting to be assigned to an alloc.",
+		metrics.GaugeType, nil, func() []metrics.Sample {
+			var n int
+			for _, t := range s.GetStats().Tasks {
+				if state := TaskState(t.State); state == TaskInit || state == TaskLost {
+					n++
+				}
+			}
+			return []metrics.Sample{{Value: float64(n)}}
+		})
 }
 
 // AddTasks adds the tasks to the stats.
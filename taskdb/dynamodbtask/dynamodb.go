@@ -753,10 +753,18 @@ func (t *TaskDB) Runs(ctx context.Context, runQuery taskdb.RunQuery) ([]taskdb.R
 		if err != nil {
 			errs = append(errs, fmt.Errorf("parse starttime %v: %v", *it[colStartTime].S, err))
 		}
+		var bundle digest.Digest
+		if v := it[colBundle]; v != nil && v.S != nil {
+			bundle, err = reflow.Digester.Parse(*v.S)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("parse bundle %v: %v", *v.S, err))
+			}
+		}
 		runs = append(runs, taskdb.Run{
 			ID:        taskdb.RunID(id),
 			Labels:    l,
 			User:      *it["User"].S,
+			Bundle:    bundle,
 			Keepalive: keepalive,
 			Start:     st})
 	}
@@ -150,6 +150,9 @@ type Run struct {
 	Labels pool.Labels
 	// User is the specified config.User()
 	User string
+	// Bundle is the digest of the bundle of the program that was run,
+	// if it has been set.
+	Bundle digest.Digest
 	// Keepalive is the keepalive lease on the run.
 	Keepalive time.Time
 	// Start is the time the run was started.
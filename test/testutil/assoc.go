@@ -83,5 +83,19 @@ func (a *inmemoryAssoc) Count(ctx context.Context) (int64, error) {
 // Scan calls the handler function for every association in the mapping.
 // Note that the handler function may be called asynchronously from multiple threads.
 func (a *inmemoryAssoc) Scan(ctx context.Context, kind assoc.Kind, handler assoc.MappingHandler) error {
-	return errors.E("scan", errors.NotSupported)
+	a.mu.Lock()
+	assocs := make(map[assocKey]digest.Digest, len(a.assocs))
+	for k, v := range a.assocs {
+		if k.Kind == kind {
+			assocs[k] = v
+		}
+	}
+	a.mu.Unlock()
+	for k, v := range assocs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		handler.HandleMapping(k.Digest, []digest.Digest{v}, k.Kind, time.Time{}, nil)
+	}
+	return nil
 }
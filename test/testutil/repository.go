@@ -14,6 +14,7 @@ import (
 	"math/rand"
 	"net/url"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -81,10 +82,31 @@ func (r *InmemoryRepository) set(k digest.Digest, b []byte) {
 }
 
 // Delete removes the key id from this repository.
-func (r *InmemoryRepository) Delete(_ context.Context, id digest.Digest) {
+func (r *InmemoryRepository) Delete(_ context.Context, id digest.Digest) error {
 	r.mu.Lock()
 	delete(r.files, id)
 	r.mu.Unlock()
+	return nil
+}
+
+// Scan invokes handler for each object in the repository, in digest order.
+func (r *InmemoryRepository) Scan(ctx context.Context, handler func(digest.Digest) error) error {
+	r.mu.Lock()
+	ids := make([]digest.Digest, 0, len(r.files))
+	for id := range r.files {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := handler(id); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Stat returns metadata for the blob named by id.
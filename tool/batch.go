@@ -26,12 +26,16 @@ import (
 	"github.com/grailbio/reflow/assoc"
 	"github.com/grailbio/reflow/batch"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	"github.com/grailbio/reflow/flow"
 	"github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/notify"
+	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/repository"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/syntax"
+	"github.com/grailbio/reflow/taskdb"
 	"github.com/grailbio/reflow/types"
 	"github.com/grailbio/reflow/wg"
 )
@@ -83,6 +87,83 @@ bam=3.bam,sample=c.
 	2,2.bam,b
 	3,3.bam,c
 
+Runs may instead be specified with typed parameters, by a runs file
+with the extension ".jsonl" (one JSON object per line) or ".yaml".
+Each run specifies its identifier, its parameters, and optionally
+its arguments. Parameter values may be of any type supported by the
+module's parameters, including lists, maps, and structs; they are
+checked against the module's parameters before the batch is run.
+For example, the following YAML file defines two runs of a module
+with parameters "sample" of type string and "fastqs" of type
+[{r1, r2 file}]:
+
+	- id: a
+	  params:
+	    sample: a
+	    fastqs:
+	      - {r1: s3://bucket/a_1.fq, r2: s3://bucket/a_2.fq}
+	      - {r1: s3://bucket/a_3.fq, r2: s3://bucket/a_4.fq}
+	- id: b
+	  params:
+	    sample: b
+	    fastqs: [{r1: s3://bucket/b_1.fq, r2: s3://bucket/b_2.fq}]
+
+Typed parameters are supported only for .rf modules.
+
+Runs with typed parameters may depend on other runs in the batch.
+A run's "deps" lists the IDs of the runs that must complete
+successfully before it is started. Parameters may also refer to the
+results of other runs, as {"$run": id} for the value of run id's
+Main, or {"$run": id, "$field": "a.b"} for the field a.b of that
+value; a run implicitly depends on the runs to which it refers. For
+example, the following run computes a joint genotype from the
+"gvcf" fields of the results of runs a and b once they are done:
+
+	- id: joint
+	  params:
+	    gvcfs:
+	      - {$run: a, $field: gvcf}
+	      - {$run: b, $field: gvcf}
+
+References are type checked against the type of the module's Main
+before the batch is run. A run whose dependencies fail is marked as
+failed without being started; it is started again, together with its
+failed dependencies, with -retry. Progress is persisted, so that
+dependent runs resume correctly when the batch is restarted.
+
+The batch configuration may also define a policy that determines
+how runs are scheduled and how their failures are handled:
+
+	{
+		"program": "pipeline.rf",
+		"runs_file": "samples.csv",
+		"policy": {
+			"max_concurrent": 10,
+			"retries": 2,
+			"retry_backoff": "5m",
+			"max_retry_backoff": "1h",
+			"fail_fast": false,
+			"timeout": "12h"
+		}
+	}
+
+The policy above evaluates at most 10 runs at a time; retries failed
+runs up to 2 times, waiting 5 minutes before the first retry and
+doubling the wait for each subsequent retry, up to an hour; continues
+with the batch's other runs when a run fails (with fail_fast, the
+batch stops at the first run that fails after exhausting its retries,
+and the runs it interrupts are restarted when the batch is next run);
+and fails each attempt that takes longer than 12 hours. All policy
+entries are optional. The policy may be overridden by the flags
+-maxconcurrent, -retries, -failfast, and -timeout. Retries count
+across restarts of the batch; runs that have failed are not attempted
+again until they are reset with -retry or -reset. Each attempt, and
+the decision made by the policy, is recorded in the run's state and
+displayed by reflow batchinfo. If a notifier is configured, a
+summary of each attempt is sent when it completes. The summary
+carries the run's labels and the idents of its failed execs and, if
+a task database is configured, the estimated cost of the run.
+
 Reflow deposits individual log files into the working directory for
 each run in the batch. These are in addition to the standard log
 files that are peristed for runs, and are always logged at the debug
@@ -94,6 +175,10 @@ flags override any parameters in the batch sample file.
 	retryFlag := flags.Bool("retry", false, "retry failed runs")
 	resetFlag := flags.Bool("reset", false, "reset failed runs")
 	idsFlag := flags.String("ids", "", "comma-separated list of ids to run; an empty list runs all")
+	maxConcurrentFlag := flags.Int("maxconcurrent", 0, "maximum number of concurrent runs; overrides the batch policy")
+	retriesFlag := flags.Int("retries", 0, "number of times failed runs are retried; overrides the batch policy")
+	failFastFlag := flags.Bool("failfast", false, "stop the batch when a run fails; overrides the batch policy")
+	timeoutFlag := flags.Duration("timeout", 0, "maximum duration of each run attempt; overrides the batch policy")
 	var bc batchConfig
 	bc.Flags(flags)
 	var config CommonRunFlags
@@ -118,7 +203,7 @@ flags override any parameters in the batch sample file.
 		Log:              c.Log,
 	}
 	if repo != nil {
-		transferer.PendingTransfers.Set(repo.URL().String(), int(^uint(0)>>1))
+		transferer.PendingTransfers.SetRepository(repo, int(^uint(0)>>1))
 	}
 	wd, err := os.Getwd()
 	if err != nil {
@@ -151,8 +236,52 @@ flags override any parameters in the batch sample file.
 		Status:  c.Status.Groupf("batch %s", wd),
 	}
 	c.must(config.Configure(&b.EvalConfig))
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type taskdb.TaskDB") {
+			c.Fatal(err)
+		}
+	}
+	b.EvalConfig.TaskDB = tdb
+	var notifier notify.Notifier
+	if err := c.Config.Instance(&notifier); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type notify.Notifier") {
+			c.Fatal(err)
+		}
+	}
+	if notifier != nil {
+		var labels pool.Labels
+		if err := c.Config.Instance(&labels); err != nil {
+			c.Log.Error(err)
+		}
+		n := &runNotifier{Notifier: notifier, Config: c.Config, Cluster: cluster, TaskDB: tdb, Repo: repo, Log: c.Log}
+		b.Notifier = func(*batch.Run) (events.Sink, func(context.Context, runner.State, pool.Labels)) {
+			failed := new(failedIdents)
+			return failed, func(ctx context.Context, state runner.State, runLabels pool.Labels) {
+				all := labels.Copy()
+				for k, v := range runLabels {
+					all[k] = v
+				}
+				n.Notify(ctx, state, all, "", time.Time{}, failed.Idents())
+			}
+		}
+	}
 	bc.Configure(b)
 	c.must(b.Init(*resetFlag))
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "maxconcurrent":
+			b.Policy.MaxConcurrent = *maxConcurrentFlag
+		case "retries":
+			b.Policy.Retries = *retriesFlag
+		case "failfast":
+			b.Policy.FailFast = *failFastFlag
+		case "timeout":
+			b.Policy.Timeout = *timeoutFlag
+		}
+	})
+	c.must(b.Policy.Validate())
+	c.Log.Printf("batch policy: %s", b.Policy)
 
 	defer b.Close()
 	if *idsFlag != "" {
@@ -200,9 +329,23 @@ func (c *Cmd) batchinfo(ctx context.Context, args ...string) {
 	flags := flag.NewFlagSet("batchinfo", flag.ExitOnError)
 	var bc batchConfig
 	bc.Flags(flags)
+	reportFlag := flags.Bool("report", false, "write a summary report of the batch's runs")
+	reportDirFlag := flags.String("reportdir", "", "directory in which the report is written; defaults to the batch directory")
+	regionFlag := flags.String("region", "us-west-2", "region in which to price instances for report costs")
 	help := `Batchinfo displays runtime information for the batch in the current directory.
-See runbatch -help for information about Reflow's batching mechanism.`
-	c.Parse(flags, args, help, "batchinfo")
+See runbatch -help for information about Reflow's batching mechanism.
+
+With -report, batchinfo instead writes a summary report of the
+batch's runs to the files report.csv, report.json, and report.html in
+the directory given by -reportdir. The report contains, for each run,
+its ID, arguments, state, run ID, start and end times, duration,
+number of attempts, error, result, cache hit ratio, and cost. Results
+are rendered as JSON where possible. Cache hit ratios are the
+fraction of execs, interns, and externs retrieved from cache. Costs
+are estimated from the tasks recorded in the task database, as
+computed by reflow cost (see reflow cost -help), and are omitted if
+no task database is configured.`
+	c.Parse(flags, args, help, "batchinfo [-report [-reportdir dir]]")
 	if flags.NArg() != 0 {
 		flags.Usage()
 	}
@@ -212,6 +355,27 @@ See runbatch -help for information about Reflow's batching mechanism.`
 	bc.Configure(&b)
 	c.must(b.Init(false))
 	defer b.Close()
+	if *reportFlag {
+		var tdb taskdb.TaskDB
+		if err := c.Config.Instance(&tdb); err != nil {
+			c.Log.Debugf("taskdb: %v", err)
+		}
+		var repo reflow.Repository
+		if tdb != nil {
+			c.must(c.Config.Instance(&repo))
+		}
+		dir := *reportDirFlag
+		if dir == "" {
+			dir = b.Dir
+		}
+		report := c.newBatchReport(ctx, &b, tdb, repo, *regionFlag)
+		paths, err := writeBatchReport(dir, report)
+		c.must(err)
+		for _, path := range paths {
+			c.Println(path)
+		}
+		return
+	}
 	ids := make([]string, len(b.Runs))
 	i := 0
 	for id := range b.Runs {
@@ -223,11 +387,24 @@ See runbatch -help for information about Reflow's batching mechanism.`
 	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
 	defer tw.Flush()
 
+	fmt.Fprintf(&tw, "policy: %s\n", b.Policy)
 	for _, id := range ids {
 		run := b.Runs[id]
 		fmt.Fprintf(&tw, "run %s: %s\n", id, run.State.ID.IDShort())
 		c.printRunInfo(ctx, &tw, digest.Digest(run.State.ID))
 		fmt.Fprintf(&tw, "\tlog:\t%s\n", filepath.Join(b.Dir, "log."+id))
+		if n := len(run.Attempts); n > 0 {
+			last := run.Attempts[n-1]
+			fmt.Fprintf(&tw, "\tattempts:\t%d\n", n)
+			fmt.Fprintf(&tw, "\tdecision:\t%s\n", last)
+			for i, a := range run.Attempts {
+				fmt.Fprintf(&tw, "\t\t%d\t%s\t%s\t%s", i+1, a.Start.Local().Format(time.RFC3339), a.End.Sub(a.Start).Round(time.Second), a.Decision)
+				if a.Err != nil {
+					fmt.Fprintf(&tw, "\t%s", a.Err.ErrorSeparator(": "))
+				}
+				fmt.Fprintln(&tw)
+			}
+		}
 	}
 }
 
@@ -266,20 +443,9 @@ The columns displayed by listbatch are:
 
 	for _, id := range ids {
 		run := b.Runs[id]
-		var state string
-		switch run.State.Phase {
-		case runner.Init:
-			state = "waiting"
-		case runner.Eval:
-			state = "running"
-		case runner.Retry:
-			state = "retrying"
-		case runner.Done:
-			if err := run.State.Err; err != nil {
-				state = errors.Recover(err).ErrorSeparator(": ")
-			} else {
-				state = "done"
-			}
+		state := batchRunState(run.State)
+		if err := run.State.Err; run.State.Phase == runner.Done && err != nil {
+			state = errors.Recover(err).ErrorSeparator(": ")
 		}
 		fmt.Fprintf(&tw, "%s\t%s\t%s\n", id, run.State.ID.IDShort(), state)
 	}
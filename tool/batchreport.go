@@ -0,0 +1,314 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/batch"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/runner"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+// batchRunReport summarizes a single run of a batch.
+type batchRunReport struct {
+	ID       string                 `json:"id"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+	Argv     []string               `json:"argv,omitempty"`
+	State    string                 `json:"state"`
+	RunID    string                 `json:"run_id,omitempty"`
+	Start    time.Time              `json:"start"`
+	End      time.Time              `json:"end"`
+	Duration float64                `json:"duration_seconds"`
+	Attempts int                    `json:"attempts"`
+	Error    string                 `json:"error,omitempty"`
+	Result   json.RawMessage        `json:"result,omitempty"`
+	Execs    int                    `json:"execs"`
+	Hits     int                    `json:"cache_hits"`
+	Cost     float64                `json:"cost"`
+}
+
+// CacheHitRatio returns the fraction of the run's execs, interns,
+// and externs that were retrieved from cache.
+func (r batchRunReport) CacheHitRatio() float64 {
+	if r.Execs == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Execs)
+}
+
+// batchReport summarizes the runs of a batch.
+type batchReport struct {
+	Dir       string           `json:"dir"`
+	Program   string           `json:"program"`
+	Policy    string           `json:"policy"`
+	Generated time.Time        `json:"generated"`
+	States    map[string]int   `json:"states"`
+	Cost      float64          `json:"cost"`
+	Runs      []batchRunReport `json:"runs"`
+}
+
+// batchRunState returns a short description of the state of a batch
+// run: waiting, running, retrying, done, or failed.
+func batchRunState(state runner.State) string {
+	switch state.Phase {
+	case runner.Init:
+		return "waiting"
+	case runner.Eval:
+		return "running"
+	case runner.Retry:
+		return "retrying"
+	case runner.Done:
+		if state.Err != nil {
+			return "failed"
+		}
+		return "done"
+	}
+	return "unknown"
+}
+
+// newBatchRunReport summarizes the provided run. The run's cost is
+// not computed.
+func newBatchRunReport(run *batch.Run) batchRunReport {
+	r := batchRunReport{
+		ID:       run.ID,
+		Argv:     run.Argv,
+		State:    batchRunState(run.State),
+		Attempts: len(run.Attempts),
+		Execs:    run.State.Execs,
+		Hits:     run.State.CacheHits,
+		Result:   run.State.Value,
+	}
+	if run.Params != nil {
+		r.Args = run.Params
+	} else if len(run.Args) > 0 {
+		r.Args = make(map[string]interface{}, len(run.Args))
+		for k, v := range run.Args {
+			r.Args[k] = v
+		}
+	}
+	if run.State.ID.IsValid() {
+		r.RunID = run.State.ID.ID()
+	}
+	r.Start, r.End = run.State.Created, run.State.Completion
+	if !r.Start.IsZero() && !r.End.IsZero() {
+		r.Duration = r.End.Sub(r.Start).Seconds()
+	}
+	if run.State.Phase == runner.Done && run.State.Err != nil {
+		r.Error = run.State.Err.ErrorSeparator(": ")
+	}
+	if len(r.Result) == 0 && run.State.Result != "" {
+		// The result could not be rendered as JSON; report its
+		// string representation instead.
+		r.Result, _ = json.Marshal(run.State.Result)
+	}
+	return r
+}
+
+// newBatchReport summarizes the runs of batch b. Run costs are
+// computed from the tasks recorded in tdb; they are omitted if tdb
+// is nil.
+func (c *Cmd) newBatchReport(ctx context.Context, b *batch.Batch, tdb taskdb.TaskDB, repo reflow.Repository, region string) batchReport {
+	report := batchReport{
+		Dir:       b.Dir,
+		Program:   b.Program(),
+		Policy:    b.Policy.String(),
+		Generated: time.Now(),
+		States:    make(map[string]int),
+	}
+	for _, run := range b.Runs {
+		r := newBatchRunReport(run)
+		report.States[r.State]++
+		report.Runs = append(report.Runs, r)
+	}
+	sort.Slice(report.Runs, func(i, j int) bool { return report.Runs[i].ID < report.Runs[j].ID })
+	if tdb == nil {
+		c.Log.Printf("no task database configured; omitting run costs")
+		return report
+	}
+	var tasks []taskdb.Task
+	for _, run := range b.Runs {
+		if !run.State.ID.IsValid() {
+			continue
+		}
+		t, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: run.State.ID})
+		if err != nil {
+			c.Log.Errorf("run %s: tasks: %v", run.ID, err)
+			continue
+		}
+		for _, task := range t {
+			if !task.Inspect.IsZero() {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return report
+	}
+	costs := computeCosts(c.costTasks(ctx, repo, tasks), func(t costTask) string { return t.RunID.ID() }, region)
+	byRun := make(map[string]float64)
+	for _, g := range costs.Groups {
+		byRun[g.Key] = g.Cost
+	}
+	for i := range report.Runs {
+		report.Runs[i].Cost = byRun[report.Runs[i].RunID]
+		report.Cost += report.Runs[i].Cost
+	}
+	return report
+}
+
+// WriteCSV writes the report as CSV, one row for each run.
+// Arguments and results are rendered as JSON.
+func (r batchReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "args", "state", "runid", "start", "end", "duration", "attempts", "error", "result", "cache_hit_ratio", "cost"})
+	for _, run := range r.Runs {
+		var args []byte
+		if run.Args != nil || run.Argv != nil {
+			var err error
+			args, err = json.Marshal(struct {
+				Args map[string]interface{} `json:"args,omitempty"`
+				Argv []string               `json:"argv,omitempty"`
+			}{run.Args, run.Argv})
+			if err != nil {
+				return errors.E("report", run.ID, err)
+			}
+		}
+		var start, end string
+		if !run.Start.IsZero() {
+			start = run.Start.Format(time.RFC3339)
+		}
+		if !run.End.IsZero() {
+			end = run.End.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			run.ID,
+			string(args),
+			run.State,
+			run.RunID,
+			start,
+			end,
+			fmt.Sprintf("%.0f", run.Duration),
+			fmt.Sprint(run.Attempts),
+			run.Error,
+			string(run.Result),
+			fmt.Sprintf("%.4f", run.CacheHitRatio()),
+			fmt.Sprintf("%.4f", run.Cost),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r batchReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+var batchReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(secs float64) string {
+		if secs == 0 {
+			return ""
+		}
+		return time.Duration(secs * float64(time.Second)).Round(time.Second).String()
+	},
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(time.RFC822)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Batch {{.Dir}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+td.num { text-align: right; }
+tr.failed { background: #fdecea; }
+pre { margin: 0; max-width: 40em; max-height: 10em; overflow: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Batch {{.Dir}}</h1>
+<table>
+<tr><th>program</th><td>{{.Program}}</td></tr>
+<tr><th>policy</th><td>{{.Policy}}</td></tr>
+<tr><th>runs</th><td>{{len .Runs}}{{range $state, $n := .States}}, {{$n}} {{$state}}{{end}}</td></tr>
+<tr><th>cost</th><td>${{printf "%.2f" .Cost}}</td></tr>
+<tr><th>generated</th><td>{{time .Generated}}</td></tr>
+</table>
+<h2>Runs</h2>
+<table>
+<tr><th>id</th><th>state</th><th>runid</th><th>start</th><th>duration</th><th>attempts</th><th>cache hits</th><th>cost</th><th>error</th><th>result</th></tr>
+{{range .Runs}}<tr{{if eq .State "failed"}} class="failed"{{end}}>
+<td>{{.ID}}</td>
+<td>{{.State}}</td>
+<td><code>{{.RunID}}</code></td>
+<td>{{time .Start}}</td>
+<td class="num">{{duration .Duration}}</td>
+<td class="num">{{.Attempts}}</td>
+<td class="num">{{if .Execs}}{{percent .CacheHitRatio}} ({{.Hits}}/{{.Execs}}){{end}}</td>
+<td class="num">${{printf "%.2f" .Cost}}</td>
+<td><pre>{{.Error}}</pre></td>
+<td><pre>{{printf "%s" .Result}}</pre></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as an HTML summary page.
+func (r batchReport) WriteHTML(w io.Writer) error {
+	return batchReportTemplate.Execute(w, r)
+}
+
+// writeBatchReport writes the report to the files report.csv,
+// report.json, and report.html in directory dir, returning their
+// paths.
+func writeBatchReport(dir string, report batchReport) ([]string, error) {
+	var paths []string
+	for _, f := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"report.csv", report.WriteCSV},
+		{"report.json", report.WriteJSON},
+		{"report.html", report.WriteHTML},
+	} {
+		path := filepath.Join(dir, f.name)
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.write(file); err != nil {
+			file.Close()
+			return nil, errors.E("write", path, err)
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow/batch"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/runner"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func TestBatchReport(t *testing.T) {
+	var (
+		start = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+		id    = taskdb.NewRunID()
+		done  = &batch.Run{
+			ID:   "a",
+			Args: map[string]string{"sample": "a"},
+			State: runner.State{
+				ID:         id,
+				Phase:      runner.Done,
+				Created:    start,
+				Completion: start.Add(90 * time.Minute),
+				Result:     `{vcf: file(sha256:..., 123), qc: 0.9}`,
+				Value:      json.RawMessage(`{"qc":0.9}`),
+				Execs:      4,
+				CacheHits:  3,
+			},
+			Attempts: []batch.Attempt{{Decision: batch.DecisionDone}},
+		}
+		failed = &batch.Run{
+			ID:     "b",
+			Params: map[string]interface{}{"sample": "b", "depth": 30},
+			State: runner.State{
+				Phase: runner.Done,
+				Err:   errors.Recover(errors.E("exec", "align", errors.New("out of memory"))),
+			},
+		}
+		waiting = &batch.Run{ID: "c", Argv: []string{"-x"}}
+	)
+	r := newBatchRunReport(done)
+	if got, want := r.State, "done"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.RunID, id.ID(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.Duration, 5400.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.CacheHitRatio(), 0.75; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := string(r.Result), `{"qc":0.9}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r = newBatchRunReport(failed)
+	if got, want := r.State, "failed"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !strings.Contains(r.Error, "out of memory") {
+		t.Errorf("got error %q", r.Error)
+	}
+	if r.RunID != "" || r.Duration != 0 || r.CacheHitRatio() != 0 {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	report := batchReport{Dir: "/batch", Program: "x.rf", States: map[string]int{"done": 1, "failed": 1, "waiting": 1}}
+	for _, run := range []*batch.Run{done, failed, waiting} {
+		report.Runs = append(report.Runs, newBatchRunReport(run))
+	}
+	report.Runs[0].Cost = 1.5
+
+	var b bytes.Buffer
+	if err := report.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(records), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := []string{"a", `{"args":{"sample":"a"}}`, "done", id.ID(), "2020-06-01T00:00:00Z", "2020-06-01T01:30:00Z", "5400", "1", "", `{"qc":0.9}`, "0.7500", "1.5000"}
+	if got := records[1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := records[2][1], `{"args":{"depth":30,"sample":"b"}}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := records[3][1], `{"argv":["-x"]}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if err := report.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded batchReport
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(decoded.Runs), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := decoded.Runs[0].Hits, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if err := report.WriteHTML(&b); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, s := range []string{"<h1>Batch /batch</h1>", `<tr class="failed">`, "75% (3/4)", "$1.50", "1h30m0s", "out of memory"} {
+		if !strings.Contains(html, s) {
+			t.Errorf("report does not contain %q", s)
+		}
+	}
+}
@@ -9,9 +9,13 @@ import (
 	"context"
 	"flag"
 	"os"
+	"time"
 
+	"github.com/grailbio/base/digest"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/internal/cachearchive"
+	"github.com/grailbio/reflow/taskdb"
 )
 
 func (c *Cmd) rmcache(ctx context.Context, args ...string) {
@@ -45,3 +49,140 @@ Items are digests read from the standard input.`
 	}
 	c.Log.Debugf("removed %d keys", n)
 }
+
+func (c *Cmd) cache(ctx context.Context, args ...string) {
+	var (
+		flags = flag.NewFlagSet("cache", flag.ExitOnError)
+		help  = `Cache manages the contents of the Reflow cache.
+
+The subcommands are:
+
+	export	write cache entries to an archive
+	import	install cache entries from an archive
+
+Run "reflow cache <subcommand> -help" for details.`
+	)
+	c.Parse(flags, args, help, "cache export|import [args]")
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	switch cmd, args := flags.Arg(0), flags.Args()[1:]; cmd {
+	case "export":
+		c.cacheExport(ctx, args...)
+	case "import":
+		c.cacheImport(ctx, args...)
+	default:
+		c.Errorf("unknown cache subcommand %s\n", cmd)
+		flags.Usage()
+	}
+}
+
+func (c *Cmd) cacheExport(ctx context.Context, args ...string) {
+	var (
+		flags = flag.NewFlagSet("cache export", flag.ExitOnError)
+		help  = `Cache export writes the cache entries named by the provided
+identifiers, together with the repository objects they reference, to
+a single archive which may be installed into another Reflow
+installation with "reflow cache import".
+
+Each identifier is either a run ID or a flow digest. Run IDs are
+resolved through the configured taskdb, and every task in the run
+contributes its flow digest. For each flow digest, all assoc mappings
+(Fileset, ExecInspect, Logs, and Bundle) are exported. Identifiers for
+which no mappings exist are reported and skipped.`
+	)
+	c.Parse(flags, args, help, "cache export archive ids...")
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil {
+		c.Log.Debug("taskdb: ", err)
+	}
+
+	exp := cachearchive.Exporter{Assoc: ass, Repository: repo, Log: c.Log}
+	for _, arg := range flags.Args()[1:] {
+		id, err := reflow.Digester.Parse(arg)
+		if err != nil {
+			c.Fatalf("parse %s: %v", arg, err)
+		}
+		keys := []digest.Digest{id}
+		if tdb != nil {
+			tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: taskdb.RunID(id)})
+			if err != nil {
+				c.Log.Debugf("taskdb tasks %s: %v", arg, err)
+			}
+			if len(tasks) > 0 {
+				keys = keys[:0]
+				for _, task := range tasks {
+					keys = append(keys, task.FlowID)
+				}
+				c.Log.Debugf("run %s: exporting %d flows", arg, len(keys))
+			}
+		}
+		var n int
+		for _, key := range keys {
+			m, err := exp.Add(ctx, key)
+			c.must(err)
+			n += m
+		}
+		if n == 0 {
+			c.Log.Errorf("%s: no cache entries found", arg)
+		}
+	}
+	mappings, objects := exp.Len()
+	if mappings == 0 {
+		c.Fatal("nothing to export")
+	}
+	f, err := os.Create(flags.Arg(0))
+	c.must(err)
+	w := bufio.NewWriter(f)
+	if err := exp.Write(ctx, w); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		c.Fatal(err)
+	}
+	c.must(w.Flush())
+	c.must(f.Close())
+	c.Log.Printf("exported %d mappings and %d objects to %s", mappings, objects, flags.Arg(0))
+}
+
+func (c *Cmd) cacheImport(ctx context.Context, args ...string) {
+	var (
+		flags         = flag.NewFlagSet("cache import", flag.ExitOnError)
+		dryRunFlag    = flags.Bool("dry-run", false, "validate the archive without modifying the cache")
+		overwriteFlag = flags.Bool("overwrite", false, "replace existing mappings that differ from the archive's")
+		help          = `Cache import installs the cache entries contained in an archive
+produced by "reflow cache export" into the configured repository and
+assoc.
+
+Every object is verified against its content digest before it is
+installed; objects and mappings that already exist are skipped. Assoc
+mappings are written only after all of the archive's objects have
+been installed.
+
+Existing mappings whose values differ from the archive's are left in
+place and reported as conflicting, unless flag -overwrite is given,
+in which case they are replaced.`
+	)
+	c.Parse(flags, args, help, "cache import [-dry-run] [-overwrite] archive")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+
+	f, err := os.Open(flags.Arg(0))
+	c.must(err)
+	defer f.Close()
+	im := cachearchive.Importer{Assoc: ass, Repository: repo, Log: c.Log, DryRun: *dryRunFlag, Overwrite: *overwriteFlag}
+	manifest, stats, err := im.Import(ctx, bufio.NewReader(f))
+	c.must(err)
+	c.Log.Printf("archive created %s: %s", manifest.Created.Local().Format(time.RFC3339), stats)
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/repository/blobrepo"
 	repositoryhttp "github.com/grailbio/reflow/repository/http"
+	"github.com/grailbio/reflow/rest/auth"
 	"github.com/grailbio/reflow/runner"
 	"golang.org/x/net/http2"
 )
@@ -46,6 +47,13 @@ func (c *Cmd) Cluster(status *status.Group) runner.Cluster {
 	if err := c.Config.Instance(&ec); err == nil {
 		ec.Status = status
 		ec.Configuration = c.Config
+		authn, err := authenticator(c.Config)
+		if err != nil {
+			c.Fatal(err)
+		}
+		if err := ec.ConfigureClient(authn); err != nil {
+			c.Fatal(err)
+		}
 	} else {
 		log.Printf("not a ec2cluster! : %v", err)
 	}
@@ -90,5 +98,13 @@ func (c *Cmd) httpClient() (*http.Client, error) {
 	if err := http2.ConfigureTransport(transport); err != nil {
 		c.Fatal(err)
 	}
-	return &http.Client{Transport: transport}, nil
+	authn, err := authenticator(c.Config)
+	if err != nil {
+		c.Fatal(err)
+	}
+	rt, err := auth.ClientTransport(authn, transport)
+	if err != nil {
+		c.Fatal(err)
+	}
+	return &http.Client{Transport: rt}, nil
 }
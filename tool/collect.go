@@ -20,6 +20,7 @@ import (
 	"github.com/grailbio/reflow/assoc"
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/liveset/bloomlive"
+	"github.com/grailbio/reflow/taskdb"
 	"github.com/willf/bloom"
 )
 
@@ -123,6 +124,7 @@ func (c *Cmd) collect(ctx context.Context, args ...string) {
 	rateFlag := flags.Int64("rate", 300, "maximum writes/sec to dynamodb")
 	keepFlag := flags.String("keep", "", "regexp to match against labels of cache entries to keep (don't collect)")
 	labelsFlag := flags.String("labels", "", "regexp to match against labels of cache entries to collect")
+	policyFlag := flags.String("policy", "", "retention policy file; when provided, -threshold, -keep, and -labels are ignored")
 	help := `Collect performs garbage collection of the reflow cache, removing
 entries where cache entry labels don't match the keep regexp clause;
 and (1) cache entry labels match the labels regexp; or (2) cache
@@ -133,9 +135,38 @@ Keep and label expressions as follows: <clause>[,<clause>,...][
 <clause>[,...]...] Space separated clauses are ORed and each OR
 clause is an AND of the comma separated sub clauses. A sub clause
 preceded by ! is negated.
+
+Alternatively, a retention policy may be provided with -policy. A
+policy is a YAML file that lists retention rules:
+
+	rules:
+	- name: prod
+	  labels: env=prod
+	  keep: 365d
+	- name: dev
+	  labels: env=dev
+	  keep: 14d
+	- name: default
+	  keep: 30d
+	recentruns: 3
+	runwindow: 90d
+
+Each cache entry is governed by the first rule whose labels
+expression (as above) matches the entry's labels; rules without an
+expression match every entry. An entry is collected if it has not
+been accessed within its rule's keep age (given in days, as a
+duration, or as "forever"). Entries that match no rule are kept.
+Regardless of rules, entries reachable from the last recentruns runs
+of each program (among the runs active within runwindow, default
+30d) are kept, as are entries leased by running evaluations: those
+reachable from their tasks, and those written or accessed since the
+oldest running evaluation started. (Cache hits refresh the access
+times of the entries they retrieve.) Policies require a task
+database. Collect reports the number of entries kept
+and collected, and the bytes reclaimed, under each rule.
 `
 
-	c.Parse(flags, args, help, "collect [-threshold date] [-keep regexp] [-labels labels]")
+	c.Parse(flags, args, help, "collect [-threshold date] [-keep regexp] [-labels labels] [-policy file]")
 
 	var (
 		keepFilter, labelsFilter *filter
@@ -149,8 +180,21 @@ preceded by ! is negated.
 		labelsFilter, err = parseFilter(*labelsFlag)
 		c.must(err)
 	}
-	var threshold time.Time
-	if strings.HasSuffix(*thresholdFlag, "d") {
+	var (
+		threshold time.Time
+		retain    *retention
+	)
+	if *policyFlag != "" {
+		policy, err := readRetentionPolicy(*policyFlag)
+		c.must(err)
+		var tdb taskdb.TaskDB
+		if err := c.Config.Instance(&tdb); err != nil {
+			c.Fatalf("retention policies require a taskdb: %v", err)
+		}
+		retain, err = newRetention(ctx, policy, tdb, time.Now())
+		c.must(err)
+		threshold = retain.Threshold()
+	} else if strings.HasSuffix(*thresholdFlag, "d") {
 		date := time.Now().Local()
 		days, err := strconv.Atoi(strings.TrimRight(*thresholdFlag, "d"))
 		if err != nil {
@@ -228,14 +272,31 @@ preceded by ! is negated.
 			return fs, nil
 		}
 
-		live := keepFilter.Match(labels)
+		var (
+			live, dead bool
+			rule       string
+		)
+		if retain != nil {
+			rule, live = retain.Retain(k, d, labels, lastAccessTime)
+			dead = !live
+		} else {
+			live = keepFilter.Match(labels)
+			dead = !live && labelsFilter.Match(labels)
+		}
 		var fs reflow.Fileset
-		if !live && labelsFilter.Match(labels) {
+		if dead {
 			fs, err = checkRepos()
 			if err != nil {
 				c.Log.Error(err)
 				return
 			}
+			if retain != nil {
+				objects := make(map[digest.Digest]int64)
+				for _, f := range fs.Files() {
+					objects[f.ID] = f.Size
+				}
+				retain.Collected(rule, objects)
+			}
 			resultsLock.Lock()
 			defer resultsLock.Unlock()
 			for _, f := range fs.Files() {
@@ -282,6 +343,9 @@ preceded by ! is negated.
 	c.Log.Debugf("Time to scan associations %s", time.Since(start))
 	c.Log.Printf("Scanned %d associations, found %d live associations, %d live objects, %d objects not in repository",
 		itemsScannedCount, liveItemCount, liveObjectsInFilesets, liveObjectsNotInRepository)
+	if retain != nil {
+		retain.Report(c.Stdout, func(id digest.Digest) bool { return valueFilter.Test(id.Bytes()) })
+	}
 
 	// Garbage collect the repository using the values liveset
 	c.must(repo.CollectWithThreshold(ctx, bloomlive.New(valueFilter), deadValueFilter, threshold, *dryRunFlag))
@@ -0,0 +1,462 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/ec2cluster/instances"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+// costTask is a task together with the resources it reserved and
+// the interval during which it ran.
+type costTask struct {
+	taskdb.Task
+	Resources  reflow.Resources
+	Begin, End time.Time
+}
+
+// Hours returns the number of hours for which the task ran.
+func (t costTask) Hours() float64 {
+	return t.End.Sub(t.Begin).Hours()
+}
+
+// costGroup is the cost attributed to a group of tasks.
+type costGroup struct {
+	Key   string  `json:"key"`
+	Tasks int     `json:"tasks"`
+	Hours float64 `json:"hours"`
+	Cost  float64 `json:"cost"`
+}
+
+// allocCost is the cost of an alloc: the instance-hours spanned by
+// its tasks. Idle is the part of the cost that is not attributed to
+// any task.
+type allocCost struct {
+	ID       string    `json:"id"`
+	Instance string    `json:"instance"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Tasks    int       `json:"tasks"`
+	Hours    float64   `json:"hours"`
+	Cost     float64   `json:"cost"`
+	Idle     float64   `json:"idle"`
+}
+
+// IdleFraction returns the fraction of the alloc's cost that is idle.
+func (a allocCost) IdleFraction() float64 {
+	if a.Cost == 0 {
+		return 0
+	}
+	return a.Idle / a.Cost
+}
+
+// costReport is the result of a cost computation.
+type costReport struct {
+	By     string      `json:"by"`
+	Groups []costGroup `json:"groups"`
+	Allocs []allocCost `json:"allocs"`
+	Total  float64     `json:"total"`
+	Idle   float64     `json:"idle"`
+}
+
+// costKey returns a function that computes the group key of a task
+// for the dimension by: run, user, ident, task, or label:KEY.
+func costKey(by string, runs map[taskdb.RunID]taskdb.Run) (func(costTask) string, error) {
+	const none = "(none)"
+	orNone := func(s string) string {
+		if s == "" {
+			return none
+		}
+		return s
+	}
+	switch {
+	case by == "run":
+		return func(t costTask) string { return t.RunID.ID() }, nil
+	case by == "user":
+		return func(t costTask) string { return orNone(runs[t.RunID].User) }, nil
+	case by == "ident":
+		return func(t costTask) string { return orNone(t.Ident) }, nil
+	case by == "task":
+		return func(t costTask) string { return t.ID.ID() }, nil
+	case strings.HasPrefix(by, "label:") && len(by) > len("label:"):
+		key := strings.TrimPrefix(by, "label:")
+		return func(t costTask) string { return orNone(runs[t.RunID].Labels[key]) }, nil
+	default:
+		return nil, fmt.Errorf("invalid grouping %q: must be run, user, ident, task, or label:KEY", by)
+	}
+}
+
+// inspectConcurrency is the number of exec inspects that are
+// retrieved concurrently.
+const inspectConcurrency = 64
+
+// instanceFor returns the name and hourly price of the cheapest
+// current-generation instance type in the provided region that can
+// accommodate the resources res. If no such type exists, the largest
+// is returned.
+func instanceFor(region string, res reflow.Resources) (name string, price float64, vcpu, mem float64) {
+	var (
+		best               = math.Inf(1)
+		largest            string
+		largestPrice       float64
+		largestCPU, maxMem float64
+	)
+	for _, typ := range instances.Types {
+		p, ok := typ.Price[region]
+		if !ok || typ.Generation != "current" {
+			continue
+		}
+		cpu, m := float64(typ.VCPU), typ.Memory*(1<<30)
+		if m > maxMem || (m == maxMem && cpu > largestCPU) {
+			largest, largestPrice, largestCPU, maxMem = typ.Name, p, cpu, m
+		}
+		if res["cpu"] > cpu || res["mem"] > m {
+			continue
+		}
+		if p < best {
+			name, price, vcpu, mem, best = typ.Name, p, cpu, m, p
+		}
+	}
+	if name == "" {
+		return largest, largestPrice, largestCPU, maxMem
+	}
+	return
+}
+
+// hourlyCost returns a function that computes the hourly cost of a
+// set of resources in the provided region: the resources' share of
+// the instance type chosen for them by instanceFor, apportioned by
+// their largest share of the instance's CPU and memory. Resources
+// that no instance type can accommodate have no cost.
+func hourlyCost(region string) func(reflow.Resources) float64 {
+	return func(res reflow.Resources) float64 {
+		_, price, vcpu, mem := instanceFor(region, res)
+		if vcpu == 0 || mem == 0 || res["cpu"] > vcpu || res["mem"] > mem {
+			return 0
+		}
+		return price * math.Max(res["cpu"]/vcpu, res["mem"]/mem)
+	}
+}
+
+// peakResources returns the peak total resources reserved by
+// concurrently running tasks. Each resource's peak is computed
+// independently.
+func peakResources(tasks []costTask) reflow.Resources {
+	type event struct {
+		t   time.Time
+		res reflow.Resources
+		add bool
+	}
+	var events []event
+	for _, t := range tasks {
+		events = append(events, event{t.Begin, t.Resources, true}, event{t.End, t.Resources, false})
+	}
+	// Process releases before reservations at the same instant.
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].t.Equal(events[j].t) {
+			return events[i].t.Before(events[j].t)
+		}
+		return !events[i].add && events[j].add
+	})
+	var cur, peak reflow.Resources
+	for _, e := range events {
+		if e.add {
+			cur.Add(cur, e.res)
+		} else {
+			cur.Sub(cur, e.res)
+		}
+		peak.Max(peak, cur)
+	}
+	return peak
+}
+
+// computeCosts apportions the cost of allocs to the tasks that ran
+// on them, and aggregates task costs by the provided key. Tasks are
+// assigned to allocs by their URIs. Each alloc is priced as the
+// cheapest instance type in region that accommodates the peak
+// resources reserved by its concurrent tasks, over the interval
+// spanned by its tasks. Each task is charged the instance's hourly
+// price times the larger of its CPU and memory shares of the
+// instance, for the duration of the task; the remainder of the
+// alloc's cost is idle.
+func computeCosts(tasks []costTask, key func(costTask) string, region string) costReport {
+	var (
+		allocTasks = make(map[string][]costTask)
+		groups     = make(map[string]*costGroup)
+		report     costReport
+	)
+	for _, t := range tasks {
+		id := path.Dir(t.URI)
+		allocTasks[id] = append(allocTasks[id], t)
+	}
+	for id, tasks := range allocTasks {
+		alloc := allocCost{ID: id, Tasks: len(tasks), Start: tasks[0].Begin, End: tasks[0].End}
+		for _, t := range tasks[1:] {
+			if t.Begin.Before(alloc.Start) {
+				alloc.Start = t.Begin
+			}
+			if t.End.After(alloc.End) {
+				alloc.End = t.End
+			}
+		}
+		var (
+			price, vcpu, mem float64
+			used             float64
+		)
+		alloc.Instance, price, vcpu, mem = instanceFor(region, peakResources(tasks))
+		alloc.Hours = alloc.End.Sub(alloc.Start).Hours()
+		alloc.Cost = price * alloc.Hours
+		for _, t := range tasks {
+			var share float64
+			if vcpu > 0 && mem > 0 {
+				share = math.Min(1, math.Max(t.Resources["cpu"]/vcpu, t.Resources["mem"]/mem))
+			}
+			cost := price * share * t.Hours()
+			used += cost
+			k := key(t)
+			g := groups[k]
+			if g == nil {
+				g = &costGroup{Key: k}
+				groups[k] = g
+			}
+			g.Tasks++
+			g.Hours += t.Hours()
+			g.Cost += cost
+		}
+		alloc.Idle = math.Max(0, alloc.Cost-used)
+		report.Allocs = append(report.Allocs, alloc)
+		report.Total += alloc.Cost
+		report.Idle += alloc.Idle
+	}
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Cost != report.Groups[j].Cost {
+			return report.Groups[i].Cost > report.Groups[j].Cost
+		}
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+	sort.Slice(report.Allocs, func(i, j int) bool {
+		if report.Allocs[i].Idle != report.Allocs[j].Idle {
+			return report.Allocs[i].Idle > report.Allocs[j].Idle
+		}
+		return report.Allocs[i].ID < report.Allocs[j].ID
+	})
+	return report
+}
+
+func (c *Cmd) cost(ctx context.Context, args ...string) {
+	const dateLayout = "2006-01-02"
+	var (
+		flags      = flag.NewFlagSet("cost", flag.ExitOnError)
+		fromFlag   = flags.String("from", "", "start date (YYYY-MM-DD) of the period to report; defaults to 7 days ago")
+		toFlag     = flags.String("to", "", "end date (YYYY-MM-DD, exclusive) of the period to report; defaults to now")
+		byFlag     = flags.String("by", "run", "dimension by which costs are grouped: run, user, ident, task, or label:KEY")
+		regionFlag = flags.String("region", "us-west-2", "region in which to price instances")
+		formatFlag = flags.String("format", "text", "output format: text, json, or csv")
+		idleFlag   = flags.Float64("idle", 0.25, "fraction of an alloc's cost above which its idle cost is flagged")
+		help       = `Cost reports the cost of reflow runs, as recorded in the task
+database.
+
+Cost considers the tasks that started in the period given by -from
+and -to; if run IDs are given as arguments, only the tasks of those
+runs are considered. Tasks are assigned to the allocs on which they
+ran. Each alloc is priced as the cheapest EC2 instance type, in the
+region given by -region, that accommodates the peak resources
+reserved by its concurrent tasks, for the interval spanned by its
+tasks. The alloc's instance-hours are apportioned to its tasks by
+resource share: each task is charged for the larger of its CPU and
+memory shares of the instance, for the duration of the task. The
+remainder of the alloc's cost is idle.
+
+Task costs are aggregated by the dimension given by -by:
+
+	run        the task's run
+	user       the user who started the task's run
+	ident      the ident of the task's exec
+	task       the task itself
+	label:KEY  the value of the run's label KEY
+
+Cost also reports the allocs whose idle cost exceeds the fraction
+-idle of their total cost. Costs are estimates: allocs are assumed
+to live only while they run the considered tasks, and instance
+types are inferred, not recorded.
+
+With -format json, the full report is written as JSON; with -format
+csv, groups and allocs are written as rows of the form
+
+	kind,key,instance,tasks,hours,cost,idle
+
+where kind is "group" or "alloc".`
+	)
+	c.Parse(flags, args, help, "cost [-from date] [-to date] [-by dimension] [-format text|json|csv] [runids...]")
+
+	var (
+		to   = time.Now()
+		from = to.Add(-7 * 24 * time.Hour)
+		err  error
+	)
+	if *fromFlag != "" {
+		from, err = time.ParseInLocation(dateLayout, *fromFlag, time.Local)
+		if err != nil {
+			c.Fatalf("invalid -from date %s: %v", *fromFlag, err)
+		}
+	}
+	if *toFlag != "" {
+		to, err = time.ParseInLocation(dateLayout, *toFlag, time.Local)
+		if err != nil {
+			c.Fatalf("invalid -to date %s: %v", *toFlag, err)
+		}
+	}
+	if !from.Before(to) {
+		c.Fatalf("empty period: %s to %s", from.Format(dateLayout), to.Format(dateLayout))
+	}
+	switch *formatFlag {
+	case "text", "json", "csv":
+	default:
+		c.Fatalf("invalid format %s", *formatFlag)
+	}
+
+	var tdb taskdb.TaskDB
+	c.must(c.Config.Instance(&tdb))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+
+	var tasks []taskdb.Task
+	if flags.NArg() == 0 {
+		tasks, err = tdb.Tasks(ctx, taskdb.TaskQuery{Since: from})
+		c.must(err)
+	} else {
+		for _, arg := range flags.Args() {
+			id, err := reflow.Digester.Parse(arg)
+			if err != nil {
+				c.Fatalf("invalid run ID %s: %v", arg, err)
+			}
+			t, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: taskdb.RunID(id)})
+			c.must(err)
+			tasks = append(tasks, t...)
+		}
+	}
+	var (
+		runs    = make(map[taskdb.RunID]taskdb.Run)
+		inRange []taskdb.Task
+	)
+	for _, task := range tasks {
+		if task.Start.Before(from) || !task.Start.Before(to) || task.Inspect.IsZero() {
+			continue
+		}
+		inRange = append(inRange, task)
+		runs[task.RunID] = taskdb.Run{}
+	}
+	if len(inRange) == 0 {
+		c.Fatalf("no tasks found from %s to %s", from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	costTasks := c.costTasks(ctx, repo, inRange)
+	if *byFlag == "user" || strings.HasPrefix(*byFlag, "label:") {
+		for id := range runs {
+			r, err := tdb.Runs(ctx, taskdb.RunQuery{ID: id})
+			if err != nil || len(r) == 0 {
+				c.Log.Debugf("run %s: %v", id.IDShort(), err)
+				continue
+			}
+			runs[id] = r[0]
+		}
+	}
+	key, err := costKey(*byFlag, runs)
+	if err != nil {
+		c.Fatal(err)
+	}
+	report := computeCosts(costTasks, key, *regionFlag)
+	report.By = *byFlag
+
+	switch *formatFlag {
+	case "json":
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		c.must(enc.Encode(report))
+	case "csv":
+		w := csv.NewWriter(c.Stdout)
+		c.must(w.Write([]string{"kind", "key", "instance", "tasks", "hours", "cost", "idle"}))
+		for _, g := range report.Groups {
+			c.must(w.Write([]string{"group", g.Key, "", fmt.Sprint(g.Tasks), fmt.Sprintf("%.4f", g.Hours), fmt.Sprintf("%.4f", g.Cost), ""}))
+		}
+		for _, a := range report.Allocs {
+			c.must(w.Write([]string{"alloc", a.ID, a.Instance, fmt.Sprint(a.Tasks), fmt.Sprintf("%.4f", a.Hours), fmt.Sprintf("%.4f", a.Cost), fmt.Sprintf("%.4f", a.Idle)}))
+		}
+		w.Flush()
+		c.must(w.Error())
+	default:
+		c.printCostReport(report, *idleFlag)
+	}
+}
+
+// costTasks retrieves the inspects of the provided tasks from repo
+// to determine their resources and runtimes. Tasks whose inspects
+// cannot be retrieved are assumed to have run until their last
+// keepalive, without reserving resources.
+func (c *Cmd) costTasks(ctx context.Context, repo reflow.Repository, tasks []taskdb.Task) []costTask {
+	costTasks := make([]costTask, len(tasks))
+	_ = traverse.Limit(inspectConcurrency).Each(len(tasks), func(i int) error {
+		task := tasks[i]
+		var inspect reflow.ExecInspect
+		if err := repository.Unmarshal(ctx, repo, task.Inspect, &inspect); err != nil {
+			c.Log.Debugf("task %s (%s): inspect %s: %v", task.ID.IDShort(), task.Ident, task.Inspect.Short(), err)
+		}
+		dur := inspect.Runtime()
+		if dur == 0 {
+			dur = task.Keepalive.Sub(task.Start)
+		}
+		costTasks[i] = costTask{Task: task, Resources: inspect.Config.Resources, Begin: task.Start, End: task.Start.Add(dur)}
+		return nil
+	})
+	return costTasks
+}
+
+func (c *Cmd) printCostReport(report costReport, idle float64) {
+	var tw tabwriter.Writer
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintf(&tw, "%s\ttasks\thours\tcost\n", report.By)
+	for _, g := range report.Groups {
+		fmt.Fprintf(&tw, "%s\t%d\t%.2f\t$%.2f\n", g.Key, g.Tasks, g.Hours, g.Cost)
+	}
+	fmt.Fprintf(&tw, "idle\t\t\t$%.2f\n", report.Idle)
+	fmt.Fprintf(&tw, "total\t\t\t$%.2f\n", report.Total)
+	tw.Flush()
+
+	var flagged []allocCost
+	for _, a := range report.Allocs {
+		if a.IdleFraction() > idle {
+			flagged = append(flagged, a)
+		}
+	}
+	if len(flagged) == 0 {
+		return
+	}
+	fmt.Fprintf(c.Stdout, "\nallocs with more than %.0f%% idle cost:\n", idle*100)
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprint(&tw, "alloc\tinstance\ttasks\thours\tcost\tidle\n")
+	for _, a := range flagged {
+		fmt.Fprintf(&tw, "%s\t%s\t%d\t%.2f\t$%.2f\t$%.2f (%.0f%%)\n",
+			a.ID, a.Instance, a.Tasks, a.Hours, a.Cost, a.Idle, a.IdleFraction()*100)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func TestComputeCosts(t *testing.T) {
+	const GiB = 1 << 30
+	var (
+		start = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+		run1  = taskdb.NewRunID()
+		run2  = taskdb.NewRunID()
+		small = reflow.Resources{"cpu": 1, "mem": GiB}
+		task  = func(run taskdb.RunID, alloc, ident string, res reflow.Resources, begin, hours float64) costTask {
+			b := start.Add(time.Duration(begin * float64(time.Hour)))
+			return costTask{
+				Task:      taskdb.Task{ID: taskdb.NewTaskID(), RunID: run, Ident: ident, URI: alloc + "/" + ident},
+				Resources: res,
+				Begin:     b,
+				End:       b.Add(time.Duration(hours * float64(time.Hour))),
+			}
+		}
+	)
+	tasks := []costTask{
+		// Two concurrent tasks on alloc a, which lives for 2 hours.
+		task(run1, "a", "align", small, 0, 2),
+		task(run2, "a", "sort", small, 0, 1),
+		// Alloc b is idle for an hour between its two tasks.
+		task(run1, "b", "index", small, 0, 1),
+		task(run1, "b", "merge", small, 2, 1),
+	}
+	runs := map[taskdb.RunID]taskdb.Run{
+		run1: {ID: run1, User: "alice", Labels: map[string]string{"project": "x"}},
+		run2: {ID: run2, User: "bob"},
+	}
+	key, err := costKey("user", runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := computeCosts(tasks, key, "us-west-2")
+
+	nameA, priceA, cpuA, memA := instanceFor("us-west-2", reflow.Resources{"cpu": 2, "mem": 2 * GiB})
+	nameB, priceB, cpuB, memB := instanceFor("us-west-2", small)
+	if priceA <= 0 || priceB <= 0 {
+		t.Fatalf("no instance prices: %v, %v", priceA, priceB)
+	}
+	var (
+		shareA = math.Max(1/cpuA, GiB/memA)
+		shareB = math.Max(1/cpuB, GiB/memB)
+		want   = costReport{
+			Groups: []costGroup{
+				{Key: "alice", Tasks: 3, Hours: 4, Cost: 2*priceA*shareA + 2*priceB*shareB},
+				{Key: "bob", Tasks: 1, Hours: 1, Cost: priceA * shareA},
+			},
+			Allocs: []allocCost{
+				{ID: "b", Instance: nameB, Start: start, End: start.Add(3 * time.Hour), Tasks: 2, Hours: 3,
+					Cost: 3 * priceB, Idle: 3*priceB - 2*priceB*shareB},
+				{ID: "a", Instance: nameA, Start: start, End: start.Add(2 * time.Hour), Tasks: 2, Hours: 2,
+					Cost: 2 * priceA, Idle: 2*priceA - 3*priceA*shareA},
+			},
+			Total: 2*priceA + 3*priceB,
+			Idle:  2*priceA - 3*priceA*shareA + 3*priceB - 2*priceB*shareB,
+		}
+	)
+	// The allocs are ordered by idle cost; if the instance types make
+	// alloc a more idle than b, swap the expected order.
+	if want.Allocs[1].Idle > want.Allocs[0].Idle {
+		want.Allocs[0], want.Allocs[1] = want.Allocs[1], want.Allocs[0]
+	}
+	if want.Groups[1].Cost > want.Groups[0].Cost {
+		want.Groups[0], want.Groups[1] = want.Groups[1], want.Groups[0]
+	}
+	if !costsEqual(report, want) {
+		t.Errorf("got %+v, want %+v", report, want)
+	}
+
+	if _, err := costKey("label:", runs); err == nil {
+		t.Error("expected error for empty label key")
+	}
+	key, err = costKey("label:project", runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = computeCosts(tasks, key, "us-west-2")
+	var keys []string
+	for _, g := range report.Groups {
+		keys = append(keys, g.Key)
+	}
+	if got, want := keys, []string{"x", "(none)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPeakResources(t *testing.T) {
+	start := time.Now()
+	at := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	tasks := []costTask{
+		{Resources: reflow.Resources{"cpu": 4, "mem": 1}, Begin: at(0), End: at(2)},
+		{Resources: reflow.Resources{"cpu": 2, "mem": 8}, Begin: at(1), End: at(3)},
+		// Starts as the first task ends.
+		{Resources: reflow.Resources{"cpu": 4, "mem": 1}, Begin: at(2), End: at(4)},
+	}
+	if got, want := peakResources(tasks), (reflow.Resources{"cpu": 6, "mem": 9}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func costsEqual(r, s costReport) bool {
+	eq := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	if len(r.Groups) != len(s.Groups) || len(r.Allocs) != len(s.Allocs) || !eq(r.Total, s.Total) || !eq(r.Idle, s.Idle) {
+		return false
+	}
+	for i := range r.Groups {
+		g, h := r.Groups[i], s.Groups[i]
+		if g.Key != h.Key || g.Tasks != h.Tasks || !eq(g.Hours, h.Hours) || !eq(g.Cost, h.Cost) {
+			return false
+		}
+	}
+	for i := range r.Allocs {
+		a, b := r.Allocs[i], s.Allocs[i]
+		if a.ID != b.ID || a.Instance != b.Instance || !a.Start.Equal(b.Start) || !a.End.Equal(b.End) ||
+			a.Tasks != b.Tasks || !eq(a.Hours, b.Hours) || !eq(a.Cost, b.Cost) || !eq(a.Idle, b.Idle) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/internal/usage"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func (c *Cmd) du(ctx context.Context, args ...string) {
+	var (
+		flags     = flag.NewFlagSet("du", flag.ExitOnError)
+		byFlag    = flags.String("by", "label,program,ident,image", "comma-separated list of dimensions by which usage is grouped")
+		growFlag  = flags.String("growth", "month", "width of growth buckets: day, week, or month")
+		nFlag     = flags.Int("n", 20, "number of groups to show for each dimension (0 for all)")
+		jsonFlag  = flags.Bool("json", false, "output the full report as JSON")
+		sinceFlag = flags.Duration("since", 30*24*time.Hour, "with -by ident or image, attribute mappings to the tasks that were active within this duration")
+		help      = `Du reports the storage used by the cache.
+
+Du scans the assoc's fileset mappings, attributing the sizes of the
+repository objects referenced by each mapping to groups along each
+of the dimensions given by -by:
+
+	label    each of the mapping's labels
+	program  the mapping's "program" label
+	ident    the ident of the exec that computed the mapping
+	image    the image of the exec that computed the mapping
+
+Mappings are grouped by ident and image according to the tasks that
+computed them, as recorded in the task database; only tasks that were
+active within the duration given by -since are considered, and the
+mappings computed by other tasks are grouped as (unknown). Grouping
+by image also requires reading each task's inspect from the
+repository, and may be omitted for faster accounting.
+
+For each group, du reports the number of mappings, the total size of
+the objects they refer to, counting each object once per mapping,
+and their deduplicated size, counting each object once. The
+deduplicated size is estimated, and may be slightly undercounted. Du
+also reports the growth of the cache: the deduplicated sizes
+attributed to the mappings created in each time bucket.
+
+With -json, the full report, including the growth of every group, is
+written as JSON.`
+	)
+	c.Parse(flags, args, help, "du [-by dimensions] [-growth day|week|month] [-since duration] [-n groups] [-json]")
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	dims, err := usage.ParseDimensions(*byFlag)
+	if err != nil {
+		c.Fatal(err)
+	}
+	g := usage.Granularity(*growFlag)
+	switch g {
+	case usage.Day, usage.Week, usage.Month:
+	default:
+		c.Fatalf("invalid growth bucket %s", *growFlag)
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+
+	a := usage.Accountant{
+		Assoc:       ass,
+		Repository:  repo,
+		Dimensions:  dims,
+		Granularity: g,
+		Log:         c.Log,
+	}
+	for _, d := range dims {
+		if d == usage.Ident || d == usage.Image {
+			var tdb taskdb.TaskDB
+			c.must(c.Config.Instance(&tdb))
+			a.TaskDB, a.Since = tdb, time.Now().Add(-*sinceFlag)
+			break
+		}
+	}
+	r, err := a.Account(ctx)
+	c.must(err)
+	if r.Errors > 0 {
+		c.Log.Errorf("%d mappings could not be accounted", r.Errors)
+	}
+	if *jsonFlag {
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		c.must(enc.Encode(r))
+		return
+	}
+
+	var tw tabwriter.Writer
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintln(&tw, "dimension\tgroup\tentries\tsize\tdeduplicated")
+	fmt.Fprintf(&tw, "total\t\t%d\t%s\t%s\n", r.Total.Entries, data.Size(r.Total.Bytes), data.Size(r.Total.UniqueBytes))
+	for _, d := range dims {
+		for _, u := range r.Top(d, *nFlag) {
+			fmt.Fprintf(&tw, "%s\t%s\t%d\t%s\t%s\n", d, u.Group, u.Entries, data.Size(u.Bytes), data.Size(u.UniqueBytes))
+		}
+	}
+	tw.Flush()
+
+	buckets := make([]string, 0, len(r.Total.Growth))
+	for bucket := range r.Total.Growth {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	fmt.Fprintln(c.Stdout)
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintf(&tw, "%s\tgrowth\n", g)
+	for _, bucket := range buckets {
+		fmt.Fprintf(&tw, "%s\t%s\n", bucket, data.Size(r.Total.Growth[bucket]))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func (c *Cmd) explain(ctx context.Context, args ...string) {
+	var (
+		flags         = flag.NewFlagSet("explain", flag.ExitOnError)
+		identFlag     = flags.String("ident", "", "only explain execs whose identifiers match this regular expression")
+		resourcesFlag = flags.Bool("resources", false, "also report differences in resource requirements")
+		help          = `Explain reports why execs were (or would be) cache misses.
+
+The identifier may name a run, a task, or a flow. For each exec task
+in a run (or the single task named), explain reconstructs the cache
+keys the evaluator looks up (the physical keys computed from the
+exec's image, command, and arguments, followed by the flow's logical
+key) and reports the outcome of looking up each key: whether an
+assoc entry exists, and if so whether all of the files it refers to
+are present in the repository.
+
+Explain then locates the most recent prior task with the same
+identifier from a different run and reports the inputs that differ
+between the two: the image, the command template, the argument
+files, and assertion mismatches. Differences in resource
+requirements, which do not contribute to cache keys, are reported
+when -resources is given.
+
+Physical keys are reconstructed from the exec's recorded
+configuration. Keys of execs whose images carry qualifiers (e.g.,
+"$aws") may therefore differ from those used by the evaluator.
+
+If the identifier does not name a run or task, it is treated as a
+flow digest and only its assoc lookup is reported.`
+	)
+	c.Parse(flags, args, help, "explain [-ident regexp] [-resources] id")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	arg := flags.Arg(0)
+	id, err := reflow.Digester.Parse(arg)
+	if err != nil {
+		c.Fatalf("parse %s: %v", arg, err)
+	}
+	var identRE *regexp.Regexp
+	if *identFlag != "" {
+		identRE, err = regexp.Compile(*identFlag)
+		if err != nil {
+			c.Fatalf("ident %s: %v", *identFlag, err)
+		}
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil {
+		c.Log.Debug("taskdb: ", err)
+	}
+
+	var tasks []taskdb.Task
+	if tdb != nil {
+		tasks, err = tdb.Tasks(ctx, taskdb.TaskQuery{RunID: taskdb.RunID(id)})
+		c.must(err)
+		if len(tasks) == 0 {
+			tasks, err = tdb.Tasks(ctx, taskdb.TaskQuery{ID: taskdb.TaskID(id)})
+			c.must(err)
+		}
+	}
+	if len(tasks) == 0 {
+		c.Log.Printf("%s: no run or task found; treating it as a flow digest", arg)
+		c.printCacheLookups(ctx, c.Stdout, ass, repo, nil, id)
+		return
+	}
+
+	var n int
+	for _, task := range tasks {
+		if identRE != nil && !identRE.MatchString(task.Ident) {
+			continue
+		}
+		if task.Inspect.IsZero() {
+			c.Log.Debugf("task %s (%s): no exec inspect recorded", task.ID.IDShort(), task.Ident)
+			continue
+		}
+		var inspect reflow.ExecInspect
+		if err := repository.Unmarshal(ctx, repo, task.Inspect, &inspect); err != nil {
+			c.Log.Errorf("task %s (%s): inspect %s: %v", task.ID.IDShort(), task.Ident, task.Inspect.Short(), err)
+			continue
+		}
+		if inspect.Config.Type != "exec" && inspect.Config.Type != "extern" {
+			continue
+		}
+		n++
+		fmt.Fprintf(c.Stdout, "%s (task %s, run %s)\n", task.Ident, task.ID.IDShort(), task.RunID.IDShort())
+		keys := flow.ExecCacheKeys(inspect.Config)
+		c.printCacheLookups(ctx, c.Stdout, ass, repo, keys, task.FlowID)
+		if tdb == nil {
+			continue
+		}
+		prev, prevInspect, err := c.priorTask(ctx, tdb, repo, task)
+		if err != nil {
+			c.Log.Errorf("task %s (%s): find prior task: %v", task.ID.IDShort(), task.Ident, err)
+			continue
+		}
+		if prev == nil {
+			fmt.Fprintf(c.Stdout, "\tno prior task with identifier %s\n\n", task.Ident)
+			continue
+		}
+		fmt.Fprintf(c.Stdout, "\tcompared to task %s (run %s, started %s):\n",
+			prev.ID.IDShort(), prev.RunID.IDShort(), prev.Start.Local().Format(time.RFC3339))
+		diffs := flow.DiffExecConfig(prevInspect.Config, inspect.Config, *resourcesFlag)
+		if len(diffs) == 0 {
+			fmt.Fprintln(c.Stdout, "\t\tno differences in inputs")
+		}
+		for _, d := range diffs {
+			fmt.Fprintln(c.Stdout, "\t\t"+strings.Replace(d, "\n", "\n\t\t", -1))
+		}
+		fmt.Fprintln(c.Stdout)
+	}
+	if n == 0 {
+		c.Fatalf("%s: no exec tasks to explain", arg)
+	}
+}
+
+// printCacheLookups prints the outcome of looking up the provided
+// physical keys and logical key.
+func (c *Cmd) printCacheLookups(ctx context.Context, w io.Writer, ass assoc.Assoc, repo reflow.Repository, physical []digest.Digest, logical digest.Digest) {
+	var tw tabwriter.Writer
+	tw.Init(w, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(&tw, "\tkey\ttype\tresult")
+	for i, l := range flow.LookupCacheKeys(ctx, ass, repo, append(physical, logical)...) {
+		typ := "logical"
+		if i < len(physical) {
+			typ = "physical"
+		}
+		fmt.Fprintf(&tw, "\t%s\t%s\t%s\n", l.Key.Short(), typ, l)
+		for _, file := range l.Missing {
+			fmt.Fprintf(&tw, "\t\t\tmissing %s\n", file.Short())
+		}
+	}
+}
+
+// priorTask returns the most recently started task, from a run other
+// than task's, that has the same identifier as task and for which an
+// exec inspect was recorded. It also returns the task's inspect.
+// priorTask returns a nil task if no such task exists.
+func (c *Cmd) priorTask(ctx context.Context, tdb taskdb.TaskDB, repo reflow.Repository, task taskdb.Task) (*taskdb.Task, reflow.ExecInspect, error) {
+	var inspect reflow.ExecInspect
+	tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{Ident: task.Ident})
+	if err != nil {
+		return nil, inspect, err
+	}
+	var prior *taskdb.Task
+	for i := range tasks {
+		t := &tasks[i]
+		if t.RunID == task.RunID || t.Inspect.IsZero() || !t.Start.Before(task.Start) {
+			continue
+		}
+		if prior == nil || t.Start.After(prior.Start) {
+			prior = t
+		}
+	}
+	if prior == nil {
+		return nil, inspect, nil
+	}
+	if err := repository.Unmarshal(ctx, repo, prior.Inspect, &inspect); err != nil {
+		return nil, inspect, errors.E("unmarshal", prior.Inspect, err)
+	}
+	return prior, inspect, nil
+}
@@ -5,13 +5,21 @@
 package tool
 
 import (
+	"bufio"
 	"context"
 	"flag"
+	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/taskdb"
+	"golang.org/x/sync/errgroup"
 )
 
 func (c *Cmd) logs(ctx context.Context, args ...string) {
@@ -19,9 +27,25 @@ func (c *Cmd) logs(ctx context.Context, args ...string) {
 		flags      = flag.NewFlagSet("logs", flag.ExitOnError)
 		stdoutFlag = flags.Bool("stdout", false, "display stdout instead of stderr")
 		followFlag = flags.Bool("f", false, "follow the logs")
-		help       = "Logs displays logs from execs."
+		grepFlag   = flags.String("grep", "", "search the stored logs of runs for lines matching this regular expression")
+		labelsFlag = flags.String("labels", "", "with -grep, search the runs with these comma-separated labels (k=v)")
+		sinceFlag  = flags.Duration("since", 7*24*time.Hour, "with -grep and -labels, search runs that were active within this duration")
+		listFlag   = flags.Bool("l", false, "with -grep, list only the execs whose logs match")
+		help       = `Logs displays logs from execs.
+
+With -grep, logs instead searches the logs of the execs of the
+provided runs, as stored in the repository, for lines matching the
+given regular expression. Runs may be selected by ID, or by label
+(-labels) from among the runs that were active within the duration
+given by -since. Each matching line is printed, prefixed by the run,
+ident, and task ID of its exec, and its line number. Searching logs
+requires a task database.`
 	)
-	c.Parse(flags, args, help, "logs [-f] [-stdout] exec")
+	c.Parse(flags, args, help, "logs [-f] [-stdout] exec | logs -grep regexp [-stdout] [-l] [-labels k=v,...] [-since duration] [runid...]")
+	if *grepFlag != "" {
+		c.grepLogs(ctx, *grepFlag, *labelsFlag, *sinceFlag, *stdoutFlag, *listFlag, flags.Args())
+		return
+	}
 	if flags.NArg() != 1 {
 		flags.Usage()
 	}
@@ -101,3 +125,150 @@ func (c *Cmd) logs(ctx context.Context, args ...string) {
 	rc.Close()
 	c.must(err)
 }
+
+// grepLogs searches the stored logs of the runs with the provided IDs,
+// or with the provided labels, for lines matching the regular
+// expression pattern.
+func (c *Cmd) grepLogs(ctx context.Context, pattern, labels string, since time.Duration, stdout, list bool, ids []string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.Fatalf("-grep: %v", err)
+	}
+	if (len(ids) == 0) == (labels == "") {
+		c.Fatalf("-grep requires either run IDs or -labels")
+	}
+	var tdb taskdb.TaskDB
+	c.must(c.Config.Instance(&tdb))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+
+	var runs []taskdb.Run
+	if len(ids) > 0 {
+		for _, arg := range ids {
+			id, err := reflow.Digester.Parse(arg)
+			if err != nil {
+				c.Fatalf("parse %s: %v", arg, err)
+			}
+			r, err := tdb.Runs(ctx, taskdb.RunQuery{ID: taskdb.RunID(id)})
+			c.must(err)
+			if len(r) == 0 {
+				c.Fatalf("run %s not found", arg)
+			}
+			runs = append(runs, r...)
+		}
+	} else {
+		want := make(map[string]string)
+		for _, kv := range strings.Split(labels, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				c.Fatalf("-labels: invalid label %q", kv)
+			}
+			want[parts[0]] = parts[1]
+		}
+		all, err := tdb.Runs(ctx, taskdb.RunQuery{Since: time.Now().Add(-since)})
+		c.must(err)
+		for _, r := range all {
+			if matchLabels(r.Labels, want) {
+				runs = append(runs, r)
+			}
+		}
+		if len(runs) == 0 {
+			c.Fatalf("no runs with labels %s found", labels)
+		}
+	}
+	var tasks []taskdb.Task
+	for _, r := range runs {
+		t, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: r.ID})
+		c.must(err)
+		tasks = append(tasks, t...)
+	}
+	n, missing, err := grepTaskLogs(ctx, repo, tasks, re, stdout, list, c.Stdout)
+	c.must(err)
+	if missing > 0 {
+		c.Log.Printf("skipped %d tasks whose logs are no longer in the repository", missing)
+	}
+	if n == 0 {
+		c.Exit(1)
+	}
+}
+
+// matchLabels tells whether labels contains all of the labels in want.
+func matchLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// grepTaskLogs searches the logs (stdout if stdout is true, stderr
+// otherwise) of the provided tasks, retrieved from the repository
+// repo, for lines matching re, and writes them to w. If list is
+// true, only the matching tasks are written. Tasks whose logs were
+// not stored, or are no longer present in the repository (e.g.,
+// because they were collected), are skipped. grepTaskLogs returns
+// the number of matching lines and the number of tasks whose logs
+// were missing from the repository.
+func grepTaskLogs(ctx context.Context, repo reflow.Repository, tasks []taskdb.Task, re *regexp.Regexp, stdout, list bool, w io.Writer) (n, missing int, err error) {
+	tasks = append([]taskdb.Task(nil), tasks...)
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Start.Before(tasks[j].Start) })
+	var (
+		matches = make([][]string, len(tasks))
+		absent  = make([]bool, len(tasks))
+		g, gctx = errgroup.WithContext(ctx)
+		sem     = make(chan struct{}, 16)
+	)
+	for i := range tasks {
+		i, task := i, tasks[i]
+		id := task.Stderr
+		if stdout {
+			id = task.Stdout
+		}
+		if id.IsZero() {
+			continue
+		}
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rc, err := repo.Get(gctx, id)
+			if errors.Is(errors.NotExist, err) {
+				absent[i] = true
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("task %s (%s): %v", task.ID.IDShort(), task.Ident, err)
+			}
+			defer rc.Close()
+			prefix := fmt.Sprintf("%s:%s:%s", task.RunID.IDShort(), task.Ident, task.ID.IDShort())
+			scanner := bufio.NewScanner(rc)
+			scanner.Buffer(nil, 1<<20)
+			for lineno := 1; scanner.Scan(); lineno++ {
+				if !re.Match(scanner.Bytes()) {
+					continue
+				}
+				if list {
+					matches[i] = []string{prefix}
+					return nil
+				}
+				matches[i] = append(matches[i], fmt.Sprintf("%s:%d: %s", prefix, lineno, scanner.Text()))
+			}
+			return scanner.Err()
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, 0, err
+	}
+	for i, lines := range matches {
+		if absent[i] {
+			missing++
+		}
+		for _, line := range lines {
+			n++
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return n, missing, err
+			}
+		}
+	}
+	return n, missing, nil
+}
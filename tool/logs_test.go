@@ -0,0 +1,108 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func TestGrepTaskLogs(t *testing.T) {
+	ctx := context.Background()
+	repo := testutil.NewInmemoryRepository()
+	put := func(s string) digest.Digest {
+		d, err := repo.Put(ctx, strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	var (
+		start = time.Now()
+		run   = taskdb.NewRunID()
+		align = taskdb.Task{
+			ID: taskdb.NewTaskID(), RunID: run, Ident: "align", Start: start.Add(time.Minute),
+			Stderr: put("loading index\nERROR: out of memory\nretrying\nERROR: out of memory\n"),
+			Stdout: put("ok\n"),
+		}
+		sort = taskdb.Task{
+			ID: taskdb.NewTaskID(), RunID: run, Ident: "sort", Start: start,
+			Stderr: put("sorting\nERROR: disk full\n"),
+		}
+		// Tasks whose logs were not stored are skipped.
+		intern = taskdb.Task{ID: taskdb.NewTaskID(), RunID: run, Ident: "intern"}
+		// As are tasks whose logs were collected.
+		collected = taskdb.Task{
+			ID: taskdb.NewTaskID(), RunID: run, Ident: "collected",
+			Stderr: reflow.Digester.FromString("ERROR: collected\n"),
+		}
+		tasks = []taskdb.Task{align, sort, intern, collected}
+		re    = regexp.MustCompile("^ERROR")
+	)
+	var b bytes.Buffer
+	n, missing, err := grepTaskLogs(ctx, repo, tasks, re, false, false, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := missing, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	prefix := func(task taskdb.Task) string {
+		return run.IDShort() + ":" + task.Ident + ":" + task.ID.IDShort()
+	}
+	want := prefix(sort) + ":2: ERROR: disk full\n" +
+		prefix(align) + ":2: ERROR: out of memory\n" +
+		prefix(align) + ":4: ERROR: out of memory\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.Reset()
+	if _, _, err := grepTaskLogs(ctx, repo, tasks, re, false, true, &b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), prefix(sort)+"\n"+prefix(align)+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.Reset()
+	n, _, err = grepTaskLogs(ctx, repo, tasks, re, true, false, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || b.Len() != 0 {
+		t.Errorf("unexpected matches in stdout: %q", b.String())
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"project": "align", "env": "prod"}
+	for _, c := range []struct {
+		want  map[string]string
+		match bool
+	}{
+		{nil, true},
+		{map[string]string{"project": "align"}, true},
+		{map[string]string{"project": "align", "env": "prod"}, true},
+		{map[string]string{"project": "sort"}, false},
+		{map[string]string{"owner": "alice"}, false},
+	} {
+		if got, want := matchLabels(labels, c.want), c.match; got != want {
+			t.Errorf("matchLabels(%v): got %v, want %v", c.want, got, want)
+		}
+	}
+}
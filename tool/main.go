@@ -19,6 +19,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strings"
 	"syscall"
 
 	"github.com/grailbio/base/status"
@@ -26,6 +27,7 @@ import (
 	"github.com/grailbio/reflow/flow"
 	infra2 "github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/metrics"
 	"gopkg.in/yaml.v2"
 )
 
@@ -102,6 +104,14 @@ var commands = map[string]Func{
 	"config":       (*Cmd).config,
 	"images":       (*Cmd).images,
 	"rmcache":      (*Cmd).rmcache,
+	"cache":        (*Cmd).cache,
+	"explain":      (*Cmd).explain,
+	"scrub":        (*Cmd).scrub,
+	"du":           (*Cmd).du,
+	"cost":         (*Cmd).cost,
+	"rightsize":    (*Cmd).rightsize,
+	"top":          (*Cmd).top,
+	"trace":        (*Cmd).traceCmd,
 	"serve":        (*Cmd).serveCmd,
 	"shell":        (*Cmd).shell,
 	"test":         (*Cmd).test,
@@ -264,13 +274,25 @@ func (c *Cmd) Main() {
 		}
 		c.SchemaKeys[k] = *v
 	}
-	c.SchemaKeys["logger"] = fmt.Sprintf("logger,level=%v", c.logFlag)
+	// The log level is always that of the -log flag; the logger's
+	// other arguments (e.g., its format) may be configured.
+	c.SchemaKeys["logger"] = loggerKey(c.SchemaKeys["logger"], c.logFlag)
 	// Set the reflow version to always match the version of the binary, regardless of the provided configuration.
 	c.SchemaKeys[infra2.Reflow] = fmt.Sprintf("reflowversion,version=%s", c.Version)
 	var err error
 	c.Config, err = c.Schema.Make(c.SchemaKeys)
 	c.must(err)
 
+	// If structured logs are configured, the system wide logger
+	// publishes them too.
+	var logger *log.Logger
+	if err := c.Config.Instance(&logger); err == nil && logger != nil {
+		if _, ok := logger.Outputter.(*log.JSONOutputter); ok {
+			log.Std = log.New(log.NewJSONOutputter(c.Stderr), level)
+			c.Log = log.Std
+		}
+	}
+
 	var (
 		bootstrapimage *infra2.BootstrapImage
 		dockerconfig   *infra2.DockerConfig
@@ -284,8 +306,13 @@ func (c *Cmd) Main() {
 	}
 
 	if c.httpFlag != "" {
+		// Metrics are served on a separate mux so that they do not
+		// conflict with those served by the reflowlet.
+		mux := http.NewServeMux()
+		mux.Handle("/", http.DefaultServeMux)
+		mux.Handle("/metrics", metrics.Handler())
 		go func() {
-			c.Fatal(http.ListenAndServe(c.httpFlag, nil))
+			c.Fatal(http.ListenAndServe(c.httpFlag, mux))
 		}()
 	}
 	if c.cpuProfileFlag != "" {
@@ -411,7 +438,7 @@ func (c *Cmd) Flags() *flag.FlagSet {
 		c.flags.Usage = func() { c.usage(c.flags) }
 		c.flags.StringVar(&flow.Universe, "universe", "", "digest namespace")
 		c.flags.StringVar(&c.ConfigFile, "config", c.DefaultConfigFile, "path to configuration file; otherwise use default (builtin) config")
-		c.flags.StringVar(&c.httpFlag, "http", "", "run a diagnostic HTTP server on this port")
+		c.flags.StringVar(&c.httpFlag, "http", "", "run a diagnostic HTTP server on this port; Prometheus metrics are served at /metrics")
 		c.flags.StringVar(&c.cpuProfileFlag, "cpuprofile", "", "capture a CPU profile and deposit it to the provided path")
 		c.flags.StringVar(&c.memProfileFlag, "memprofile", "", "capture a Memory profile and deposit it to the provided path")
 		c.flags.StringVar(&c.logFlag, "log", "info", "set the log level: off, error, info, debug")
@@ -439,6 +466,22 @@ func (c *Cmd) onexit(fn func()) {
 	c.onexits = append(c.onexits, fn)
 }
 
+// loggerKey returns the logger configuration key that sets the
+// provided level, retaining the other arguments of the provided key.
+func loggerKey(key, level string) string {
+	parts := strings.Split(key, ",")
+	args := []string{"logger", "level=" + level}
+	if parts[0] != "logger" {
+		return strings.Join(args, ",")
+	}
+	for _, arg := range parts[1:] {
+		if arg != "" && !strings.HasPrefix(arg, "level=") {
+			args = append(args, arg)
+		}
+	}
+	return strings.Join(args, ",")
+}
+
 // increaseFDRlimit maxes out the FD soft limit.
 func increaseFDRlimit() error {
 	var l syscall.Rlimit
@@ -2,9 +2,16 @@ package tool
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"docker.io/go-docker/api/types"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/internal/assertgen"
 )
 
 type nilAuthenticator struct{}
@@ -42,3 +49,81 @@ func TestResolveImages(t *testing.T) {
 		}
 	}
 }
+
+// testRegistry is an in-process, anonymously accessible Docker
+// registry that serves image manifests keyed by repository:tag.
+type testRegistry struct {
+	mu        sync.Mutex
+	manifests map[string]string
+}
+
+func (r *testRegistry) tag(image, manifest string) {
+	r.mu.Lock()
+	r.manifests[image] = manifest
+	r.mu.Unlock()
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/v2/" {
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	i := strings.LastIndex(path, "/manifests/")
+	if i < 0 {
+		http.NotFound(w, req)
+		return
+	}
+	r.mu.Lock()
+	manifest, ok := r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+	r.mu.Unlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	io.WriteString(w, manifest)
+}
+
+func TestImageAssertions(t *testing.T) {
+	const (
+		v1 = `{"schemaVersion":2,"config":{"digest":"sha256:0001"}}`
+		v2 = `{"schemaVersion":2,"config":{"digest":"sha256:0002"}}`
+	)
+	ctx := context.Background()
+	reg := &testRegistry{manifests: map[string]string{"grailbio/test:latest": v1}}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	r := &ImageResolver{Authenticator: nilAuthenticator{}}
+	g := assertgen.ImageGenerator{Resolve: r.resolveImage}
+	key := reflow.AssertionKey{Subject: host + "/grailbio/test:latest", Namespace: assertgen.ImageNamespace}
+	before, err := g.Generate(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := reflow.AssertionsFromEntry(key, map[string]string{"digest": reflow.Digester.FromString(v1).String()})
+	if !before.Equal(want) {
+		t.Errorf("got %v, want %v", before, want)
+	}
+	same, err := g.Generate(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflow.AssertExact(ctx, []*reflow.Assertions{same}, []*reflow.Assertions{before}) {
+		t.Error("assertions on an unchanged image must match")
+	}
+	reg.tag("grailbio/test:latest", v2)
+	after, err := g.Generate(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflow.AssertExact(ctx, []*reflow.Assertions{after}, []*reflow.Assertions{before}) {
+		t.Error("assertions on a retagged image must not match")
+	}
+	if _, err := g.Generate(ctx, reflow.AssertionKey{Subject: host + "/grailbio/missing:latest", Namespace: assertgen.ImageNamespace}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/taskdb"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// forever is the age of rules that retain entries indefinitely.
+	forever = time.Duration(1<<63 - 1)
+
+	// defaultRunWindow is the window within which recent runs are
+	// considered, when a policy does not specify one.
+	defaultRunWindow = 30 * 24 * time.Hour
+)
+
+// Names under which retention decisions that are not made by a
+// policy's rules are reported.
+const (
+	retainLeased    = "(leased)"
+	retainRecent    = "(recent runs)"
+	retainUnmatched = "(unmatched)"
+)
+
+// parseAge parses an age given either as a number of days ("15d"),
+// a Go duration ("36h"), or "forever".
+func parseAge(s string) (time.Duration, error) {
+	switch {
+	case s == "forever":
+		return forever, nil
+	case strings.HasSuffix(s, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	default:
+		return time.ParseDuration(s)
+	}
+}
+
+// A retentionPolicy declares which cache entries are retained by
+// collection. Policies are read from YAML files of the form:
+//
+//	rules:
+//	- name: prod
+//	  labels: env=prod
+//	  keep: 365d
+//	- name: dev
+//	  labels: env=dev
+//	  keep: 14d
+//	- name: default
+//	  keep: 30d
+//	recentruns: 3
+//	runwindow: 90d
+//
+// Each cache entry is governed by the first rule whose labels
+// expression (in the syntax of collect's -keep and -labels flags)
+// matches the entry's labels; a rule without an expression matches
+// every entry. An entry is retained if it was accessed within its
+// rule's keep age ("forever" retains entries indefinitely). Entries
+// that match no rule are retained.
+//
+// Regardless of rules, entries are retained if they are reachable
+// from one of the last recentruns runs of each program (considering
+// runs active within runwindow), or if they are leased by a running
+// evaluation: entries reachable from the tasks of running
+// evaluations, and entries written or accessed since the start of
+// the oldest running evaluation, are considered leased. Since cache
+// lookups refresh the access times of the entries they retrieve
+// (see assoc.Assoc.BatchGet), entries hit by running evaluations are
+// leased.
+type retentionPolicy struct {
+	Rules      []*retentionRule `yaml:"rules"`
+	RecentRuns int              `yaml:"recentruns"`
+	RunWindow  string           `yaml:"runwindow"`
+
+	runWindow time.Duration
+}
+
+// A retentionRule retains the cache entries matching its labels
+// expression for a fixed age past their last access.
+type retentionRule struct {
+	Name   string `yaml:"name"`
+	Labels string `yaml:"labels"`
+	Keep   string `yaml:"keep"`
+
+	filter *filter
+	keep   time.Duration
+}
+
+// readRetentionPolicy reads and validates the retention policy in
+// the provided file.
+func readRetentionPolicy(path string) (*retentionPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseRetentionPolicy(b)
+	if err != nil {
+		return nil, errors.E("retention policy", path, err)
+	}
+	return p, nil
+}
+
+func parseRetentionPolicy(b []byte) (*retentionPolicy, error) {
+	p := new(retentionPolicy)
+	if err := yaml.UnmarshalStrict(b, p); err != nil {
+		return nil, errors.E(errors.Invalid, err)
+	}
+	if len(p.Rules) == 0 {
+		return nil, errors.E(errors.Invalid, errors.New("no rules"))
+	}
+	names := make(map[string]bool)
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return nil, errors.E(errors.Invalid, errors.Errorf("rule %d: missing name", i))
+		}
+		if names[rule.Name] {
+			return nil, errors.E(errors.Invalid, errors.Errorf("rule %s: duplicate name", rule.Name))
+		}
+		names[rule.Name] = true
+		if rule.Keep == "" {
+			return nil, errors.E(errors.Invalid, errors.Errorf("rule %s: missing keep", rule.Name))
+		}
+		var err error
+		if rule.keep, err = parseAge(rule.Keep); err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("rule %s: keep %s: %v", rule.Name, rule.Keep, err))
+		}
+		if rule.Labels != "" {
+			if rule.filter, err = parseFilter(rule.Labels); err != nil {
+				return nil, errors.E(errors.Invalid, errors.Errorf("rule %s: labels %s: %v", rule.Name, rule.Labels, err))
+			}
+		}
+	}
+	if p.RecentRuns < 0 {
+		return nil, errors.E(errors.Invalid, errors.New("recentruns must not be negative"))
+	}
+	p.runWindow = defaultRunWindow
+	if p.RunWindow != "" {
+		var err error
+		if p.runWindow, err = parseAge(p.RunWindow); err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("runwindow %s: %v", p.RunWindow, err))
+		}
+	}
+	return p, nil
+}
+
+// Rule returns the rule that governs entries with the provided
+// labels, or nil if none does.
+func (p *retentionPolicy) Rule(labels []string) *retentionRule {
+	for _, rule := range p.Rules {
+		if rule.filter == nil || rule.filter.Match(labels) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MaxKeep returns the longest keep age of the policy's rules.
+func (p *retentionPolicy) MaxKeep() time.Duration {
+	var max time.Duration
+	for _, rule := range p.Rules {
+		if rule.keep > max {
+			max = rule.keep
+		}
+	}
+	return max
+}
+
+// Threshold returns the access time before which entries governed
+// by the rule are collected, as of now.
+func (r *retentionRule) Threshold(now time.Time) time.Time {
+	if r.keep == forever {
+		return time.Time{}
+	}
+	return now.Add(-r.keep)
+}
+
+// ruleStats accumulates the decisions made under a retention rule.
+type ruleStats struct {
+	retained, collected int64
+	// objects are the objects referenced by collected entries.
+	objects map[digest.Digest]int64
+}
+
+// A retention applies a retention policy to the cache entries of a
+// collection.
+type retention struct {
+	policy *retentionPolicy
+	now    time.Time
+	// pinned contains the cache keys and values that are reachable
+	// from recent runs.
+	pinned mapLiveset
+	// leased contains the cache keys and values that are reachable
+	// from running runs.
+	leased mapLiveset
+	// leaseStart is the start time of the oldest running evaluation,
+	// or zero if there are none.
+	leaseStart time.Time
+
+	mu    sync.Mutex
+	stats map[string]*ruleStats
+}
+
+// newRetention returns a retention for the provided policy, as of
+// now. It queries the task database for running and recent runs.
+func newRetention(ctx context.Context, policy *retentionPolicy, tdb taskdb.TaskDB, now time.Time) (*retention, error) {
+	r := &retention{
+		policy: policy,
+		now:    now,
+		pinned: make(mapLiveset),
+		leased: make(mapLiveset),
+		stats:  make(map[string]*ruleStats),
+	}
+	running, err := tdb.Runs(ctx, taskdb.RunQuery{Since: now})
+	if err != nil {
+		return nil, errors.E("running runs", err)
+	}
+	for _, run := range running {
+		if r.leaseStart.IsZero() || run.Start.Before(r.leaseStart) {
+			r.leaseStart = run.Start
+		}
+		tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: run.ID})
+		if err != nil {
+			return nil, errors.E("tasks", run.ID.ID(), err)
+		}
+		for _, task := range tasks {
+			r.leased.Add(task.FlowID)
+			if !task.ResultID.IsZero() {
+				r.leased.Add(task.ResultID)
+			}
+		}
+	}
+	if policy.RecentRuns == 0 {
+		return r, nil
+	}
+	runs, err := tdb.Runs(ctx, taskdb.RunQuery{Since: now.Add(-policy.runWindow)})
+	if err != nil {
+		return nil, errors.E("recent runs", err)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start.After(runs[j].Start) })
+	// Runs are attributed to programs by their bundles.
+	// Runs without bundles cannot be attributed, and are ignored.
+	n := make(map[digest.Digest]int)
+	for _, run := range runs {
+		if run.Bundle.IsZero() || n[run.Bundle] == policy.RecentRuns {
+			continue
+		}
+		n[run.Bundle]++
+		tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: run.ID})
+		if err != nil {
+			return nil, errors.E("tasks", run.ID.ID(), err)
+		}
+		for _, task := range tasks {
+			r.pinned.Add(task.FlowID)
+			if !task.ResultID.IsZero() {
+				r.pinned.Add(task.ResultID)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Retain decides whether the cache entry k, whose value is v,
+// is retained. It returns the name of the rule under which the
+// decision was made. Retain may be called concurrently.
+func (r *retention) Retain(k, v digest.Digest, labels []string, lastAccessTime time.Time) (name string, retain bool) {
+	switch rule := r.policy.Rule(labels); {
+	case r.leased.Contains(k) || r.leased.Contains(v),
+		!r.leaseStart.IsZero() && !lastAccessTime.Before(r.leaseStart):
+		name, retain = retainLeased, true
+	case r.pinned.Contains(k) || r.pinned.Contains(v):
+		name, retain = retainRecent, true
+	case rule == nil:
+		name, retain = retainUnmatched, true
+	default:
+		name, retain = rule.Name, lastAccessTime.After(rule.Threshold(r.now))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[name]
+	if s == nil {
+		s = &ruleStats{objects: make(map[digest.Digest]int64)}
+		r.stats[name] = s
+	}
+	if retain {
+		s.retained++
+	} else {
+		s.collected++
+	}
+	return
+}
+
+// Collected records the objects referenced by an entry that is
+// collected under the named rule.
+func (r *retention) Collected(name string, objects map[digest.Digest]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[name]
+	for id, size := range objects {
+		s.objects[id] = size
+	}
+}
+
+// Threshold returns the threshold to be used for objects and
+// entries that were not explicitly decided: those that were not
+// referenced by any cache entry, or that were created during
+// collection.
+func (r *retention) Threshold() time.Time {
+	keep := r.policy.MaxKeep()
+	if keep == forever {
+		return time.Time{}
+	}
+	threshold := r.now.Add(-keep)
+	if !r.leaseStart.IsZero() && r.leaseStart.Before(threshold) {
+		threshold = r.leaseStart
+	}
+	return threshold
+}
+
+// Report writes a per-rule report of the retention decisions to w.
+// The bytes reclaimed under each rule are those of the objects of
+// its collected entries that are not also live; live reports whether
+// an object is. Objects collected under multiple rules are attributed
+// to the first of them, in policy order.
+func (r *retention) Report(w io.Writer, live func(digest.Digest) bool) {
+	var tw tabwriter.Writer
+	tw.Init(w, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(&tw, "rule\tkeep\tretained\tcollected\treclaimed")
+	var (
+		seen  = make(map[digest.Digest]bool)
+		total int64
+	)
+	report := func(name, keep string) {
+		s := r.stats[name]
+		if s == nil {
+			s = &ruleStats{}
+		}
+		var reclaimed int64
+		for id, size := range s.objects {
+			if seen[id] || live(id) {
+				continue
+			}
+			seen[id] = true
+			reclaimed += size
+		}
+		total += reclaimed
+		fmt.Fprintf(&tw, "%s\t%s\t%d\t%d\t%s\n", name, keep, s.retained, s.collected, data.Size(reclaimed))
+	}
+	for _, rule := range r.policy.Rules {
+		report(rule.Name, rule.Keep)
+	}
+	report(retainLeased, "-")
+	report(retainRecent, "-")
+	report(retainUnmatched, "-")
+	fmt.Fprintf(&tw, "total\t\t\t\t%s\n", data.Size(total))
+}
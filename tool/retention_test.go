@@ -0,0 +1,165 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+const testPolicy = `
+rules:
+- name: prod
+  labels: env=prod
+  keep: 365d
+- name: dev
+  labels: env=dev
+  keep: 14d
+recentruns: 1
+runwindow: 90d
+`
+
+type retentionTaskDB struct {
+	taskdb.TaskDB
+	runs  []taskdb.Run
+	tasks map[taskdb.RunID][]taskdb.Task
+}
+
+func (t *retentionTaskDB) Runs(ctx context.Context, query taskdb.RunQuery) ([]taskdb.Run, error) {
+	var runs []taskdb.Run
+	for _, run := range t.runs {
+		if run.Keepalive.After(query.Since) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (t *retentionTaskDB) Tasks(ctx context.Context, query taskdb.TaskQuery) ([]taskdb.Task, error) {
+	return t.tasks[query.RunID], nil
+}
+
+func TestRetentionPolicyParse(t *testing.T) {
+	p, err := parseRetentionPolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(p.Rules), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := p.MaxKeep(), 365*24*time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := p.runWindow, 90*24*time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{
+		``,
+		"rules:\n- name: x\n",
+		"rules:\n- keep: 1d\n",
+		"rules:\n- name: x\n  keep: 1d\n- name: x\n  keep: 2d\n",
+		"rules:\n- name: x\n  keep: soon\n",
+		"rules:\n- name: x\n  keep: 1d\n  unknown: true\n",
+	} {
+		if _, err := parseRetentionPolicy([]byte(bad)); err == nil {
+			t.Errorf("expected error for policy %q", bad)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	p, err := parseRetentionPolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		now     = time.Now()
+		day     = 24 * time.Hour
+		bundle  = reflow.Digester.FromString("bundle")
+		oldRun  = taskdb.NewRunID()
+		lastRun = taskdb.NewRunID()
+		oldKey  = reflow.Digester.FromString("old")
+		lastKey = reflow.Digester.FromString("last")
+		tdb     = &retentionTaskDB{
+			runs: []taskdb.Run{
+				{ID: oldRun, Bundle: bundle, Start: now.Add(-50 * day), Keepalive: now.Add(-50 * day)},
+				{ID: lastRun, Bundle: bundle, Start: now.Add(-40 * day), Keepalive: now.Add(-40 * day)},
+			},
+			tasks: map[taskdb.RunID][]taskdb.Task{
+				oldRun:  {{FlowID: oldKey}},
+				lastRun: {{FlowID: lastKey}},
+			},
+		}
+	)
+	r, err := newRetention(context.Background(), p, tdb, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.leaseStart.IsZero() {
+		t.Errorf("unexpected lease start %v", r.leaseStart)
+	}
+	k := func() digest.Digest { return reflow.Digester.Rand(nil) }
+	for _, c := range []struct {
+		k        digest.Digest
+		labels   []string
+		age      time.Duration
+		name     string
+		retained bool
+	}{
+		{k(), []string{"env=prod"}, 100 * day, "prod", true},
+		{k(), []string{"env=prod"}, 400 * day, "prod", false},
+		{k(), []string{"env=dev"}, 10 * day, "dev", true},
+		{k(), []string{"env=dev"}, 20 * day, "dev", false},
+		{k(), []string{"env=test"}, 1000 * day, retainUnmatched, true},
+		{lastKey, []string{"env=dev"}, 40 * day, retainRecent, true},
+		{oldKey, []string{"env=dev"}, 50 * day, "dev", false},
+	} {
+		name, retained := r.Retain(c.k, k(), c.labels, now.Add(-c.age))
+		if name != c.name || retained != c.retained {
+			t.Errorf("%v (%v old): got %v %v, want %v %v", c.labels, c.age, name, retained, c.name, c.retained)
+		}
+	}
+	if got, want := r.Threshold(), now.Add(-365*day); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Entries accessed since the start of a running evaluation,
+	// and entries reachable from its tasks, are leased.
+	running := taskdb.NewRunID()
+	tdb.runs = append(tdb.runs, taskdb.Run{ID: running, Start: now.Add(-30 * day), Keepalive: now.Add(time.Minute)})
+	tdb.tasks[running] = []taskdb.Task{{FlowID: oldKey}}
+	r, err = newRetention(context.Background(), p, tdb, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, retained := r.Retain(oldKey, k(), []string{"env=dev"}, now.Add(-50*day)); name != retainLeased || !retained {
+		t.Errorf("got %v %v, want %v true", name, retained, retainLeased)
+	}
+	if name, retained := r.Retain(k(), k(), []string{"env=dev"}, now.Add(-20*day)); name != retainLeased || !retained {
+		t.Errorf("got %v %v, want %v true", name, retained, retainLeased)
+	}
+	name, retained := r.Retain(k(), k(), []string{"env=dev"}, now.Add(-31*day))
+	if name != "dev" || retained {
+		t.Errorf("got %v %v, want dev false", name, retained)
+	}
+	var (
+		shared    = reflow.Digester.FromString("shared")
+		reclaimed = reflow.Digester.FromString("reclaimed")
+	)
+	r.Collected(name, map[digest.Digest]int64{shared: 1 << 30, reclaimed: 2 << 20})
+	var b bytes.Buffer
+	r.Report(&b, func(id digest.Digest) bool { return id == shared })
+	if !strings.Contains(b.String(), data.Size(2<<20).String()) {
+		t.Errorf("unexpected report:\n%s", b.String())
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/syntax"
+	"github.com/grailbio/reflow/taskdb"
+	"golang.org/x/sync/errgroup"
+)
+
+// rightsizeResources are the resources for which rightsize makes
+// recommendations.
+var rightsizeResources = []string{"cpu", "mem", "disk"}
+
+const (
+	// memQuantum and diskQuantum are the granularities to which
+	// memory and disk recommendations are rounded up.
+	memQuantum  = 256 << 20
+	diskQuantum = 1 << 30
+)
+
+// execProfile is the usage history of a single exec, identified by
+// its ident and image.
+type execProfile struct {
+	Ident, Image string
+	// Position is the source position of the exec, if known.
+	Position string
+	// Declared is the most recently declared resources of the exec.
+	Declared reflow.Resources
+	// declaredTime is the time at which Declared was recorded.
+	declaredTime time.Time
+	// Peaks contains the peak usage of each resource, one sample
+	// per execution.
+	Peaks map[string][]float64
+	// Hours contains the runtime, in hours, of each execution.
+	Hours []float64
+}
+
+// add adds the usage of an execution, as recorded by its inspect,
+// to the profile.
+func (p *execProfile) add(inspect reflow.ExecInspect, hours float64) {
+	if p.Peaks == nil {
+		p.Peaks = make(map[string][]float64)
+	}
+	if p.Declared == nil || inspect.Created.After(p.declaredTime) {
+		p.Declared = inspect.Config.Resources
+		p.declaredTime = inspect.Created
+	}
+	p.Peaks["cpu"] = append(p.Peaks["cpu"], inspect.Profile["cpu"].Max)
+	p.Peaks["mem"] = append(p.Peaks["mem"], inspect.Profile["mem"].Max)
+	// The exec's data directory is accounted separately from its
+	// scratch space, but both are allocated from its disk.
+	p.Peaks["disk"] = append(p.Peaks["disk"], inspect.Profile["disk"].Max+inspect.Profile["tmp"].Max)
+	p.Hours = append(p.Hours, hours)
+}
+
+// N returns the number of executions in the profile.
+func (p *execProfile) N() int { return len(p.Hours) }
+
+// recommend returns the recommended resources for the exec: the
+// pct-th percentile of each resource's peak usage, scaled by
+// (1+headroom) and rounded up to a practical granularity.
+func (p *execProfile) recommend(pct int, headroom float64) reflow.Resources {
+	rec := make(reflow.Resources)
+	for k, v := range p.Declared {
+		rec[k] = v
+	}
+	for _, k := range rightsizeResources {
+		v := percentile(p.Peaks[k], pct) * (1 + headroom)
+		switch k {
+		case "cpu":
+			v = math.Max(1, math.Ceil(v))
+		case "mem":
+			v = math.Max(1, math.Ceil(v/memQuantum)) * memQuantum
+		case "disk":
+			if v == 0 && p.Declared[k] == 0 {
+				continue
+			}
+			v = math.Max(1, math.Ceil(v/diskQuantum)) * diskQuantum
+		}
+		rec[k] = v
+	}
+	// CPU features are requested one per CPU.
+	for k := range p.Declared {
+		switch k {
+		case "cpu", "mem", "disk":
+		default:
+			rec[k] = rec["cpu"]
+		}
+	}
+	return rec
+}
+
+// savings returns the projected cost savings, in dollars, had the
+// profile's executions requested resources rec instead of their
+// declared resources, given the hourly cost function cost.
+func (p *execProfile) savings(rec reflow.Resources, cost func(reflow.Resources) float64) float64 {
+	var hours float64
+	for _, h := range p.Hours {
+		hours += h
+	}
+	return hours * (cost(p.Declared) - cost(rec))
+}
+
+// percentile returns the pct-th percentile of the provided values,
+// as computed by the nearest-rank method.
+func percentile(values []float64, pct int) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	idx := int(math.Ceil(float64(n*pct)/100)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= n {
+		idx = n - 1
+	}
+	return sorted[idx]
+}
+
+// moduleExecs returns the source positions of the execs in module m,
+// keyed by the idents with which they are evaluated. Exec idents are
+// determined lexically, by the chain of declarations in which the
+// exec expression appears. Execs in imported modules are not
+// included; they are reported by rightsizing those modules.
+func moduleExecs(m *syntax.ModuleImpl) map[string]string {
+	execs := make(map[string]string)
+	var walk func(e *syntax.Expr, ident string)
+	walk = func(e *syntax.Expr, ident string) {
+		if e == nil {
+			return
+		}
+		switch e.Kind {
+		case syntax.ExprExec:
+			if _, ok := execs[ident]; !ok {
+				execs[ident] = e.Position.String()
+			}
+			for _, d := range e.Decls {
+				walk(d.Expr, d.ID(ident))
+			}
+			return
+		case syntax.ExprBlock:
+			for _, d := range e.Decls {
+				walk(d.Expr, d.ID(ident))
+			}
+		}
+		for _, sub := range e.Subexpr() {
+			walk(sub, ident)
+		}
+		walk(e.ComprExpr, ident)
+		for _, clause := range e.ComprClauses {
+			walk(clause.Expr, ident)
+		}
+		for _, clause := range e.CaseClauses {
+			walk(clause.Expr, ident)
+		}
+	}
+	for _, d := range m.Decls {
+		walk(d.Expr, d.ID(""))
+	}
+	return execs
+}
+
+func (c *Cmd) rightsize(ctx context.Context, args ...string) {
+	var (
+		flags          = flag.NewFlagSet("rightsize", flag.ExitOnError)
+		pctFlag        = flags.Int("pct", 95, "percentile of peak usage on which to base recommendations")
+		headroomFlag   = flags.Float64("headroom", 0.2, "fraction of headroom to add to recommendations")
+		limitFlag      = flags.Int64("limit", 100, "maximum number of past executions of each exec to consider")
+		regionFlag     = flags.String("region", "us-west-2", "region in which to price resources")
+		positionsFlag  = flags.Bool("positions", false, "print recommendations as a listing of source positions")
+		minSamplesFlag = flags.Int("n", 1, "minimum number of executions required to make a recommendation")
+		help           = `Rightsize recommends resource requirements for execs, based on the
+resource profiles recorded for their past executions.
+
+Rightsize accepts either a run ID or the path of a module. If a run
+ID is given, rightsize considers the execs of that run. If a module
+is given, rightsize finds the execs declared in the module and
+considers up to -limit past executions of each, as recorded in the
+task database.
+
+Execs are grouped by their identifier and image. For each
+resource (cpu, mem, disk), rightsize computes the -pct percentile
+of the exec's peak usage across executions, adds -headroom, and
+rounds the result up: CPUs to whole CPUs, memory to 256 MiB, and
+disk to 1 GiB. Rightsize displays the exec's declared resources
+alongside the recommendation, together with the projected savings:
+the difference in cost, over all considered executions, between the
+declared and recommended resources. Costs are computed as the
+resources' share of the cheapest EC2 instance type that can
+accommodate them, in the region given by -region. Negative savings
+indicate that an exec is under-provisioned.
+
+If -positions is given (and a module is provided), rightsize
+instead prints a listing of the source positions of the execs,
+together with the recommended resource declarations, in a format
+suitable for editor quickfix lists.`
+	)
+	c.Parse(flags, args, help, "rightsize [-pct n] [-headroom fraction] [-positions] module|runid")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	if *pctFlag < 0 || *pctFlag > 100 {
+		c.Fatalf("invalid percentile %d", *pctFlag)
+	}
+	arg := flags.Arg(0)
+	var tdb taskdb.TaskDB
+	c.must(c.Config.Instance(&tdb))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+
+	var (
+		tasks     []taskdb.Task
+		positions map[string]string
+	)
+	if id, err := reflow.Digester.Parse(arg); err == nil {
+		tasks, err = tdb.Tasks(ctx, taskdb.TaskQuery{RunID: taskdb.RunID(id)})
+		c.must(err)
+		if len(tasks) == 0 {
+			c.Fatalf("no tasks found for run %s", arg)
+		}
+	} else {
+		sess := syntax.NewSession(nil)
+		m, err := sess.Open(arg)
+		if err != nil {
+			c.Fatalf("open %s: %v", arg, err)
+		}
+		impl, ok := m.(*syntax.ModuleImpl)
+		if !ok {
+			c.Fatalf("%s: not a reflow module", arg)
+		}
+		positions = moduleExecs(impl)
+		if len(positions) == 0 {
+			c.Fatalf("%s: no execs found", arg)
+		}
+		var (
+			identTasks = make([][]taskdb.Task, 0, len(positions))
+			g, gctx    = errgroup.WithContext(ctx)
+		)
+		for ident := range positions {
+			i, ident := len(identTasks), ident
+			identTasks = append(identTasks, nil)
+			g.Go(func() (err error) {
+				identTasks[i], err = tdb.Tasks(gctx, taskdb.TaskQuery{Ident: ident, Limit: *limitFlag})
+				return
+			})
+		}
+		c.must(g.Wait())
+		for _, t := range identTasks {
+			tasks = append(tasks, t...)
+		}
+	}
+
+	inspects := make([]reflow.ExecInspect, len(tasks))
+	_ = traverse.Limit(inspectConcurrency).Each(len(tasks), func(i int) error {
+		if tasks[i].Inspect.IsZero() {
+			return nil
+		}
+		if err := repository.Unmarshal(ctx, repo, tasks[i].Inspect, &inspects[i]); err != nil {
+			c.Log.Debugf("task %s (%s): inspect %s: %v", tasks[i].ID.IDShort(), tasks[i].Ident, tasks[i].Inspect.Short(), err)
+		}
+		return nil
+	})
+
+	type key struct{ ident, image string }
+	profiles := make(map[key]*execProfile)
+	for i, inspect := range inspects {
+		if inspect.Config.Type != "exec" || len(inspect.Profile) == 0 {
+			continue
+		}
+		k := key{inspect.Config.Ident, inspect.Config.Image}
+		p := profiles[k]
+		if p == nil {
+			p = &execProfile{Ident: k.ident, Image: k.image, Position: positions[k.ident]}
+			profiles[k] = p
+		}
+		dur := inspect.Runtime()
+		if dur == 0 {
+			dur = tasks[i].Keepalive.Sub(tasks[i].Start)
+		}
+		p.add(inspect, dur.Hours())
+	}
+	var sorted []*execProfile
+	for _, p := range profiles {
+		if p.N() >= *minSamplesFlag {
+			sorted = append(sorted, p)
+		}
+	}
+	if len(sorted) == 0 {
+		c.Fatalf("no resource profiles found for %s", arg)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Ident != sorted[j].Ident {
+			return sorted[i].Ident < sorted[j].Ident
+		}
+		return sorted[i].Image < sorted[j].Image
+	})
+
+	if *positionsFlag {
+		if positions == nil {
+			c.Fatalf("-positions requires a module")
+		}
+		for _, p := range sorted {
+			fmt.Fprintf(c.Stdout, "%s: %s: %s\n", p.Position, p.Ident, resourceDecls(p.recommend(*pctFlag, *headroomFlag)))
+		}
+		return
+	}
+	var (
+		cost  = hourlyCost(*regionFlag)
+		total float64
+		tw    tabwriter.Writer
+	)
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprint(&tw, "ident\timage\tn\tcpu\tmem\tdisk\tsavings\n")
+	for _, p := range sorted {
+		rec := p.recommend(*pctFlag, *headroomFlag)
+		savings := p.savings(rec, cost)
+		total += savings
+		fmt.Fprintf(&tw, "%s\t%s\t%d\t%.0f -> %.0f\t%s -> %s\t%s -> %s\t$%.2f\n",
+			p.Ident, p.Image, p.N(),
+			p.Declared["cpu"], rec["cpu"],
+			data.Size(p.Declared["mem"]), data.Size(rec["mem"]),
+			data.Size(p.Declared["disk"]), data.Size(rec["disk"]),
+			savings)
+	}
+	fmt.Fprintf(&tw, "total\t\t\t\t\t\t$%.2f\n", total)
+}
+
+// resourceDecls renders resources as reflow exec resource
+// declarations.
+func resourceDecls(res reflow.Resources) string {
+	decls := fmt.Sprintf("cpu := %.0f, mem := %s", res["cpu"], sizeExpr(res["mem"]))
+	if res["disk"] > 0 {
+		decls += ", disk := " + sizeExpr(res["disk"])
+	}
+	return decls
+}
+
+// sizeExpr renders a size in bytes as a reflow expression.
+func sizeExpr(size float64) string {
+	switch {
+	case size >= 1<<30 && math.Mod(size, 1<<30) == 0:
+		return fmt.Sprintf("%.0f*GiB", size/(1<<30))
+	case size >= 1<<20 && math.Mod(size, 1<<20) == 0:
+		return fmt.Sprintf("%.0f*MiB", size/(1<<20))
+	default:
+		return fmt.Sprintf("%.0f", size)
+	}
+}
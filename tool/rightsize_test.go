@@ -0,0 +1,110 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/syntax"
+)
+
+func TestRightsizeRecommend(t *testing.T) {
+	const GiB = 1 << 30
+	var p execProfile
+	for i := 1; i <= 10; i++ {
+		p.add(reflow.ExecInspect{
+			Config: reflow.ExecConfig{
+				Ident:     "align",
+				Resources: reflow.Resources{"cpu": 16, "mem": 64 * GiB, "intel_avx": 16},
+			},
+			Profile: reflow.Profile{
+				"cpu": {Max: float64(i) / 2},
+				"mem": {Max: float64(i) * GiB},
+			},
+		}, 1)
+	}
+	if got, want := p.N(), 10; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The 90th percentile of peak usage is 4.5 CPUs and 9 GiB, to
+	// which 20% headroom is added: 5.4 CPUs and 10.8 GiB.
+	rec := p.recommend(90, 0.2)
+	want := reflow.Resources{"cpu": 6, "mem": 11 * GiB, "intel_avx": 6}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("got %v, want %v", rec, want)
+	}
+	cost := func(r reflow.Resources) float64 { return r["cpu"] }
+	if got, want := p.savings(rec, cost), 100.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := resourceDecls(rec), "cpu := 6, mem := 11*GiB"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	rec["mem"] = 1.5 * GiB
+	rec["disk"] = 10 * GiB
+	if got, want := resourceDecls(rec), "cpu := 6, mem := 1536*MiB, disk := 10*GiB"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRightsizeCost(t *testing.T) {
+	cost := hourlyCost("us-west-2")
+	small := cost(reflow.Resources{"cpu": 1, "mem": 1 << 30})
+	large := cost(reflow.Resources{"cpu": 16, "mem": 64 << 30})
+	if small <= 0 || large <= small {
+		t.Errorf("invalid costs: small %v, large %v", small, large)
+	}
+	if got := cost(reflow.Resources{"cpu": 1 << 20}); got != 0 {
+		t.Errorf("got %v, want 0", got)
+	}
+}
+
+func TestModuleExecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rightsize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.rf")
+	const module = `val Index = exec(image := "ubuntu", mem := GiB) (out file) {"
+	echo index >{{out}}
+"}
+
+func align(x string) = {
+	val sam = exec(image := "ubuntu", cpu := 2) (out file) {"
+		echo {{x}} >{{out}}
+	"}
+	sam
+}
+
+val Main = [align(x) | x <- ["a", "b"]]
+`
+	if err := ioutil.WriteFile(path, []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := syntax.NewSession(nil).Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := moduleExecs(m.(*syntax.ModuleImpl))
+	if got, want := len(execs), 2; got != want {
+		t.Fatalf("got %v, want %v: %v", got, want, execs)
+	}
+	for ident, line := range map[string]string{"Index": ":1:", "align.sam": ":6:"} {
+		pos, ok := execs[ident]
+		if !ok {
+			t.Errorf("missing exec %s: %v", ident, execs)
+			continue
+		}
+		if want := path + line; len(pos) < len(want) || pos[:len(want)] != want {
+			t.Errorf("exec %s: got position %v, want %v", ident, pos, want)
+		}
+	}
+}
@@ -14,18 +14,23 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"docker.io/go-docker"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/grailbio/base/digest"
 	"github.com/grailbio/infra"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/assoc"
 	"github.com/grailbio/reflow/blob"
+	"github.com/grailbio/reflow/ec2authenticator"
 	"github.com/grailbio/reflow/ec2cluster"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	"github.com/grailbio/reflow/flow"
 	reflowinfra "github.com/grailbio/reflow/infra"
+	"github.com/grailbio/reflow/internal/assertgen"
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/syntax"
@@ -184,6 +189,19 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 	r.RunID = runID
 
 	result, err = r.Go(ctx)
+	// Export buffered trace events before we exit.
+	if ferr := trace.Flush(ctx); ferr != nil {
+		c.Log.Debugf("flush trace: %v", ferr)
+	}
+	// Write buffered run events before we exit.
+	var sink events.Sink
+	if serr := c.Config.Instance(&sink); serr == nil {
+		if closer, ok := sink.(io.Closer); ok {
+			if cerr := closer.Close(); cerr != nil {
+				c.Log.Errorf("close events: %v", cerr)
+			}
+		}
+	}
 	if err != nil {
 		c.Errorln(err)
 		c.Exit(1)
@@ -248,8 +266,22 @@ func (c Cmd) WaitForBackgroundTasks(wg *wg.WaitGroup, timeout time.Duration) {
 func assertionGenerator(config infra.Config) (reflow.AssertionGeneratorMux, error) {
 	mux := make(reflow.AssertionGeneratorMux)
 	var err error
-	mux[blob.AssertionsNamespace], err = blobMux(config)
-	return mux, err
+	if mux[blob.AssertionsNamespace], err = blobMux(config); err != nil {
+		return nil, err
+	}
+	// Images are resolved without an AWS session if none is
+	// configured; only ECR images then fail to resolve.
+	var sess *session.Session
+	if err = config.Instance(&sess); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type *session.Session") {
+			return nil, err
+		}
+	}
+	resolver := &ImageResolver{Authenticator: ec2authenticator.New(sess)}
+	mux[assertgen.ImageNamespace] = assertgen.ImageGenerator{Resolve: resolver.resolveImage}
+	mux[assertgen.HTTPNamespace] = assertgen.HTTPGenerator{}
+	mux[assertgen.HTTPSNamespace] = assertgen.HTTPGenerator{}
+	return mux, nil
 }
 
 // asserter returns a reflow.Assert based on the given name.
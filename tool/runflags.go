@@ -63,6 +63,9 @@ func (r *CommonRunFlags) Configure(c *flow.EvalConfig) error {
 	if err != nil {
 		return err
 	}
+	// Under exact assertions, results are also invalidated when
+	// their images are retagged.
+	c.AssertImages = r.Assert == "exact"
 	c.NoCacheExtern = r.NoCacheExtern
 	c.GC = r.GC
 	c.RecomputeEmpty = r.RecomputeEmpty
@@ -93,6 +96,11 @@ type RunFlags struct {
 	Resources reflow.Resources
 	Cache     bool
 	Sched     bool
+	// RepoCache is the directory in which repository objects are
+	// cached locally. No cache is used if it is empty.
+	RepoCache string
+	// RepoCacheSize is the maximum size of the repository cache, in GiB.
+	RepoCacheSize int
 
 	resourcesFlag string
 	needAss       bool
@@ -109,6 +117,8 @@ func (r *RunFlags) Flags(flags *flag.FlagSet) {
 	flags.BoolVar(&r.Trace, "trace", false, "trace flow evaluation")
 	flags.StringVar(&r.resourcesFlag, "resources", "", "override offered resources in local mode (JSON formatted reflow.Resources)")
 	flags.BoolVar(&r.Sched, "sched", true, "use scalable scheduler instead of work stealing")
+	flags.StringVar(&r.RepoCache, "repocache", "", "directory in which repository objects are cached locally")
+	flags.IntVar(&r.RepoCacheSize, "repocachesize", 10, "maximum size of the local repository cache (GiB)")
 }
 
 // Err checks if the flag values are consistent and valid.
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -29,14 +30,18 @@ import (
 	"github.com/grailbio/reflow/ec2authenticator"
 	"github.com/grailbio/reflow/ec2cluster"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/events"
 	"github.com/grailbio/reflow/flow"
 	reflowinfra "github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/local"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/notify"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/repository"
 	"github.com/grailbio/reflow/repository/blobrepo"
 	repositoryhttp "github.com/grailbio/reflow/repository/http"
+	"github.com/grailbio/reflow/repository/tieredrepo"
+	"github.com/grailbio/reflow/rest/auth"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/sched"
 	"github.com/grailbio/reflow/taskdb"
@@ -85,7 +90,29 @@ func httpClient(config infra.Config) (*http.Client, error) {
 	if err := http2.ConfigureTransport(transport); err != nil {
 		return nil, err
 	}
-	return &http.Client{Transport: transport}, nil
+	authn, err := authenticator(config)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := auth.ClientTransport(authn, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// authenticator returns the configured REST authenticator, whose
+// client credentials are presented to reflowlets, or nil if none is
+// configured.
+func authenticator(config infra.Config) (auth.Authenticator, error) {
+	var authn auth.Authenticator
+	if err := config.Instance(&authn); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type auth.Authenticator") {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return authn, nil
 }
 
 func clusterInstance(config infra.Config, status *status.Status) (runner.Cluster, error) {
@@ -100,6 +127,13 @@ func clusterInstance(config infra.Config, status *status.Status) (runner.Cluster
 			ec.Status = status.Group("ec2cluster")
 		}
 		ec.Configuration = config
+		authn, err := authenticator(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := ec.ConfigureClient(authn); err != nil {
+			return nil, err
+		}
 	} else {
 		log.Printf("not a ec2cluster! : %v", cerr)
 	}
@@ -138,6 +172,7 @@ func NewScheduler(config infra.Config, wg *wg.WaitGroup, logger *log.Logger, sta
 		tdb     taskdb.TaskDB
 		cluster runner.Cluster
 		repo    reflow.Repository
+		sink    events.Sink
 		limit   int
 	)
 	if err = config.Instance(&logger); err != nil {
@@ -149,6 +184,11 @@ func NewScheduler(config infra.Config, wg *wg.WaitGroup, logger *log.Logger, sta
 		}
 		logger.Debug(err)
 	}
+	if err = config.Instance(&sink); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type events.Sink") {
+			return nil, nil, err
+		}
+	}
 	if cluster, err = clusterInstance(config, status); err != nil {
 		return nil, nil, err
 	}
@@ -165,7 +205,7 @@ func NewScheduler(config infra.Config, wg *wg.WaitGroup, logger *log.Logger, sta
 		Log:              logger,
 	}
 	if repo != nil {
-		transferer.PendingTransfers.Set(repo.URL().String(), int(^uint(0)>>1))
+		transferer.PendingTransfers.SetRepository(repo, int(^uint(0)>>1))
 	}
 	ctx := context.Background()
 	scheduler := sched.New()
@@ -174,6 +214,7 @@ func NewScheduler(config infra.Config, wg *wg.WaitGroup, logger *log.Logger, sta
 	scheduler.Transferer = transferer
 	scheduler.Log = logger.Tee(nil, "scheduler: ")
 	scheduler.TaskDB = tdb
+	scheduler.Events = sink
 	scheduler.ExportStats()
 	mux, err := blobMux(config)
 	if err != nil {
@@ -212,6 +253,13 @@ func NewRunner(runConfig RunConfig, scheduler *sched.Scheduler, logger *log.Logg
 	if err = runConfig.Config.Instance(&repo); err != nil {
 		return nil, err
 	}
+	if dir := runConfig.RunFlags.RepoCache; dir != "" && repo != nil {
+		cache, err := tieredrepo.NewCache(dir, int64(runConfig.RunFlags.RepoCacheSize)<<30, logger.Tee(nil, "repocache: "))
+		if err != nil {
+			return nil, err
+		}
+		repo = &tieredrepo.Repository{Cache: cache, Remote: repo}
+	}
 	if limit, err = transferLimit(runConfig.Config); err != nil {
 		return nil, err
 	}
@@ -223,7 +271,7 @@ func NewRunner(runConfig RunConfig, scheduler *sched.Scheduler, logger *log.Logg
 	}
 	var transferer reflow.Transferer = manager
 	if repo != nil {
-		manager.PendingTransfers.Set(repo.URL().String(), int(^uint(0)>>1))
+		manager.PendingTransfers.SetRepository(repo, int(^uint(0)>>1))
 	}
 	mux, err = blobMux(runConfig.Config)
 	if err != nil {
@@ -292,11 +340,15 @@ type Runner struct {
 	wg          *wg.WaitGroup
 
 	// infra
-	repo    reflow.Repository
-	assoc   assoc.Assoc
-	cache   *reflowinfra.CacheProvider
-	tdb     taskdb.TaskDB
-	cluster runner.Cluster
+	repo     reflow.Repository
+	assoc    assoc.Assoc
+	cache    *reflowinfra.CacheProvider
+	tdb      taskdb.TaskDB
+	cluster  runner.Cluster
+	events   events.Sink
+	notifier notify.Notifier
+	// failed records the idents of failed execs, for notifications.
+	failed *failedIdents
 
 	mux                blob.Mux
 	transferer         reflow.Transferer
@@ -326,6 +378,24 @@ func (r *Runner) initInfra() error {
 			return err
 		}
 	}
+	if err = config.Instance(&r.events); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type events.Sink") {
+			return err
+		}
+	}
+	if err = config.Instance(&r.notifier); err != nil {
+		if !strings.HasPrefix(err.Error(), "no providers for type notify.Notifier") {
+			return err
+		}
+	}
+	if r.notifier != nil {
+		r.failed = new(failedIdents)
+		if r.events != nil {
+			r.events = events.Multi(r.events, r.failed)
+		} else {
+			r.events = r.failed
+		}
+	}
 	return nil
 }
 
@@ -340,6 +410,7 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 		r.Log.Error(err)
 	}
 	r.Log.Printf("run ID: %s", r.RunID.IDShort())
+	start := time.Now()
 	e := Eval{
 		Program: r.runConfig.Program,
 		Args:    r.runConfig.Args,
@@ -401,12 +472,16 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	}
 
 	if r.runConfig.RunFlags.Local {
-		return r.runLocal(ctx, e.Main(), e.MainType(), e.ImageMap, cmdline)
+		state, err := r.runLocal(ctx, e.Main(), e.MainType(), e.ImageMap, cmdline)
+		if err == nil {
+			r.notify(ctx, state, labels, cmdline, start)
+		}
+		return state, err
 	}
 	run := runner.Runner{
 		Flow: e.Main(),
 		EvalConfig: flow.EvalConfig{
-			Log:                r.Log,
+			Log:                r.Log.With(log.RunKey, r.RunID.ID()),
 			Repository:         r.repo,
 			Snapshotter:        r.mux,
 			Assoc:              r.assoc,
@@ -418,12 +493,18 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 			ImageMap:           e.ImageMap,
 			TaskDB:             r.tdb,
 			RunID:              r.RunID,
+			Events:             r.events,
 		},
 		Type:    e.MainType(),
 		Labels:  labels,
 		Cluster: r.cluster,
 		Cmdline: r.cmdline,
 	}
+	if r.notifier != nil {
+		run.Notify = func(ctx context.Context, state runner.State, _ pool.Labels) {
+			r.notify(ctx, state, labels, cmdline, start)
+		}
+	}
 
 	if err = r.runConfig.RunFlags.Configure(&run.EvalConfig); err != nil {
 		return runner.State{}, err
@@ -474,6 +555,171 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	return run.State, nil
 }
 
+// notify sends a summary of the completed run, with state state, to
+// the configured notifier, if any. Notification errors are logged.
+func (r *Runner) notify(ctx context.Context, state runner.State, labels pool.Labels, cmdline string, start time.Time) {
+	if r.notifier == nil {
+		return
+	}
+	if !state.ID.IsValid() {
+		state.ID = r.RunID
+	}
+	n := &runNotifier{
+		Notifier: r.notifier,
+		Config:   r.runConfig.Config,
+		Cluster:  r.cluster,
+		TaskDB:   r.tdb,
+		Repo:     r.repo,
+		Log:      r.Log,
+	}
+	n.Notify(ctx, state, labels, cmdline, start, r.failed.Idents())
+}
+
+// A runNotifier sends summaries of completed runs to a notifier.
+type runNotifier struct {
+	notify.Notifier
+	// Config is the configuration from which the user is determined.
+	Config infra.Config
+	// Cluster, TaskDB, and Repo are used to estimate run costs, if
+	// the cluster is an ec2cluster and a task database is configured.
+	Cluster runner.Cluster
+	TaskDB  taskdb.TaskDB
+	Repo    reflow.Repository
+	// Log logs notification errors.
+	Log *log.Logger
+}
+
+// Notify sends a summary of the completed run, with state state.
+// The run is described by cmdline or, if it is empty, by the
+// program, parameters, and arguments of its state. The run started
+// at start or, if it is zero, at its creation. Failed are the idents
+// of the run's failed execs. Notification errors are logged.
+func (n *runNotifier) Notify(ctx context.Context, state runner.State, labels pool.Labels, cmdline string, start time.Time, failed []string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	if start.IsZero() {
+		start = state.Created
+	}
+	end := state.Completion
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if cmdline == "" {
+		cmdline = stateCmdline(state)
+	}
+	s := notify.Summary{
+		Program:      cmdline,
+		Labels:       labels,
+		Status:       notify.Succeeded,
+		Start:        start,
+		End:          end,
+		Duration:     end.Sub(start).Seconds(),
+		FailedIdents: failed,
+	}
+	if state.ID.IsValid() {
+		s.RunID = state.ID.ID()
+	}
+	var user *reflowinfra.User
+	if err := n.Config.Instance(&user); err == nil {
+		s.User = string(*user)
+	}
+	if state.Err != nil {
+		s.Status = notify.Failed
+		s.Error = state.Err.Error()
+	} else {
+		s.Value = state.Result
+	}
+	if ec, ok := n.Cluster.(*ec2cluster.Cluster); ok && n.TaskDB != nil && state.ID.IsValid() {
+		cost, err := runCost(ctx, n.TaskDB, n.Repo, state.ID, hourlyCost(ec.Region))
+		if err != nil {
+			n.Log.Debugf("estimating run cost: %v", err)
+		}
+		s.Cost = cost
+	}
+	if err := n.Notifier.Notify(ctx, s); err != nil {
+		n.Log.Errorf("notify: %v", err)
+	}
+}
+
+// stateCmdline renders the program, parameters, and arguments of
+// the run with state state as a command line.
+func stateCmdline(state runner.State) string {
+	cmdline := state.Program
+	keys := make([]string, 0, len(state.Params))
+	for key := range state.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmdline += fmt.Sprintf(" -%s=%s", key, state.Params[key])
+	}
+	for _, arg := range state.Args {
+		cmdline += fmt.Sprintf(" %s", arg)
+	}
+	return cmdline
+}
+
+// runCost estimates the cost of the execs of the run with the
+// provided ID, given the hourly cost function cost, from the
+// inspects of the run's tasks.
+func runCost(ctx context.Context, tdb taskdb.TaskDB, repo reflow.Repository, id taskdb.RunID, cost func(reflow.Resources) float64) (float64, error) {
+	tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: id})
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, task := range tasks {
+		if task.Inspect.IsZero() {
+			continue
+		}
+		var inspect reflow.ExecInspect
+		if err := repository.Unmarshal(ctx, repo, task.Inspect, &inspect); err != nil {
+			return total, err
+		}
+		dur := inspect.Runtime()
+		if dur == 0 {
+			dur = task.Keepalive.Sub(task.Start)
+		}
+		total += cost(inspect.Config.Resources) * dur.Hours()
+	}
+	return total, nil
+}
+
+// failedIdents is an events.Sink that records the idents of execs
+// that failed.
+type failedIdents struct {
+	mu     sync.Mutex
+	idents map[string]bool
+}
+
+// Publish implements events.Sink.
+func (f *failedIdents) Publish(e events.Event) {
+	if e.Kind != events.Error || e.Op != flow.Exec.String() {
+		return
+	}
+	f.mu.Lock()
+	if f.idents == nil {
+		f.idents = make(map[string]bool)
+	}
+	f.idents[e.Ident] = true
+	f.mu.Unlock()
+}
+
+// Idents returns the sorted idents of the execs that failed.
+func (f *failedIdents) Idents() []string {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	idents := make([]string, 0, len(f.idents))
+	for ident := range f.idents {
+		idents = append(idents, ident)
+	}
+	sort.Strings(idents)
+	return idents
+}
+
 // UploadBundle generates a bundle and updates taskdb with its digest. If the bundle does not already exist in taskdb,
 // uploadBundle caches it.
 func (r *Runner) uploadBundle(ctx context.Context, repo reflow.Repository, tdb taskdb.TaskDB, runID taskdb.RunID, e Eval, file string, args []string) error {
@@ -558,7 +804,7 @@ func (r *Runner) runLocal(ctx context.Context, f *flow.Flow, typ *types.T, image
 		Executor:           x,
 		Snapshotter:        r.mux,
 		Transferer:         r.transferer,
-		Log:                r.Log,
+		Log:                r.Log.With(log.RunKey, r.RunID.ID()),
 		Repository:         r.repo,
 		Assoc:              r.assoc,
 		AssertionGenerator: r.assertionGenerator,
@@ -567,6 +813,7 @@ func (r *Runner) runLocal(ctx context.Context, f *flow.Flow, typ *types.T, image
 		ImageMap:           imageMap,
 		TaskDB:             r.tdb,
 		RunID:              r.RunID,
+		Events:             r.events,
 	}
 	if err = flags.CommonRunFlags.Configure(&evalConfig); err != nil {
 		return runner.State{}, err
@@ -0,0 +1,100 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/internal/scrub"
+	"golang.org/x/time/rate"
+)
+
+func (c *Cmd) scrub(ctx context.Context, args ...string) {
+	var (
+		flags        = flag.NewFlagSet("scrub", flag.ExitOnError)
+		sampleFlag   = flags.Float64("sample", 1, "fraction of repository objects to verify")
+		rateFlag     = flags.Float64("rate", 100, "maximum repository operations per second (0 for no limit)")
+		repairFlag   = flags.Bool("repair", false, "remove corrupt objects and incomplete fileset mappings so that they are recomputed")
+		progressFlag = flags.String("progress", "", "file in which progress is recorded; an interrupted scrub is resumed from this file")
+		help         = `Scrub checks the integrity of the cache.
+
+Scrub first enumerates the objects in the repository and verifies
+that the contents of each (or, with -sample, of a deterministic
+sample of them) match their digest. It then checks every fileset
+mapping in the assoc, verifying that the fileset and all of the
+objects it refers to are present and uncorrupted.
+
+With -repair, corrupt objects are removed from the repository and
+incomplete fileset mappings are removed from the assoc, so that
+the corresponding execs are recomputed when next needed.
+
+Scrubbing a large repository can take a long time. With -progress,
+scrub periodically records its progress in the provided file; a
+subsequent invocation with the same file resumes where the previous
+one left off. The file is removed once the scrub completes.`
+	)
+	c.Parse(flags, args, help, "scrub [-sample fraction] [-rate ops] [-repair] [-progress file]")
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	if *sampleFlag <= 0 || *sampleFlag > 1 {
+		c.Fatalf("invalid sample fraction %v", *sampleFlag)
+	}
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	var ass assoc.Assoc
+	if err := c.Config.Instance(&ass); err != nil {
+		c.Log.Printf("assoc: %v; skipping fileset mapping checks", err)
+	}
+
+	s := scrub.Scrubber{
+		Repository: repo,
+		Assoc:      ass,
+		Log:        c.Log,
+		Sample:     *sampleFlag,
+		Repair:     *repairFlag,
+	}
+	if *rateFlag > 0 {
+		s.Limiter = rate.NewLimiter(rate.Limit(*rateFlag), 1)
+	}
+	var progress scrub.Progress
+	if path := *progressFlag; path != "" {
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			c.must(json.Unmarshal(b, &progress))
+			c.Log.Printf("resuming scrub from %s: %s", path, progress.Stats)
+		case os.IsNotExist(err):
+		default:
+			c.Fatal(err)
+		}
+		s.Checkpoint = func(p scrub.Progress) error {
+			b, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			tmp := path + ".tmp"
+			if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+				return err
+			}
+			return os.Rename(tmp, path)
+		}
+	}
+	c.must(s.Scrub(ctx, &progress))
+	c.Log.Printf("scrub complete: %s", progress.Stats)
+	if *progressFlag != "" {
+		c.must(os.Remove(*progressFlag))
+	}
+	if progress.Stats.ObjectsCorrupt > progress.Stats.ObjectsDeleted ||
+		progress.Stats.MappingsIncomplete > progress.Stats.MappingsDeleted {
+		c.Exit(1)
+	}
+}
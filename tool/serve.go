@@ -26,6 +26,39 @@ Flag -config defines a configuration filename from which the Reflowlet
 restores its configuration. When run in an automatic cluster configuration,
 the configuration is typically sealed, containing both configuration information
 as well as credentials to access various services.
+
+Reflowlets launched outside of an automatic cluster configuration
+may authenticate their clients through the "auth" configuration key.
+With provider mtls, clients must present certificates issued by a
+local certificate authority, and are identified by their
+certificates' common names:
+
+	auth: mtls,ca=/etc/reflow/ca.pem,cert=/etc/reflow/server.pem,key=/etc/reflow/server.key,admins=alice
+
+With provider tokens, clients present bearer tokens listed, together
+with the users they identify, in a file:
+
+	auth: tokens,file=/etc/reflow/tokens,admins=alice:bob
+
+The token file contains one token and the user it identifies per
+line, separated by whitespace. All authenticated users may make
+nondestructive requests; only the (colon-separated) users listed in
+admins may free allocs, delete or kill execs, unload filesets, or
+collect repositories. Clients of servers that authenticate with
+tokens need not present certificates.
+
+Clients (e.g., reflow run, or the cluster managing the reflowlets)
+present the credentials configured through the same key: a client
+certificate issued by the local authority, or a bearer token read
+from a file:
+
+	auth: mtls,ca=/etc/reflow/ca.pem,clientcert=alice.pem,clientkey=alice.key
+	auth: tokens,token=/home/alice/.reflow/token
+
+Metrics, served at /metrics, are also authenticated. The server
+given by -metricsaddr, which serves only metrics, is neither
+encrypted nor authenticated, so that metrics may be scraped without
+credentials; it should be reachable only from trusted networks.
 `
 	)
 	server := reflowlet.NewServer(c.Version, c.Config)
@@ -0,0 +1,587 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/taskdb"
+	"golang.org/x/sync/errgroup"
+)
+
+// topExec is a running exec displayed by top.
+type topExec struct {
+	execInfo
+	// Alloc is the alloc on which the exec is running.
+	Alloc pool.Alloc
+	// Run and User are the run to which the exec belongs and its
+	// user, if the exec's task is recorded in the task database.
+	Run  taskdb.RunID
+	User string
+}
+
+// topAlloc is an alloc displayed by top, together with the total
+// usage of its running execs.
+type topAlloc struct {
+	pool.AllocInspect
+	Execs int
+	Used  reflow.Resources
+}
+
+// topRun is a run displayed by top, together with its progress.
+type topRun struct {
+	taskdb.Run
+	Done, Total int
+}
+
+// topSnapshot is the state of the cluster at a point in time.
+type topSnapshot struct {
+	Time   time.Time
+	Allocs []topAlloc
+	Runs   []topRun
+	Execs  []topExec
+}
+
+// topFilter restricts the runs and execs displayed by top.
+type topFilter struct {
+	// User restricts runs and execs to those of the named user.
+	User string
+	// Run restricts runs and execs to those of runs whose IDs begin
+	// with the provided prefix.
+	Run string
+}
+
+func (f topFilter) String() string {
+	var filters []string
+	if f.User != "" {
+		filters = append(filters, "user="+f.User)
+	}
+	if f.Run != "" {
+		filters = append(filters, "run="+f.Run)
+	}
+	if len(filters) == 0 {
+		return "none"
+	}
+	return strings.Join(filters, " ")
+}
+
+func (f topFilter) matchRun(id taskdb.RunID, user string) bool {
+	if f.User != "" && user != f.User {
+		return false
+	}
+	return f.Run == "" || strings.HasPrefix(id.ID(), f.Run)
+}
+
+func (f topFilter) matchExec(x topExec) bool {
+	user := x.User
+	if user == "" {
+		// Execs that are not recorded in the task database are
+		// attributed to the owners of their allocs.
+		user = strings.SplitN(x.execInfo.Alloc.Meta.Owner, "@", 2)[0]
+	}
+	return f.matchRun(x.Run, user)
+}
+
+// execs returns the snapshot's execs that match the filter f.
+func (s topSnapshot) execs(f topFilter) []topExec {
+	var execs []topExec
+	for _, x := range s.Execs {
+		if f.matchExec(x) {
+			execs = append(execs, x)
+		}
+	}
+	return execs
+}
+
+// render writes a screen of at most height lines displaying the
+// snapshot s, filtered by f, with the exec at index sel selected.
+func (s topSnapshot) render(w io.Writer, f topFilter, sel, height int) {
+	var (
+		b     bytes.Buffer
+		tw    tabwriter.Writer
+		execs = s.execs(f)
+	)
+	fmt.Fprintf(&b, "reflow top - %s - %d allocs, %d running execs - filter: %s\n",
+		s.Time.Local().Format(time.Kitchen), len(s.Allocs), len(execs), f)
+	b.WriteString("keys: j/k select, u user, r run, c clear, l logs, K kill, q quit\n\n")
+
+	tw.Init(&b, 4, 4, 1, ' ', 0)
+	fmt.Fprint(&tw, "alloc\towner\texecs\tcpu\t\tmem\t\tdisk\n")
+	for _, a := range s.Allocs {
+		fmt.Fprintf(&tw, "%s\t%s\t%d\t%s\t%.1f/%.0f\t%s\t%s/%s\t%s\n",
+			a.ID, a.Meta.Owner, a.Execs,
+			gauge(a.Used["cpu"], a.Resources["cpu"]), a.Used["cpu"], a.Resources["cpu"],
+			gauge(a.Used["mem"], a.Resources["mem"]), data.Size(a.Used["mem"]), data.Size(a.Resources["mem"]),
+			data.Size(a.Used["disk"]))
+	}
+	tw.Flush()
+	b.WriteString("\n")
+
+	tw.Init(&b, 4, 4, 1, ' ', 0)
+	fmt.Fprint(&tw, "run\tuser\tstarted\tprogress\t\n")
+	for _, r := range s.Runs {
+		if !f.matchRun(r.ID, r.User) {
+			continue
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\t%s\t%d/%d tasks\n",
+			r.ID.IDShort(), r.User, r.Start.Local().Format(time.Kitchen),
+			gauge(float64(r.Done), float64(r.Total)), r.Done, r.Total)
+	}
+	tw.Flush()
+	b.WriteString("\n")
+
+	tw.Init(&b, 4, 4, 1, ' ', 0)
+	fmt.Fprint(&tw, "\texec\tident\trun\tduration\tcpu\tmem\tdisk\n")
+	for i, x := range execs {
+		mark := " "
+		if i == sel {
+			mark = ">"
+		}
+		var run string
+		if !x.Run.IsValid() {
+			run = "-"
+		} else {
+			run = x.Run.IDShort()
+		}
+		// Docker's timestamps are complete only once an exec has
+		// finished, so the duration of running execs is computed from
+		// their creation time.
+		dur := s.Time.Sub(x.Created)
+		if dur < 0 || x.Created.IsZero() {
+			dur = 0
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\t%s\t%d:%02d\t%.1f\t%s\t%s\n",
+			mark, x.ID.Short(), x.Config.Ident, run,
+			int(dur.Hours()), int(dur.Minutes())%60,
+			x.Gauges["cpu"], data.Size(x.Gauges["mem"]), data.Size(x.Gauges["disk"]+x.Gauges["tmp"]))
+	}
+	tw.Flush()
+
+	lines := strings.SplitAfter(b.String(), "\n")
+	if height > 0 && len(lines) > height {
+		lines = lines[:height]
+	}
+	io.WriteString(w, strings.Join(lines, ""))
+}
+
+// gauge renders the fraction used/total as a bar.
+func gauge(used, total float64) string {
+	const width = 10
+	var n int
+	if total > 0 {
+		n = int(used/total*width + 0.5)
+	}
+	if n > width {
+		n = width
+	}
+	return "[" + strings.Repeat("|", n) + strings.Repeat(" ", width-n) + "]"
+}
+
+// topSnapshot retrieves the current state of the cluster's allocs,
+// their running execs, and, if a task database is configured, the
+// runs that were active within the provided duration.
+func (c *Cmd) topSnapshot(ctx context.Context, cluster pool.Pool, tdb taskdb.TaskDB, since time.Duration) topSnapshot {
+	snap := topSnapshot{Time: time.Now()}
+	allocsCtx, allocsCancel := context.WithTimeout(ctx, 5*time.Second)
+	allocs := pool.Allocs(allocsCtx, cluster, c.Log)
+	allocsCancel()
+	var (
+		inspects = make([]pool.AllocInspect, len(allocs))
+		infos    = make([][]execInfo, len(allocs))
+		g, gctx  = errgroup.WithContext(ctx)
+	)
+	for i := range allocs {
+		i, alloc := i, allocs[i]
+		g.Go(func() error {
+			ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+			defer cancel()
+			var err error
+			if inspects[i], err = alloc.Inspect(ctx); err != nil {
+				c.Log.Debugf("inspect %s: %v", alloc.ID(), err)
+				return nil
+			}
+			execs, err := alloc.Execs(ctx)
+			if err != nil {
+				c.Log.Debugf("execs %s: %v", alloc.ID(), err)
+				return nil
+			}
+			infos[i] = c.execInfos(ctx, execs)
+			return nil
+		})
+	}
+	// Runs and their tasks are retrieved concurrently with the allocs.
+	var tasks []taskdb.Task
+	if tdb != nil {
+		g.Go(func() error {
+			ctx, cancel := context.WithTimeout(gctx, 10*time.Second)
+			defer cancel()
+			runs, err := tdb.Runs(ctx, taskdb.RunQuery{Since: time.Now().Add(-since)})
+			if err != nil {
+				c.Log.Debugf("runs: %v", err)
+				return nil
+			}
+			runTasks := make([][]taskdb.Task, len(runs))
+			rg, rctx := errgroup.WithContext(ctx)
+			for i := range runs {
+				i := i
+				rg.Go(func() error {
+					var err error
+					if runTasks[i], err = tdb.Tasks(rctx, taskdb.TaskQuery{RunID: runs[i].ID}); err != nil {
+						c.Log.Debugf("tasks %s: %v", runs[i].ID.IDShort(), err)
+					}
+					return nil
+				})
+			}
+			rg.Wait()
+			for i, run := range runs {
+				r := topRun{Run: run, Total: len(runTasks[i])}
+				for _, task := range runTasks[i] {
+					if !task.ResultID.IsZero() {
+						r.Done++
+					}
+				}
+				snap.Runs = append(snap.Runs, r)
+				tasks = append(tasks, runTasks[i]...)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	users := make(map[taskdb.RunID]string)
+	for _, r := range snap.Runs {
+		users[r.ID] = r.User
+	}
+	runs := make(map[string]taskdb.RunID)
+	for _, task := range tasks {
+		runs[task.URI] = task.RunID
+	}
+	for i, inspect := range inspects {
+		if inspect.ID == "" {
+			continue
+		}
+		a := topAlloc{AllocInspect: inspect, Used: make(reflow.Resources)}
+		for _, info := range infos[i] {
+			if info.State != "running" && info.State != "initializing" {
+				continue
+			}
+			info.Alloc = inspect
+			a.Execs++
+			a.Used["cpu"] += info.Gauges["cpu"]
+			a.Used["mem"] += info.Gauges["mem"]
+			a.Used["disk"] += info.Gauges["disk"] + info.Gauges["tmp"]
+			run := runs[info.URI]
+			snap.Execs = append(snap.Execs, topExec{execInfo: info, Alloc: allocs[i], Run: run, User: users[run]})
+		}
+		snap.Allocs = append(snap.Allocs, a)
+	}
+	sort.Slice(snap.Allocs, func(i, j int) bool { return snap.Allocs[i].ID < snap.Allocs[j].ID })
+	sort.Slice(snap.Runs, func(i, j int) bool { return snap.Runs[i].Start.Before(snap.Runs[j].Start) })
+	sort.Slice(snap.Execs, func(i, j int) bool { return snap.Execs[i].Created.Before(snap.Execs[j].Created) })
+	return snap
+}
+
+func (c *Cmd) top(ctx context.Context, args ...string) {
+	var (
+		flags        = flag.NewFlagSet("top", flag.ExitOnError)
+		intervalFlag = flags.Duration("n", 5*time.Second, "refresh interval")
+		sinceFlag    = flags.Duration("since", 10*time.Minute, "display runs that were active within this duration")
+		userFlag     = flags.String("u", "", "display only the runs and execs of this user")
+		runFlag      = flags.String("run", "", "display only the execs of the run with this ID (prefix)")
+		help         = `Top displays a continuously refreshed, full-screen view of the
+cluster's allocs, the runs that were recently active, and the execs
+that are currently running.
+
+For each alloc, top displays the alloc's owner, its number of
+running execs, and its utilization: the CPU, memory, and disk usage
+of its running execs, as reported by their live gauges, relative to
+the alloc's resources. For each run (displayed only if a task
+database is configured), top displays its progress: the number of
+its tasks that have completed. For each exec, top displays its live
+CPU, memory, and disk usage.
+
+Top responds to the following keys:
+
+	j, k (or down, up)  select the next (previous) exec
+	u                   filter runs and execs by user
+	r                   filter runs and execs by run ID
+	c                   clear filters
+	l                   view the logs of the selected exec
+	K                   kill the selected exec
+	q                   quit
+
+Top requires a terminal.`
+	)
+	c.Parse(flags, args, help, "top [-n interval] [-since duration] [-u user] [-run runid]")
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil {
+		c.Log.Debug(err)
+	}
+	cluster := c.Cluster(nil)
+	term, err := newTopTerm()
+	if err != nil {
+		c.Fatalf("top requires a terminal: %v", err)
+	}
+	defer term.Close()
+
+	ui := &topUI{
+		c:      c,
+		term:   term,
+		filter: topFilter{User: *userFlag, Run: *runFlag},
+	}
+	refresh := func() { ui.snap = c.topSnapshot(ctx, cluster, tdb, *sinceFlag) }
+	refresh()
+	ui.draw()
+	ticker := time.NewTicker(*intervalFlag)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		case key, ok := <-term.keys:
+			if !ok || !ui.key(ctx, key) {
+				return
+			}
+		}
+		ui.draw()
+	}
+}
+
+// topUI maintains the interactive state of top.
+type topUI struct {
+	c      *Cmd
+	term   *topTerm
+	snap   topSnapshot
+	filter topFilter
+	sel    int
+	// message is displayed in place of the key help, until the next
+	// key is pressed.
+	message string
+}
+
+func (u *topUI) draw() {
+	execs := u.snap.execs(u.filter)
+	if u.sel >= len(execs) {
+		u.sel = len(execs) - 1
+	}
+	if u.sel < 0 {
+		u.sel = 0
+	}
+	var b bytes.Buffer
+	u.snap.render(&b, u.filter, u.sel, u.term.height())
+	screen := b.String()
+	if u.message != "" {
+		lines := strings.SplitN(screen, "\n", 3)
+		if len(lines) == 3 {
+			screen = lines[0] + "\n" + u.message + "\n" + lines[2]
+		}
+	}
+	u.term.draw(screen)
+}
+
+func (u *topUI) selected() (topExec, bool) {
+	execs := u.snap.execs(u.filter)
+	if u.sel < 0 || u.sel >= len(execs) {
+		return topExec{}, false
+	}
+	return execs[u.sel], true
+}
+
+// key handles a key press, returning false if top should exit.
+func (u *topUI) key(ctx context.Context, key string) bool {
+	u.message = ""
+	switch key {
+	case "q", "\x03":
+		return false
+	case "j", "\x1b[B":
+		u.sel++
+	case "k", "\x1b[A":
+		u.sel--
+	case "u":
+		u.filter.User = u.term.prompt("user: ")
+		u.sel = 0
+	case "r":
+		u.filter.Run = u.term.prompt("run: ")
+		u.sel = 0
+	case "c":
+		u.filter = topFilter{}
+		u.sel = 0
+	case "l":
+		x, ok := u.selected()
+		if !ok {
+			break
+		}
+		u.logs(ctx, x)
+	case "K":
+		x, ok := u.selected()
+		if !ok {
+			break
+		}
+		if answer := u.term.prompt(fmt.Sprintf("kill exec %s (%s)? [y/N] ", x.ID.Short(), x.Config.Ident)); answer != "y" {
+			break
+		}
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := x.Alloc.Remove(ctx, x.ID)
+		cancel()
+		if err != nil {
+			u.message = fmt.Sprintf("kill %s: %v", x.ID.Short(), err)
+		} else {
+			u.message = fmt.Sprintf("killed %s", x.ID.Short())
+		}
+	}
+	return true
+}
+
+// logs displays the tail of the logs of exec x until a key is
+// pressed.
+func (u *topUI) logs(ctx context.Context, x topExec) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	exec, err := x.Alloc.Get(ctx, x.ID)
+	if err != nil {
+		u.message = fmt.Sprintf("logs %s: %v", x.ID.Short(), err)
+		return
+	}
+	rc, err := exec.Logs(ctx, true, true, false)
+	if err != nil {
+		u.message = fmt.Sprintf("logs %s: %v", x.ID.Short(), err)
+		return
+	}
+	defer rc.Close()
+	height := u.term.height()
+	if height <= 0 {
+		height = 24
+	}
+	var lines []string
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > height-2 {
+			lines = lines[1:]
+		}
+	}
+	header := fmt.Sprintf("logs of exec %s (%s) - press any key to return\n\n", x.ID.Short(), x.Config.Ident)
+	u.term.draw(header + strings.Join(lines, "\n"))
+	<-u.term.keys
+}
+
+// topTerm is a full-screen terminal, in which keys are read as they
+// are pressed.
+type topTerm struct {
+	out   *os.File
+	state string
+	keys  chan string
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// newTopTerm puts the terminal in cbreak mode and switches to its
+// alternate screen.
+func newTopTerm() (*topTerm, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return nil, err
+	}
+	t := &topTerm{out: os.Stdout, state: state, keys: make(chan string)}
+	// Switch to the alternate screen and hide the cursor.
+	io.WriteString(t.out, "\x1b[?1049h\x1b[?25l")
+	go t.read()
+	return t, nil
+}
+
+// read reads keys from the terminal. Escape sequences (e.g., for
+// arrow keys) are delivered as single keys.
+func (t *topTerm) read() {
+	defer close(t.keys)
+	var buf [16]byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil {
+			return
+		}
+		t.keys <- string(buf[:n])
+	}
+}
+
+// height returns the height of the terminal, or 0 if it cannot be
+// determined.
+func (t *topTerm) height() int {
+	size, err := stty("size")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return 0
+	}
+	height, _ := strconv.Atoi(fields[0])
+	return height
+}
+
+// draw replaces the contents of the screen.
+func (t *topTerm) draw(screen string) {
+	io.WriteString(t.out, "\x1b[H\x1b[2J"+strings.Replace(screen, "\n", "\r\n", -1))
+}
+
+// prompt reads a line of input, displayed on the last line of the
+// screen.
+func (t *topTerm) prompt(prompt string) string {
+	var input []byte
+	for {
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K%s%s", t.height(), prompt, input)
+		key, ok := <-t.keys
+		if !ok {
+			return string(input)
+		}
+		switch key {
+		case "\r", "\n":
+			return string(input)
+		case "\x1b", "\x03":
+			return ""
+		case "\x7f", "\b":
+			if len(input) > 0 {
+				input = input[:len(input)-1]
+			}
+		default:
+			if len(key) == 1 && key[0] >= ' ' {
+				input = append(input, key...)
+			}
+		}
+	}
+}
+
+// Close restores the terminal's screen and mode.
+func (t *topTerm) Close() {
+	io.WriteString(t.out, "\x1b[?25h\x1b[?1049l")
+	stty(t.state)
+}
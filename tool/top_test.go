@@ -0,0 +1,117 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func TestTopRender(t *testing.T) {
+	now := time.Date(2020, 3, 18, 12, 0, 0, 0, time.UTC)
+	var (
+		run1 = taskdb.Run{ID: taskdb.NewRunID(), User: "alice", Start: now.Add(-time.Hour)}
+		run2 = taskdb.Run{ID: taskdb.NewRunID(), User: "bob", Start: now.Add(-time.Minute)}
+		a    = pool.AllocInspect{
+			ID:        "alloc1",
+			Resources: reflow.Resources{"cpu": 8, "mem": 32 << 30},
+			Meta:      pool.AllocMeta{Owner: "alice@example.com"},
+		}
+		x1 = topExec{
+			execInfo: execInfo{
+				ID:    reflow.Digester.FromString("exec1"),
+				Alloc: a,
+				ExecInspect: reflow.ExecInspect{
+					Created: now.Add(-90 * time.Minute),
+					Config:  reflow.ExecConfig{Ident: "align"},
+					Gauges:  reflow.Gauges{"cpu": 4, "mem": 16 << 30},
+				},
+			},
+			Run:  run1.ID,
+			User: run1.User,
+		}
+		x2 = topExec{
+			execInfo: execInfo{
+				ID:    reflow.Digester.FromString("exec2"),
+				Alloc: a,
+				ExecInspect: reflow.ExecInspect{
+					Created: now.Add(-time.Minute),
+					Config:  reflow.ExecConfig{Ident: "sort"},
+				},
+			},
+			Run:  run2.ID,
+			User: run2.User,
+		}
+		// x3 is not recorded in the task database, and so is
+		// attributed to the alloc's owner.
+		x3 = topExec{
+			execInfo: execInfo{
+				ID:          reflow.Digester.FromString("exec3"),
+				Alloc:       a,
+				ExecInspect: reflow.ExecInspect{Config: reflow.ExecConfig{Ident: "orphan"}},
+			},
+		}
+	)
+	snap := topSnapshot{
+		Time:   now,
+		Allocs: []topAlloc{{AllocInspect: a, Execs: 3, Used: reflow.Resources{"cpu": 4, "mem": 16 << 30}}},
+		Runs:   []topRun{{Run: run1, Done: 3, Total: 4}, {Run: run2, Total: 1}},
+		Execs:  []topExec{x1, x2, x3},
+	}
+	for _, c := range []struct {
+		filter topFilter
+		want   []string
+	}{
+		{topFilter{}, []string{"align", "sort", "orphan"}},
+		{topFilter{User: "alice"}, []string{"align", "orphan"}},
+		{topFilter{User: "bob"}, []string{"sort"}},
+		{topFilter{Run: run2.ID.ID()[:8]}, []string{"sort"}},
+	} {
+		var idents []string
+		for _, x := range snap.execs(c.filter) {
+			idents = append(idents, x.Config.Ident)
+		}
+		if got, want := strings.Join(idents, ","), strings.Join(c.want, ","); got != want {
+			t.Errorf("filter %s: got %v, want %v", c.filter, got, want)
+		}
+	}
+
+	var b bytes.Buffer
+	snap.render(&b, topFilter{User: "alice"}, 1, 0)
+	screen := b.String()
+	for _, want := range []string{
+		"filter: user=alice",
+		"alloc1",
+		"[|||||     ]",
+		"4.0/8",
+		run1.ID.IDShort(),
+		"[||||||||  ]",
+		"3/4 tasks",
+		"1:30",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen does not contain %q:\n%s", want, screen)
+		}
+	}
+	for _, line := range strings.Split(screen, "\n") {
+		if strings.HasPrefix(line, ">") && !strings.Contains(line, x3.ID.Short()) {
+			t.Errorf("selected %q, want %s", line, x3.ID.Short())
+		}
+	}
+	if strings.Contains(screen, run2.ID.IDShort()) {
+		t.Errorf("screen contains filtered run %s:\n%s", run2.ID.IDShort(), screen)
+	}
+	b.Reset()
+	snap.render(&b, topFilter{}, 0, 5)
+	if got, want := strings.Count(b.String(), "\n"), 5; got != want {
+		t.Errorf("got %v lines, want %v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"flag"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/trace/chrometrace"
+)
+
+func (c *Cmd) traceCmd(ctx context.Context, args ...string) {
+	var (
+		flags   = flag.NewFlagSet("trace", flag.ExitOnError)
+		outFlag = flags.String("o", "", "write the trace to this file instead of standard output")
+		help    = `Trace reconstructs the timeline of a run from the task database,
+writing it as a Chrome trace-event file that may be viewed in
+Perfetto (https://ui.perfetto.dev) or chrome://tracing.
+
+Each of the run's tasks is displayed on its own track, grouped by
+the alloc on which it ran. Where the task's exec inspect was
+recorded, the spans of its phases are nested within the task's
+span: staging (pulling the exec's image and staging its inputs),
+running, and uploading (installing its outputs). Task end times
+are approximated by their last keepalives.
+
+Runs may also be traced as they are evaluated by configuring the
+chrometrace tracer.`
+	)
+	c.Parse(flags, args, help, "trace [-o path] runid")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	arg := flags.Arg(0)
+	id, err := reflow.Digester.Parse(arg)
+	if err != nil {
+		c.Fatalf("parse %s: %v", arg, err)
+	}
+	var tdb taskdb.TaskDB
+	c.must(c.Config.Instance(&tdb))
+	var repo reflow.Repository
+	if err := c.Config.Instance(&repo); err != nil {
+		c.Log.Debug("repository: ", err)
+	}
+	runs, err := tdb.Runs(ctx, taskdb.RunQuery{ID: taskdb.RunID(id)})
+	c.must(err)
+	if len(runs) == 0 {
+		c.Fatalf("run %s not found", arg)
+	}
+	tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: runs[0].ID})
+	c.must(err)
+	inspects := make(map[taskdb.TaskID]reflow.ExecInspect)
+	for _, task := range tasks {
+		if repo == nil || task.Inspect.IsZero() {
+			continue
+		}
+		var inspect reflow.ExecInspect
+		if err := repository.Unmarshal(ctx, repo, task.Inspect, &inspect); err != nil {
+			c.Log.Errorf("task %s (%s): inspect %s: %v", task.ID.IDShort(), task.Ident, task.Inspect.Short(), err)
+			continue
+		}
+		inspects[task.ID] = inspect
+	}
+	t := runTrace(runs[0], tasks, inspects)
+	if *outFlag != "" {
+		c.must(t.WriteFile(*outFlag))
+		return
+	}
+	c.must(t.Encode(c.Stdout))
+}
+
+// runTrace reconstructs the timeline of the provided run from its
+// tasks and, where available, their exec inspects.
+func runTrace(run taskdb.Run, tasks []taskdb.Task, inspects map[taskdb.TaskID]reflow.ExecInspect) *chrometrace.Trace {
+	t := new(chrometrace.Trace)
+	pid := t.Process("run " + run.ID.IDShort())
+	t.Span(pid, t.Track(pid, "run"), "Run", run.ID.IDShort(), run.Start, run.Keepalive,
+		map[string]interface{}{"id": run.ID.ID(), "user": run.User})
+
+	tasks = append([]taskdb.Task(nil), tasks...)
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Start.Before(tasks[j].Start) })
+	for _, task := range tasks {
+		inspect, ok := inspects[task.ID]
+		start, end := task.Start, task.Keepalive
+		if ok && !inspect.Created.IsZero() && inspect.Created.Before(start) {
+			start = inspect.Created
+		}
+		// Tasks are grouped by the alloc on which they ran; task
+		// URIs are of the form alloc/exec.
+		alloc := path.Dir(task.URI)
+		if task.URI == "" || alloc == "." {
+			alloc = "(unknown alloc)"
+		}
+		pid := t.Process("alloc " + alloc)
+		name := task.Ident
+		if name == "" {
+			name = task.ID.IDShort()
+		}
+		tid := t.Track(pid, name)
+		args := map[string]interface{}{
+			"id":   task.ID.ID(),
+			"flow": task.FlowID.String(),
+			"uri":  task.URI,
+		}
+		if ok {
+			args["type"] = inspect.Config.Type
+			args["image"] = inspect.Config.Image
+			args["state"] = inspect.State
+		}
+		t.Span(pid, tid, "Exec", name, start, end, args)
+		if !ok {
+			continue
+		}
+		for _, phase := range inspect.Phases(end) {
+			t.Span(pid, tid, "Phase", phase.Name, phase.Start, minTime(phase.End, end), nil)
+		}
+	}
+	return t
+}
+
+func minTime(t, u time.Time) time.Time {
+	if t.Before(u) {
+		return t
+	}
+	return u
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"testing"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func TestRunTrace(t *testing.T) {
+	const dockerFmt = "2006-01-02T15:04:05.999999999Z"
+	var (
+		start  = time.Date(2020, 3, 18, 12, 0, 0, 0, time.UTC)
+		run    = taskdb.Run{ID: taskdb.NewRunID(), Start: start, Keepalive: start.Add(time.Hour)}
+		exec   = taskdb.Task{ID: taskdb.NewTaskID(), Ident: "align", URI: "alloc1/exec1", Start: start.Add(time.Minute), Keepalive: start.Add(30 * time.Minute)}
+		intern = taskdb.Task{ID: taskdb.NewTaskID(), Ident: "intern", URI: "alloc2/exec2", Start: start, Keepalive: start.Add(time.Minute)}
+	)
+	inspect := reflow.ExecInspect{
+		Created: start.Add(time.Minute),
+		Docker: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			Created: start.Add(5 * time.Minute).Format(dockerFmt),
+			State: &types.ContainerState{
+				StartedAt:  start.Add(5 * time.Minute).Format(dockerFmt),
+				FinishedAt: start.Add(25 * time.Minute).Format(dockerFmt),
+			},
+		}},
+	}
+	tr := runTrace(run, []taskdb.Task{exec, intern}, map[taskdb.TaskID]reflow.ExecInspect{exec.ID: inspect})
+	durs := make(map[string]time.Duration)
+	pids := make(map[string]int)
+	for _, e := range tr.Events {
+		if e.Ph == "X" {
+			durs[e.Name] = time.Duration(e.Dur) * time.Microsecond
+			pids[e.Name] = e.Pid
+		}
+	}
+	for name, want := range map[string]time.Duration{
+		"align":     29 * time.Minute,
+		"intern":    time.Minute,
+		"staging":   4 * time.Minute,
+		"running":   20 * time.Minute,
+		"uploading": 5 * time.Minute,
+	} {
+		if got := durs[name]; got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	// Tasks are grouped by alloc.
+	if pids["align"] == pids["intern"] {
+		t.Error("tasks on different allocs share a process")
+	}
+	if pids["align"] != pids["running"] {
+		t.Error("phase is not in its task's process")
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package chrometrace implements a Reflow tracer that writes run
+// timelines to local files in the Chrome trace-event format [1],
+// which may be viewed in Perfetto (https://ui.perfetto.dev) or
+// chrome://tracing without any tracing backend.
+//
+// Each exec is displayed on its own track; the spans of the
+// exec's phases (staging, running, uploading) and of its cache
+// transfers are nested within the exec's span.
+//
+// [1] https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+package chrometrace
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/trace"
+)
+
+func init() {
+	infra.Register("chrometrace", new(Tracer))
+}
+
+// Event is a trace event. Only complete ("X") and metadata ("M")
+// events are used.
+type Event struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat,omitempty"`
+	Ph   string `json:"ph"`
+	// Ts and Dur are the event's timestamp and duration, in
+	// microseconds.
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// A Trace is a set of trace events, organized into processes, each
+// of which comprises a set of tracks.
+type Trace struct {
+	Events []Event
+
+	procs  map[string]int
+	tracks map[int]int
+}
+
+// Process returns the ID of the named process, creating it if
+// necessary.
+func (t *Trace) Process(name string) int {
+	if t.procs == nil {
+		t.procs = make(map[string]int)
+		t.tracks = make(map[int]int)
+	}
+	if pid, ok := t.procs[name]; ok {
+		return pid
+	}
+	pid := len(t.procs) + 1
+	t.procs[name] = pid
+	t.Events = append(t.Events, Event{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  pid,
+		Args: map[string]interface{}{"name": name},
+	})
+	return pid
+}
+
+// Track creates a new named track in the process pid, returning its
+// ID.
+func (t *Trace) Track(pid int, name string) int {
+	t.tracks[pid]++
+	tid := t.tracks[pid]
+	t.Events = append(t.Events, Event{
+		Name: "thread_name",
+		Ph:   "M",
+		Pid:  pid,
+		Tid:  tid,
+		Args: map[string]interface{}{"name": name},
+	}, Event{
+		Name: "thread_sort_index",
+		Ph:   "M",
+		Pid:  pid,
+		Tid:  tid,
+		Args: map[string]interface{}{"sort_index": tid},
+	})
+	return tid
+}
+
+// Span adds a span of the given category and name to the track tid
+// of process pid. Spans on a track must nest.
+func (t *Trace) Span(pid, tid int, cat, name string, start, end time.Time, args map[string]interface{}) {
+	dur := end.Sub(start).Nanoseconds() / 1e3
+	if dur <= 0 {
+		// Zero-duration complete events are not displayed.
+		dur = 1
+	}
+	t.Events = append(t.Events, Event{
+		Name: name,
+		Cat:  cat,
+		Ph:   "X",
+		Ts:   start.UnixNano() / 1e3,
+		Dur:  dur,
+		Pid:  pid,
+		Tid:  tid,
+		Args: args,
+	})
+}
+
+// Encode writes the trace to w in the JSON object format. Span
+// events are written in order of their start times; longer spans
+// precede the spans they contain.
+func (t *Trace) Encode(w io.Writer) error {
+	events := append([]Event(nil), t.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if (events[i].Ph == "M") != (events[j].Ph == "M") {
+			return events[i].Ph == "M"
+		}
+		if events[i].Ts != events[j].Ts {
+			return events[i].Ts < events[j].Ts
+		}
+		return events[i].Dur > events[j].Dur
+	})
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []Event `json:"traceEvents"`
+		DisplayTimeUnit string  `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
+
+// WriteFile writes the trace to the provided path. The file is
+// replaced atomically, so that readers never observe a partial
+// trace.
+func (t *Trace) WriteFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	if err := t.Encode(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+type key int
+
+const spanKey key = 0
+
+// A span is a span whose end has not yet been traced.
+type span struct {
+	kind     trace.Kind
+	name     string
+	start    time.Time
+	pid, tid int
+
+	mu   sync.Mutex
+	args map[string]interface{}
+}
+
+// Tracer is a reflow tracer that writes a Chrome trace-event file.
+// Spans are written when they end; the file is rewritten on each
+// flush.
+type Tracer struct {
+	// Path is the path of the trace file.
+	Path string
+
+	mu    sync.Mutex
+	trace Trace
+	// runs is the number of traced runs.
+	runs int
+}
+
+// Help implements infra.Provider.
+func (*Tracer) Help() string {
+	return "configure a tracer that writes Chrome trace-event files, for viewing in Perfetto or chrome://tracing"
+}
+
+// Flags implements infra.Provider.
+func (t *Tracer) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&t.Path, "path", "reflow.trace.json", "path of the trace file")
+}
+
+// Init implements infra.Provider.
+func (t *Tracer) Init() error {
+	if t.Path == "" {
+		return errors.E(errors.Invalid, errors.New("chrometrace: missing path"))
+	}
+	return nil
+}
+
+// Emit implements trace.Tracer.
+func (t *Tracer) Emit(ctx context.Context, e trace.Event) (context.Context, error) {
+	switch e.Kind {
+	case trace.StartEvent:
+		s := &span{kind: e.SpanKind, name: e.Name, start: e.Time}
+		parent, _ := ctx.Value(spanKey).(*span)
+		t.mu.Lock()
+		switch {
+		case e.SpanKind == trace.Run:
+			// Each run is a process.
+			t.runs++
+			s.pid = t.trace.Process(fmt.Sprintf("run %d: %s", t.runs, e.Name))
+			s.tid = t.trace.Track(s.pid, "run")
+		case parent == nil:
+			s.pid = t.trace.Process("reflow")
+			s.tid = t.trace.Track(s.pid, e.Name)
+		case e.SpanKind == trace.Exec:
+			// Each exec has its own track.
+			s.pid = parent.pid
+			s.tid = t.trace.Track(s.pid, e.Name)
+		default:
+			s.pid, s.tid = parent.pid, parent.tid
+		}
+		t.mu.Unlock()
+		return context.WithValue(ctx, spanKey, s), nil
+	case trace.EndEvent:
+		s, _ := ctx.Value(spanKey).(*span)
+		if s == nil {
+			return ctx, fmt.Errorf("event not found %+v", e.Id)
+		}
+		s.mu.Lock()
+		args := s.args
+		s.mu.Unlock()
+		if args == nil {
+			args = make(map[string]interface{})
+		}
+		args["id"] = e.Id.String()
+		t.mu.Lock()
+		t.trace.Span(s.pid, s.tid, s.kind.String(), s.name, s.start, e.Time, args)
+		t.mu.Unlock()
+		return ctx, nil
+	case trace.NoteEvent:
+		s, _ := ctx.Value(spanKey).(*span)
+		if s == nil {
+			return ctx, fmt.Errorf("no current span")
+		}
+		s.mu.Lock()
+		if s.args == nil {
+			s.args = make(map[string]interface{})
+		}
+		s.args[e.Key] = e.Value
+		s.mu.Unlock()
+		return ctx, nil
+	}
+	return ctx, nil
+}
+
+// Flush writes the spans traced so far to the trace file. It
+// implements trace.Flusher.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.trace.WriteFile(t.Path)
+}
+
+// WriteHTTPContext implements trace.Tracer. Traces are local, and
+// are not propagated.
+func (*Tracer) WriteHTTPContext(context.Context, *http.Header) {}
+
+// ReadHTTPContext implements trace.Tracer.
+func (*Tracer) ReadHTTPContext(ctx context.Context, h http.Header) context.Context {
+	return ctx
+}
+
+// CopyTraceContext implements trace.Tracer.
+func (*Tracer) CopyTraceContext(src, dst context.Context) context.Context {
+	if s, ok := src.Value(spanKey).(*span); ok {
+		return context.WithValue(dst, spanKey, s)
+	}
+	return dst
+}
+
+// URL implements trace.Tracer.
+func (t *Tracer) URL(context.Context) string {
+	path, err := filepath.Abs(t.Path)
+	if err != nil {
+		path = t.Path
+	}
+	return "file://" + path
+}
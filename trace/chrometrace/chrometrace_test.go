@@ -0,0 +1,105 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package chrometrace
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/trace"
+)
+
+func readTrace(t *testing.T, path string) []Event {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		TraceEvents []Event `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+	return file.TraceEvents
+}
+
+func TestTracer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chrometrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tracer := &Tracer{Path: filepath.Join(dir, "trace.json")}
+	ctx := trace.WithTracer(context.Background(), tracer)
+
+	id := reflow.Digester.FromString("id")
+	runCtx, runDone := trace.Start(ctx, trace.Run, id, "align.rf")
+	for _, name := range []string{"exec bwa", "exec samtools"} {
+		execCtx, execDone := trace.Start(runCtx, trace.Exec, id, name)
+		trace.Note(execCtx, "ident", name)
+		now := time.Now()
+		trace.Span(execCtx, trace.Phase, id, "running", now.Add(-time.Second), now)
+		_, xferDone := trace.Start(execCtx, trace.Transfer, id, "xfer")
+		xferDone()
+		execDone()
+	}
+	runDone()
+	if err := trace.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		tracks = make(map[int]string)
+		spans  = make(map[string][]Event)
+	)
+	for _, e := range readTrace(t, tracer.Path) {
+		switch {
+		case e.Ph == "M" && e.Name == "thread_name":
+			tracks[e.Tid] = e.Args["name"].(string)
+		case e.Ph == "X":
+			spans[e.Name] = append(spans[e.Name], e)
+		}
+	}
+	if got, want := len(tracks), 3; got != want {
+		t.Fatalf("got %v tracks, want %v: %v", got, want, tracks)
+	}
+	for _, name := range []string{"exec bwa", "exec samtools"} {
+		if got, want := len(spans[name]), 1; got != want {
+			t.Fatalf("%s: got %v spans, want %v", name, got, want)
+		}
+		exec := spans[name][0]
+		if got, want := tracks[exec.Tid], name; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := exec.Args["ident"], name; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	// Phases and transfers are nested in their execs' tracks.
+	for _, name := range []string{"running", "xfer"} {
+		if got, want := len(spans[name]), 2; got != want {
+			t.Fatalf("%s: got %v spans, want %v", name, got, want)
+		}
+		for i, span := range spans[name] {
+			exec := spans[[]string{"exec bwa", "exec samtools"}[i]][0]
+			if span.Tid != exec.Tid || span.Pid != exec.Pid {
+				t.Errorf("%s span is not on its exec's track", name)
+			}
+		}
+	}
+	if got, want := spans["running"][0].Dur, int64(time.Second/time.Microsecond); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := trace.URL(runCtx), "file://"+tracer.Path; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
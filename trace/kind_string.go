@@ -4,9 +4,9 @@ package trace
 
 import "fmt"
 
-const _Kind_name = "RunExecCacheTransfer"
+const _Kind_name = "RunExecCacheTransferPhase"
 
-var _Kind_index = [...]uint8{0, 3, 7, 12, 20}
+var _Kind_index = [...]uint8{0, 3, 7, 12, 20, 25}
 
 func (i Kind) String() string {
 	if i < 0 || i >= Kind(len(_Kind_index)-1) {
@@ -0,0 +1,400 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package otlptrace implements a Reflow tracer that exports spans
+// to an OpenTelemetry collector using the OTLP/HTTP protocol with
+// JSON encoding [1].
+//
+// Reflow spans are mapped to OpenTelemetry spans: a span of kind
+// trace.Run begins a new trace; others are children of the span of
+// the context in which they are started. Span notes are recorded as
+// span attributes. Trace context is propagated across HTTP calls
+// using W3C Trace Context [2] headers.
+//
+// [1] https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/protocol/otlp.md
+// [2] https://www.w3.org/TR/trace-context/
+package otlptrace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/trace"
+)
+
+func init() {
+	infra.Register("otlp", new(Tracer))
+}
+
+const (
+	// traceparentHeader is the W3C Trace Context header.
+	traceparentHeader = "traceparent"
+
+	// maxBatch is the maximum number of spans exported in a single
+	// request.
+	maxBatch = 512
+	// maxQueue is the maximum number of finished spans that are
+	// buffered for export. Spans finished while the queue is full
+	// are dropped, so that tracing never blocks evaluation.
+	maxQueue = 8192
+	// exportInterval is the interval at which buffered spans are
+	// exported.
+	exportInterval = 5 * time.Second
+)
+
+type key int
+
+const spanKey key = 0
+
+// A span is an OpenTelemetry span under construction. Spans that are
+// propagated from remote callers are not recorded; they serve only as
+// parents of local spans.
+type span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	remote     bool
+	name       string
+	start, end time.Time
+
+	mu    sync.Mutex
+	attrs []attribute
+}
+
+// spanOf returns the span of the provided context, or nil if there
+// is none.
+func spanOf(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey).(*span)
+	return s
+}
+
+// Tracer is a reflow tracer that exports spans to an OTLP collector.
+type Tracer struct {
+	// Endpoint is the URL of the OTLP/HTTP collector. Spans are
+	// posted to Endpoint/v1/traces.
+	Endpoint string
+	// Service is the service name reported for exported spans.
+	Service string
+	// URLPrefix, if set, is the prefix of trace URLs: the URL of a
+	// trace is URLPrefix followed by its hex-encoded trace ID.
+	URLPrefix string
+	// Client is the HTTP client used to export spans. If nil,
+	// a client with a 30 second timeout is used.
+	Client *http.Client
+
+	once  sync.Once
+	mu    sync.Mutex
+	queue []*span
+	// flushc is signalled when the queue contains a full batch.
+	flushc chan struct{}
+}
+
+// Help implements infra.Provider.
+func (*Tracer) Help() string {
+	return "configure an OpenTelemetry (OTLP) tracer to write traces"
+}
+
+// Flags implements infra.Provider.
+func (t *Tracer) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&t.Endpoint, "endpoint", "http://localhost:4318", "URL of the OTLP/HTTP collector")
+	flags.StringVar(&t.Service, "service", "reflow", "service name of exported spans")
+	flags.StringVar(&t.URLPrefix, "url", "", "prefix of trace URLs (e.g., a Jaeger UI's http://host:16686/trace/)")
+}
+
+// Init implements infra.Provider.
+func (t *Tracer) Init() error {
+	if t.Endpoint == "" {
+		return errors.E(errors.Invalid, errors.New("otlp tracer: missing endpoint"))
+	}
+	return nil
+}
+
+// start starts the tracer's background exporter, once.
+func (t *Tracer) start() {
+	t.once.Do(func() {
+		t.flushc = make(chan struct{}, 1)
+		go func() {
+			tick := time.NewTicker(exportInterval)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+				case <-t.flushc:
+				}
+				if err := t.Flush(context.Background()); err != nil {
+					log.Debugf("otlp: export spans: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// Emit implements trace.Tracer.
+func (t *Tracer) Emit(ctx context.Context, e trace.Event) (context.Context, error) {
+	switch e.Kind {
+	case trace.StartEvent:
+		s := &span{name: e.Name, start: e.Time}
+		if parent := spanOf(ctx); parent != nil && e.SpanKind != trace.Run {
+			s.traceID, s.parentID = parent.traceID, parent.spanID
+		} else {
+			rand.Read(s.traceID[:])
+		}
+		rand.Read(s.spanID[:])
+		s.attrs = []attribute{
+			newAttribute("reflow.id", e.Id.String()),
+			newAttribute("reflow.kind", e.SpanKind.String()),
+			newAttribute("reflow.name", e.Name),
+		}
+		return context.WithValue(ctx, spanKey, s), nil
+	case trace.EndEvent:
+		s := spanOf(ctx)
+		if s == nil || s.remote {
+			return ctx, fmt.Errorf("event not found %+v", e.Id)
+		}
+		s.end = e.Time
+		t.enqueue(s)
+		return ctx, nil
+	case trace.NoteEvent:
+		s := spanOf(ctx)
+		if s == nil || s.remote {
+			return ctx, fmt.Errorf("no current span")
+		}
+		s.mu.Lock()
+		s.attrs = append(s.attrs, newAttribute(e.Key, e.Value))
+		s.mu.Unlock()
+		return ctx, nil
+	}
+	return ctx, nil
+}
+
+// enqueue queues the finished span s for export.
+func (t *Tracer) enqueue(s *span) {
+	t.start()
+	t.mu.Lock()
+	if len(t.queue) < maxQueue {
+		t.queue = append(t.queue, s)
+	}
+	full := len(t.queue) >= maxBatch
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flushc <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush exports all finished spans. It implements trace.Flusher.
+func (t *Tracer) Flush(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n := len(t.queue)
+		if n > maxBatch {
+			n = maxBatch
+		}
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		t.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.export(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// export posts the provided spans to the collector.
+func (t *Tracer) export(ctx context.Context, spans []*span) error {
+	b, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(t.Endpoint, "/")+"/v1/traces", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.E("otlp export", errors.Net, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.E("otlp export", errors.Errorf("collector returned %s", resp.Status))
+	}
+	return nil
+}
+
+// WriteHTTPContext implements trace.Tracer. It writes the current span
+// context as a W3C traceparent header.
+func (*Tracer) WriteHTTPContext(ctx context.Context, h *http.Header) {
+	s := spanOf(ctx)
+	if s == nil {
+		return
+	}
+	h.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:])))
+}
+
+// ReadHTTPContext implements trace.Tracer. It restores a span context
+// from a W3C traceparent header; spans started in the returned context
+// are children of the remote span.
+func (*Tracer) ReadHTTPContext(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(h.Get(traceparentHeader), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return ctx
+	}
+	s := &span{remote: true}
+	if !decodeID(s.traceID[:], parts[1]) || !decodeID(s.spanID[:], parts[2]) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey, s)
+}
+
+// decodeID decodes the hex-encoded ID s into id, returning false if
+// s is not a valid ID.
+func decodeID(id []byte, s string) bool {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return false
+	}
+	copy(id, b)
+	return true
+}
+
+// CopyTraceContext implements trace.Tracer.
+func (*Tracer) CopyTraceContext(src, dst context.Context) context.Context {
+	s := spanOf(src)
+	if s == nil {
+		return dst
+	}
+	return context.WithValue(dst, spanKey, s)
+}
+
+// URL implements trace.Tracer.
+func (t *Tracer) URL(ctx context.Context) string {
+	s := spanOf(ctx)
+	if s == nil || t.URLPrefix == "" {
+		return ""
+	}
+	return t.URLPrefix + hex.EncodeToString(s.traceID[:])
+}
+
+// The following types define the OTLP/HTTP JSON encoding of
+// ExportTraceServiceRequest messages.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+}
+
+// spanKindInternal is OTLP's SPAN_KIND_INTERNAL.
+const spanKindInternal = 1
+
+type attribute struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// newAttribute returns an attribute with the provided key and value.
+// Values that are not booleans or numbers are recorded as strings.
+func newAttribute(key string, value interface{}) attribute {
+	var v anyValue
+	switch value := value.(type) {
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.FormatInt(int64(value), 10)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	case string:
+		v.StringValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return attribute{key, v}
+}
+
+func (t *Tracer) request(spans []*span) exportRequest {
+	ss := scopeSpans{Scope: scope{Name: "github.com/grailbio/reflow"}}
+	var zero [8]byte
+	for _, s := range spans {
+		s.mu.Lock()
+		js := spanJSON{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        append([]attribute(nil), s.attrs...),
+		}
+		s.mu.Unlock()
+		if s.parentID != zero {
+			js.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		ss.Spans = append(ss.Spans, js)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []attribute{newAttribute("service.name", t.Service)}},
+		ScopeSpans: []scopeSpans{ss},
+	}}}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package otlptrace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/trace"
+)
+
+// collector is an in-process OTLP/HTTP collector.
+type collector struct {
+	mu    sync.Mutex
+	spans map[string]spanJSON
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				c.spans[s.Name] = s
+			}
+		}
+	}
+}
+
+func attr(s spanJSON, key string) *anyValue {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return &a.Value
+		}
+	}
+	return nil
+}
+
+func TestOTLPTracer(t *testing.T) {
+	c := &collector{spans: make(map[string]spanJSON)}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	tracer := &Tracer{Endpoint: srv.URL, Service: "reflow", URLPrefix: "http://jaeger/trace/"}
+	if err := tracer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := trace.WithTracer(context.Background(), tracer)
+
+	runCtx, runDone := trace.Start(ctx, trace.Run, reflow.Digester.FromString("run"), "run")
+	execCtx, execDone := trace.Start(runCtx, trace.Exec, reflow.Digester.FromString("exec"), "exec")
+	trace.Note(execCtx, "size", int64(1024))
+	trace.Note(execCtx, "cmd", "echo hello")
+
+	// Calls made in the exec's context are traced by their servers
+	// as part of the run's trace.
+	h := make(http.Header)
+	trace.WriteHTTPContext(execCtx, &h)
+	serverCtx := trace.ReadHTTPContext(trace.WithTracer(context.Background(), tracer), h)
+	_, serveDone := trace.Start(serverCtx, trace.Transfer, reflow.Digester.FromString("serve"), "serve")
+	serveDone()
+	execDone()
+	runDone()
+
+	if err := trace.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(c.spans), 3; got != want {
+		t.Fatalf("got %v spans, want %v", got, want)
+	}
+	run, exec, serve := c.spans["run"], c.spans["exec"], c.spans["serve"]
+	if run.ParentSpanID != "" {
+		t.Errorf("run span has parent %v", run.ParentSpanID)
+	}
+	if got, want := exec.ParentSpanID, run.SpanID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := serve.ParentSpanID, exec.SpanID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, s := range []spanJSON{exec, serve} {
+		if got, want := s.TraceID, run.TraceID; got != want {
+			t.Errorf("%s: got %v, want %v", s.Name, got, want)
+		}
+	}
+	if v := attr(exec, "reflow.kind"); v == nil || *v.StringValue != "Exec" {
+		t.Errorf("unexpected kind %v", v)
+	}
+	if v := attr(exec, "size"); v == nil || v.IntValue == nil || *v.IntValue != "1024" {
+		t.Errorf("unexpected size %v", v)
+	}
+	if v := attr(exec, "cmd"); v == nil || *v.StringValue != "echo hello" {
+		t.Errorf("unexpected cmd %v", v)
+	}
+	if got, want := trace.URL(runCtx), "http://jaeger/trace/"+run.TraceID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Remote spans are not annotated.
+	if _, err := tracer.Emit(serverCtx, trace.Event{Kind: trace.NoteEvent, Key: "k", Value: "v"}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestOTLPTracerInfra(t *testing.T) {
+	var schema = infra.Schema{
+		"tracer": new(trace.Tracer),
+	}
+	config, err := schema.Make(infra.Keys{
+		"tracer": "otlp,endpoint=http://collector:4318",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tracer trace.Tracer
+	config.Must(&tracer)
+	otlp, ok := tracer.(*Tracer)
+	if !ok {
+		t.Fatalf("%v is not an otlptrace", reflect.TypeOf(tracer))
+	}
+	if got, want := otlp.Endpoint, "http://collector:4318"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
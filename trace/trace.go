@@ -50,6 +50,9 @@ const (
 	Cache
 	// Transfer is the span type for transfer operations.
 	Transfer
+	// Phase is the span type for a phase of an operation (e.g.,
+	// the staging or running of an exec).
+	Phase
 )
 
 //go:generate stringer -type=Kind
@@ -72,6 +75,19 @@ func Start(ctx context.Context, kind Kind, id digest.Digest, name string) (outct
 	}
 }
 
+// Span traces a span of the indicated kind, name and id that began
+// at start and ended at end. It is used to trace operations whose
+// timing is known only after they have completed, for example the
+// phases of execs that are performed remotely.
+func Span(ctx context.Context, kind Kind, id digest.Digest, name string, start, end time.Time) {
+	if !On(ctx) {
+		return
+	}
+	t := tracer(ctx)
+	ctx, _ = t.Emit(ctx, Event{Time: start, SpanKind: kind, Id: id, Name: name, Kind: StartEvent})
+	t.Emit(ctx, Event{Time: end, SpanKind: kind, Id: id, Name: name, Kind: EndEvent})
+}
+
 // Note emits the provided key and value as a trace event associated with the span of the provided context.
 func Note(ctx context.Context, key string, value interface{}) {
 	if !On(ctx) {
@@ -50,6 +50,9 @@ type Event struct {
 type Tracer interface {
 	// Emit is called to emit a new event to the tracer.
 	// The returned context should be used to create children spans.
+	// Spans may be emitted after the fact (see Span), so tracers
+	// should record events at their timestamps rather than at the
+	// time they are emitted.
 	Emit(context.Context, Event) (context.Context, error)
 	// WriteHTTPContext saves the current trace context to http header.
 	WriteHTTPContext(context.Context, *http.Header)
@@ -61,6 +64,13 @@ type Tracer interface {
 	URL(context.Context) string
 }
 
+// Flusher is implemented by tracers that buffer events before
+// exporting them.
+type Flusher interface {
+	// Flush exports all buffered events.
+	Flush(context.Context) error
+}
+
 // NopTracer is default tracer that does nothing.
 var NopTracer Tracer = nopTracer{}
 
@@ -111,3 +121,15 @@ func tracer(ctx context.Context) Tracer {
 func Emit(ctx context.Context, event Event) (context.Context, error) {
 	return ctx.Value(tracerKey).(Tracer).Emit(ctx, event)
 }
+
+// Flush exports the events buffered by the tracer affiliated with
+// the provided context, if it buffers events.
+func Flush(ctx context.Context) error {
+	if !On(ctx) {
+		return nil
+	}
+	if f, ok := tracer(ctx).(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
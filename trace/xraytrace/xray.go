@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/xray"
@@ -81,6 +82,13 @@ func (Tracer) Emit(ctx context.Context, e trace.Event) (context.Context, error)
 				ctx, seg = xray.BeginSegment(ctx, name)
 			}
 		}
+		if !e.Time.IsZero() {
+			// Spans may be traced after the fact (see trace.Span), so
+			// segments begin at the time of their start events.
+			seg.Lock()
+			seg.StartTime = seconds(e.Time)
+			seg.Unlock()
+		}
 		seg.AddAnnotation("id", e.Id.String())
 		seg.AddAnnotation("kind", e.SpanKind.String())
 		seg.AddAnnotation("name", e.Name)
@@ -90,7 +98,20 @@ func (Tracer) Emit(ctx context.Context, e trace.Event) (context.Context, error)
 		if seg == nil {
 			return ctx, fmt.Errorf("event not found %+v", e.Id)
 		}
-		seg.Close(nil)
+		if e.Time.IsZero() {
+			seg.Close(nil)
+			return ctx, nil
+		}
+		// Segment.Close always ends the segment at the current time,
+		// so we end and emit it ourselves. Reflow's segments are
+		// always top-level segments, so there are no subsegments
+		// to wait for.
+		seg.Lock()
+		seg.EndTime = seconds(e.Time)
+		seg.InProgress = false
+		seg.Emitted = true
+		seg.Unlock()
+		xray.Emit(seg)
 		return ctx, nil
 	case trace.NoteEvent:
 		s := xray.GetSegment(ctx)
@@ -103,6 +124,12 @@ func (Tracer) Emit(ctx context.Context, e trace.Event) (context.Context, error)
 	return ctx, nil
 }
 
+// seconds returns t as (fractional) seconds since the Unix epoch,
+// as used by xray segment timestamps.
+func seconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
 // URL returns the trace URL.
 func (Tracer) URL(ctx context.Context) string {
 	id := xray.TraceID(ctx)
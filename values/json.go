@@ -0,0 +1,122 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package values
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/types"
+)
+
+// MarshalJSON renders the value v of type t as JSON. Ints and floats
+// are rendered as JSON numbers (without loss of precision); strings
+// and bools as JSON strings and bools; files as JSON-encoded
+// reflow.Files; directories as objects mapping paths to files;
+// lists and tuples as arrays; structs and modules as objects; and
+// maps as objects if they are keyed by strings, and as arrays of
+// key-value pairs otherwise. The unit value is rendered as null.
+// Functions and sum types cannot be rendered.
+func MarshalJSON(v T, t *types.T) ([]byte, error) {
+	j, err := jsonValue(v, t)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+func jsonValue(v T, t *types.T) (interface{}, error) {
+	switch t.Kind {
+	case types.IntKind:
+		return json.Number(v.(*big.Int).String()), nil
+	case types.FloatKind:
+		return json.Number(v.(*big.Float).Text('g', -1)), nil
+	case types.StringKind, types.BoolKind:
+		return v, nil
+	case types.FileKind:
+		return v.(reflow.File), nil
+	case types.DirKind:
+		dir := v.(Dir)
+		m := make(map[string]reflow.File, dir.Len())
+		for scan := dir.Scan(); scan.Scan(); {
+			m[scan.Path()] = scan.File()
+		}
+		return m, nil
+	case types.FilesetKind:
+		return v, nil
+	case types.UnitKind:
+		return nil, nil
+	case types.ListKind:
+		list := v.(List)
+		elems := make([]interface{}, len(list))
+		for i := range list {
+			var err error
+			if elems[i], err = jsonValue(list[i], t.Elem); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	case types.TupleKind:
+		tuple := v.(Tuple)
+		elems := make([]interface{}, len(t.Fields))
+		for i, f := range t.Fields {
+			var err error
+			if elems[i], err = jsonValue(tuple[i], f.T); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	case types.StructKind, types.ModuleKind:
+		var fields map[string]T
+		if t.Kind == types.StructKind {
+			fields = v.(Struct)
+		} else {
+			fields = v.(Module)
+		}
+		m := make(map[string]interface{}, len(t.Fields))
+		for _, f := range t.Fields {
+			var err error
+			if m[f.Name], err = jsonValue(fields[f.Name], f.T); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case types.MapKind:
+		var (
+			obj   = make(map[string]interface{})
+			pairs = [][2]interface{}{}
+			err   error
+		)
+		v.(*Map).Each(func(k, e T) {
+			if err != nil {
+				return
+			}
+			var ej interface{}
+			if ej, err = jsonValue(e, t.Elem); err != nil {
+				return
+			}
+			if t.Index.Kind == types.StringKind {
+				obj[k.(string)] = ej
+				return
+			}
+			var kj interface{}
+			if kj, err = jsonValue(k, t.Index); err != nil {
+				return
+			}
+			pairs = append(pairs, [2]interface{}{kj, ej})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if t.Index.Kind == types.StringKind {
+			return obj, nil
+		}
+		return pairs, nil
+	default:
+		return nil, fmt.Errorf("values of type %s cannot be rendered as JSON", t)
+	}
+}
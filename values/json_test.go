@@ -0,0 +1,58 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package values
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/types"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	file := reflow.File{ID: reflow.Digester.FromString("a"), Size: 1}
+	fileJSON, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir Dir
+	dir.Set("x/y", file)
+	intMap := new(Map)
+	intMap.Insert(Digest(NewInt(1), types.Int), NewInt(1), "one")
+	for _, c := range []struct {
+		v T
+		t *types.T
+		j string
+	}{
+		{NewInt(123), types.Int, `123`},
+		{NewFloat(1.5), types.Float, `1.5`},
+		{List{"hello", "world"}, types.List(types.String), `["hello","world"]`},
+		{
+			Struct{"a": NewInt(123), "b": Tuple{"ok", true}},
+			types.Struct(
+				&types.Field{"a", types.Int},
+				&types.Field{"b", types.Tuple(&types.Field{T: types.String}, &types.Field{T: types.Bool})}),
+			`{"a":123,"b":["ok",true]}`,
+		},
+		{makeMap(map[string]string{"a": "b"}), types.Map(types.String, types.String), `{"a":"b"}`},
+		{intMap, types.Map(types.Int, types.String), `[[1,"one"]]`},
+		{file, types.File, string(fileJSON)},
+		{dir, types.Dir, `{"x/y":` + string(fileJSON) + `}`},
+		{Unit, types.Unit, `null`},
+	} {
+		p, err := MarshalJSON(c.v, c.t)
+		if err != nil {
+			t.Errorf("%s: %v", c.t, err)
+			continue
+		}
+		if got, want := string(p), c.j; got != want {
+			t.Errorf("%s: got %v, want %v", c.t, got, want)
+		}
+	}
+	if _, err := MarshalJSON(nil, types.Func(types.Int)); err == nil {
+		t.Error("expected error")
+	}
+}